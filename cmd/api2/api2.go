@@ -2,25 +2,102 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/asynkron/protoactor-go/actor"
+	"github.com/asynkron/protoactor-go/cluster"
+	"github.com/asynkron/protoactor-go/cluster/clusterproviders/test"
+	"github.com/asynkron/protoactor-go/cluster/identitylookup/disthash"
 	"github.com/asynkron/protoactor-go/remote"
 	"github.com/kakugri/redditClone/internal/api2"
+	"github.com/kakugri/redditClone/internal/auth"
+	"github.com/kakugri/redditClone/internal/engine"
+	"github.com/kakugri/redditClone/internal/tracing"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 func main() {
+	addr := flag.String("addr", ":8081", "address the REST API listens on")
+	members := flag.String("members", "localhost:6330", "comma-separated host:manage-port of the engine cluster's members")
+	embedded := flag.Bool("embedded", false, "run an engine in this process, keeping its state in memory, instead of joining an engine cluster")
+	traceOutput := flag.String("trace", "", "where to export traces: stdout, or a file to append them to as JSON; empty disables tracing")
+	tokenTTL := flag.Duration("token-ttl", 24*time.Hour, "how long the token a user gets by logging in stays valid")
+	flag.Parse()
+
+	shutdownTracing, err := tracing.Setup("reddit-api", *traceOutput)
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
+
 	system := actor.NewActorSystem()
-	config := remote.Configure("localhost", 0)
-	remoting := remote.NewRemote(system, config)
-	remoting.Start()
+	var client *engine.Client
+	if *embedded {
+		client = startEmbedded(system)
+		log.Printf("API running an embedded engine")
+	} else {
+		// Join the engine cluster as a client; requests go to the grains by identity
+		client = engine.StartClient(system, strings.Split(*members, ","))
+		log.Printf("API targeting engine cluster %s at Members=%s", engine.ClusterName, *members)
+	}
+
+	router := api2.SetupRouter(client, auth.NewTokens(tokenSecret(), *tokenTTL))
 
-	// Target the RedditEngine actor
-	enginePID := actor.NewPID("127.0.0.1:8080", "reddit-engine")
-	log.Printf("Simulator targeting engine at Address=%s, Id=%s", enginePID.GetAddress(), enginePID.GetId())
+	go func() {
+		log.Printf("REST API server running on %s", *addr)
+		if err := router.Run(*addr); err != nil {
+			log.Fatalf("REST API server stopped: %v", err)
+		}
+	}()
 
-	router := api2.SetupRouter(system, enginePID)
+	// Flush the spans of the last requests before exiting
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+	log.Println("Shutting down")
+	if err := shutdownTracing(context.Background()); err != nil {
+		log.Printf("Failed to flush traces: %v", err)
+	}
+}
+
+// tokenSecret returns the secret login tokens are signed with, from the
+// REDDIT_AUTH_SECRET environment variable. API processes that share it accept
+// each other's tokens. Without it, a random secret is used, and tokens do not
+// outlive the process.
+func tokenSecret() []byte {
+	if secret := os.Getenv("REDDIT_AUTH_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+	secret, err := auth.RandomSecret()
+	if err != nil {
+		log.Fatalf("Failed to generate a token secret: %v", err)
+	}
+	log.Printf("REDDIT_AUTH_SECRET is not set: tokens are signed with a random secret and expire when the API exits")
+	return secret
+}
 
-	log.Println("REST API server running on :8081")
-	router.Run(":8081")
+// startEmbedded starts a one-member engine cluster in this process, which no
+// other process can join, and returns a client of it. Its metrics are served
+// along with the API's.
+func startEmbedded(system *actor.ActorSystem) *engine.Client {
+	member, err := engine.NewMember(engine.NewMemoryStore(), 1, prometheus.DefaultRegisterer)
+	if err != nil {
+		log.Fatalf("Failed to start engine: %v", err)
+	}
+	if _, err := system.Root.SpawnNamed(actor.PropsFromProducer(func() actor.Actor {
+		return member
+	}), "reddit-engine"); err != nil {
+		log.Fatalf("Failed to start engine: %v", err)
+	}
+	config := cluster.Configure(engine.ClusterName, test.NewTestProvider(test.NewInMemAgent()), disthash.New(),
+		remote.Configure("localhost", 0), cluster.WithKinds(member.Kinds()...))
+	c := cluster.New(system, config)
+	c.StartMember()
+	return engine.NewClient(c)
 }
@@ -11,21 +11,33 @@ import (
 	"sync"
 )
 
-const registerURL = "http://localhost:8081/api/register"
-const postURL = "http://localhost:8081/api/posts"
-const subredditURL = "http://localhost:8081/api/newsubreddit"
-const commentURL = "http://localhost:8081/api/comment"
+const apiURL = "http://localhost:8081/api/v1"
+const registerURL = apiURL + "/users"
+const subredditURL = apiURL + "/subreddits"
+const loginURL = apiURL + "/sessions"
+
+// Writes are made as this user, who every client logs in as
+const (
+	clientUsername = "simulated-client"
+	clientPassword = "simulated-password"
+)
+
+// The clients do not keep the names and IDs they create, so posts and
+// comments go to a subreddit and a post that may not exist
+const postURL = apiURL + "/subreddits/simulated/posts"
+const commentURL = apiURL + "/posts/t3_0000000000000/comments"
 
 func main() {
 	var wg sync.WaitGroup
 	clientCount := 100000
+	token := login()
 
 	for i := 0; i < clientCount; i++ {
 		wg.Add(1)
 		go func(clientID int) {
 			defer wg.Done()
 			username := "user" + strconv.Itoa(clientID)
-			simulateRequest(username)
+			simulateRequest(username, token)
 		}(i)
 	}
 
@@ -33,10 +45,45 @@ func main() {
 	log.Println("All clients finished")
 }
 
-func simulateRequest(username string) {
+// login registers the user the clients write as, unless an earlier run did,
+// and returns the token it logs in with.
+func login() string {
+	credentials, _ := json.Marshal(map[string]string{"username": clientUsername, "password": clientPassword})
+	resp, err := http.Post(registerURL, "application/json", bytes.NewBuffer(credentials))
+	if err != nil {
+		log.Fatalf("Error registering user %s: %v", clientUsername, err)
+	}
+	resp.Body.Close()
+
+	resp, err = http.Post(loginURL, "application/json", bytes.NewBuffer(credentials))
+	if err != nil {
+		log.Fatalf("Error logging in as %s: %v", clientUsername, err)
+	}
+	defer resp.Body.Close()
+	var session struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&session); err != nil || session.Token == "" {
+		log.Fatalf("Error logging in as %s: status %d", clientUsername, resp.StatusCode)
+	}
+	return session.Token
+}
+
+// post sends body to url as the logged-in user.
+func post(url, token string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	return http.DefaultClient.Do(req)
+}
+
+func simulateRequest(username, token string) {
 	switch rand.Intn(4) {
 	case 0:
-		data := map[string]string{"username": username}
+		data := map[string]string{"username": username, "password": clientPassword}
 		body, _ := json.Marshal(data)
 
 		resp, err := http.Post(registerURL, "application/json", bytes.NewBuffer(body))
@@ -52,7 +99,7 @@ func simulateRequest(username string) {
 		data := map[string]string{"title": title, "content": content}
 		body, _ := json.Marshal(data)
 
-		resp, err := http.Post(postURL, "application/json", bytes.NewBuffer(body))
+		resp, err := post(postURL, token, body)
 		if err != nil {
 			log.Printf("Error sending post with title %s: %v", title, err)
 			return
@@ -61,11 +108,10 @@ func simulateRequest(username string) {
 		log.Printf("Sent post by user: %s, Status: %d", username, resp.StatusCode)
 	case 2:
 		description := "Simulated Subreddit Description"
-		creatorid := username
-		data := map[string]string{"username": username, "description": description, "creatorid": creatorid}
+		data := map[string]string{"name": username, "description": description}
 		body, _ := json.Marshal(data)
 
-		resp, err := http.Post(subredditURL, "application/json", bytes.NewBuffer(body))
+		resp, err := post(subredditURL, token, body)
 		if err != nil {
 			log.Printf("Error creating subreddit with description %s: %v", description, err)
 			return
@@ -77,7 +123,7 @@ func simulateRequest(username string) {
 		data := map[string]string{"content": content}
 		body, _ := json.Marshal(data)
 
-		resp, err := http.Post(commentURL, "application/json", bytes.NewBuffer(body))
+		resp, err := post(commentURL, token, body)
 		if err != nil {
 			log.Printf("Error creating comment by user %s: %v", username, err)
 			return
@@ -2,28 +2,164 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
 
 	"github.com/asynkron/protoactor-go/actor"
-	"github.com/asynkron/protoactor-go/remote"
+	"github.com/asynkron/protoactor-go/cluster"
 	"github.com/kakugri/redditClone/internal/engine"
+	"github.com/kakugri/redditClone/internal/idgen"
+	"github.com/kakugri/redditClone/internal/tracing"
+	"github.com/kakugri/redditClone/internal/wal"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func main() {
-	system := actor.NewActorSystem()
-	config := remote.Configure("localhost", 8080)
-	remoting := remote.NewRemote(system, config)
-	remoting.Start()
+	nodeID := flag.Int("node-id", -1, "unique ID of this engine node, used to generate collision-free IDs; defaults to this member's position in -members")
+	storeKind := flag.String("store", "memory", "storage backend: memory, or bolt for an embedded database file in -data-dir")
+	dataDir := flag.String("data-dir", "", "directory for the database, or for the memory store's write-ahead log and snapshots; empty keeps all state in memory")
+	fsync := flag.String("fsync", string(wal.SyncAlways), "when to fsync the write-ahead log: always, interval or never")
+	fsyncInterval := flag.Duration("fsync-interval", wal.DefaultOptions().SyncInterval, "how often to fsync with -fsync=interval")
+	snapshotEvery := flag.Int("snapshot-every", 10000, "take a snapshot and compact the log after this many commands")
+	single := flag.Bool("single", false, "apply every request in a single engine actor instead of one grain per subreddit and per user; the cluster must have one member")
+	host := flag.String("host", "localhost", "host the engine listens on for actor messages")
+	port := flag.Int("port", 8080, "port the engine listens on for actor messages")
+	managePort := flag.Int("manage-port", 6330, "port of this member's cluster health endpoint")
+	members := flag.String("members", "localhost:6330", "comma-separated host:manage-port of every member of the cluster, this one included")
+	metricsAddr := flag.String("metrics-addr", ":2112", "address to serve Prometheus metrics on at /metrics; empty disables them")
+	traceOutput := flag.String("trace", "", "where to export traces: stdout, or a file to append them to as JSON; empty disables tracing")
+	flag.Parse()
+
+	memberList := strings.Split(*members, ",")
+	if *nodeID < 0 {
+		// Every member lists the same members, so each finds itself at a
+		// different position
+		self := net.JoinHostPort(*host, strconv.Itoa(*managePort))
+		*nodeID = slices.Index(memberList, self)
+		if *nodeID < 0 {
+			log.Fatalf("%s is not in -members; list it there or set -node-id", self)
+		}
+	}
+	if *nodeID == idgen.ClientNode {
+		log.Fatalf("Node ID %d is kept for clients; choose another -node-id", idgen.ClientNode)
+	}
+	if err := idgen.SetNode(*nodeID); err != nil {
+		log.Fatalf("Invalid node ID: %v", err)
+	}
+
+	var store engine.Store
+	var journal *engine.Journal
+	switch {
+	case *storeKind == "bolt":
+		if *dataDir == "" {
+			log.Fatalf("-store=bolt needs a -data-dir")
+		}
+		if err := os.MkdirAll(*dataDir, 0o755); err != nil {
+			log.Fatalf("Failed to create data directory: %v", err)
+		}
+		var err error
+		store, err = engine.OpenBoltStore(filepath.Join(*dataDir, "reddit.db"))
+		if err != nil {
+			log.Fatalf("Failed to open store: %v", err)
+		}
+	case *storeKind != "memory":
+		log.Fatalf("Unknown store %q", *storeKind)
+	case *dataDir != "":
+		// Only a single engine can replay the log in order
+		*single = true
+		opts := wal.DefaultOptions()
+		policy, err := wal.ParseSyncPolicy(*fsync)
+		if err != nil {
+			log.Fatalf("Invalid -fsync: %v", err)
+		}
+		opts.Sync = policy
+		opts.SyncInterval = *fsyncInterval
+		journal, err = engine.OpenJournal(*dataDir, opts, *snapshotEvery)
+		if err != nil {
+			log.Fatalf("Failed to open journal: %v", err)
+		}
+	}
+
+	shutdownTracing, err := tracing.Setup("reddit-engine", *traceOutput)
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
 
-	props := actor.PropsFromProducer(func() actor.Actor {
-		return engine.NewRedditEngine()
-	})
+	if store == nil && journal == nil {
+		store = engine.NewMemoryStore()
+	}
+
+	// Clients reach the grains through the cluster. In single mode the grains
+	// hand every request to the engine actor; otherwise they are the engine,
+	// and the actor only reports this member's metrics.
+	var kinds []*cluster.Kind
+	var props *actor.Props
+	if *single {
+		kinds = engine.SingleEngineKinds("reddit-engine")
+		props = engine.EngineProps(store, journal, prometheus.DefaultRegisterer)
+	} else {
+		member, err := engine.NewMember(store, len(memberList), prometheus.DefaultRegisterer)
+		if err != nil {
+			log.Fatalf("Failed to load engine state: %v", err)
+		}
+		kinds = member.Kinds()
+		props = actor.PropsFromProducer(func() actor.Actor {
+			return member
+		})
+	}
 
+	system := actor.NewActorSystem()
 	pid, err := system.Root.SpawnNamed(props, "reddit-engine")
 	if err != nil {
 		log.Fatalf("Failed to start engine: %v", err)
 	}
-	log.Printf("RedditEngine started: Address=%s, Id=%s", pid.GetAddress(), pid.GetId())
+	config := engine.NewClusterConfig(*host, *port, *managePort, memberList, kinds...)
+	c := cluster.New(system, config)
+	c.StartMember()
+	log.Printf("RedditEngine started: Address=%s, Cluster=%s, Members=%s", system.Address(), engine.ClusterName, *members)
+
+	if *metricsAddr != "" {
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", promhttp.Handler())
+			log.Printf("Serving metrics on %s/metrics", *metricsAddr)
+			if err := http.ListenAndServe(*metricsAddr, mux); err != nil {
+				log.Printf("Metrics server stopped: %v", err)
+			}
+		}()
+	}
 
-	select {}
+	// Stop the engine cleanly so it can snapshot and flush the log
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+	log.Println("Shutting down")
+	if err := system.Root.StopFuture(pid).Wait(); err != nil {
+		log.Printf("Engine did not stop cleanly: %v", err)
+	}
+	c.Shutdown(true)
+	if journal != nil {
+		if err := journal.Close(); err != nil {
+			log.Printf("Failed to close journal: %v", err)
+		}
+	}
+	if store != nil {
+		if err := store.Close(); err != nil {
+			log.Printf("Failed to close store: %v", err)
+		}
+	}
+	if err := shutdownTracing(context.Background()); err != nil {
+		log.Printf("Failed to flush traces: %v", err)
+	}
 }
@@ -0,0 +1,75 @@
+// cmd/simulator/bench.go
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"runtime"
+
+	"github.com/asynkron/protoactor-go/actor"
+	"github.com/asynkron/protoactor-go/cluster"
+	"github.com/asynkron/protoactor-go/cluster/clusterproviders/test"
+	"github.com/asynkron/protoactor-go/cluster/identitylookup/disthash"
+	"github.com/asynkron/protoactor-go/remote"
+	"github.com/kakugri/redditClone/internal/engine"
+	"github.com/kakugri/redditClone/internal/simulator"
+)
+
+// runBenchmarks runs the same workload against a single engine actor and a
+// sharded engine, each as a one-member cluster in this process, and reports
+// the throughput of each.
+func runBenchmarks(cfg simulator.BenchmarkConfig) {
+	engines := []struct {
+		name  string
+		start func(system *actor.ActorSystem) []*cluster.Kind
+	}{
+		{"single engine", func(system *actor.ActorSystem) []*cluster.Kind {
+			props := engine.EngineProps(engine.NewMemoryStore(), nil, nil)
+			if _, err := system.Root.SpawnNamed(props, "reddit-engine"); err != nil {
+				log.Fatalf("Failed to start engine: %v", err)
+			}
+			return engine.SingleEngineKinds("reddit-engine")
+		}},
+		{"sharded engine", func(system *actor.ActorSystem) []*cluster.Kind {
+			member, err := engine.NewMember(engine.NewMemoryStore(), 1, nil)
+			if err != nil {
+				log.Fatalf("Failed to start engine: %v", err)
+			}
+			return member.Kinds()
+		}},
+	}
+
+	log.Printf("Benchmarking %d clients over %d subreddits and %d users for %s on %d CPUs",
+		cfg.Clients, cfg.Subreddits, cfg.Users, cfg.Duration, runtime.NumCPU())
+	output := log.Writer()
+	var baseline float64
+	for _, e := range engines {
+		// The engines log every request, which would drown the results
+		log.SetOutput(io.Discard)
+		system := actor.NewActorSystem(actor.WithLoggerFactory(func(system *actor.ActorSystem) *slog.Logger {
+			return slog.New(slog.NewTextHandler(io.Discard, nil))
+		}))
+		config := cluster.Configure(engine.ClusterName, test.NewTestProvider(test.NewInMemAgent()), disthash.New(),
+			remote.Configure("localhost", 0), cluster.WithKinds(e.start(system)...))
+		c := cluster.New(system, config)
+		c.StartMember()
+
+		result, err := simulator.RunBenchmark(engine.NewClient(c), cfg)
+		c.Shutdown(false)
+		log.SetOutput(output)
+		if err != nil {
+			log.Fatalf("Benchmark of the %s failed: %v", e.name, err)
+		}
+
+		speedup := ""
+		if baseline == 0 {
+			baseline = result.Throughput()
+		} else {
+			speedup = fmt.Sprintf(" (%.1fx)", result.Throughput()/baseline)
+		}
+		log.Printf("%s: %.0f requests/s%s, %d requests, %d failed",
+			e.name, result.Throughput(), speedup, result.Requests, result.Failures)
+	}
+}
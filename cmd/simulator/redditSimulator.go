@@ -2,34 +2,59 @@
 package main
 
 import (
+	"flag"
 	"log"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/asynkron/protoactor-go/actor"
-	"github.com/asynkron/protoactor-go/remote"
+	"github.com/kakugri/redditClone/internal/engine"
+	"github.com/kakugri/redditClone/internal/idgen"
 	"github.com/kakugri/redditClone/internal/proto"
 	"github.com/kakugri/redditClone/internal/simulator"
 	"github.com/shirou/gopsutil/v3/cpu"
 )
 
 func main() {
+	members := flag.String("members", "localhost:6330", "comma-separated host:manage-port of the engine cluster's members")
+	bench := flag.Bool("bench", false, "compare the request throughput of a single and a sharded engine in this process, then exit")
+	benchClients := flag.Int("bench-clients", 64, "concurrent clients in -bench mode")
+	benchSubreddits := flag.Int("bench-subreddits", 64, "subreddits the -bench workload is spread over")
+	benchUsers := flag.Int("bench-users", 500, "users registered for -bench")
+	benchDuration := flag.Duration("bench-duration", 10*time.Second, "how long -bench measures each engine")
+	nodeID := flag.Int("node-id", idgen.ClientNode, "node ID the simulator generates user IDs with; must differ from every engine member's")
+	flag.Parse()
+
+	if *bench {
+		if *benchClients < 1 || *benchSubreddits < 1 || *benchUsers < 1 {
+			log.Fatalf("-bench needs at least one client, subreddit and user")
+		}
+		runBenchmarks(simulator.BenchmarkConfig{
+			Users:      *benchUsers,
+			Subreddits: *benchSubreddits,
+			Clients:    *benchClients,
+			Duration:   *benchDuration,
+		})
+		return
+	}
+
+	// The IDs the simulator generates must not collide with the engine's
+	if err := idgen.SetNode(*nodeID); err != nil {
+		log.Fatalf("Invalid node ID: %v", err)
+	}
+
 	// Initialize the actor system
 	system := actor.NewActorSystem()
 
-	// Configure the remote actor system
-	config := remote.Configure("localhost", 0) // The simulator listens on a dynamic port
-	remoting := remote.NewRemote(system, config)
-	remoting.Start()
-
-	// Target the RedditEngine actor
-	enginePID := actor.NewPID("127.0.0.1:8080", "reddit-engine")
-	log.Printf("Simulator targeting engine at Address=%s, Id=%s", enginePID.GetAddress(), enginePID.GetId())
+	// Join the engine cluster as a client; requests go to the grains by identity
+	client := engine.StartClient(system, strings.Split(*members, ","))
+	log.Printf("Simulator targeting engine cluster %s at Members=%s", engine.ClusterName, *members)
 
 	// Subscribe to DeadLetter events for debugging
 	system.EventStream.Subscribe(func(evt interface{}) {
 		if deadLetter, ok := evt.(actor.DeadLetterEvent); ok {
-			log.Printf("DeadLetter Event - PID: %+v, Message: %+v, Sender: %+v",
+			log.Printf("DeadLetter Event - PID: %+v, Message: %T, Sender: %+v",
 				deadLetter.PID, deadLetter.Message, deadLetter.Sender)
 		}
 	})
@@ -40,7 +65,7 @@ func main() {
 
 	// Send a test CreatePostMsg to the engine
 	log.Println("Sending test CreatePostMsg to engine...")
-	system.Root.Send(enginePID, &proto.CreatePostMsg{
+	client.Send(&proto.CreatePostMsg{
 		Title:       "Test Post",
 		Content:     "Test Content",
 		AuthorId:    "test-author-id",
@@ -50,7 +75,7 @@ func main() {
 	time.Sleep(20 * time.Second)
 
 	// Start the simulator with 5 simulated users
-	sim := simulator.NewSimulator(enginePID, 2100)
+	sim := simulator.NewSimulator(system, client, 2100)
 	sim.Start()
 	log.Println("Simulator started.")
 
@@ -63,11 +88,14 @@ func main() {
 			var m runtime.MemStats
 			runtime.ReadMemStats(&m)
 
-			// Capture CPU usage
-			cpuPercent, _ := cpu.Percent(0, false)
+			// Capture CPU usage; cpu.Percent returns no values when it fails
+			var cpuPercent float64
+			if percents, err := cpu.Percent(0, false); err == nil && len(percents) > 0 {
+				cpuPercent = percents[0]
+			}
 			log.Printf("Metrics Report: TotalPosts=%d, ActiveUsers=%d, TotalVotes=%d, TotalComments=%d, TotalMessages=%d, Memory=%.2f MB, CPU=%.2f%%", metrics.TotalPosts, metrics.ActiveUsers,
 				metrics.TotalVotes, metrics.TotalComments, metrics.TotalMessages, float64(m.Alloc)/1024/1024,
-				cpuPercent[0])
+				cpuPercent)
 		}
 	}()
 
@@ -0,0 +1,121 @@
+// internal/api2/auth.go
+package api2
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kakugri/redditClone/internal/auth"
+	"github.com/kakugri/redditClone/internal/engine"
+	"github.com/kakugri/redditClone/internal/proto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// errCodeUnauthenticated is the code of requests that need a logged-in user
+// and carry no valid token, or of a failed login.
+const errCodeUnauthenticated = "unauthenticated"
+
+// callerKey is the gin context key of the ID of the user making the request.
+const callerKey = "caller"
+
+// requireUser only lets requests through that carry a valid token in an
+// "Authorization: Bearer" header, and records the user it identifies for
+// handlers to take from caller. Other requests are answered 401.
+func requireUser(tokens *auth.Tokens) gin.HandlerFunc {
+	return authenticate(tokens, true)
+}
+
+// identifyUser records the user of requests that carry a valid token, like
+// requireUser, but also lets anonymous requests through. A request with an
+// invalid token is still answered 401.
+func identifyUser(tokens *auth.Tokens) gin.HandlerFunc {
+	return authenticate(tokens, false)
+}
+
+func authenticate(tokens *auth.Tokens, required bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if (!found || token == "") && !required {
+			c.Next()
+			return
+		}
+		if !found || token == "" {
+			c.Header("WWW-Authenticate", "Bearer")
+			writeError(c, http.StatusUnauthorized, errCodeUnauthenticated, "log in at /api/v1/sessions and send the token in an Authorization: Bearer header")
+			return
+		}
+		userID, err := tokens.Verify(token)
+		if err != nil {
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			writeError(c, http.StatusUnauthorized, errCodeUnauthenticated, err.Error())
+			return
+		}
+		trace.SpanFromContext(c.Request.Context()).SetAttributes(attribute.String("enduser.id", userID))
+		c.Set(callerKey, userID)
+		c.Next()
+	}
+}
+
+// caller returns the ID of the logged-in user making the request, on routes
+// behind requireUser. Behind identifyUser it is empty for anonymous requests.
+func caller(c *gin.Context) string {
+	return c.GetString(callerKey)
+}
+
+// requireSelf answers 403 unless userID is the caller, for resources only
+// their user may act on.
+func requireSelf(c *gin.Context, userID string) bool {
+	if userID != caller(c) {
+		writeError(c, http.StatusForbidden, engine.ErrCodePermissionDenied, "you cannot act for another user")
+		return false
+	}
+	return true
+}
+
+// LoginHandler checks a username and password and answers with a token that
+// identifies the user to the routes behind requireUser.
+func LoginHandler(tokens *auth.Tokens) func(*gin.Context, *engine.Client) {
+	return func(c *gin.Context, client *engine.Client) {
+		var req struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+		}
+		if !bindJSON(c, &req) {
+			return
+		}
+
+		resp, ok := askEngine(c, client, &proto.GetCredentialsMsg{Username: req.Username})
+		if !ok {
+			return
+		}
+		credentials := resp.(*proto.GetCredentialsResponse)
+		if err := credentials.Error; err != nil && err.Code != engine.ErrCodeNotFound {
+			writeEngineError(c, err)
+			return
+		}
+		// An unknown user is checked against no hash, which takes as long
+		if err := auth.CheckPassword(credentials.PasswordHash, req.Password); err != nil {
+			if !errors.Is(err, auth.ErrWrongPassword) {
+				log.Printf("Failed to check the password of %q: %v", req.Username, err)
+			}
+			writeError(c, http.StatusUnauthorized, errCodeUnauthenticated, "wrong username or password")
+			return
+		}
+
+		token, expires, err := tokens.Issue(credentials.UserId)
+		if err != nil {
+			writeError(c, http.StatusInternalServerError, engine.ErrCodeInternal, "failed to issue a token")
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{
+			"token":      token,
+			"token_type": "Bearer",
+			"expires_at": expires.Unix(),
+			"user_id":    credentials.UserId,
+		})
+	}
+}
@@ -0,0 +1,85 @@
+// internal/api2/auth_test.go
+package api2
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kakugri/redditClone/internal/auth"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// serve has router answer a request with the token, if any, and returns the
+// status it answered with.
+func serve(t *testing.T, router http.Handler, method, path, token string) int {
+	t.Helper()
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func issue(t *testing.T, tokens *auth.Tokens, userID string) string {
+	t.Helper()
+	token, _, err := tokens.Issue(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestRoutesRequireAToken(t *testing.T) {
+	tokens := auth.NewTokens([]byte("secret"), time.Hour)
+	// Requests that are refused never reach the engine
+	router := SetupRouter(nil, tokens)
+	alice := issue(t, tokens, "t2_alice")
+	forged := issue(t, auth.NewTokens([]byte("other secret"), time.Hour), "t2_alice")
+
+	tests := []struct {
+		name, method, path, token string
+		want                      int
+	}{
+		{"no token", http.MethodPost, "/api/v1/subreddits", "", http.StatusUnauthorized},
+		{"no token for a feed", http.MethodGet, "/api/v1/users/t2_alice/feed", "", http.StatusUnauthorized},
+		{"forged token", http.MethodGet, "/api/v1/users/t2_alice/messages", forged, http.StatusUnauthorized},
+		{"invalid token on an open listing", http.MethodGet, "/api/v1/subreddits/go/posts", "not.a.token", http.StatusUnauthorized},
+		{"another user's inbox", http.MethodGet, "/api/v1/users/t2_bob/messages", alice, http.StatusForbidden},
+		{"another user's feed", http.MethodGet, "/api/v1/users/t2_bob/feed", alice, http.StatusForbidden},
+		{"another user's blocks", http.MethodPut, "/api/v1/users/t2_bob/blocks/t2_carol", alice, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if got := serve(t, router, tt.method, tt.path, tt.token); got != tt.want {
+			t.Errorf("%s: %s %s answered %d, want %d", tt.name, tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestRequireSelf(t *testing.T) {
+	tokens := auth.NewTokens([]byte("secret"), time.Hour)
+	router := gin.New()
+	router.GET("/users/:id", requireUser(tokens), func(c *gin.Context) {
+		if requireSelf(c, c.Param("id")) {
+			c.Status(http.StatusNoContent)
+		}
+	})
+	alice := issue(t, tokens, "t2_alice")
+
+	if got := serve(t, router, http.MethodGet, "/users/t2_alice", alice); got != http.StatusNoContent {
+		t.Errorf("acting as oneself answered %d, want %d", got, http.StatusNoContent)
+	}
+	if got := serve(t, router, http.MethodGet, "/users/t2_bob", alice); got != http.StatusForbidden {
+		t.Errorf("acting as another user answered %d, want %d", got, http.StatusForbidden)
+	}
+	if got := serve(t, router, http.MethodGet, "/users/t2_alice", ""); got != http.StatusUnauthorized {
+		t.Errorf("acting without a token answered %d, want %d", got, http.StatusUnauthorized)
+	}
+}
@@ -2,81 +2,472 @@
 package api2
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 
 	"github.com/asynkron/protoactor-go/actor"
 	"github.com/gin-gonic/gin"
+	"github.com/kakugri/redditClone/internal/auth"
+	"github.com/kakugri/redditClone/internal/engine"
 	"github.com/kakugri/redditClone/internal/proto"
 )
 
-func RegisterUserHandler(c *gin.Context, system *actor.ActorSystem, enginePID *actor.PID) {
+// errCodeTimeout is the error code of requests the engine did not answer in
+// time, next to the engine's codes.
+const errCodeTimeout = "timeout"
+
+// engineResponse is implemented by every response message the engine sends back.
+type engineResponse interface {
+	GetError() *proto.Error
+}
+
+// writeError answers with the body every error response has: a code clients
+// can act on and a message for people, and for a request with invalid fields,
+// what is wrong with each.
+func writeError(c *gin.Context, status int, code, message string, fields ...*proto.FieldViolation) {
+	body := gin.H{"code": code, "message": message}
+	if len(fields) > 0 {
+		body["fields"] = fields
+	}
+	c.AbortWithStatusJSON(status, gin.H{"error": body})
+}
+
+// requestEngine sends msg to the engine and waits for its reply. If the request
+// times out or the engine rejects the command, the error response is written
+// here and false is returned.
+func requestEngine(c *gin.Context, client *engine.Client, msg interface{}) (interface{}, bool) {
+	resp, ok := askEngine(c, client, msg)
+	if !ok {
+		return nil, false
+	}
+	if resp.GetError() != nil {
+		writeEngineError(c, resp.GetError())
+		return nil, false
+	}
+	return resp, true
+}
+
+// askEngine is requestEngine for handlers that answer the errors the engine
+// returns themselves: only a request that gets no answer is answered here.
+func askEngine(c *gin.Context, client *engine.Client, msg interface{}) (engineResponse, bool) {
+	result, err := client.RequestContext(c.Request.Context(), msg)
+	if err != nil {
+		if errors.Is(err, actor.ErrTimeout) {
+			writeError(c, http.StatusGatewayTimeout, errCodeTimeout, err.Error())
+		} else {
+			writeError(c, http.StatusBadGateway, engine.ErrCodeUnavailable, err.Error())
+		}
+		return nil, false
+	}
+	resp, ok := result.(engineResponse)
+	if !ok {
+		writeError(c, http.StatusBadGateway, engine.ErrCodeUnavailable, fmt.Sprintf("unexpected engine response %T", result))
+		return nil, false
+	}
+	return resp, true
+}
+
+// writeEngineError translates an error returned by the engine into an HTTP response.
+func writeEngineError(c *gin.Context, engineErr *proto.Error) {
+	status := http.StatusInternalServerError
+	switch engineErr.Code {
+	case engine.ErrCodeNotFound:
+		status = http.StatusNotFound
+	case engine.ErrCodeInvalidArgument:
+		status = http.StatusBadRequest
+	case engine.ErrCodePermissionDenied:
+		status = http.StatusForbidden
+	case engine.ErrCodeAlreadyExists:
+		status = http.StatusConflict
+	case engine.ErrCodeUnavailable:
+		status = http.StatusServiceUnavailable
+	}
+	writeError(c, status, engineErr.Code, engineErr.Message, engineErr.Fields...)
+}
+
+// bindJSON decodes the request body into req, answering 400 if it cannot.
+func bindJSON(c *gin.Context, req interface{}) bool {
+	if err := c.ShouldBindJSON(req); err != nil {
+		writeError(c, http.StatusBadRequest, engine.ErrCodeInvalidArgument, err.Error())
+		return false
+	}
+	return true
+}
+
+// bindQuery decodes the query string into req, answering 400 if it cannot.
+func bindQuery(c *gin.Context, req interface{}) bool {
+	if err := c.ShouldBindQuery(req); err != nil {
+		writeError(c, http.StatusBadRequest, engine.ErrCodeInvalidArgument, err.Error())
+		return false
+	}
+	return true
+}
+
+// subredditID looks up the ID of the subreddit named by the path.
+func subredditID(c *gin.Context, client *engine.Client) (string, bool) {
+	result, ok := requestEngine(c, client, &proto.GetSubredditMsg{Name: c.Param("name")})
+	if !ok {
+		return "", false
+	}
+	return result.(*proto.GetSubredditResponse).Subreddit.Id, true
+}
+
+func RegisterUserHandler(c *gin.Context, client *engine.Client) {
 	var req struct {
 		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if !bindJSON(c, &req) {
+		return
+	}
+	if problem := auth.CheckPasswordRules(req.Password); problem != "" {
+		writeError(c, http.StatusBadRequest, engine.ErrCodeInvalidArgument, "invalid request: password: "+problem,
+			&proto.FieldViolation{Field: "password", Description: problem})
+		return
+	}
+	hash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		writeError(c, http.StatusInternalServerError, engine.ErrCodeInternal, "failed to hash the password")
+		return
+	}
+
+	result, ok := requestEngine(c, client, &proto.RegisterUserMsg{Username: req.Username, PasswordHash: hash})
+	if !ok {
+		return
+	}
+	resp := result.(*proto.RegisterUserResponse)
+	c.JSON(http.StatusCreated, gin.H{"message": "User registered", "user_id": resp.UserId})
+}
+
+func GetUserHandler(c *gin.Context, client *engine.Client) {
+	result, ok := requestEngine(c, client, &proto.GetUserMsg{UserId: c.Param("id")})
+	if !ok {
+		return
+	}
+	resp := result.(*proto.GetUserResponse)
+	c.JSON(http.StatusOK, gin.H{"user": resp.User})
+}
+
+func GetUserKarmaHandler(c *gin.Context, client *engine.Client) {
+	result, ok := requestEngine(c, client, &proto.GetUserKarmaMsg{UserId: c.Param("id")})
+	if !ok {
+		return
+	}
+	resp := result.(*proto.GetUserKarmaResponse)
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":       resp.UserId,
+		"karma":         resp.Karma,
+		"post_karma":    resp.PostKarma,
+		"comment_karma": resp.CommentKarma,
+		"subreddits":    resp.Subreddits,
+	})
+}
+
+func GetUserSubredditsHandler(c *gin.Context, client *engine.Client) {
+	result, ok := requestEngine(c, client, &proto.GetUserSubredditsMsg{UserId: c.Param("id")})
+	if !ok {
+		return
+	}
+	resp := result.(*proto.GetUserSubredditsResponse)
+	c.JSON(http.StatusOK, gin.H{"subreddits": resp.Subreddits})
+}
+
+// listingParams are the query parameters shared by every post listing.
+type listingParams struct {
+	Sort       string `form:"sort"`
+	TimeWindow string `form:"t"`
+	Cursor     string `form:"cursor"`
+	Limit      int32  `form:"limit"`
+}
+
+func GetFeedHandler(c *gin.Context, client *engine.Client) {
+	var req listingParams
+	if !requireSelf(c, c.Param("id")) || !bindQuery(c, &req) {
+		return
+	}
+
+	msg := &proto.GetFeedMsg{
+		UserId:     c.Param("id"),
+		Cursor:     req.Cursor,
+		Limit:      req.Limit,
+		Sort:       req.Sort,
+		TimeWindow: req.TimeWindow,
+	}
+	result, ok := requestEngine(c, client, msg)
+	if !ok {
+		return
+	}
+	resp := result.(*proto.GetFeedResponse)
+	c.JSON(http.StatusOK, gin.H{"posts": resp.Posts, "next_cursor": resp.NextCursor})
+}
+
+func CreateSubredditHandler(c *gin.Context, client *engine.Client) {
+	var req struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	msg := &proto.CreateSubredditMsg{
+		Name:        req.Name,
+		Description: req.Description,
+		CreatorId:   caller(c),
+	}
+	result, ok := requestEngine(c, client, msg)
+	if !ok {
+		return
+	}
+	resp := result.(*proto.CreateSubredditResponse)
+	c.JSON(http.StatusCreated, gin.H{"message": "Subreddit created", "subreddit_id": resp.SubredditId})
+}
+
+func GetSubredditHandler(c *gin.Context, client *engine.Client) {
+	result, ok := requestEngine(c, client, &proto.GetSubredditMsg{Name: c.Param("name")})
+	if !ok {
+		return
+	}
+	resp := result.(*proto.GetSubredditResponse)
+	c.JSON(http.StatusOK, gin.H{"subreddit": resp.Subreddit})
+}
+
+func GetSubredditPostsHandler(c *gin.Context, client *engine.Client) {
+	var req listingParams
+	if !bindQuery(c, &req) {
+		return
 	}
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	id, ok := subredditID(c, client)
+	if !ok {
 		return
 	}
 
-	system.Root.Send(enginePID, &proto.RegisterUserMsg{Username: req.Username})
-	c.JSON(http.StatusOK, gin.H{"message": "User registration initiated"})
+	msg := &proto.GetSubredditPostsMsg{
+		SubredditId: id,
+		ViewerId:    caller(c),
+		Cursor:      req.Cursor,
+		Limit:       req.Limit,
+		Sort:        req.Sort,
+		TimeWindow:  req.TimeWindow,
+	}
+	result, ok := requestEngine(c, client, msg)
+	if !ok {
+		return
+	}
+	resp := result.(*proto.GetSubredditPostsResponse)
+	c.JSON(http.StatusOK, gin.H{"posts": resp.Posts, "next_cursor": resp.NextCursor})
 }
 
-func CreatePostHandler(c *gin.Context, system *actor.ActorSystem, enginePID *actor.PID) {
+func CreatePostHandler(c *gin.Context, client *engine.Client) {
 	var req struct {
 		Title   string `json:"title"`
 		Content string `json:"content"`
 	}
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !bindJSON(c, &req) {
+		return
+	}
+	id, ok := subredditID(c, client)
+	if !ok {
 		return
 	}
 
 	msg := &proto.CreatePostMsg{
+		Title:       req.Title,
+		Content:     req.Content,
+		AuthorId:    caller(c),
+		SubredditId: id,
+	}
+	result, ok := requestEngine(c, client, msg)
+	if !ok {
+		return
+	}
+	resp := result.(*proto.CreatePostResponse)
+	c.JSON(http.StatusCreated, gin.H{"message": "Post created", "post_id": resp.PostId})
+}
+
+func JoinSubredditHandler(c *gin.Context, client *engine.Client) {
+	id, ok := subredditID(c, client)
+	if !ok {
+		return
+	}
+
+	result, ok := requestEngine(c, client, &proto.JoinSubredditMsg{UserId: caller(c), SubredditId: id})
+	if !ok {
+		return
+	}
+	resp := result.(*proto.JoinSubredditResponse)
+	c.JSON(http.StatusOK, gin.H{"message": "Joined subreddit", "subreddit_id": resp.SubredditId, "member_count": resp.MemberCount})
+}
+
+func LeaveSubredditHandler(c *gin.Context, client *engine.Client) {
+	if !requireSelf(c, c.Param("user_id")) {
+		return
+	}
+	id, ok := subredditID(c, client)
+	if !ok {
+		return
+	}
+
+	result, ok := requestEngine(c, client, &proto.LeaveSubredditMsg{UserId: c.Param("user_id"), SubredditId: id})
+	if !ok {
+		return
+	}
+	resp := result.(*proto.LeaveSubredditResponse)
+	c.JSON(http.StatusOK, gin.H{"message": "Left subreddit", "subreddit_id": resp.SubredditId, "member_count": resp.MemberCount})
+}
+
+func GetPostHandler(c *gin.Context, client *engine.Client) {
+	result, ok := requestEngine(c, client, &proto.GetPostMsg{PostId: c.Param("id")})
+	if !ok {
+		return
+	}
+	resp := result.(*proto.GetPostResponse)
+	c.JSON(http.StatusOK, gin.H{"post": resp.Post})
+}
+
+func EditPostHandler(c *gin.Context, client *engine.Client) {
+	var req struct {
+		Title   string `json:"title"`
+		Content string `json:"content"`
+	}
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	msg := &proto.EditPostMsg{
+		PostId:  c.Param("id"),
+		UserId:  caller(c),
 		Title:   req.Title,
 		Content: req.Content,
 	}
-	system.Root.Send(enginePID, msg)
-	c.JSON(http.StatusOK, gin.H{"message": "Post creation initiated"})
+	result, ok := requestEngine(c, client, msg)
+	if !ok {
+		return
+	}
+	resp := result.(*proto.EditPostResponse)
+	c.JSON(http.StatusOK, gin.H{"message": "Post edited", "post_id": resp.PostId, "edited_at": resp.EditedAt})
 }
 
-func CreateSubredditHandler(c *gin.Context, system *actor.ActorSystem, enginePID *actor.PID) {
+func DeletePostHandler(c *gin.Context, client *engine.Client) {
+	result, ok := requestEngine(c, client, &proto.DeletePostMsg{PostId: c.Param("id"), UserId: caller(c)})
+	if !ok {
+		return
+	}
+	resp := result.(*proto.DeletePostResponse)
+	c.JSON(http.StatusOK, gin.H{"message": "Post deleted", "post_id": resp.PostId})
+}
+
+func GetPostCommentsHandler(c *gin.Context, client *engine.Client) {
 	var req struct {
-		Name        string `json:"username"`
-		Description string `json:"description"`
-		CreatorId   string `json:"creatorid"`
+		Sort         string `form:"sort"`
+		Depth        int32  `form:"depth"`
+		Limit        int32  `form:"limit"`
+		Continuation string `form:"continuation"`
 	}
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !bindQuery(c, &req) {
 		return
 	}
 
-	msg := &proto.CreateSubredditMsg{
-		Name:        req.Name,
-		Description: req.Description,
-		CreatorId:   req.CreatorId,
+	msg := &proto.GetPostCommentsMsg{
+		PostId:       c.Param("id"),
+		ViewerId:     caller(c),
+		Sort:         req.Sort,
+		Depth:        req.Depth,
+		Limit:        req.Limit,
+		Continuation: req.Continuation,
 	}
-
-	system.Root.Send(enginePID, msg)
-	c.JSON(http.StatusOK, gin.H{"message": "Subreddit created"})
+	result, ok := requestEngine(c, client, msg)
+	if !ok {
+		return
+	}
+	resp := result.(*proto.GetPostCommentsResponse)
+	c.JSON(http.StatusOK, gin.H{"comments": resp.Comments, "more": resp.More})
 }
 
-func CreateCommentHandler(c *gin.Context, system *actor.ActorSystem, enginePID *actor.PID) {
+func CreateCommentHandler(c *gin.Context, client *engine.Client) {
 	var req struct {
-		Content string `json:"username"`
+		Content  string `json:"content"`
+		ParentId string `json:"parent_id"`
 	}
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !bindJSON(c, &req) {
 		return
 	}
 
 	msg := &proto.CreateCommentMsg{
-		PostId:   "Simulated Post Title",
+		PostId:   c.Param("id"),
 		Content:  req.Content,
-		AuthorId: "Simulated AuthorId",
-		ParentId: "Simulated ParentId",
+		AuthorId: caller(c),
+		ParentId: req.ParentId,
+	}
+	result, ok := requestEngine(c, client, msg)
+	if !ok {
+		return
+	}
+	resp := result.(*proto.CreateCommentResponse)
+	c.JSON(http.StatusCreated, gin.H{"message": "Comment created", "comment_id": resp.CommentId})
+}
+
+func EditCommentHandler(c *gin.Context, client *engine.Client) {
+	var req struct {
+		Content string `json:"content"`
+	}
+	if !bindJSON(c, &req) {
+		return
 	}
 
-	system.Root.Send(enginePID, msg)
-	c.JSON(http.StatusOK, gin.H{"message": "Comment created"})
+	msg := &proto.EditCommentMsg{
+		CommentId: c.Param("id"),
+		UserId:    caller(c),
+		Content:   req.Content,
+	}
+	result, ok := requestEngine(c, client, msg)
+	if !ok {
+		return
+	}
+	resp := result.(*proto.EditCommentResponse)
+	c.JSON(http.StatusOK, gin.H{"message": "Comment edited", "comment_id": resp.CommentId, "edited_at": resp.EditedAt})
+}
+
+func DeleteCommentHandler(c *gin.Context, client *engine.Client) {
+	result, ok := requestEngine(c, client, &proto.DeleteCommentMsg{CommentId: c.Param("id"), UserId: caller(c)})
+	if !ok {
+		return
+	}
+	resp := result.(*proto.DeleteCommentResponse)
+	c.JSON(http.StatusOK, gin.H{"message": "Comment deleted", "comment_id": resp.CommentId})
+}
+
+// voteDirections maps the direction of a vote request to the engine's.
+var voteDirections = map[string]proto.VoteDirection{
+	"up":    proto.VoteDirection_VOTE_UP,
+	"down":  proto.VoteDirection_VOTE_DOWN,
+	"clear": proto.VoteDirection_VOTE_CLEAR,
+}
+
+func VoteHandler(c *gin.Context, client *engine.Client) {
+	var req struct {
+		TargetId  string `json:"target_id"`
+		Direction string `json:"direction"`
+	}
+	if !bindJSON(c, &req) {
+		return
+	}
+	direction, known := voteDirections[req.Direction]
+	if !known {
+		writeError(c, http.StatusBadRequest, engine.ErrCodeInvalidArgument, `direction must be "up", "down" or "clear"`)
+		return
+	}
+
+	result, ok := requestEngine(c, client, &proto.VoteMsg{UserId: caller(c), TargetId: req.TargetId, Direction: direction})
+	if !ok {
+		return
+	}
+	resp := result.(*proto.VoteResponse)
+	c.JSON(http.StatusOK, gin.H{
+		"target_id": resp.TargetId,
+		"upvotes":   resp.Upvotes,
+		"downvotes": resp.Downvotes,
+		"direction": req.Direction,
+	})
 }
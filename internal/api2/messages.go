@@ -0,0 +1,167 @@
+// internal/api2/messages.go
+package api2
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kakugri/redditClone/internal/engine"
+	"github.com/kakugri/redditClone/internal/proto"
+)
+
+// messagePage is the query of a page of messages or conversations.
+type messagePage struct {
+	Cursor string `form:"cursor"`
+	Limit  int32  `form:"limit"`
+}
+
+// readRequest is the body of a request to mark messages read or unread.
+type readRequest struct {
+	Read *bool `json:"read"`
+}
+
+func (r *readRequest) bind(c *gin.Context) bool {
+	if !bindJSON(c, r) {
+		return false
+	}
+	if r.Read == nil {
+		writeError(c, http.StatusBadRequest, engine.ErrCodeInvalidArgument, "read is required")
+		return false
+	}
+	return true
+}
+
+func SendMessageHandler(c *gin.Context, client *engine.Client) {
+	var req struct {
+		ToUserId  string `json:"to_user_id"`
+		Content   string `json:"content"`
+		ReplyToId string `json:"reply_to_id"`
+	}
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	msg := &proto.DirectMessageMsg{
+		FromUserId: caller(c),
+		ToUserId:   req.ToUserId,
+		Content:    req.Content,
+		ReplyToId:  req.ReplyToId,
+	}
+	result, ok := requestEngine(c, client, msg)
+	if !ok {
+		return
+	}
+	resp := result.(*proto.DirectMessageResponse)
+	c.JSON(http.StatusCreated, gin.H{"message": "Message sent", "message_id": resp.MessageId})
+}
+
+// GetMessagesHandler lists the user's messages in a folder: inbox (default),
+// sent or all.
+func GetMessagesHandler(c *gin.Context, client *engine.Client) {
+	var req struct {
+		messagePage
+		Folder string `form:"folder"`
+		Unread bool   `form:"unread"`
+	}
+	if !requireSelf(c, c.Param("id")) || !bindQuery(c, &req) {
+		return
+	}
+
+	msg := &proto.GetMessagesMsg{
+		UserId:     c.Param("id"),
+		Folder:     req.Folder,
+		UnreadOnly: req.Unread,
+		Cursor:     req.Cursor,
+		Limit:      req.Limit,
+	}
+	writeMessages(c, client, msg)
+}
+
+func GetConversationsHandler(c *gin.Context, client *engine.Client) {
+	var req messagePage
+	if !requireSelf(c, c.Param("id")) || !bindQuery(c, &req) {
+		return
+	}
+
+	msg := &proto.GetConversationsMsg{UserId: c.Param("id"), Cursor: req.Cursor, Limit: req.Limit}
+	result, ok := requestEngine(c, client, msg)
+	if !ok {
+		return
+	}
+	resp := result.(*proto.GetConversationsResponse)
+	c.JSON(http.StatusOK, gin.H{
+		"conversations": resp.Conversations,
+		"next_cursor":   resp.NextCursor,
+		"unread_count":  resp.UnreadCount,
+	})
+}
+
+// GetConversationHandler lists the messages the user exchanged with another,
+// both ways.
+func GetConversationHandler(c *gin.Context, client *engine.Client) {
+	var req messagePage
+	if !requireSelf(c, c.Param("id")) || !bindQuery(c, &req) {
+		return
+	}
+
+	msg := &proto.GetMessagesMsg{
+		UserId:      c.Param("id"),
+		Folder:      "all",
+		OtherUserId: c.Param("user_id"),
+		Cursor:      req.Cursor,
+		Limit:       req.Limit,
+	}
+	writeMessages(c, client, msg)
+}
+
+func writeMessages(c *gin.Context, client *engine.Client, msg *proto.GetMessagesMsg) {
+	result, ok := requestEngine(c, client, msg)
+	if !ok {
+		return
+	}
+	resp := result.(*proto.GetMessagesResponse)
+	c.JSON(http.StatusOK, gin.H{
+		"messages":     resp.Messages,
+		"next_cursor":  resp.NextCursor,
+		"unread_count": resp.UnreadCount,
+	})
+}
+
+// MarkConversationReadHandler marks every message the user received from
+// another read or unread.
+func MarkConversationReadHandler(c *gin.Context, client *engine.Client) {
+	var req readRequest
+	if !requireSelf(c, c.Param("id")) || !req.bind(c) {
+		return
+	}
+	writeMarkRead(c, client, &proto.MarkReadMsg{UserId: caller(c), OtherUserId: c.Param("user_id"), Read: *req.Read})
+}
+
+// MarkMessageReadHandler marks a message the caller received read or unread.
+func MarkMessageReadHandler(c *gin.Context, client *engine.Client) {
+	var req readRequest
+	if !req.bind(c) {
+		return
+	}
+	writeMarkRead(c, client, &proto.MarkReadMsg{UserId: caller(c), MessageId: c.Param("id"), Read: *req.Read})
+}
+
+func writeMarkRead(c *gin.Context, client *engine.Client, msg *proto.MarkReadMsg) {
+	result, ok := requestEngine(c, client, msg)
+	if !ok {
+		return
+	}
+	resp := result.(*proto.MarkReadResponse)
+	c.JSON(http.StatusOK, gin.H{"updated": resp.Updated, "unread_count": resp.UnreadCount})
+}
+
+// DeleteMessageHandler deletes the caller's copy of a message; the other
+// participant keeps theirs.
+func DeleteMessageHandler(c *gin.Context, client *engine.Client) {
+	result, ok := requestEngine(c, client, &proto.DeleteMessageMsg{MessageId: c.Param("id"), UserId: caller(c)})
+	if !ok {
+		return
+	}
+	resp := result.(*proto.DeleteMessageResponse)
+	c.JSON(http.StatusOK, gin.H{"message": "Message deleted", "message_id": resp.MessageId})
+}
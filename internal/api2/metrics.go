@@ -0,0 +1,52 @@
+// internal/api2/metrics.go
+package api2
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "reddit",
+		Subsystem: "api",
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by method, route and status code.",
+	}, []string{"method", "route", "code"})
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "reddit",
+		Subsystem: "api",
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to answer HTTP requests, by method and route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+	httpInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "reddit",
+		Subsystem: "api",
+		Name:      "http_requests_in_flight",
+		Help:      "HTTP requests being handled.",
+	})
+)
+
+// recordMetrics counts and times every request. Requests are labelled with
+// the route that matched them rather than their path, so IDs in paths do not
+// create a series each.
+func recordMetrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		httpInFlight.Inc()
+		c.Next()
+		httpInFlight.Dec()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		httpRequests.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		httpDuration.WithLabelValues(c.Request.Method, route).Observe(time.Since(start).Seconds())
+	}
+}
@@ -0,0 +1,304 @@
+// internal/api2/moderation.go
+package api2
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kakugri/redditClone/internal/engine"
+	"github.com/kakugri/redditClone/internal/proto"
+)
+
+// secondsPerDay converts the duration of a ban or mute, which clients give in
+// days, to the engine's seconds.
+const secondsPerDay = 24 * 60 * 60
+
+// userExists answers 404 unless the user with id is registered, for requests
+// that name a user the engine does not look up itself.
+func userExists(c *gin.Context, client *engine.Client, id string) bool {
+	_, ok := requestEngine(c, client, &proto.GetUserMsg{UserId: id})
+	return ok
+}
+
+func AddModeratorHandler(c *gin.Context, client *engine.Client) {
+	var req struct {
+		UserId string `json:"user_id"`
+	}
+	if !bindJSON(c, &req) {
+		return
+	}
+	id, ok := subredditID(c, client)
+	if !ok || !userExists(c, client, req.UserId) {
+		return
+	}
+
+	msg := &proto.AddModeratorMsg{SubredditId: id, UserId: caller(c), ModeratorId: req.UserId}
+	result, ok := requestEngine(c, client, msg)
+	if !ok {
+		return
+	}
+	resp := result.(*proto.ModeratorsResponse)
+	c.JSON(http.StatusCreated, gin.H{"message": "Moderator added", "subreddit_id": resp.SubredditId, "moderator_ids": resp.ModeratorIds})
+}
+
+func RemoveModeratorHandler(c *gin.Context, client *engine.Client) {
+	id, ok := subredditID(c, client)
+	if !ok {
+		return
+	}
+
+	msg := &proto.RemoveModeratorMsg{SubredditId: id, UserId: caller(c), ModeratorId: c.Param("user_id")}
+	result, ok := requestEngine(c, client, msg)
+	if !ok {
+		return
+	}
+	resp := result.(*proto.ModeratorsResponse)
+	c.JSON(http.StatusOK, gin.H{"message": "Moderator removed", "subreddit_id": resp.SubredditId, "moderator_ids": resp.ModeratorIds})
+}
+
+// RestrictUserHandler bans or mutes a user in the subreddit, for duration_days
+// or, if that is 0, until a moderator lifts it.
+func RestrictUserHandler(restriction proto.Restriction, done string) func(*gin.Context, *engine.Client) {
+	return func(c *gin.Context, client *engine.Client) {
+		var req struct {
+			UserId       string `json:"user_id"`
+			DurationDays int64  `json:"duration_days"`
+			Reason       string `json:"reason"`
+		}
+		if !bindJSON(c, &req) {
+			return
+		}
+		// Checked here, before the days are converted to seconds
+		if req.DurationDays < 0 || req.DurationDays > engine.MaxRestrictionDays {
+			problem := fmt.Sprintf("must be 0 to %d", engine.MaxRestrictionDays)
+			writeError(c, http.StatusBadRequest, engine.ErrCodeInvalidArgument, "invalid request: duration_days: "+problem,
+				&proto.FieldViolation{Field: "duration_days", Description: problem})
+			return
+		}
+		id, ok := subredditID(c, client)
+		if !ok || !userExists(c, client, req.UserId) {
+			return
+		}
+
+		msg := &proto.RestrictUserMsg{
+			SubredditId:     id,
+			UserId:          caller(c),
+			TargetUserId:    req.UserId,
+			Restriction:     restriction,
+			DurationSeconds: req.DurationDays * secondsPerDay,
+			Reason:          req.Reason,
+		}
+		result, ok := requestEngine(c, client, msg)
+		if !ok {
+			return
+		}
+		resp := result.(*proto.RestrictUserResponse)
+		c.JSON(http.StatusCreated, gin.H{
+			"message":      done,
+			"subreddit_id": resp.SubredditId,
+			"user_id":      resp.TargetUserId,
+			"expires_at":   resp.ExpiresAt,
+		})
+	}
+}
+
+// LiftRestrictionHandler lifts a ban or mute before it expires.
+func LiftRestrictionHandler(restriction proto.Restriction, done string) func(*gin.Context, *engine.Client) {
+	return func(c *gin.Context, client *engine.Client) {
+		id, ok := subredditID(c, client)
+		if !ok {
+			return
+		}
+
+		msg := &proto.LiftRestrictionMsg{
+			SubredditId:  id,
+			UserId:       caller(c),
+			TargetUserId: c.Param("user_id"),
+			Restriction:  restriction,
+		}
+		result, ok := requestEngine(c, client, msg)
+		if !ok {
+			return
+		}
+		resp := result.(*proto.LiftRestrictionResponse)
+		c.JSON(http.StatusOK, gin.H{"message": done, "subreddit_id": resp.SubredditId, "user_id": resp.TargetUserId})
+	}
+}
+
+// removalRequest is the body of a request to remove a post or comment.
+type removalRequest struct {
+	Reason string `json:"reason"`
+}
+
+func RemovePostHandler(c *gin.Context, client *engine.Client) {
+	var req removalRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	result, ok := requestEngine(c, client, &proto.RemovePostMsg{PostId: c.Param("id"), UserId: caller(c), Reason: req.Reason})
+	if !ok {
+		return
+	}
+	resp := result.(*proto.RemovePostResponse)
+	c.JSON(http.StatusOK, gin.H{"message": "Post removed", "post_id": resp.PostId})
+}
+
+func RemoveCommentHandler(c *gin.Context, client *engine.Client) {
+	var req removalRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	result, ok := requestEngine(c, client, &proto.RemoveCommentMsg{CommentId: c.Param("id"), UserId: caller(c), Reason: req.Reason})
+	if !ok {
+		return
+	}
+	resp := result.(*proto.RemoveCommentResponse)
+	c.JSON(http.StatusOK, gin.H{"message": "Comment removed", "comment_id": resp.CommentId})
+}
+
+func GetModLogHandler(c *gin.Context, client *engine.Client) {
+	var req struct {
+		Limit int32 `form:"limit"`
+	}
+	if !bindQuery(c, &req) {
+		return
+	}
+	id, ok := subredditID(c, client)
+	if !ok {
+		return
+	}
+
+	result, ok := requestEngine(c, client, &proto.GetModLogMsg{SubredditId: id, UserId: caller(c), Limit: req.Limit})
+	if !ok {
+		return
+	}
+	resp := result.(*proto.GetModLogResponse)
+	c.JSON(http.StatusOK, gin.H{"actions": resp.Actions})
+}
+
+func SetRulesHandler(c *gin.Context, client *engine.Client) {
+	var req struct {
+		Rules []string `json:"rules"`
+	}
+	if !bindJSON(c, &req) {
+		return
+	}
+	id, ok := subredditID(c, client)
+	if !ok {
+		return
+	}
+
+	result, ok := requestEngine(c, client, &proto.SetRulesMsg{SubredditId: id, UserId: caller(c), Rules: req.Rules})
+	if !ok {
+		return
+	}
+	resp := result.(*proto.SetRulesResponse)
+	c.JSON(http.StatusOK, gin.H{"message": "Rules set", "subreddit_id": resp.SubredditId, "rules": resp.Rules})
+}
+
+func ReportHandler(c *gin.Context, client *engine.Client) {
+	var req struct {
+		TargetId string `json:"target_id"`
+		Rule     int32  `json:"rule"`
+		Reason   string `json:"reason"`
+	}
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	msg := &proto.ReportMsg{TargetId: req.TargetId, UserId: caller(c), Rule: req.Rule, Reason: req.Reason}
+	result, ok := requestEngine(c, client, msg)
+	if !ok {
+		return
+	}
+	resp := result.(*proto.ReportResponse)
+	c.JSON(http.StatusCreated, gin.H{"message": "Reported", "target_id": resp.TargetId})
+}
+
+func GetModQueueHandler(c *gin.Context, client *engine.Client) {
+	var req struct {
+		Limit int32 `form:"limit"`
+	}
+	if !bindQuery(c, &req) {
+		return
+	}
+	id, ok := subredditID(c, client)
+	if !ok {
+		return
+	}
+
+	result, ok := requestEngine(c, client, &proto.GetModQueueMsg{SubredditId: id, UserId: caller(c), Limit: req.Limit})
+	if !ok {
+		return
+	}
+	resp := result.(*proto.GetModQueueResponse)
+	c.JSON(http.StatusOK, gin.H{"items": resp.Items})
+}
+
+// queueResolutions maps the resolution of a request to the engine's.
+var queueResolutions = map[string]proto.QueueResolution{
+	"approve": proto.QueueResolution_QUEUE_RESOLUTION_APPROVE,
+	"remove":  proto.QueueResolution_QUEUE_RESOLUTION_REMOVE,
+	"ignore":  proto.QueueResolution_QUEUE_RESOLUTION_IGNORE,
+}
+
+func ResolveReportsHandler(c *gin.Context, client *engine.Client) {
+	var req struct {
+		Resolution string `json:"resolution"`
+		Reason     string `json:"reason"`
+	}
+	if !bindJSON(c, &req) {
+		return
+	}
+	resolution, known := queueResolutions[req.Resolution]
+	if !known {
+		writeError(c, http.StatusBadRequest, engine.ErrCodeInvalidArgument, `resolution must be "approve", "remove" or "ignore"`)
+		return
+	}
+
+	msg := &proto.ResolveReportsMsg{TargetId: c.Param("target_id"), UserId: caller(c), Resolution: resolution, Reason: req.Reason}
+	result, ok := requestEngine(c, client, msg)
+	if !ok {
+		return
+	}
+	resp := result.(*proto.ResolveReportsResponse)
+	c.JSON(http.StatusOK, gin.H{"message": "Resolved", "target_id": resp.TargetId, "resolution": req.Resolution})
+}
+
+// SetAutomodHandler replaces the subreddit's AutoModerator configuration with
+// the YAML in the request body.
+func SetAutomodHandler(c *gin.Context, client *engine.Client) {
+	config, err := c.GetRawData()
+	if err != nil {
+		writeError(c, http.StatusBadRequest, engine.ErrCodeInvalidArgument, err.Error())
+		return
+	}
+	id, ok := subredditID(c, client)
+	if !ok {
+		return
+	}
+
+	result, ok := requestEngine(c, client, &proto.SetAutomodMsg{SubredditId: id, UserId: caller(c), Config: string(config)})
+	if !ok {
+		return
+	}
+	resp := result.(*proto.AutomodResponse)
+	c.JSON(http.StatusOK, gin.H{"message": "AutoModerator configured", "subreddit_id": resp.SubredditId, "rule_count": resp.RuleCount})
+}
+
+func GetAutomodHandler(c *gin.Context, client *engine.Client) {
+	id, ok := subredditID(c, client)
+	if !ok {
+		return
+	}
+
+	result, ok := requestEngine(c, client, &proto.GetAutomodMsg{SubredditId: id, UserId: caller(c)})
+	if !ok {
+		return
+	}
+	resp := result.(*proto.AutomodResponse)
+	c.JSON(http.StatusOK, gin.H{"subreddit_id": resp.SubredditId, "config": resp.Config, "rule_count": resp.RuleCount})
+}
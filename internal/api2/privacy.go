@@ -0,0 +1,86 @@
+// internal/api2/privacy.go
+package api2
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kakugri/redditClone/internal/engine"
+	"github.com/kakugri/redditClone/internal/proto"
+)
+
+func GetBlocksHandler(c *gin.Context, client *engine.Client) {
+	if !requireSelf(c, c.Param("id")) {
+		return
+	}
+
+	result, ok := requestEngine(c, client, &proto.GetBlocksMsg{UserId: caller(c)})
+	if !ok {
+		return
+	}
+	resp := result.(*proto.BlocksResponse)
+	c.JSON(http.StatusOK, gin.H{"blocked_user_ids": resp.BlockedUserIds})
+}
+
+// BlockUserHandler blocks or unblocks another user. A blocked user cannot
+// message the caller, and the caller no longer sees their posts and comments.
+func BlockUserHandler(block bool, done string) func(*gin.Context, *engine.Client) {
+	return func(c *gin.Context, client *engine.Client) {
+		if !requireSelf(c, c.Param("id")) {
+			return
+		}
+		if block && !userExists(c, client, c.Param("user_id")) {
+			return
+		}
+
+		msg := &proto.BlockUserMsg{UserId: caller(c), BlockedUserId: c.Param("user_id"), Block: block}
+		result, ok := requestEngine(c, client, msg)
+		if !ok {
+			return
+		}
+		resp := result.(*proto.BlocksResponse)
+		c.JSON(http.StatusOK, gin.H{"message": done, "blocked_user_ids": resp.BlockedUserIds})
+	}
+}
+
+func GetMessageSettingsHandler(c *gin.Context, client *engine.Client) {
+	if !requireSelf(c, c.Param("id")) {
+		return
+	}
+
+	result, ok := requestEngine(c, client, &proto.GetMessageSettingsMsg{UserId: caller(c)})
+	if !ok {
+		return
+	}
+	writeMessageSettings(c, result.(*proto.MessageSettingsResponse).Settings)
+}
+
+// SetMessageSettingsHandler limits who may message the caller to accounts at
+// least min_account_age_days old or users with at least min_karma karma.
+// Leaving both 0 accepts messages from anyone.
+func SetMessageSettingsHandler(c *gin.Context, client *engine.Client) {
+	var req struct {
+		MinAccountAgeDays int32 `json:"min_account_age_days"`
+		MinKarma          int32 `json:"min_karma"`
+	}
+	if !requireSelf(c, c.Param("id")) || !bindJSON(c, &req) {
+		return
+	}
+
+	msg := &proto.SetMessageSettingsMsg{
+		UserId:   caller(c),
+		Settings: &proto.MessageSettings{MinAccountAgeDays: req.MinAccountAgeDays, MinKarma: req.MinKarma},
+	}
+	result, ok := requestEngine(c, client, msg)
+	if !ok {
+		return
+	}
+	writeMessageSettings(c, result.(*proto.MessageSettingsResponse).Settings)
+}
+
+func writeMessageSettings(c *gin.Context, settings *proto.MessageSettings) {
+	c.JSON(http.StatusOK, gin.H{
+		"min_account_age_days": settings.GetMinAccountAgeDays(),
+		"min_karma":            settings.GetMinKarma(),
+	})
+}
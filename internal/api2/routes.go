@@ -4,39 +4,98 @@ package api2
 import (
 	"net/http"
 
-	"github.com/asynkron/protoactor-go/actor"
 	"github.com/gin-gonic/gin"
+	"github.com/kakugri/redditClone/internal/auth"
+	"github.com/kakugri/redditClone/internal/engine"
+	"github.com/kakugri/redditClone/internal/proto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-func SetupRouter(system *actor.ActorSystem, enginePID *actor.PID) *gin.Engine {
+// SetupRouter serves the REST API, version 1, under /api/v1. Its resources
+// are users, subreddits (addressed by name), posts, comments, votes and
+// direct messages, the moderation of subreddits, with reports, and users'
+// blocks and message settings; every error response has the same body,
+// written by writeError. Users log in at /sessions for a token signed by
+// tokens, which every change, and reading one's feed or inbox, requires; the changes
+// are made as the user the token identifies. Listings of posts and comments
+// take an optional token, and then leave out the users its user blocked.
+func SetupRouter(client *engine.Client, tokens *auth.Tokens) *gin.Engine {
 	router := gin.Default()
-	// router := gin.New()
-	// router.Use(gin.Logger(), gin.Recovery()) // Attach middleware explicitly
-	// gin.SetMode(gin.ReleaseMode)
+	router.Use(recordMetrics(), traceRequests())
 
-	router.POST("/api/register", func(c *gin.Context) {
-		RegisterUserHandler(c, system, enginePID)
-	})
-	router.GET("/api/register", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"message": "User registration initiated"})
-	})
-	router.POST("/api/posts", func(c *gin.Context) {
-		CreatePostHandler(c, system, enginePID)
-	})
-	router.GET("/api/posts", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"message": "Post creation initiated"})
-	})
-	router.POST("/api/newsubreddit", func(c *gin.Context) {
-		CreateSubredditHandler(c, system, enginePID)
-	})
-	router.POST("/api/comment", func(c *gin.Context) {
-		CreateCommentHandler(c, system, enginePID)
-	})
+	handle := func(handler func(*gin.Context, *engine.Client)) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			handler(c, client)
+		}
+	}
+
+	authed := requireUser(tokens)
+	identified := identifyUser(tokens)
+	v1 := router.Group("/api/v1")
+
+	v1.POST("/users", handle(RegisterUserHandler))
+	v1.POST("/sessions", handle(LoginHandler(tokens)))
+	v1.GET("/users/:id", handle(GetUserHandler))
+	v1.GET("/users/:id/karma", handle(GetUserKarmaHandler))
+	v1.GET("/users/:id/subreddits", handle(GetUserSubredditsHandler))
+	v1.GET("/users/:id/feed", authed, handle(GetFeedHandler))
+	v1.GET("/users/:id/messages", authed, handle(GetMessagesHandler))
+	v1.GET("/users/:id/conversations", authed, handle(GetConversationsHandler))
+	v1.GET("/users/:id/conversations/:user_id", authed, handle(GetConversationHandler))
+	v1.PATCH("/users/:id/conversations/:user_id", authed, handle(MarkConversationReadHandler))
+	v1.GET("/users/:id/blocks", authed, handle(GetBlocksHandler))
+	v1.PUT("/users/:id/blocks/:user_id", authed, handle(BlockUserHandler(true, "User blocked")))
+	v1.DELETE("/users/:id/blocks/:user_id", authed, handle(BlockUserHandler(false, "User unblocked")))
+	v1.GET("/users/:id/message_settings", authed, handle(GetMessageSettingsHandler))
+	v1.PUT("/users/:id/message_settings", authed, handle(SetMessageSettingsHandler))
+
+	v1.POST("/subreddits", authed, handle(CreateSubredditHandler))
+	v1.GET("/subreddits/:name", handle(GetSubredditHandler))
+	v1.GET("/subreddits/:name/posts", identified, handle(GetSubredditPostsHandler))
+	v1.POST("/subreddits/:name/posts", authed, handle(CreatePostHandler))
+	v1.POST("/subreddits/:name/members", authed, handle(JoinSubredditHandler))
+	v1.DELETE("/subreddits/:name/members/:user_id", authed, handle(LeaveSubredditHandler))
+	v1.POST("/subreddits/:name/moderators", authed, handle(AddModeratorHandler))
+	v1.DELETE("/subreddits/:name/moderators/:user_id", authed, handle(RemoveModeratorHandler))
+	v1.POST("/subreddits/:name/bans", authed, handle(RestrictUserHandler(proto.Restriction_RESTRICTION_BAN, "User banned")))
+	v1.DELETE("/subreddits/:name/bans/:user_id", authed, handle(LiftRestrictionHandler(proto.Restriction_RESTRICTION_BAN, "User unbanned")))
+	v1.POST("/subreddits/:name/mutes", authed, handle(RestrictUserHandler(proto.Restriction_RESTRICTION_MUTE, "User muted")))
+	v1.DELETE("/subreddits/:name/mutes/:user_id", authed, handle(LiftRestrictionHandler(proto.Restriction_RESTRICTION_MUTE, "User unmuted")))
+	v1.GET("/subreddits/:name/modlog", authed, handle(GetModLogHandler))
+	v1.PUT("/subreddits/:name/rules", authed, handle(SetRulesHandler))
+	v1.GET("/subreddits/:name/modqueue", authed, handle(GetModQueueHandler))
+	v1.PUT("/subreddits/:name/automod", authed, handle(SetAutomodHandler))
+	v1.GET("/subreddits/:name/automod", authed, handle(GetAutomodHandler))
+
+	v1.GET("/posts/:id", handle(GetPostHandler))
+	v1.PATCH("/posts/:id", authed, handle(EditPostHandler))
+	v1.DELETE("/posts/:id", authed, handle(DeletePostHandler))
+	v1.GET("/posts/:id/comments", identified, handle(GetPostCommentsHandler))
+	v1.POST("/posts/:id/removal", authed, handle(RemovePostHandler))
+	v1.POST("/posts/:id/comments", authed, handle(CreateCommentHandler))
+
+	v1.PATCH("/comments/:id", authed, handle(EditCommentHandler))
+	v1.DELETE("/comments/:id", authed, handle(DeleteCommentHandler))
+	v1.POST("/comments/:id/removal", authed, handle(RemoveCommentHandler))
+
+	v1.POST("/votes", authed, handle(VoteHandler))
+
+	v1.POST("/reports", authed, handle(ReportHandler))
+	v1.POST("/reports/:target_id/resolution", authed, handle(ResolveReportsHandler))
+
+	v1.POST("/messages", authed, handle(SendMessageHandler))
+	v1.PATCH("/messages/:id", authed, handle(MarkMessageReadHandler))
+	v1.DELETE("/messages/:id", authed, handle(DeleteMessageHandler))
+
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
 	router.GET("/", func(c *gin.Context) {
-		c.JSON(200, gin.H{"message": "Welcome to the Reddit Clone API"})
+		c.JSON(http.StatusOK, gin.H{"message": "Welcome to the Reddit Clone API"})
 	})
 	router.GET("/favicon.ico", func(c *gin.Context) {
-		c.Status(204) // No Content
+		c.Status(http.StatusNoContent)
+	})
+	router.NoRoute(func(c *gin.Context) {
+		writeError(c, http.StatusNotFound, engine.ErrCodeNotFound, "no such endpoint: "+c.Request.Method+" "+c.Request.URL.Path)
 	})
 
 	return router
@@ -0,0 +1,61 @@
+// internal/auth/password.go
+
+// Package auth hashes the passwords users log in with and issues the tokens
+// that identify them to the REST API afterwards.
+package auth
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrWrongPassword is returned by CheckPassword if the password does not match.
+var ErrWrongPassword = errors.New("wrong password")
+
+// dummyHash is checked against when there is no hash to check, so a login
+// for an unknown user takes as long as one with a wrong password.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+
+// Limits on the length of passwords, in bytes. bcrypt ignores what comes after
+// the first 72.
+const (
+	MinPasswordLength = 8
+	MaxPasswordLength = 72
+)
+
+// CheckPasswordRules describes what is wrong with password as a new password,
+// or returns "" if nothing is.
+func CheckPasswordRules(password string) string {
+	if len(password) < MinPasswordLength || len(password) > MaxPasswordLength {
+		return fmt.Sprintf("must be %d to %d bytes long", MinPasswordLength, MaxPasswordLength)
+	}
+	return ""
+}
+
+// HashPassword returns the bcrypt hash of password, which must follow the
+// rules of CheckPasswordRules.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// CheckPassword returns nil if password is the one hashed to hash. An empty
+// hash, of a user who has no password, matches nothing.
+func CheckPassword(hash, password string) error {
+	if hash == "" {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return ErrWrongPassword
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return ErrWrongPassword
+		}
+		return err
+	}
+	return nil
+}
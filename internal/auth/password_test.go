@@ -0,0 +1,37 @@
+// internal/auth/password_test.go
+package auth
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckPassword(t *testing.T) {
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckPassword(hash, "correct horse"); err != nil {
+		t.Errorf("right password: got %v, want no error", err)
+	}
+	if err := CheckPassword(hash, "battery staple"); !errors.Is(err, ErrWrongPassword) {
+		t.Errorf("wrong password: got %v, want ErrWrongPassword", err)
+	}
+	// An unknown user has no hash; not even the dummy hash's password matches
+	if err := CheckPassword("", "not a password"); !errors.Is(err, ErrWrongPassword) {
+		t.Errorf("unknown user: got %v, want ErrWrongPassword", err)
+	}
+}
+
+func TestCheckPasswordRules(t *testing.T) {
+	for password, ok := range map[string]bool{
+		"short":                  false,
+		"12345678":               true,
+		string(make([]byte, 72)): true,
+		string(make([]byte, 73)): false,
+	} {
+		if got := CheckPasswordRules(password) == ""; got != ok {
+			t.Errorf("password of %d bytes: accepted %v, want %v", len(password), got, ok)
+		}
+	}
+}
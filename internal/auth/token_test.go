@@ -0,0 +1,96 @@
+// internal/auth/token_test.go
+package auth
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestTokens returns Tokens whose clock reads *now.
+func newTestTokens(secret string, now *time.Time) *Tokens {
+	tokens := NewTokens([]byte(secret), time.Hour)
+	tokens.now = func() time.Time { return *now }
+	return tokens
+}
+
+func encodeSegment(s string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+func TestTokenRoundTrip(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tokens := newTestTokens("secret", &now)
+	token, expires, err := tokens.Issue("t2_user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !expires.Equal(now.Add(time.Hour)) {
+		t.Errorf("expires at %v, want %v", expires, now.Add(time.Hour))
+	}
+	if userID, err := tokens.Verify(token); err != nil || userID != "t2_user" {
+		t.Errorf("Verify got %q, %v, want t2_user", userID, err)
+	}
+}
+
+func TestTokenRejected(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tokens := newTestTokens("secret", &now)
+	token, _, err := tokens.Issue("t2_user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(token, ".")
+	otherPayload := encodeSegment(`{"sub":"t2_admin","iat":1700000000,"exp":1700003600}`)
+	// resigned is a token with header, signed with the secret as if any
+	// algorithm it names were accepted
+	resigned := func(header string) string {
+		signed := encodeSegment(header) + "." + parts[1]
+		return signed + "." + tokens.sign(signed)
+	}
+	otherSecret, _, err := newTestTokens("other secret", &now).Issue("t2_user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	flipped := []byte(parts[2])
+	flipped[0] ^= 1
+
+	tests := []struct {
+		name, token string
+	}{
+		{"tampered payload", parts[0] + "." + otherPayload + "." + parts[2]},
+		{"tampered signature", parts[0] + "." + parts[1] + "." + string(flipped)},
+		{"alg none", encodeSegment(`{"alg":"none","typ":"JWT"}`) + "." + parts[1] + "."},
+		{"alg none signed", resigned(`{"alg":"none","typ":"JWT"}`)},
+		{"alg HS512", resigned(`{"alg":"HS512","typ":"JWT"}`)},
+		{"another secret", otherSecret},
+		{"two segments", parts[0] + "." + parts[1]},
+		{"four segments", token + "." + parts[2]},
+		{"empty", ""},
+	}
+	for _, tt := range tests {
+		if userID, err := tokens.Verify(tt.token); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: got %q, %v, want ErrInvalidToken", tt.name, userID, err)
+		}
+	}
+}
+
+func TestTokenExpires(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tokens := newTestTokens("secret", &now)
+	token, expires, err := tokens.Issue("t2_user")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now = expires.Add(-time.Second)
+	if _, err := tokens.Verify(token); err != nil {
+		t.Errorf("a second before it expires: got %v, want no error", err)
+	}
+	now = expires
+	if _, err := tokens.Verify(token); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("when it expires: got %v, want ErrTokenExpired", err)
+	}
+}
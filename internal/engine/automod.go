@@ -0,0 +1,594 @@
+// internal/engine/automod.go
+package engine
+
+import (
+	"errors"
+	"io"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/asynkron/protoactor-go/actor"
+	"github.com/kakugri/redditClone/internal/idgen"
+	"github.com/kakugri/redditClone/internal/proto"
+	"gopkg.in/yaml.v3"
+)
+
+// automoderatorID is the author of AutoModerator's replies and the moderator
+// its actions are logged under. Nobody can register the name.
+const automoderatorID = "AutoModerator"
+
+// Limits on an AutoModerator configuration.
+const (
+	maxAutomodLength = 20000 // bytes
+	maxAutomodRules  = 100
+	maxFlairLength   = 64
+)
+
+// The actions a rule can take on what it matches.
+const (
+	automodFilter  = "filter" // remove it until a moderator approves it
+	automodRemove  = "remove"
+	automodApprove = "approve"
+)
+
+// automodSpec is one rule of an AutoModerator configuration as moderators
+// write it, one YAML document per rule. Numbers are compared as "< 7",
+// ">= 100" and so on; a bare number means at least that much.
+type automodSpec struct {
+	Name           string
+	Type           string // "post", "comment" or "any", the default
+	TitleRegex     string
+	BodyRegex      string
+	AccountAgeDays string
+	Karma          string
+	Domains        []string
+	Reports        string
+	Action         string
+	ActionReason   string
+	SetFlair       *string
+	SetLocked      *bool
+	Comment        string // replied to what the rule matches
+}
+
+// fields maps the keys of a rule document to what they decode into.
+func (s *automodSpec) fields() map[string]interface{} {
+	return map[string]interface{}{
+		"name":             &s.Name,
+		"type":             &s.Type,
+		"title_regex":      &s.TitleRegex,
+		"body_regex":       &s.BodyRegex,
+		"account_age_days": &s.AccountAgeDays,
+		"karma":            &s.Karma,
+		"domains":          &s.Domains,
+		"reports":          &s.Reports,
+		"action":           &s.Action,
+		"action_reason":    &s.ActionReason,
+		"set_flair":        &s.SetFlair,
+		"set_locked":       &s.SetLocked,
+		"comment":          &s.Comment,
+	}
+}
+
+// automodComparison is a condition on a number, such as "< 7".
+type automodComparison struct {
+	op    string
+	value int
+}
+
+var comparisonPattern = regexp.MustCompile(`^\s*(<=|>=|==|<|>|=)?\s*(\d+)\s*$`)
+
+func parseComparison(s string) (*automodComparison, bool) {
+	m := comparisonPattern.FindStringSubmatch(s)
+	if m == nil {
+		return nil, false
+	}
+	value, err := strconv.Atoi(m[2])
+	if err != nil {
+		return nil, false
+	}
+	op := m[1]
+	if op == "" {
+		op = ">="
+	}
+	return &automodComparison{op: op, value: value}, true
+}
+
+// holds reports whether n satisfies the comparison. A nil comparison always
+// holds.
+func (c *automodComparison) holds(n int) bool {
+	if c == nil {
+		return true
+	}
+	switch c.op {
+	case "<":
+		return n < c.value
+	case "<=":
+		return n <= c.value
+	case ">":
+		return n > c.value
+	case ">=":
+		return n >= c.value
+	}
+	return n == c.value
+}
+
+// automodRule is a rule of a subreddit's AutoModerator, ready to be checked.
+type automodRule struct {
+	reason     string // given for what the rule does
+	kinds      string // "post", "comment" or "any"
+	title      *regexp.Regexp
+	body       *regexp.Regexp
+	accountAge *automodComparison // in days
+	karma      *automodComparison
+	domains    []string
+	reports    *automodComparison
+	action     string
+	flair      *string
+	locked     *bool
+	comment    string
+}
+
+// automod is the configuration of a subreddit's AutoModerator and the rules
+// compiled from it.
+type automod struct {
+	config string
+	rules  []*automodRule
+}
+
+// compileAutomod parses and checks an AutoModerator configuration.
+func compileAutomod(config string) ([]*automodRule, *proto.Error) {
+	var v violations
+	if len(config) > maxAutomodLength {
+		v.add("config", "must be at most %d bytes long", maxAutomodLength)
+		return nil, v.err()
+	}
+
+	var rules []*automodRule
+	decoder := yaml.NewDecoder(strings.NewReader(config))
+	for {
+		var doc yaml.Node
+		err := decoder.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			v.add("config", "is not valid YAML: %s", strings.TrimPrefix(err.Error(), "yaml: "))
+			return nil, v.err()
+		}
+		if len(doc.Content) == 0 || doc.Content[0].Tag == "!!null" {
+			continue // an empty document
+		}
+		field := "config[" + strconv.Itoa(len(rules)) + "]"
+		if len(rules) == maxAutomodRules {
+			v.add("config", "must have at most %d rules", maxAutomodRules)
+			return nil, v.err()
+		}
+		rules = append(rules, compileAutomodRule(&v, field, doc.Content[0], len(rules)))
+	}
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// compileAutomodRule compiles the rule in node, recording what is wrong with
+// it in v.
+func compileAutomodRule(v *violations, field string, node *yaml.Node, index int) *automodRule {
+	if node.Kind != yaml.MappingNode {
+		v.add(field, "must be a mapping of conditions and actions")
+		return nil
+	}
+	var spec automodSpec
+	fields := spec.fields()
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i].Value, node.Content[i+1]
+		target, known := fields[key]
+		if !known {
+			v.add(field+"."+key, "is not a known condition or action")
+			continue
+		}
+		if err := value.Decode(target); err != nil {
+			switch target.(type) {
+			case *[]string:
+				v.add(field+"."+key, "must be a list of strings")
+			case **bool:
+				v.add(field+"."+key, "must be true or false")
+			default:
+				v.add(field+"."+key, "must be a string")
+			}
+		}
+	}
+
+	rule := &automodRule{
+		reason:  spec.ActionReason,
+		kinds:   spec.Type,
+		action:  spec.Action,
+		flair:   spec.SetFlair,
+		locked:  spec.SetLocked,
+		comment: spec.Comment,
+	}
+	if rule.reason == "" {
+		rule.reason = spec.Name
+	}
+	if rule.reason == "" {
+		rule.reason = "rule " + strconv.Itoa(index+1)
+	}
+	v.text(field+".name", spec.Name, false, maxReasonLength)
+	v.text(field+".action_reason", spec.ActionReason, false, maxReasonLength)
+	v.text(field+".comment", spec.Comment, false, maxCommentLength)
+
+	switch rule.kinds {
+	case "":
+		rule.kinds = "any"
+	case "post", "comment", "any":
+	default:
+		v.add(field+".type", `must be "post", "comment" or "any"`)
+	}
+	rule.title = compileAutomodRegex(v, field+".title_regex", spec.TitleRegex)
+	rule.body = compileAutomodRegex(v, field+".body_regex", spec.BodyRegex)
+	rule.accountAge = compileComparison(v, field+".account_age_days", spec.AccountAgeDays)
+	rule.karma = compileComparison(v, field+".karma", spec.Karma)
+	rule.reports = compileComparison(v, field+".reports", spec.Reports)
+	for i, domain := range spec.Domains {
+		domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+		if domain == "" || strings.ContainsAny(domain, "/: ") {
+			v.add(field+".domains["+strconv.Itoa(i)+"]", "must be a domain name such as example.com")
+			continue
+		}
+		rule.domains = append(rule.domains, domain)
+	}
+
+	switch rule.action {
+	case "", automodFilter, automodRemove, automodApprove:
+	default:
+		v.add(field+".action", `must be "filter", "remove" or "approve"`)
+	}
+	if rule.flair != nil {
+		v.text(field+".set_flair", *rule.flair, false, maxFlairLength)
+	}
+	if rule.reports != nil && (rule.accountAge != nil || rule.karma != nil) {
+		v.add(field+".reports", "cannot be combined with account_age_days or karma")
+	}
+	if rule.kinds != "post" {
+		if rule.title != nil {
+			v.add(field+".title_regex", `only posts have a title; set type to "post"`)
+		}
+		if rule.flair != nil {
+			v.add(field+".set_flair", `only posts have flair; set type to "post"`)
+		}
+	}
+	if rule.action == "" && rule.flair == nil && rule.locked == nil && rule.comment == "" {
+		v.add(field, "must have an action, set_flair, set_locked or comment")
+	}
+	return rule
+}
+
+func compileAutomodRegex(v *violations, field, pattern string) *regexp.Regexp {
+	if pattern == "" {
+		return nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		v.add(field, "is not a valid regular expression: %s", strings.TrimPrefix(err.Error(), "error parsing regexp: "))
+	}
+	return re
+}
+
+func compileComparison(v *violations, field, s string) *automodComparison {
+	if s == "" {
+		return nil
+	}
+	c, ok := parseComparison(s)
+	if !ok {
+		v.add(field, `must be a number, optionally after <, <=, >, >= or =, such as "< 7"`)
+	}
+	return c
+}
+
+// automodRules returns the compiled rules of subreddit's AutoModerator.
+func (e *RedditEngine) automodRules(subreddit *Subreddit) []*automodRule {
+	if subreddit.Automod == "" {
+		return nil
+	}
+	if cached, ok := e.automods[subreddit.ID]; ok && cached.config == subreddit.Automod {
+		return cached.rules
+	}
+	rules, err := compileAutomod(subreddit.Automod)
+	if err != nil {
+		// Configurations are checked when they are set
+		log.Printf("Invalid AutoModerator configuration in %s: %s", subreddit.ID, err.Message)
+		return nil
+	}
+	e.automods[subreddit.ID] = &automod{config: subreddit.Automod, rules: rules}
+	return rules
+}
+
+// automodAuthor is what the rules know of the author of a post or comment.
+type automodAuthor struct {
+	karma    int
+	joinDate time.Time
+	known    bool
+}
+
+// automodAuthor looks up the author of a post or comment being created. In
+// an engine cluster the author belongs to another grain, which sent info
+// along with the post or comment; asking it would block this grain on one
+// that asks this one. Conditions on an author that is not known never hold.
+func (e *RedditEngine) automodAuthor(authorID string, info *proto.UserInfo) automodAuthor {
+	if e.cluster == nil {
+		user, err := e.store.User(authorID)
+		if err != nil {
+			return automodAuthor{}
+		}
+		return automodAuthor{karma: user.Karma, joinDate: user.JoinDate, known: true}
+	}
+	if info == nil || info.Id != authorID {
+		return automodAuthor{}
+	}
+	return automodAuthor{karma: int(info.Karma), joinDate: time.Unix(info.JoinedAt, 0), known: true}
+}
+
+// linkPattern finds the host of the links in a title or body.
+var linkPattern = regexp.MustCompile(`(?i)https?://([a-z0-9.-]+)`)
+
+// linkDomains returns the hosts text links to, in lower case.
+func linkDomains(texts ...string) []string {
+	var domains []string
+	for _, text := range texts {
+		for _, m := range linkPattern.FindAllStringSubmatch(text, -1) {
+			domains = append(domains, strings.TrimSuffix(strings.ToLower(m[1]), "."))
+		}
+	}
+	return domains
+}
+
+// matchesDomain reports whether host is domain or one of its subdomains.
+func matchesDomain(host, domain string) bool {
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// automodTrigger is why the rules are checked: a post or comment was created,
+// or it was reported and its report count went from before to after.
+type automodTrigger struct {
+	reported      bool
+	before, after int
+}
+
+// matches reports whether the rule applies to target. author is looked up
+// the first time a condition needs it.
+func (r *automodRule) matches(target *reviewTarget, trigger automodTrigger, author func() automodAuthor, now time.Time) bool {
+	if r.kinds != "any" && r.kinds != target.kind {
+		return false
+	}
+	// A rule on reports acts once, when the count first meets it
+	if r.reports == nil {
+		if trigger.reported {
+			return false
+		}
+	} else if !trigger.reported || !r.reports.holds(trigger.after) || r.reports.holds(trigger.before) {
+		return false
+	}
+
+	title, body := target.post.Title, target.post.Content
+	if target.comment != nil {
+		title, body = "", target.comment.Content
+	}
+	if r.title != nil && !r.title.MatchString(title) {
+		return false
+	}
+	if r.body != nil && !r.body.MatchString(body) {
+		return false
+	}
+	if len(r.domains) > 0 && !r.linksTo(linkDomains(title, body)) {
+		return false
+	}
+	if r.accountAge != nil || r.karma != nil {
+		a := author()
+		if !a.known {
+			return false
+		}
+		if !r.accountAge.holds(int(now.Sub(a.joinDate).Hours() / 24)) {
+			return false
+		}
+		if !r.karma.holds(a.karma) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *automodRule) linksTo(hosts []string) bool {
+	for _, host := range hosts {
+		for _, domain := range r.domains {
+			if matchesDomain(host, domain) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// runAutomod applies the rules of subreddit's AutoModerator to target, which
+// the caller stores afterwards. What moderators post is left alone. author is
+// what the author's grain sent along with a new post or comment, or nil.
+//
+// The replies the rules write are counted on target's post but not stored:
+// the caller stores them with storeAutomodReplies after the post, and calls
+// automodRepliesCommitted once the command is committed.
+func (e *RedditEngine) runAutomod(subreddit *Subreddit, target *reviewTarget, trigger automodTrigger, author *proto.UserInfo) []*Comment {
+	rules := e.automodRules(subreddit)
+	if len(rules) == 0 {
+		return nil
+	}
+	authorID := target.post.AuthorID
+	if target.comment != nil {
+		authorID = target.comment.AuthorID
+	}
+	if subreddit.isModerator(authorID) {
+		return nil
+	}
+
+	var known *automodAuthor
+	lookup := func() automodAuthor {
+		if known == nil {
+			a := e.automodAuthor(authorID, author)
+			known = &a
+		}
+		return *known
+	}
+	now := e.now()
+	var replies []*Comment
+	for _, rule := range rules {
+		if !rule.matches(target, trigger, lookup, now) {
+			continue
+		}
+		log.Printf("AutoModerator rule %q matched %s", rule.reason, target.id)
+		if reply := e.applyAutomodRule(rule, subreddit.ID, target); reply != nil {
+			replies = append(replies, reply)
+		}
+	}
+	return replies
+}
+
+// applyAutomodRule does what rule says to target, logging each change under
+// AutoModerator, and returns the reply the rule writes, if any.
+func (e *RedditEngine) applyAutomodRule(rule *automodRule, subredditID string, target *reviewTarget) *Comment {
+	review := target.review()
+	isComment := target.comment != nil
+	switch rule.action {
+	case automodFilter:
+		target.setRemoved(true)
+		review.FilterReason = rule.reason
+		review.QueuedAt = e.now()
+		e.store.PutQueued(subredditID, target.id, true)
+		e.logModAction(subredditID, automoderatorID, modActionFor(isComment, modActionFilterComment, modActionFilterPost), target.id, rule.reason, time.Time{})
+	case automodRemove:
+		target.setRemoved(true)
+		e.dequeue(subredditID, target.id, review)
+		e.logModAction(subredditID, automoderatorID, modActionFor(isComment, modActionRemoveComment, modActionRemovePost), target.id, rule.reason, time.Time{})
+	case automodApprove:
+		target.setRemoved(false)
+		e.dequeue(subredditID, target.id, review)
+		e.logModAction(subredditID, automoderatorID, modActionFor(isComment, modActionApproveComment, modActionApprovePost), target.id, rule.reason, time.Time{})
+	}
+	if rule.flair != nil && !isComment {
+		target.post.Flair = *rule.flair
+		e.logModAction(subredditID, automoderatorID, modActionSetFlair, target.id, rule.reason, time.Time{})
+	}
+	if rule.locked != nil {
+		action := modActionFor(isComment, modActionLockComment, modActionLockPost)
+		if !*rule.locked {
+			action = modActionFor(isComment, modActionUnlockComment, modActionUnlockPost)
+		}
+		if isComment {
+			target.comment.Locked = *rule.locked
+		} else {
+			target.post.Locked = *rule.locked
+		}
+		e.logModAction(subredditID, automoderatorID, action, target.id, rule.reason, time.Time{})
+	}
+	if rule.comment == "" {
+		return nil
+	}
+	return e.automodReply(target, rule.comment)
+}
+
+// modActionFor picks the action logged for a comment or for a post.
+func modActionFor(isComment bool, commentAction, postAction string) string {
+	if isComment {
+		return commentAction
+	}
+	return postAction
+}
+
+// automodReply writes content as AutoModerator's reply to target and counts
+// it on target's post. A comment too deep to be replied to gets no reply.
+func (e *RedditEngine) automodReply(target *reviewTarget, content string) *Comment {
+	parentID, depth := "", 0
+	if target.comment != nil {
+		parentID, depth = target.comment.ID, target.comment.Depth+1
+	}
+	if depth > maxCommentDepth {
+		return nil
+	}
+
+	reply := &Comment{
+		ID:        e.extraID(idgen.KindComment),
+		Content:   content,
+		AuthorID:  automoderatorID,
+		PostID:    target.post.ID,
+		ParentID:  parentID,
+		Depth:     depth,
+		CreatedAt: e.now(),
+	}
+	reply.Scores = computeScores(0, 0, reply.CreatedAt)
+	target.post.CommentCount++
+	return reply
+}
+
+// storeAutomodReplies stores the replies runAutomod wrote. The store indexes
+// comments under their post, so the post is stored first.
+func (e *RedditEngine) storeAutomodReplies(replies []*Comment) {
+	for _, reply := range replies {
+		e.store.PutComment(reply)
+	}
+}
+
+// automodRepliesCommitted counts the replies of a committed command and, in
+// an engine cluster, registers them in the directory so they can be found by
+// ID. A reply that cannot be registered stays in its comment tree.
+func (e *RedditEngine) automodRepliesCommitted(subredditID string, replies []*Comment) {
+	if len(replies) == 0 {
+		return
+	}
+	if e.cluster != nil {
+		for _, reply := range replies {
+			if err := e.registerID(reply.ID, subredditID); err != nil {
+				log.Printf("AutoModerator's reply %s could not be registered: %s", reply.ID, err.Message)
+			}
+		}
+	}
+	e.updateMetrics(func(m *Metrics) {
+		m.TotalComments += int64(len(replies))
+	})
+}
+
+func (e *RedditEngine) handleSetAutomod(context actor.Context, msg *proto.SetAutomodMsg) {
+	subreddit, err := e.moderatedSubreddit(msg.SubredditId, msg.UserId)
+	var rules []*automodRule
+	if err == nil {
+		rules, err = compileAutomod(msg.Config)
+		err = e.check(err)
+	}
+	if err != nil {
+		respond(context, &proto.AutomodResponse{SubredditId: msg.SubredditId, Error: err})
+		return
+	}
+
+	subreddit.Automod = msg.Config
+	e.store.PutSubreddit(subreddit)
+	e.automods[subreddit.ID] = &automod{config: msg.Config, rules: rules}
+	e.logModAction(subreddit.ID, msg.UserId, modActionEditAutomod, subreddit.ID, "", time.Time{})
+	if err := e.commit(msg); err != nil {
+		respond(context, &proto.AutomodResponse{SubredditId: subreddit.ID, Error: err})
+		return
+	}
+	log.Printf("AutoModerator configured: SubredditID=%s, Rules=%d, By=%s", subreddit.ID, len(rules), msg.UserId)
+	respond(context, &proto.AutomodResponse{SubredditId: subreddit.ID, Config: subreddit.Automod, RuleCount: int32(len(rules))})
+}
+
+func (e *RedditEngine) handleGetAutomod(context actor.Context, msg *proto.GetAutomodMsg) {
+	subreddit, err := e.moderatedSubreddit(msg.SubredditId, msg.UserId)
+	if err != nil {
+		respond(context, &proto.AutomodResponse{SubredditId: msg.SubredditId, Error: err})
+		return
+	}
+	respond(context, &proto.AutomodResponse{
+		SubredditId: subreddit.ID,
+		Config:      subreddit.Automod,
+		RuleCount:   int32(len(e.automodRules(subreddit))),
+	})
+}
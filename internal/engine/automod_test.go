@@ -0,0 +1,252 @@
+// internal/engine/automod_test.go
+package engine
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/kakugri/redditClone/internal/proto"
+)
+
+// setAutomod configures the AutoModerator of the subreddit as moderatorID.
+func (te *testEngine) setAutomod(subredditID, moderatorID, config string) {
+	te.t.Helper()
+	resp := te.request(&proto.SetAutomodMsg{SubredditId: subredditID, UserId: moderatorID, Config: config}).(*proto.AutomodResponse)
+	if resp.Error != nil {
+		te.t.Fatalf("set AutoModerator of %s: %v", subredditID, resp.Error)
+	}
+}
+
+// setAuthor gives the user an account of age and karma.
+func setAuthor(t *testing.T, e *RedditEngine, userID string, age time.Duration, karma int) {
+	t.Helper()
+	user, err := e.store.User(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.JoinDate = time.Now().Add(-age)
+	user.Karma = karma
+	e.store.PutUser(user)
+	if err := e.store.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAutomodRejectsInvalidConfig(t *testing.T) {
+	te := startEngine(t, NewRedditEngine())
+	mod := te.registerUser("mod")
+	subredditID := te.createSubreddit("automod", mod)
+
+	tests := []struct {
+		name, config, field string
+	}{
+		{"unknown key", "colour: red\naction: remove", "config[0].colour"},
+		{"bad regex", "body_regex: '(spam'\naction: remove", "config[0].body_regex"},
+		{"bad comparison", "karma: 'lots'\naction: remove", "config[0].karma"},
+		{"unknown action", "action: explode", "config[0].action"},
+		{"no action", "body_regex: spam", "config[0]"},
+		{"title of a comment", "type: comment\ntitle_regex: spam\naction: remove", "config[0].title_regex"},
+		{"bad second rule", "action: remove\n---\naction: explode", "config[1].action"},
+		{"not YAML", "action: [remove", "config"},
+	}
+	for _, tt := range tests {
+		resp := te.request(&proto.SetAutomodMsg{SubredditId: subredditID, UserId: mod, Config: tt.config}).(*proto.AutomodResponse)
+		if resp.Error == nil || resp.Error.Code != ErrCodeInvalidArgument {
+			t.Errorf("%s: got %v, want %s", tt.name, resp.Error, ErrCodeInvalidArgument)
+			continue
+		}
+		if !slices.ContainsFunc(resp.Error.Fields, func(f *proto.FieldViolation) bool { return f.Field == tt.field }) {
+			t.Errorf("%s: got violations %v, want one of %s", tt.name, resp.Error.Fields, tt.field)
+		}
+	}
+
+	// A configuration that was rejected leaves the one before in place
+	te.setAutomod(subredditID, mod, "action: remove")
+	te.request(&proto.SetAutomodMsg{SubredditId: subredditID, UserId: mod, Config: "action: explode"})
+	resp := te.request(&proto.GetAutomodMsg{SubredditId: subredditID, UserId: mod}).(*proto.AutomodResponse)
+	if resp.Config != "action: remove" || resp.RuleCount != 1 {
+		t.Errorf("configuration after a rejected one: got %q with %d rules", resp.Config, resp.RuleCount)
+	}
+}
+
+func TestAutomodConditions(t *testing.T) {
+	e := NewRedditEngine()
+	te := startEngine(t, e)
+	mod := te.registerUser("mod")
+	newbie := te.registerUser("newbie")
+	veteran := te.registerUser("veteran")
+	setAuthor(t, e, veteran, 30*24*time.Hour, 100)
+
+	tests := []struct {
+		name, rule, author, title, content string
+		removed                            bool
+	}{
+		{"title matches", "type: post\ntitle_regex: (?i)free", newbie, "FREE stuff", "", true},
+		{"title does not match", "type: post\ntitle_regex: (?i)free", newbie, "paid stuff", "", false},
+		{"body matches", "body_regex: spam+", newbie, "title", "spammm", true},
+		{"body does not match", "body_regex: spam+", newbie, "title", "ham", false},
+		{"new account", "account_age_days: < 7", newbie, "title", "", true},
+		{"old account", "account_age_days: < 7", veteran, "title", "", false},
+		{"little karma", "karma: < 10", newbie, "title", "", true},
+		{"enough karma", "karma: < 10", veteran, "title", "", false},
+		{"linked domain", "domains: [example.com]", veteran, "title", "see https://www.example.com/x", true},
+		{"linked in the title", "domains: [example.com]", veteran, "http://example.com", "", true},
+		{"other domain", "domains: [example.com]", veteran, "title", "see https://notexample.com/x", false},
+		{"every condition", "body_regex: buy\nkarma: < 10", newbie, "title", "buy now", true},
+		{"not every condition", "body_regex: buy\nkarma: < 10", veteran, "title", "buy now", false},
+	}
+	for i, tt := range tests {
+		subredditID := te.createSubreddit(fmt.Sprintf("conditions%d", i), mod)
+		te.setAutomod(subredditID, mod, tt.rule+"\naction: remove")
+		resp := te.request(&proto.CreatePostMsg{SubredditId: subredditID, AuthorId: tt.author, Title: tt.title, Content: tt.content}).(*proto.CreatePostResponse)
+		if resp.Error != nil {
+			t.Fatalf("%s: %v", tt.name, resp.Error)
+		}
+		if got := te.post(resp.PostId).Removed; got != tt.removed {
+			t.Errorf("%s: removed %v, want %v", tt.name, got, tt.removed)
+		}
+	}
+}
+
+func TestAutomodActions(t *testing.T) {
+	e := NewRedditEngine()
+	te := startEngine(t, e)
+	mod := te.registerUser("mod")
+	author := te.registerUser("author")
+	reporter := te.registerUser("reporter")
+	subredditID := te.createSubreddit("actions", mod)
+	te.setAutomod(subredditID, mod, `
+type: post
+title_regex: filter
+action: filter
+action_reason: looks like spam
+---
+type: post
+title_regex: remove
+action: remove
+---
+type: post
+title_regex: flair
+set_flair: Question
+---
+title_regex: lock
+type: post
+set_locked: true
+---
+type: post
+title_regex: reply
+comment: Please read the rules.
+---
+reports: 1
+action: approve
+`)
+
+	filtered := te.createPost(subredditID, author, "filter me")
+	if post := te.post(filtered); !post.Removed {
+		t.Errorf("filtered post is not removed")
+	}
+	queue := te.modQueue(subredditID, mod)
+	if len(queue) != 1 || queue[0].Post.Id != filtered || queue[0].FilterReason != "looks like spam" {
+		t.Errorf("queue after a filter: got %v, want %s filtered as spam", queue, filtered)
+	}
+
+	removed := te.createPost(subredditID, author, "remove me")
+	if post := te.post(removed); !post.Removed {
+		t.Errorf("removed post is not removed")
+	}
+	if queue := te.modQueue(subredditID, mod); len(queue) != 1 {
+		t.Errorf("queue after a removal has %d items, want only the filtered post", len(queue))
+	}
+
+	flaired := te.createPost(subredditID, author, "flair me")
+	if post := te.post(flaired); post.Flair != "Question" || post.Removed {
+		t.Errorf("flaired post: got flair %q, removed %v", post.Flair, post.Removed)
+	}
+
+	// Reporting the post queues it, and the report rule approves it again
+	resp := te.request(&proto.ReportMsg{TargetId: flaired, UserId: reporter, Reason: "off topic"}).(*proto.ReportResponse)
+	if resp.Error != nil {
+		t.Fatal(resp.Error)
+	}
+	if queue := te.modQueue(subredditID, mod); len(queue) != 1 || queue[0].Post.Id != filtered {
+		t.Errorf("queue after an approval: got %v, want only the filtered post", queue)
+	}
+
+	locked := te.createPost(subredditID, author, "lock me")
+	if post := te.post(locked); !post.Locked {
+		t.Errorf("locked post is not locked")
+	}
+	comment := te.request(&proto.CreateCommentMsg{PostId: locked, AuthorId: author, Content: "hello"}).(*proto.CreateCommentResponse)
+	if comment.Error == nil || comment.Error.Code != ErrCodePermissionDenied {
+		t.Errorf("comment on the locked post: got %v, want %s", comment.Error, ErrCodePermissionDenied)
+	}
+
+	comments := e.metrics.TotalComments
+	replied := te.createPost(subredditID, author, "reply to me")
+	if post := te.post(replied); post.CommentCount != 1 {
+		t.Errorf("replied post counts %d comments, want 1", post.CommentCount)
+	}
+	tree := te.request(&proto.GetPostCommentsMsg{PostId: replied}).(*proto.GetPostCommentsResponse)
+	if len(tree.Comments) != 1 || tree.Comments[0].AuthorId != automoderatorID || tree.Comments[0].Content != "Please read the rules." {
+		t.Errorf("comments of the replied post: got %v, want AutoModerator's reply", tree.Comments)
+	}
+	if e.metrics.TotalComments != comments+1 {
+		t.Errorf("metrics count %d comments after the reply, want %d", e.metrics.TotalComments, comments+1)
+	}
+
+	// Replies are not moderator actions
+	want := []string{
+		"lock_post:" + locked,
+		"approve_post:" + flaired,
+		"set_flair:" + flaired,
+		"remove_post:" + removed,
+		"filter_post:" + filtered,
+		"edit_automod:" + subredditID,
+	}
+	if log := te.modLog(subredditID, mod); !slices.Equal(log, want) {
+		t.Errorf("moderation log %v, want %v", log, want)
+	}
+}
+
+func TestAutomodExemptsModerators(t *testing.T) {
+	te := startEngine(t, NewRedditEngine())
+	mod := te.registerUser("mod")
+	subredditID := te.createSubreddit("exempt", mod)
+	te.setAutomod(subredditID, mod, "body_regex: .\naction: remove\ncomment: Removed.")
+
+	postID := te.createPost(subredditID, mod, "announcement")
+	if post := te.post(postID); post.Removed || post.CommentCount != 0 {
+		t.Errorf("moderator's post: got removed %v with %d comments, want it untouched", post.Removed, post.CommentCount)
+	}
+	te.createComment(postID, mod, "pinned")
+	if post := te.post(postID); post.CommentCount != 1 {
+		t.Errorf("moderator's comment was replied to: %d comments, want 1", post.CommentCount)
+	}
+}
+
+func TestAutomodReportsTriggerOnce(t *testing.T) {
+	te := startEngine(t, NewRedditEngine())
+	mod := te.registerUser("mod")
+	author := te.registerUser("author")
+	subredditID := te.createSubreddit("reported", mod)
+	te.setAutomod(subredditID, mod, "reports: 2\ncomment: Under review.")
+	postID := te.createPost(subredditID, author, "title")
+
+	first, second, third := te.registerUser("first"), te.registerUser("second"), te.registerUser("third")
+	// The same user reporting again is not another report
+	for i, reporter := range []string{first, first, second, third} {
+		resp := te.request(&proto.ReportMsg{TargetId: postID, UserId: reporter, Reason: "spam"}).(*proto.ReportResponse)
+		if resp.Error != nil {
+			t.Fatalf("report %d: %v", i+1, resp.Error)
+		}
+		want := int32(0)
+		if i >= 2 {
+			want = 1
+		}
+		if got := te.post(postID).CommentCount; got != want {
+			t.Errorf("after report %d: %d replies, want %d", i+1, got, want)
+		}
+	}
+}
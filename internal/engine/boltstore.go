@@ -0,0 +1,340 @@
+// internal/engine/boltstore.go
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	usersBucket        = []byte("users")
+	subredditsBucket   = []byte("subreddits")
+	postsBucket        = []byte("posts")
+	commentsBucket     = []byte("comments")
+	mailboxesBucket    = []byte("mailboxes")     // user ID/message ID -> the user's copy
+	votesBucket        = []byte("votes")         // target ID/user ID -> vote
+	postCommentsBucket = []byte("post_comments") // post ID/comment ID -> nothing
+	inboxesBucket      = []byte("inboxes")       // recipient ID/message ID -> nothing
+	ownersBucket       = []byte("owners")        // record ID -> owning grain
+	modActionsBucket   = []byte("mod_actions")   // subreddit ID/action ID -> action
+	modQueueBucket     = []byte("mod_queue")     // subreddit ID/post or comment ID -> nothing
+
+	boltBuckets = [][]byte{
+		usersBucket, subredditsBucket, postsBucket, commentsBucket, mailboxesBucket,
+		votesBucket, postCommentsBucket, inboxesBucket, ownersBucket, modActionsBucket,
+		modQueueBucket,
+	}
+)
+
+// boltStore keeps records as JSON in an embedded bbolt database file.
+//
+// The Puts of one command are staged and written by Commit in a single
+// transaction, so a command's changes reach the disk together or not at all.
+// Reads only see committed changes. Fullname IDs sort by creation time, so
+// the ID-keyed indexes iterate oldest first.
+type boltStore struct {
+	db      *bolt.DB
+	pending []func(tx *bolt.Tx) error // changes staged by the current command
+	err     error                     // first error hit while staging them
+}
+
+// OpenBoltStore opens or creates a store in the database file at path.
+func OpenBoltStore(path string) (Store, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range boltBuckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltStore{db: db}, nil
+}
+
+func (s *boltStore) User(id string) (*User, error) {
+	var user User
+	return &user, s.get(usersBucket, id, &user)
+}
+
+func (s *boltStore) Subreddit(id string) (*Subreddit, error) {
+	var subreddit Subreddit
+	return &subreddit, s.get(subredditsBucket, id, &subreddit)
+}
+
+func (s *boltStore) Post(id string) (*Post, error) {
+	var post Post
+	return &post, s.get(postsBucket, id, &post)
+}
+
+func (s *boltStore) Comment(id string) (*Comment, error) {
+	var comment Comment
+	return &comment, s.get(commentsBucket, id, &comment)
+}
+
+func (s *boltStore) Message(userID, id string) (*DirectMessage, error) {
+	var dm DirectMessage
+	return &dm, s.get(mailboxesBucket, string(indexKey(userID, id)), &dm)
+}
+
+func (s *boltStore) Vote(targetID, userID string) (int, error) {
+	value := 0
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if raw := tx.Bucket(votesBucket).Get(indexKey(targetID, userID)); len(raw) == 1 {
+			value = int(int8(raw[0]))
+		}
+		return nil
+	})
+	return value, err
+}
+
+func (s *boltStore) PostComments(postID string) ([]*Comment, error) {
+	var comments []*Comment
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return scanIndex(tx, postCommentsBucket, commentsBucket, postID, func(raw []byte) error {
+			var comment Comment
+			if err := json.Unmarshal(raw, &comment); err != nil {
+				return err
+			}
+			comments = append(comments, &comment)
+			return nil
+		})
+	})
+	return comments, err
+}
+
+func (s *boltStore) Mailbox(userID string) ([]*DirectMessage, error) {
+	var messages []*DirectMessage
+	err := s.db.View(func(tx *bolt.Tx) error {
+		prefix := indexKey(userID, "")
+		c := tx.Bucket(mailboxesBucket).Cursor()
+		for key, raw := c.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, raw = c.Next() {
+			var dm DirectMessage
+			if err := json.Unmarshal(raw, &dm); err != nil {
+				return err
+			}
+			messages = append(messages, &dm)
+		}
+		return nil
+	})
+	return messages, err
+}
+
+func (s *boltStore) ModLog(subredditID string) ([]*ModAction, error) {
+	var actions []*ModAction
+	err := s.db.View(func(tx *bolt.Tx) error {
+		prefix := indexKey(subredditID, "")
+		c := tx.Bucket(modActionsBucket).Cursor()
+		for key, raw := c.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, raw = c.Next() {
+			var action ModAction
+			if err := json.Unmarshal(raw, &action); err != nil {
+				return err
+			}
+			actions = append(actions, &action)
+		}
+		return nil
+	})
+	return actions, err
+}
+
+func (s *boltStore) ModQueue(subredditID string) ([]string, error) {
+	var ids []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		prefix := indexKey(subredditID, "")
+		c := tx.Bucket(modQueueBucket).Cursor()
+		for key, _ := c.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, _ = c.Next() {
+			ids = append(ids, string(key[len(prefix):]))
+		}
+		return nil
+	})
+	return ids, err
+}
+
+func (s *boltStore) ForEachPost(fn func(*Post) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(postsBucket).ForEach(func(_, raw []byte) error {
+			var post Post
+			if err := json.Unmarshal(raw, &post); err != nil {
+				return err
+			}
+			return fn(&post)
+		})
+	})
+}
+
+func (s *boltStore) Totals() (Totals, error) {
+	var totals Totals
+	err := s.db.View(func(tx *bolt.Tx) error {
+		totals = Totals{
+			Users:    int64(tx.Bucket(usersBucket).Stats().KeyN),
+			Posts:    int64(tx.Bucket(postsBucket).Stats().KeyN),
+			Comments: int64(tx.Bucket(commentsBucket).Stats().KeyN),
+			Votes:    int64(tx.Bucket(votesBucket).Stats().KeyN),
+			Messages: int64(tx.Bucket(inboxesBucket).Stats().KeyN),
+		}
+		return nil
+	})
+	return totals, err
+}
+
+func (s *boltStore) Owner(id string) (string, error) {
+	var ownerID string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		raw := tx.Bucket(ownersBucket).Get([]byte(id))
+		if raw == nil {
+			return ErrNotFound
+		}
+		ownerID = string(raw)
+		return nil
+	})
+	return ownerID, err
+}
+
+func (s *boltStore) PutUser(user *User) {
+	s.put(usersBucket, user.ID, user)
+}
+
+func (s *boltStore) PutSubreddit(subreddit *Subreddit) {
+	s.put(subredditsBucket, subreddit.ID, subreddit)
+}
+
+func (s *boltStore) PutPost(post *Post) {
+	s.put(postsBucket, post.ID, post)
+}
+
+func (s *boltStore) PutComment(comment *Comment) {
+	s.put(commentsBucket, comment.ID, comment)
+	s.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(postCommentsBucket).Put(indexKey(comment.PostID, comment.ID), nil)
+	})
+}
+
+// PutMessage stores the copy under its owner, and counts the recipient's in
+// the inboxes index, which Totals reads.
+func (s *boltStore) PutMessage(dm *DirectMessage) {
+	s.put(mailboxesBucket, string(indexKey(dm.OwnerID, dm.ID)), dm)
+	if dm.received() {
+		s.update(func(tx *bolt.Tx) error {
+			return tx.Bucket(inboxesBucket).Put(indexKey(dm.ToUserID, dm.ID), nil)
+		})
+	}
+}
+
+// PutModAction stores the action under its subreddit: the log is only ever
+// read whole.
+func (s *boltStore) PutModAction(action *ModAction) {
+	s.put(modActionsBucket, string(indexKey(action.SubredditID, action.ID)), action)
+}
+
+func (s *boltStore) PutQueued(subredditID, targetID string, queued bool) {
+	s.update(func(tx *bolt.Tx) error {
+		key := indexKey(subredditID, targetID)
+		if !queued {
+			return tx.Bucket(modQueueBucket).Delete(key)
+		}
+		return tx.Bucket(modQueueBucket).Put(key, nil)
+	})
+}
+
+func (s *boltStore) PutVote(targetID, userID string, value int) {
+	s.update(func(tx *bolt.Tx) error {
+		key := indexKey(targetID, userID)
+		if value == 0 {
+			return tx.Bucket(votesBucket).Delete(key)
+		}
+		return tx.Bucket(votesBucket).Put(key, []byte{byte(int8(value))})
+	})
+}
+
+func (s *boltStore) PutOwner(id, ownerID string) {
+	s.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(ownersBucket).Put([]byte(id), []byte(ownerID))
+	})
+}
+
+func (s *boltStore) Commit() error {
+	pending, err := s.pending, s.err
+	s.pending, s.err = nil, nil
+	if err != nil || len(pending) == 0 {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, change := range pending {
+			if err := change(tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
+
+// fork returns a store on the same database with its own staged changes, so
+// concurrent actors each commit only their own commands.
+func (s *boltStore) fork() Store {
+	return &boltStore{db: s.db}
+}
+
+// update stages a change for the next Commit.
+func (s *boltStore) update(fn func(tx *bolt.Tx) error) {
+	s.pending = append(s.pending, fn)
+}
+
+func (s *boltStore) get(bucket []byte, id string, record interface{}) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		raw := tx.Bucket(bucket).Get([]byte(id))
+		if raw == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(raw, record)
+	})
+}
+
+// put encodes the record right away, so later changes to it are not written
+// unless it is Put again.
+func (s *boltStore) put(bucket []byte, id string, record interface{}) {
+	raw, err := json.Marshal(record)
+	if err != nil {
+		if s.err == nil {
+			s.err = err
+		}
+		return
+	}
+	s.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(id), raw)
+	})
+}
+
+// indexKey joins an owner ID and a member ID. IDs never contain '/'.
+func indexKey(ownerID, id string) []byte {
+	return []byte(ownerID + "/" + id)
+}
+
+// scanIndex calls fn with the record of every member indexed under ownerID.
+func scanIndex(tx *bolt.Tx, index, records []byte, ownerID string, fn func(raw []byte) error) error {
+	prefix := indexKey(ownerID, "")
+	c := tx.Bucket(index).Cursor()
+	for key, _ := c.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, _ = c.Next() {
+		raw := tx.Bucket(records).Get(key[len(prefix):])
+		if raw == nil {
+			continue
+		}
+		if err := fn(raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,323 @@
+// internal/engine/client.go
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/asynkron/protoactor-go/actor"
+	"github.com/asynkron/protoactor-go/cluster"
+	"github.com/kakugri/redditClone/internal/proto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Client sends requests to an engine cluster. Each request goes to the grain
+// that owns what it is about, addressed by identity, so clients never need to
+// know which member hosts it.
+type Client struct {
+	cluster *cluster.Cluster
+}
+
+// NewClient returns a client on c, which must be started as a member or a
+// client of the engine cluster.
+func NewClient(c *cluster.Cluster) *Client {
+	return &Client{cluster: c}
+}
+
+// Request sends msg to its grain and waits for the answer. A request the
+// cluster could not answer in time fails with actor.ErrTimeout.
+func (c *Client) Request(msg interface{}) (interface{}, error) {
+	return c.RequestContext(context.Background(), msg)
+}
+
+// RequestContext is Request as part of the trace of ctx, if any: the request
+// is traced in a span of its own, and the grains handle it within the trace.
+func (c *Client) RequestContext(ctx context.Context, msg interface{}) (interface{}, error) {
+	identity, kind := route(msg)
+	switch {
+	case kind == "":
+		return nil, fmt.Errorf("no grain handles %T", msg)
+	case identity == "":
+		return errorResponse(msg, missingID(kind)), nil
+	}
+
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		var span trace.Span
+		ctx, span = tracer.Start(ctx, "request "+messageName(msg),
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(attribute.String("grain.kind", kind), attribute.String("grain.identity", identity)))
+		defer span.End()
+	}
+	sender := tracedSender(c.cluster.ActorSystem, ctx)
+	result, err := c.cluster.Request(identity, kind, msg, cluster.WithContext(sender))
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = actor.ErrTimeout
+	}
+	if err != nil {
+		span := trace.SpanFromContext(ctx)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	return result, nil
+}
+
+// Send sends msg to its grain without waiting for an answer.
+func (c *Client) Send(msg interface{}) {
+	identity, kind := route(msg)
+	if identity == "" {
+		log.Printf("Dropped %T: no grain to route it to", msg)
+		return
+	}
+	pid := grainPID(c.cluster, identity, kind)
+	if pid == nil {
+		log.Printf("Dropped %T: %s %q unreachable", msg, kind, identity)
+		return
+	}
+	c.cluster.ActorSystem.Root.Send(pid, msg)
+}
+
+// route returns the identity and kind of the grain a client message goes to.
+// The identity is empty if the message names no record to route by, and the
+// kind too if the message is not a client request.
+func route(msg interface{}) (identity, kind string) {
+	switch msg := msg.(type) {
+	// New users and subreddits get their IDs from the directory of their
+	// name, which keeps it unique
+	case *proto.RegisterUserMsg:
+		return directoryIdentity(foldName(msg.Username)), DirectoryKind
+	case *proto.CreateSubredditMsg:
+		return directoryIdentity(foldName(msg.Name)), DirectoryKind
+
+	case *proto.GetSubredditPostsMsg:
+		if msg.ViewerId != "" {
+			// The viewer's grain adds the authors they blocked
+			return msg.ViewerId, UserKind
+		}
+		return msg.SubredditId, SubredditKind
+	case *proto.GetSubredditMsg:
+		if msg.SubredditId == "" {
+			// Subreddit names are indexed by the directory that created them
+			return directoryKey(foldName(msg.Name))
+		}
+		return msg.SubredditId, SubredditKind
+	case *proto.AddModeratorMsg:
+		return msg.SubredditId, SubredditKind
+	case *proto.RemoveModeratorMsg:
+		return msg.SubredditId, SubredditKind
+	case *proto.RestrictUserMsg:
+		return msg.SubredditId, SubredditKind
+	case *proto.LiftRestrictionMsg:
+		return msg.SubredditId, SubredditKind
+	case *proto.GetModLogMsg:
+		return msg.SubredditId, SubredditKind
+	case *proto.SetRulesMsg:
+		return msg.SubredditId, SubredditKind
+	case *proto.SetAutomodMsg:
+		return msg.SubredditId, SubredditKind
+	case *proto.GetAutomodMsg:
+		return msg.SubredditId, SubredditKind
+	case *proto.GetModQueueMsg:
+		return msg.SubredditId, SubredditKind
+
+	case *proto.JoinSubredditMsg:
+		return msg.UserId, UserKind
+	case *proto.LeaveSubredditMsg:
+		return msg.UserId, UserKind
+	case *proto.GetUserKarmaMsg:
+		return msg.UserId, UserKind
+	case *proto.GetUserSubredditsMsg:
+		return msg.UserId, UserKind
+	case *proto.GetFeedMsg:
+		return msg.UserId, UserKind
+	case *proto.GetUserMsg:
+		return msg.UserId, UserKind
+	// Each participant's grain keeps their copies of their messages
+	case *proto.DirectMessageMsg:
+		return msg.FromUserId, UserKind
+	case *proto.GetMessagesMsg:
+		return msg.UserId, UserKind
+	case *proto.GetConversationsMsg:
+		return msg.UserId, UserKind
+	case *proto.MarkReadMsg:
+		return msg.UserId, UserKind
+	case *proto.DeleteMessageMsg:
+		return msg.UserId, UserKind
+	case *proto.BlockUserMsg:
+		return msg.UserId, UserKind
+	case *proto.GetBlocksMsg:
+		return msg.UserId, UserKind
+	case *proto.SetMessageSettingsMsg:
+		return msg.UserId, UserKind
+	case *proto.GetMessageSettingsMsg:
+		return msg.UserId, UserKind
+	// Usernames are indexed by the directory that registered them
+	case *proto.GetCredentialsMsg:
+		return directoryKey(foldName(msg.Username))
+	// The voter's grain checks the voter before the vote is routed on
+	case *proto.VoteMsg:
+		return msg.UserId, UserKind
+	// and the author's grain the author of a new post or comment
+	case *proto.CreatePostMsg:
+		return msg.AuthorId, UserKind
+	case *proto.CreateCommentMsg:
+		return msg.AuthorId, UserKind
+
+	// Posts and comments are found through the directory
+	case *proto.GetPostCommentsMsg:
+		if msg.ViewerId != "" {
+			return msg.ViewerId, UserKind
+		}
+		return directoryKey(msg.PostId)
+	case *proto.GetPostMsg:
+		return directoryKey(msg.PostId)
+	case *proto.EditPostMsg:
+		return directoryKey(msg.PostId)
+	case *proto.DeletePostMsg:
+		return directoryKey(msg.PostId)
+	case *proto.EditCommentMsg:
+		return directoryKey(msg.CommentId)
+	case *proto.DeleteCommentMsg:
+		return directoryKey(msg.CommentId)
+	case *proto.RemovePostMsg:
+		return directoryKey(msg.PostId)
+	case *proto.RemoveCommentMsg:
+		return directoryKey(msg.CommentId)
+	case *proto.ReportMsg:
+		return directoryKey(msg.TargetId)
+	case *proto.ResolveReportsMsg:
+		return directoryKey(msg.TargetId)
+	}
+	return "", ""
+}
+
+func directoryKey(id string) (string, string) {
+	if id == "" {
+		return "", DirectoryKind
+	}
+	return directoryIdentity(id), DirectoryKind
+}
+
+// missingID answers a request that names no record of the given kind.
+func missingID(kind string) *proto.Error {
+	if kind == DirectoryKind {
+		return newError(ErrCodeNotFound, "record %q not found", "")
+	}
+	return newError(ErrCodeNotFound, "%s %q not found", kind, "")
+}
+
+// errorResponse builds the response to msg that carries nothing but err.
+func errorResponse(msg interface{}, err *proto.Error) interface{} {
+	switch msg := msg.(type) {
+	case *proto.RegisterUserMsg:
+		return &proto.RegisterUserResponse{Error: err}
+	case *proto.CreateSubredditMsg:
+		return &proto.CreateSubredditResponse{Error: err}
+	case *proto.CreatePostMsg:
+		return &proto.CreatePostResponse{Error: err}
+	case *proto.CreateCommentMsg:
+		return &proto.CreateCommentResponse{Error: err}
+	case *proto.DirectMessageMsg:
+		return &proto.DirectMessageResponse{Error: err}
+	case *proto.JoinSubredditMsg:
+		return membershipResponse(msg, msg.SubredditId, 0, err)
+	case *proto.LeaveSubredditMsg:
+		return membershipResponse(msg, msg.SubredditId, 0, err)
+	case *proto.GetUserKarmaMsg:
+		return &proto.GetUserKarmaResponse{UserId: msg.UserId, Error: err}
+	case *proto.GetUserSubredditsMsg:
+		return &proto.GetUserSubredditsResponse{Error: err}
+	case *proto.GetFeedMsg:
+		return &proto.GetFeedResponse{Error: err}
+	case *proto.GetSubredditPostsMsg:
+		return &proto.GetSubredditPostsResponse{Error: err}
+	case *proto.GetPostCommentsMsg:
+		return &proto.GetPostCommentsResponse{Error: err}
+	case *proto.EditPostMsg:
+		return &proto.EditPostResponse{PostId: msg.PostId, Error: err}
+	case *proto.DeletePostMsg:
+		return &proto.DeletePostResponse{PostId: msg.PostId, Error: err}
+	case *proto.EditCommentMsg:
+		return &proto.EditCommentResponse{CommentId: msg.CommentId, Error: err}
+	case *proto.DeleteCommentMsg:
+		return &proto.DeleteCommentResponse{CommentId: msg.CommentId, Error: err}
+	case *proto.VoteMsg:
+		return &proto.VoteResponse{TargetId: msg.TargetId, Error: err}
+	case *proto.DeleteMessageMsg:
+		return &proto.DeleteMessageResponse{MessageId: msg.MessageId, Error: err}
+	case *proto.GetUserMsg:
+		return &proto.GetUserResponse{Error: err}
+	case *proto.GetSubredditMsg:
+		return &proto.GetSubredditResponse{Error: err}
+	case *proto.GetPostMsg:
+		return &proto.GetPostResponse{Error: err}
+	case *proto.GetMessagesMsg:
+		return &proto.GetMessagesResponse{Error: err}
+	case *proto.GetConversationsMsg:
+		return &proto.GetConversationsResponse{Error: err}
+	case *proto.MarkReadMsg:
+		return &proto.MarkReadResponse{Error: err}
+	case *proto.BlockUserMsg:
+		return &proto.BlocksResponse{Error: err}
+	case *proto.GetBlocksMsg:
+		return &proto.BlocksResponse{Error: err}
+	case *proto.SetMessageSettingsMsg:
+		return &proto.MessageSettingsResponse{Error: err}
+	case *proto.GetMessageSettingsMsg:
+		return &proto.MessageSettingsResponse{Error: err}
+	case *proto.GetCredentialsMsg:
+		return &proto.GetCredentialsResponse{Error: err}
+	case *proto.AddModeratorMsg:
+		return &proto.ModeratorsResponse{SubredditId: msg.SubredditId, Error: err}
+	case *proto.RemoveModeratorMsg:
+		return &proto.ModeratorsResponse{SubredditId: msg.SubredditId, Error: err}
+	case *proto.RemovePostMsg:
+		return &proto.RemovePostResponse{PostId: msg.PostId, Error: err}
+	case *proto.RemoveCommentMsg:
+		return &proto.RemoveCommentResponse{CommentId: msg.CommentId, Error: err}
+	case *proto.RestrictUserMsg:
+		return &proto.RestrictUserResponse{SubredditId: msg.SubredditId, TargetUserId: msg.TargetUserId, Error: err}
+	case *proto.LiftRestrictionMsg:
+		return &proto.LiftRestrictionResponse{SubredditId: msg.SubredditId, TargetUserId: msg.TargetUserId, Error: err}
+	case *proto.GetModLogMsg:
+		return &proto.GetModLogResponse{Error: err}
+	case *proto.SetRulesMsg:
+		return &proto.SetRulesResponse{SubredditId: msg.SubredditId, Error: err}
+	case *proto.SetAutomodMsg:
+		return &proto.AutomodResponse{SubredditId: msg.SubredditId, Error: err}
+	case *proto.GetAutomodMsg:
+		return &proto.AutomodResponse{SubredditId: msg.SubredditId, Error: err}
+	case *proto.ReportMsg:
+		return &proto.ReportResponse{TargetId: msg.TargetId, Error: err}
+	case *proto.GetModQueueMsg:
+		return &proto.GetModQueueResponse{Error: err}
+	case *proto.ResolveReportsMsg:
+		return &proto.ResolveReportsResponse{TargetId: msg.TargetId, Error: err}
+	case *proto.KarmaDeltaMsg:
+		return &proto.KarmaDeltaResponse{Error: err}
+	case *proto.DeliverMessageMsg:
+		return &proto.DeliverMessageResponse{Error: err}
+	case *proto.MemberChangeMsg:
+		return &proto.MemberChangeResponse{Error: err}
+	case *proto.ListingPageMsg:
+		return &proto.ListingPageResponse{Error: err}
+	case *proto.SubredditInfoMsg:
+		return &proto.SubredditInfoResponse{Error: err}
+	case *proto.RegisterOwnerMsg:
+		return &proto.RegisterOwnerResponse{Error: err}
+	}
+	return nil
+}
+
+// StartClient joins the engine cluster whose members serve their health
+// endpoints at the host:port addresses in members, as a client that hosts no
+// grains.
+func StartClient(system *actor.ActorSystem, members []string) *Client {
+	c := cluster.New(system, NewClusterConfig("localhost", 0, 0, members))
+	c.StartClient()
+	return NewClient(c)
+}
@@ -0,0 +1,315 @@
+// internal/engine/cluster.go
+package engine
+
+import (
+	"hash/fnv"
+	"log"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/asynkron/protoactor-go/actor"
+	"github.com/asynkron/protoactor-go/cluster"
+	"github.com/asynkron/protoactor-go/cluster/clusterproviders/automanaged"
+	"github.com/asynkron/protoactor-go/cluster/identitylookup/disthash"
+	"github.com/asynkron/protoactor-go/eventstream"
+	"github.com/asynkron/protoactor-go/remote"
+	"github.com/prometheus/client_golang/prometheus"
+	gproto "google.golang.org/protobuf/proto"
+)
+
+// ClusterName is the name of the cluster engine members and clients join.
+const ClusterName = "reddit-engine"
+
+// The kinds of grain an engine cluster is made of. Grains are placed on the
+// members by consistent hashing of their identity.
+const (
+	UserKind      = "user"      // one per user, identified by the user ID
+	SubredditKind = "subreddit" // one per subreddit, identified by the subreddit ID
+	DirectoryKind = "directory" // routes requests about posts, comments and messages
+)
+
+const (
+	// directoryGrains is how many directory grains the owner index is
+	// partitioned over.
+	directoryGrains = 64
+
+	// grainTimeout bounds how long a request to a grain waits for its
+	// answer, whether it comes from a client or from another grain.
+	grainTimeout = 5 * time.Second
+
+	// membershipRefresh is how often members poll each other's health.
+	membershipRefresh = 2 * time.Second
+)
+
+// NewClusterConfig configures an engine cluster with a static membership:
+// members lists the host:port of every member's health endpoint, and each
+// member serves its own on managePort. Clients pass no kinds and a
+// managePort of 0. host and port are where this process listens for actor
+// messages; port 0 picks a free one.
+func NewClusterConfig(host string, port, managePort int, members []string, kinds ...*cluster.Kind) *cluster.Config {
+	provider := automanaged.NewWithConfig(membershipRefresh, managePort, members...)
+	return cluster.Configure(ClusterName, provider, disthash.New(), remote.Configure(host, port),
+		cluster.WithKinds(kinds...),
+		cluster.WithRequestTimeout(grainTimeout))
+}
+
+// directoryIdentity returns the directory grain that indexes id.
+func directoryIdentity(id string) string {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return strconv.Itoa(int(h.Sum32() % directoryGrains))
+}
+
+// grainPID returns the PID of a grain, activating it if it is not running.
+// It is nil if the grain's member cannot be reached.
+func grainPID(c *cluster.Cluster, identity, kind string) *actor.PID {
+	if pid, ok := c.PidCache.Get(identity, kind); ok {
+		return pid
+	}
+	pid := c.Get(identity, kind)
+	if pid != nil {
+		c.PidCache.Set(identity, kind, pid)
+	}
+	return pid
+}
+
+// Member is the part of an engine cluster that runs in one process. The
+// grains placed on it keep their records in its store and count them in its
+// metrics. Every member needs a store of its own.
+//
+// Records do not move between stores, so the membership of a cluster is
+// fixed: a grain must always be placed on the same member. The cluster places
+// grains by hashing their identity over the addresses of the members it can
+// reach, so when one is missing, its grains are activated on the others,
+// which do not have their records. A member refuses the requests of such
+// grains as unavailable: it takes the placement of the grains from the first
+// time all members had joined, and refuses every request until then, and
+// while the members run at other addresses than they did then. A cluster of
+// one member has nowhere else to place grains.
+//
+// A Member is also an actor: spawned next to the grains, before the cluster
+// starts, it reports the member's metrics and follows the membership.
+//
+// Grains are restarted by the cluster after a panic, and reload what they
+// keep in memory from the store; the messages that crashed them are
+// quarantined.
+type Member struct {
+	store        Store
+	size         int // how many members the cluster has
+	metrics      *Metrics
+	recovery     *recovery
+	instruments  *instruments
+	stopTick     func()
+	subscription *eventstream.Subscription
+
+	placement atomic.Pointer[placement] // nil until all members joined
+	moved     atomic.Bool               // the members run at other addresses than in placement
+
+	mu       sync.Mutex
+	rankings map[string]*postRanking // subreddit ID -> ranked posts, shared with the subreddit's grain
+}
+
+// placement is where grains go in a cluster with all its members.
+type placement struct {
+	addresses []string // of the members, sorted
+	owners    *cluster.Rendezvous
+}
+
+// NewMember loads the member's share of the engine from store, for a cluster
+// of size members. Its metrics are exported to Prometheus through reg, unless
+// reg is nil.
+func NewMember(store Store, size int, reg prometheus.Registerer) (*Member, error) {
+	loader, err := NewRedditEngineWithStore(store)
+	if err != nil {
+		return nil, err
+	}
+	m := &Member{
+		store:    store,
+		size:     size,
+		metrics:  loader.metrics,
+		recovery: newRecovery(),
+		rankings: loader.rankings,
+	}
+	m.instruments = newInstruments(reg, m.metrics, m.recovery)
+	return m, nil
+}
+
+// Kinds returns the grain kinds the member hosts.
+func (m *Member) Kinds() []*cluster.Kind {
+	return []*cluster.Kind{
+		m.kind(SubredditKind, func() actor.Actor {
+			return &subredditGrain{RedditEngine: m.newGrainEngine(), member: m}
+		}),
+		m.kind(UserKind, func() actor.Actor {
+			return &userGrain{RedditEngine: m.newGrainEngine()}
+		}),
+		m.kind(DirectoryKind, func() actor.Actor {
+			return newDirectoryGrain(forkStore(m.store))
+		}),
+	}
+}
+
+// kind returns a grain kind whose grains are instrumented, traced and
+// supervised.
+func (m *Member) kind(name string, producer actor.Producer) *cluster.Kind {
+	opts := append(m.instruments.props(name), actor.WithReceiverMiddleware(traceReceive(name), m.placed, m.recovery.receive))
+	return cluster.NewKind(name, actor.PropsFromProducer(producer, opts...))
+}
+
+func (m *Member) Receive(context actor.Context) {
+	switch context.Message().(type) {
+	case *actor.Started:
+		log.Println("Engine member started and ready to host grains.")
+		m.stopTick = scheduleMetrics(context)
+		m.subscription = context.ActorSystem().EventStream.Subscribe(func(event interface{}) {
+			if topology, ok := event.(*cluster.ClusterTopology); ok {
+				m.place(topology)
+			}
+		})
+	case *actor.Stopping:
+		m.stopTick()
+		context.ActorSystem().EventStream.Unsubscribe(m.subscription)
+	case *metricsTick:
+		logMetricsReport(m.recovery.report(m.metrics.report()))
+	}
+}
+
+// place follows the membership of the cluster. The first time all members
+// have joined fixes the placement of the grains.
+func (m *Member) place(topology *cluster.ClusterTopology) {
+	var members cluster.Members
+	var addresses []string
+	for _, member := range topology.Members {
+		// Clients host no grains
+		if member.HasKind(SubredditKind) {
+			members = append(members, member)
+			addresses = append(addresses, member.Address())
+		}
+	}
+	sort.Strings(addresses)
+	if len(members) != m.size {
+		log.Printf("Cluster has %d of its %d members %v; grains of the missing ones are unavailable", len(members), m.size, addresses)
+		return
+	}
+
+	fixed := m.placement.Load()
+	switch {
+	case fixed == nil:
+		owners := cluster.NewRendezvous()
+		owners.UpdateMembers(members)
+		m.placement.Store(&placement{addresses: addresses, owners: owners})
+		log.Printf("All %d cluster members joined: %v", m.size, addresses)
+	case !slices.Equal(fixed.addresses, addresses):
+		log.Printf("Cluster members run at %v instead of %v; refusing every request until they are back", addresses, fixed.addresses)
+		m.moved.Store(true)
+	default:
+		log.Printf("All %d cluster members are back", m.size)
+		m.moved.Store(false)
+	}
+}
+
+// placed is the receiver middleware of grains. It refuses the requests of a
+// grain activated on a member that does not keep its records.
+func (m *Member) placed(next actor.ReceiverFunc) actor.ReceiverFunc {
+	return func(c actor.ReceiverContext, envelope *actor.MessageEnvelope) {
+		switch envelope.Message.(type) {
+		case actor.SystemMessage, actor.AutoReceiveMessage:
+			// Among them the poison pill that stops a grain placed elsewhere
+		case gproto.Message:
+			if !m.keeps(c) {
+				reply(c, envelope, newError(ErrCodeUnavailable, "the engine member that keeps this record is unavailable"))
+				return
+			}
+		}
+		next(c, envelope)
+	}
+}
+
+// keeps reports whether the member keeps the records of the grain c runs.
+func (m *Member) keeps(c actor.ReceiverContext) bool {
+	if m.size == 1 {
+		return true
+	}
+	fixed := m.placement.Load()
+	if fixed == nil || m.moved.Load() {
+		return false
+	}
+	return fixed.owners.GetByClusterIdentity(cluster.GetClusterIdentity(c)) == c.ActorSystem().Address()
+}
+
+// newGrainEngine returns the engine a grain applies commands with. Its store
+// stages changes apart from other grains', so a grain that crashes drops the
+// ones it had staged.
+func (m *Member) newGrainEngine() *RedditEngine {
+	e := newRedditEngine(forkStore(m.store))
+	e.metrics = m.metrics
+	e.recovery = m.recovery
+	return e
+}
+
+// ranking returns the ranking of a subreddit's posts. The member keeps it so
+// a grain activated again after stopping picks up where the last one left.
+func (m *Member) ranking(subredditID string) *postRanking {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ranking := m.rankings[subredditID]
+	if ranking == nil {
+		ranking = newPostRanking()
+		m.rankings[subredditID] = ranking
+	}
+	return ranking
+}
+
+// reloadRanking rebuilds the ranking of a subreddit's posts from the store,
+// for a grain restarted after a crash that may have left it half updated.
+func (m *Member) reloadRanking(subredditID string) error {
+	ranking := newPostRanking()
+	err := m.store.ForEachPost(func(post *Post) error {
+		if post.SubredditID == subredditID {
+			ranking.add(post)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	ranking.sortNewest()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rankings[subredditID] = ranking
+	return nil
+}
+
+// SingleEngineKinds returns grain kinds that hand every request to the engine
+// actor spawned under name on each member, so clients reach an unsharded
+// engine the same way as a sharded one. All requests have to end up at the
+// same engine, so a cluster running these kinds must have a single member.
+func SingleEngineKinds(name string) []*cluster.Kind {
+	props := actor.PropsFromProducer(func() actor.Actor {
+		return &engineForwarder{engine: name}
+	})
+	return []*cluster.Kind{
+		cluster.NewKind(SubredditKind, props),
+		cluster.NewKind(UserKind, props),
+		cluster.NewKind(DirectoryKind, props),
+	}
+}
+
+// engineForwarder passes the requests sent to a grain on to the engine,
+// which answers the original sender.
+type engineForwarder struct {
+	engine string
+}
+
+func (f *engineForwarder) Receive(context actor.Context) {
+	switch context.Message().(type) {
+	case *actor.Started, *actor.Stopping, *actor.Stopped, *actor.Restarting, *cluster.ClusterInit:
+	default:
+		context.Forward(context.ActorSystem().NewLocalPID(f.engine))
+	}
+}
@@ -0,0 +1,41 @@
+// internal/engine/cluster_test.go
+package engine
+
+import (
+	"testing"
+
+	"github.com/asynkron/protoactor-go/cluster"
+)
+
+func TestMemberFixesPlacementOnceAllJoined(t *testing.T) {
+	member := func(port int) *cluster.Member {
+		return &cluster.Member{Host: "127.0.0.1", Port: int32(port), Kinds: []string{SubredditKind, UserKind, DirectoryKind}}
+	}
+	client := &cluster.Member{Host: "127.0.0.1", Port: 9000}
+	m := &Member{size: 2}
+	owner := func() string {
+		return m.placement.Load().owners.GetByClusterIdentity(cluster.NewClusterIdentity("t5_sub", SubredditKind))
+	}
+
+	m.place(&cluster.ClusterTopology{Members: []*cluster.Member{member(8080), client}})
+	if m.placement.Load() != nil {
+		t.Fatal("placement fixed with one of two members")
+	}
+
+	m.place(&cluster.ClusterTopology{Members: []*cluster.Member{member(8081), member(8080), client}})
+	if m.placement.Load() == nil || m.moved.Load() {
+		t.Fatal("placement not fixed once both members joined")
+	}
+	fixed := owner()
+
+	// A member back at another address would be handed the grains of the
+	// one it replaced, without their records
+	m.place(&cluster.ClusterTopology{Members: []*cluster.Member{member(8080), member(8082)}})
+	if !m.moved.Load() {
+		t.Error("member at a new address not noticed")
+	}
+	m.place(&cluster.ClusterTopology{Members: []*cluster.Member{member(8080), member(8081)}})
+	if m.moved.Load() || owner() != fixed {
+		t.Errorf("members back at their addresses: moved %t, owner %s, want false and %s", m.moved.Load(), owner(), fixed)
+	}
+}
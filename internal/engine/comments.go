@@ -0,0 +1,231 @@
+// internal/engine/comments.go
+package engine
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/asynkron/protoactor-go/actor"
+	"github.com/kakugri/redditClone/internal/proto"
+)
+
+const (
+	// maxCommentDepth is the deepest level a reply can be created at.
+	maxCommentDepth = 16
+
+	defaultTreeDepth = 8
+	defaultTreeLimit = 200
+	maxTreeLimit     = 500
+)
+
+// replyParent returns the comment a reply on postID is attached to.
+func (e *RedditEngine) replyParent(postID, parentID string) (*Comment, *proto.Error) {
+	parent, err := e.store.Comment(parentID)
+	if err != nil {
+		return nil, storeError(err, "parent comment %q not found", parentID)
+	}
+	if parent.Deleted || parent.Removed {
+		return nil, newError(ErrCodeInvalidArgument, "parent comment %q has been %s", parentID, goneAs(parent.Deleted))
+	}
+	if parent.PostID != postID {
+		return nil, newError(ErrCodeInvalidArgument, "parent comment %q belongs to post %q, not %q", parentID, parent.PostID, postID)
+	}
+	if parent.Depth+1 > maxCommentDepth {
+		return nil, newError(ErrCodeInvalidArgument, "replies are limited to %d levels", maxCommentDepth)
+	}
+	return parent, nil
+}
+
+func (e *RedditEngine) handleGetPostComments(context actor.Context, msg *proto.GetPostCommentsMsg) {
+	post, err := e.store.Post(msg.PostId)
+	if err != nil {
+		respond(context, &proto.GetPostCommentsResponse{
+			Error: storeError(err, "post %q not found", msg.PostId),
+		})
+		return
+	}
+	order, err := parseCommentSort(msg.Sort)
+	if err != nil {
+		respond(context, &proto.GetPostCommentsResponse{
+			Error: newError(ErrCodeInvalidArgument, "%v", err),
+		})
+		return
+	}
+	comments, err := e.store.PostComments(post.ID)
+	if err != nil {
+		respond(context, &proto.GetPostCommentsResponse{Error: storeError(err, "")})
+		return
+	}
+
+	// Group the comments by parent; top-level comments have an empty parent ID
+	children := make(map[string][]*Comment)
+	for _, comment := range comments {
+		children[comment.ParentID] = append(children[comment.ParentID], comment)
+	}
+	hidden := e.hiddenAuthors(msg.ViewerId, msg.HiddenAuthorIds)
+	if len(hidden) > 0 {
+		hideComments(children, "", hidden)
+	}
+
+	builder := &commentTreeBuilder{
+		postID:   post.ID,
+		order:    order,
+		children: children,
+		hidden:   hidden,
+		maxDepth: int(msg.Depth),
+		budget:   int(msg.Limit),
+	}
+	if builder.maxDepth <= 0 || builder.maxDepth > maxCommentDepth+1 {
+		builder.maxDepth = defaultTreeDepth
+	}
+	if builder.budget <= 0 {
+		builder.budget = defaultTreeLimit
+	} else if builder.budget > maxTreeLimit {
+		builder.budget = maxTreeLimit
+	}
+
+	// Start at the top of the tree, or where a previous response left off
+	parentID, offset := "", 0
+	if msg.Continuation != "" {
+		cont, err := decodeContinuation(msg.Continuation)
+		if err != nil || cont.postID != post.ID {
+			respond(context, &proto.GetPostCommentsResponse{
+				Error: newError(ErrCodeInvalidArgument, "invalid continuation"),
+			})
+			return
+		}
+		builder.order = cont.order
+		parentID, offset = cont.parentID, cont.offset
+		if parentID != "" && !containsComment(comments, parentID) {
+			respond(context, &proto.GetPostCommentsResponse{
+				Error: newError(ErrCodeNotFound, "comment %q not found", parentID),
+			})
+			return
+		}
+	}
+
+	infos, more := builder.build(parentID, offset, 0)
+	respond(context, &proto.GetPostCommentsResponse{Comments: infos, More: more})
+}
+
+// hideComments drops the replies to parentID by hidden authors, and theirs,
+// unless a reply further down is left to show. It reports whether any reply
+// to parentID is left.
+func hideComments(children map[string][]*Comment, parentID string, hidden map[string]bool) bool {
+	var kept []*Comment
+	for _, comment := range children[parentID] {
+		if hideComments(children, comment.ID, hidden) || !hidden[comment.AuthorID] {
+			kept = append(kept, comment)
+		}
+	}
+	if len(kept) == 0 {
+		delete(children, parentID)
+		return false
+	}
+	children[parentID] = kept
+	return true
+}
+
+// commentTreeBuilder converts a comment tree into its proto form, ranking every
+// level and cutting it at maxDepth levels or budget comments, whichever comes
+// first.
+type commentTreeBuilder struct {
+	postID   string
+	order    SortOrder
+	children map[string][]*Comment // parent ID -> replies
+	hidden   map[string]bool       // authors whose comments are blanked
+	maxDepth int
+	budget   int
+}
+
+// build converts the replies to parentID, starting at offset in rank order,
+// and their replies. level counts the levels already emitted above them.
+func (b *commentTreeBuilder) build(parentID string, offset, level int) ([]*proto.CommentInfo, *proto.MoreComments) {
+	sorted := sortComments(b.children[parentID], b.order)
+	infos := make([]*proto.CommentInfo, 0, len(sorted))
+	for i := offset; i < len(sorted); i++ {
+		if b.budget == 0 {
+			return infos, b.more(parentID, i, len(sorted)-i)
+		}
+		b.budget--
+
+		comment := sorted[i]
+		info := commentInfo(comment)
+		if b.hidden[comment.AuthorID] && !comment.Deleted {
+			info.Content = blockedPlaceholder
+			info.AuthorId = blockedPlaceholder
+		}
+		if replies := len(b.children[comment.ID]); replies > 0 {
+			if level+1 < b.maxDepth {
+				info.Replies, info.More = b.build(comment.ID, 0, level+1)
+			} else {
+				info.More = b.more(comment.ID, 0, replies)
+			}
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (b *commentTreeBuilder) more(parentID string, offset, count int) *proto.MoreComments {
+	cont := continuation{postID: b.postID, parentID: parentID, order: b.order, offset: offset}
+	return &proto.MoreComments{Continuation: cont.encode(), Count: int32(count)}
+}
+
+// continuation points at the replies of parentID (the top-level comments if
+// empty) from offset onwards, in the given order.
+type continuation struct {
+	postID   string
+	parentID string
+	order    SortOrder
+	offset   int
+}
+
+func (c continuation) encode() string {
+	raw := strings.Join([]string{c.postID, c.parentID, string(c.order), strconv.Itoa(c.offset)}, "|")
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeContinuation(token string) (continuation, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return continuation{}, err
+	}
+	parts := strings.Split(string(raw), "|")
+	if len(parts) != 4 {
+		return continuation{}, fmt.Errorf("malformed continuation")
+	}
+	order, err := parseCommentSort(parts[2])
+	if err != nil {
+		return continuation{}, err
+	}
+	offset, err := strconv.Atoi(parts[3])
+	if err != nil || offset < 0 {
+		return continuation{}, fmt.Errorf("malformed continuation")
+	}
+	return continuation{postID: parts[0], parentID: parts[1], order: order, offset: offset}, nil
+}
+
+func containsComment(comments []*Comment, id string) bool {
+	for _, comment := range comments {
+		if comment.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// sortComments returns a ranked copy of one level of a comment tree. Sibling
+// lists are short, and their scores are cached on each comment, so ranking
+// them per request is cheap.
+func sortComments(comments []*Comment, order SortOrder) []*Comment {
+	sorted := make([]*Comment, len(comments))
+	copy(sorted, comments)
+	sort.Slice(sorted, func(i, j int) bool {
+		return compareRankKeys(commentRankKey(sorted[i], order), commentRankKey(sorted[j], order)) < 0
+	})
+	return sorted
+}
@@ -0,0 +1,120 @@
+// internal/engine/comments_test.go
+package engine
+
+import (
+	"encoding/base64"
+	"fmt"
+	"testing"
+
+	"github.com/kakugri/redditClone/internal/proto"
+)
+
+func (te *testEngine) createReply(postID, parentID, authorID, content string) string {
+	te.t.Helper()
+	resp := te.request(&proto.CreateCommentMsg{PostId: postID, ParentId: parentID, AuthorId: authorID, Content: content}).(*proto.CreateCommentResponse)
+	if resp.Error != nil {
+		te.t.Fatalf("reply to %s: %v", parentID, resp.Error)
+	}
+	return resp.CommentId
+}
+
+// collectComments adds the IDs of the comments of a tree to seen, counting
+// each, and returns the continuations left in it.
+func collectComments(comments []*proto.CommentInfo, more *proto.MoreComments, seen map[string]int) []string {
+	var continuations []string
+	if more != nil {
+		continuations = append(continuations, more.Continuation)
+	}
+	for _, comment := range comments {
+		seen[comment.Id]++
+		continuations = append(continuations, collectComments(comment.Replies, comment.More, seen)...)
+	}
+	return continuations
+}
+
+func TestContinuationsReachEveryComment(t *testing.T) {
+	te := startEngine(t, NewRedditEngine())
+	author := te.registerUser("author")
+	subredditID := te.createSubreddit("threads", author)
+	postID := te.createPost(subredditID, author, "title")
+
+	// Wider than a response's limit at the top and below it, and a thread
+	// deeper than a response's depth
+	var created []string
+	for i := 0; i < 12; i++ {
+		created = append(created, te.createComment(postID, author, fmt.Sprintf("top %d", i)))
+	}
+	for i := 0; i < 9; i++ {
+		created = append(created, te.createReply(postID, created[0], author, fmt.Sprintf("wide %d", i)))
+	}
+	parent := created[1]
+	for i := 0; i < maxCommentDepth-1; i++ {
+		parent = te.createReply(postID, parent, author, fmt.Sprintf("deep %d", i))
+		created = append(created, parent)
+	}
+
+	for _, sort := range []string{"", "new", "top", "controversial"} {
+		seen := make(map[string]int)
+		pending := []string{""}
+		for requests := 0; len(pending) > 0; requests++ {
+			if requests > len(created) {
+				t.Fatalf("sort %q: more requests than comments", sort)
+			}
+			cont := pending[0]
+			pending = pending[1:]
+			resp := te.request(&proto.GetPostCommentsMsg{PostId: postID, Sort: sort, Depth: 3, Limit: 5, Continuation: cont}).(*proto.GetPostCommentsResponse)
+			if resp.Error != nil {
+				t.Fatalf("sort %q: %v", sort, resp.Error)
+			}
+			if len(resp.Comments) > 5 {
+				t.Errorf("sort %q: %d comments in a response limited to 5", sort, len(resp.Comments))
+			}
+			pending = append(pending, collectComments(resp.Comments, resp.More, seen)...)
+		}
+
+		if len(seen) != len(created) {
+			t.Errorf("sort %q: reached %d comments, want %d", sort, len(seen), len(created))
+		}
+		for _, id := range created {
+			if seen[id] != 1 {
+				t.Errorf("sort %q: %s returned %d times, want once", sort, id, seen[id])
+			}
+		}
+	}
+}
+
+func TestContinuationRejectsCorruption(t *testing.T) {
+	te := startEngine(t, NewRedditEngine())
+	author := te.registerUser("author")
+	subredditID := te.createSubreddit("corrupt", author)
+	postID := te.createPost(subredditID, author, "title")
+	otherPost := te.createPost(subredditID, author, "other")
+	commentID := te.createComment(postID, author, "comment")
+
+	encode := func(raw string) string { return base64.RawURLEncoding.EncodeToString([]byte(raw)) }
+	valid := continuation{postID: postID, parentID: commentID, order: SortBest, offset: 1}.encode()
+	tests := []struct {
+		name, token, code string
+	}{
+		{"not base64", "!!" + valid, ErrCodeInvalidArgument},
+		{"flipped character", "A" + valid[1:], ErrCodeInvalidArgument},
+		{"missing part", encode(postID + "|" + commentID + "|best"), ErrCodeInvalidArgument},
+		{"extra part", encode(postID + "|" + commentID + "|best|1|2"), ErrCodeInvalidArgument},
+		{"unknown sort", encode(postID + "|" + commentID + "|sideways|1"), ErrCodeInvalidArgument},
+		{"negative offset", encode(postID + "|" + commentID + "|best|-1"), ErrCodeInvalidArgument},
+		{"offset not a number", encode(postID + "|" + commentID + "|best|one"), ErrCodeInvalidArgument},
+		{"another post", continuation{postID: otherPost, parentID: commentID, order: SortBest}.encode(), ErrCodeInvalidArgument},
+		{"missing parent", continuation{postID: postID, parentID: "t1_missing", order: SortBest}.encode(), ErrCodeNotFound},
+	}
+	for _, tt := range tests {
+		resp := te.request(&proto.GetPostCommentsMsg{PostId: postID, Continuation: tt.token}).(*proto.GetPostCommentsResponse)
+		if resp.Error == nil || resp.Error.Code != tt.code {
+			t.Errorf("%s: got %v, want %s", tt.name, resp.Error, tt.code)
+		}
+	}
+
+	resp := te.request(&proto.GetPostCommentsMsg{PostId: postID, Continuation: valid}).(*proto.GetPostCommentsResponse)
+	if resp.Error != nil {
+		t.Errorf("valid continuation: %v", resp.Error)
+	}
+}
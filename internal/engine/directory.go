@@ -0,0 +1,161 @@
+// internal/engine/directory.go
+package engine
+
+import (
+	"context"
+	"log"
+
+	"github.com/asynkron/protoactor-go/actor"
+	"github.com/asynkron/protoactor-go/cluster"
+	"github.com/kakugri/redditClone/internal/idgen"
+	"github.com/kakugri/redditClone/internal/proto"
+)
+
+// directoryGrain routes requests about posts and comments, whose
+// IDs do not say which grain owns them. The owner index is partitioned over
+// the directory grains by record ID; each entry is written by the grain that
+// creates the record, before it answers.
+//
+// The directory also picks the IDs of new users and subreddits and activates
+// their grains with them. It only ever forwards, so it never blocks on a
+// grain.
+type directoryGrain struct {
+	store   Store
+	cluster *cluster.Cluster
+	trace   context.Context // of the message being handled, nil unless it is traced
+}
+
+func newDirectoryGrain(store Store) *directoryGrain {
+	d := &directoryGrain{}
+	d.store = &tracedStore{Store: store, trace: &d.trace}
+	return d
+}
+
+func (d *directoryGrain) traceWith(ctx context.Context) {
+	d.trace = ctx
+}
+
+func (d *directoryGrain) Receive(context actor.Context) {
+	switch msg := context.Message().(type) {
+	case *cluster.ClusterInit:
+		d.cluster = msg.Cluster
+
+	case *proto.RegisterUserMsg:
+		d.registerUser(context, msg)
+	case *proto.CreateSubredditMsg:
+		d.createSubreddit(context, msg)
+	case *proto.RegisterOwnerMsg:
+		d.store.PutOwner(msg.Id, msg.OwnerId)
+		if err := d.store.Commit(); err != nil {
+			log.Printf("Failed to store the owner of %s: %v", msg.Id, err)
+			respond(context, &proto.RegisterOwnerResponse{
+				Error: newError(ErrCodeInternal, "failed to persist the change"),
+			})
+			return
+		}
+		respond(context, &proto.RegisterOwnerResponse{})
+
+	case *proto.GetSubredditMsg:
+		d.forwardToOwner(context, subredditNameKey(msg.Name), SubredditKind, "subreddit %q not found")
+	case *proto.GetCredentialsMsg:
+		d.forwardToOwner(context, usernameKey(msg.Username), UserKind, "user %q not found")
+	case *proto.GetPostMsg:
+		d.forwardToOwner(context, msg.PostId, SubredditKind, "post %q not found")
+	case *proto.CreateCommentMsg:
+		d.forwardToOwner(context, msg.PostId, SubredditKind, "post %q not found")
+	case *proto.GetPostCommentsMsg:
+		d.forwardToOwner(context, msg.PostId, SubredditKind, "post %q not found")
+	case *proto.EditPostMsg:
+		d.forwardToOwner(context, msg.PostId, SubredditKind, "post %q not found")
+	case *proto.DeletePostMsg:
+		d.forwardToOwner(context, msg.PostId, SubredditKind, "post %q not found")
+	case *proto.EditCommentMsg:
+		d.forwardToOwner(context, msg.CommentId, SubredditKind, "comment %q not found")
+	case *proto.DeleteCommentMsg:
+		d.forwardToOwner(context, msg.CommentId, SubredditKind, "comment %q not found")
+	case *proto.RemovePostMsg:
+		d.forwardToOwner(context, msg.PostId, SubredditKind, "post %q not found")
+	case *proto.RemoveCommentMsg:
+		d.forwardToOwner(context, msg.CommentId, SubredditKind, "comment %q not found")
+	case *proto.ReportMsg:
+		d.forwardToOwner(context, msg.TargetId, SubredditKind, "report target %q not found")
+	case *proto.ResolveReportsMsg:
+		d.forwardToOwner(context, msg.TargetId, SubredditKind, "post or comment %q not found")
+	case *proto.VoteMsg:
+		d.forwardToOwner(context, msg.TargetId, SubredditKind, "vote target %q not found")
+
+	case *actor.Started, *actor.Stopping, *actor.Stopped, *actor.Restarting:
+	default:
+		log.Printf("Unhandled message type: %T", msg)
+	}
+}
+
+// registerUser picks the ID of a new user and indexes their username, which
+// this grain is the directory of, before the user's grain creates them.
+// Invalid usernames, and those already indexed, are refused.
+func (d *directoryGrain) registerUser(context actor.Context, msg *proto.RegisterUserMsg) {
+	err := validateRegisterUser(msg)
+	if err == nil {
+		err = nameFree(d.store, usernameKey(msg.Username), "username", "username", msg.Username)
+	}
+	if err != nil {
+		respond(context, &proto.RegisterUserResponse{Error: err})
+		return
+	}
+	id := idgen.New(idgen.KindUser)
+	d.store.PutOwner(usernameKey(msg.Username), id)
+	if err := d.store.Commit(); err != nil {
+		log.Printf("Failed to index username %q: %v", msg.Username, err)
+		respond(context, &proto.RegisterUserResponse{
+			Error: newError(ErrCodeInternal, "failed to persist the change"),
+		})
+		return
+	}
+	d.forward(context, id, UserKind)
+}
+
+// createSubreddit picks the ID of a new subreddit and indexes its name, which
+// this grain is the directory of, before the subreddit's grain creates it.
+// Invalid names, and those already indexed, are refused. Like other owner
+// entries, the name routes to a grain that answers that the subreddit does
+// not exist if the command is then rejected.
+func (d *directoryGrain) createSubreddit(context actor.Context, msg *proto.CreateSubredditMsg) {
+	err := validateCreateSubreddit(msg)
+	if err == nil {
+		err = nameFree(d.store, subredditNameKey(msg.Name), "name", "subreddit", msg.Name)
+	}
+	if err != nil {
+		respond(context, &proto.CreateSubredditResponse{Error: err})
+		return
+	}
+	id := idgen.New(idgen.KindSubreddit)
+	d.store.PutOwner(subredditNameKey(msg.Name), id)
+	if err := d.store.Commit(); err != nil {
+		log.Printf("Failed to index subreddit name %q: %v", msg.Name, err)
+		respond(context, &proto.CreateSubredditResponse{
+			Error: newError(ErrCodeInternal, "failed to persist the change"),
+		})
+		return
+	}
+	d.forward(context, id, SubredditKind)
+}
+
+// forwardToOwner passes the current message on to the grain that owns the
+// record id, which answers it.
+func (d *directoryGrain) forwardToOwner(context actor.Context, id, kind, format string) {
+	ownerID, err := d.store.Owner(id)
+	if err != nil {
+		respond(context, errorResponse(context.Message(), storeError(err, format, id)))
+		return
+	}
+	d.forward(context, ownerID, kind)
+}
+
+func (d *directoryGrain) forward(context actor.Context, identity, kind string) {
+	pid := grainPID(d.cluster, identity, kind)
+	if pid == nil {
+		respond(context, errorResponse(context.Message(), newError(ErrCodeInternal, "%s %q unreachable", kind, identity)))
+		return
+	}
+	context.Forward(pid)
+}
@@ -0,0 +1,168 @@
+// internal/engine/edits.go
+package engine
+
+import (
+	"log"
+
+	"github.com/asynkron/protoactor-go/actor"
+	"github.com/kakugri/redditClone/internal/proto"
+)
+
+func (e *RedditEngine) handleEditPost(context actor.Context, msg *proto.EditPostMsg) {
+	if err := e.check(validateEditPost(msg)); err != nil {
+		respond(context, &proto.EditPostResponse{PostId: msg.PostId, Error: err})
+		return
+	}
+	post, err := e.store.Post(msg.PostId)
+	if err != nil {
+		respond(context, &proto.EditPostResponse{
+			PostId: msg.PostId,
+			Error:  storeError(err, "post %q not found", msg.PostId),
+		})
+		return
+	}
+	if err := checkEditable(post.AuthorID, msg.UserId, post.Deleted, post.Removed); err != nil {
+		respond(context, &proto.EditPostResponse{PostId: post.ID, Error: err})
+		return
+	}
+
+	title, content := post.Title, post.Content
+	if msg.Title != "" {
+		title = msg.Title
+	}
+	if msg.Content != "" {
+		content = msg.Content
+	}
+	if title == post.Title && content == post.Content {
+		// Nothing changed, so there is no revision to keep
+		respond(context, &proto.EditPostResponse{PostId: post.ID, EditedAt: unixOrZero(post.EditedAt)})
+		return
+	}
+
+	now := e.now()
+	post.History = append(post.History, Revision{Title: post.Title, Content: post.Content, ReplacedAt: now})
+	post.Title, post.Content = title, content
+	post.EditedAt = now
+	e.store.PutPost(post)
+	if err := e.commit(msg); err != nil {
+		respond(context, &proto.EditPostResponse{PostId: post.ID, Error: err})
+		return
+	}
+	log.Printf("Post edited: PostID=%s, Revisions=%d", post.ID, len(post.History))
+	respond(context, &proto.EditPostResponse{PostId: post.ID, EditedAt: now.Unix()})
+}
+
+func (e *RedditEngine) handleEditComment(context actor.Context, msg *proto.EditCommentMsg) {
+	if err := e.check(validateComment(msg.Content, true)); err != nil {
+		respond(context, &proto.EditCommentResponse{CommentId: msg.CommentId, Error: err})
+		return
+	}
+	comment, err := e.store.Comment(msg.CommentId)
+	if err != nil {
+		respond(context, &proto.EditCommentResponse{
+			CommentId: msg.CommentId,
+			Error:     storeError(err, "comment %q not found", msg.CommentId),
+		})
+		return
+	}
+	if err := checkEditable(comment.AuthorID, msg.UserId, comment.Deleted, comment.Removed); err != nil {
+		respond(context, &proto.EditCommentResponse{CommentId: comment.ID, Error: err})
+		return
+	}
+
+	// Edits journaled before an edit needed content may have none
+	if msg.Content == "" || msg.Content == comment.Content {
+		respond(context, &proto.EditCommentResponse{CommentId: comment.ID, EditedAt: unixOrZero(comment.EditedAt)})
+		return
+	}
+
+	now := e.now()
+	comment.History = append(comment.History, Revision{Content: comment.Content, ReplacedAt: now})
+	comment.Content = msg.Content
+	comment.EditedAt = now
+	e.store.PutComment(comment)
+	if err := e.commit(msg); err != nil {
+		respond(context, &proto.EditCommentResponse{CommentId: comment.ID, Error: err})
+		return
+	}
+	log.Printf("Comment edited: CommentID=%s, Revisions=%d", comment.ID, len(comment.History))
+	respond(context, &proto.EditCommentResponse{CommentId: comment.ID, EditedAt: now.Unix()})
+}
+
+func (e *RedditEngine) handleDeletePost(context actor.Context, msg *proto.DeletePostMsg) {
+	post, err := e.store.Post(msg.PostId)
+	if err != nil {
+		respond(context, &proto.DeletePostResponse{
+			PostId: msg.PostId,
+			Error:  storeError(err, "post %q not found", msg.PostId),
+		})
+		return
+	}
+	if msg.UserId == "" || post.AuthorID != msg.UserId {
+		respond(context, &proto.DeletePostResponse{
+			PostId: post.ID,
+			Error:  newError(ErrCodePermissionDenied, "only the author can delete post %q", post.ID),
+		})
+		return
+	}
+
+	// The post keeps its place, title and comments; its content and history go.
+	// Listings skip it from now on, and the moderation queue drops it.
+	post.Deleted = true
+	post.Content = ""
+	post.History = nil
+	e.dequeue(post.SubredditID, post.ID, &post.Review)
+	e.store.PutPost(post)
+	if err := e.commit(msg); err != nil {
+		respond(context, &proto.DeletePostResponse{PostId: post.ID, Error: err})
+		return
+	}
+	log.Printf("Post deleted: PostID=%s", post.ID)
+	respond(context, &proto.DeletePostResponse{PostId: post.ID})
+}
+
+func (e *RedditEngine) handleDeleteComment(context actor.Context, msg *proto.DeleteCommentMsg) {
+	comment, err := e.store.Comment(msg.CommentId)
+	if err != nil {
+		respond(context, &proto.DeleteCommentResponse{
+			CommentId: msg.CommentId,
+			Error:     storeError(err, "comment %q not found", msg.CommentId),
+		})
+		return
+	}
+	if msg.UserId == "" || comment.AuthorID != msg.UserId {
+		respond(context, &proto.DeleteCommentResponse{
+			CommentId: comment.ID,
+			Error:     newError(ErrCodePermissionDenied, "only the author can delete comment %q", comment.ID),
+		})
+		return
+	}
+
+	// The comment stays in the tree so its replies keep their parent
+	comment.Deleted = true
+	comment.Content = ""
+	comment.History = nil
+	if comment.Review.queued() {
+		if post, err := e.store.Post(comment.PostID); err == nil {
+			e.dequeue(post.SubredditID, comment.ID, &comment.Review)
+		}
+	}
+	e.store.PutComment(comment)
+	if err := e.commit(msg); err != nil {
+		respond(context, &proto.DeleteCommentResponse{CommentId: comment.ID, Error: err})
+		return
+	}
+	log.Printf("Comment deleted: CommentID=%s", comment.ID)
+	respond(context, &proto.DeleteCommentResponse{CommentId: comment.ID})
+}
+
+// checkEditable allows edits by the author of content that still exists.
+func checkEditable(authorID, userID string, deleted, removed bool) *proto.Error {
+	if deleted || removed {
+		return newError(ErrCodeInvalidArgument, "%s content cannot be edited", goneAs(deleted))
+	}
+	if userID == "" || authorID != userID {
+		return newError(ErrCodePermissionDenied, "only the author can edit this")
+	}
+	return nil
+}
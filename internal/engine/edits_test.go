@@ -0,0 +1,54 @@
+// internal/engine/edits_test.go
+package engine
+
+import (
+	"testing"
+
+	"github.com/kakugri/redditClone/internal/proto"
+)
+
+func TestEditKeepsRevisionsOfChangesOnly(t *testing.T) {
+	e := NewRedditEngine()
+	te := startEngine(t, e)
+	author := te.registerUser("author")
+	subredditID := te.createSubreddit("edits", author)
+	postID := te.createPost(subredditID, author, "title")
+	commentID := te.createComment(postID, author, "comment")
+
+	empty := te.request(&proto.EditPostMsg{PostId: postID, UserId: author}).(*proto.EditPostResponse)
+	if empty.Error == nil || empty.Error.Code != ErrCodeInvalidArgument {
+		t.Errorf("post edit without fields: got %v, want %s", empty.Error, ErrCodeInvalidArgument)
+	}
+	emptyComment := te.request(&proto.EditCommentMsg{CommentId: commentID, UserId: author}).(*proto.EditCommentResponse)
+	if emptyComment.Error == nil || emptyComment.Error.Code != ErrCodeInvalidArgument {
+		t.Errorf("comment edit without content: got %v, want %s", emptyComment.Error, ErrCodeInvalidArgument)
+	}
+
+	same := te.request(&proto.EditPostMsg{PostId: postID, UserId: author, Title: "title"}).(*proto.EditPostResponse)
+	if same.Error != nil || same.EditedAt != 0 {
+		t.Errorf("post edit that changes nothing: got edited at %d, %v, want 0 and no error", same.EditedAt, same.Error)
+	}
+	sameComment := te.request(&proto.EditCommentMsg{CommentId: commentID, UserId: author, Content: "comment"}).(*proto.EditCommentResponse)
+	if sameComment.Error != nil || sameComment.EditedAt != 0 {
+		t.Errorf("comment edit that changes nothing: got edited at %d, %v, want 0 and no error", sameComment.EditedAt, sameComment.Error)
+	}
+
+	edited := te.request(&proto.EditPostMsg{PostId: postID, UserId: author, Content: "new content"}).(*proto.EditPostResponse)
+	if edited.Error != nil || edited.EditedAt == 0 {
+		t.Fatalf("post edit: got edited at %d, %v", edited.EditedAt, edited.Error)
+	}
+	te.request(&proto.EditPostMsg{PostId: postID, UserId: author, Content: "new content"})
+	editedComment := te.request(&proto.EditCommentMsg{CommentId: commentID, UserId: author, Content: "new comment"}).(*proto.EditCommentResponse)
+	if editedComment.Error != nil || editedComment.EditedAt == 0 {
+		t.Fatalf("comment edit: got edited at %d, %v", editedComment.EditedAt, editedComment.Error)
+	}
+
+	post, _ := e.store.Post(postID)
+	if len(post.History) != 1 || post.History[0].Content != "content of title" || post.Title != "title" {
+		t.Errorf("post has title %q and history %+v, want one revision of the original", post.Title, post.History)
+	}
+	comment, _ := e.store.Comment(commentID)
+	if len(comment.History) != 1 || comment.History[0].Content != "comment" {
+		t.Errorf("comment has history %+v, want one revision of the original", comment.History)
+	}
+}
@@ -2,104 +2,277 @@
 package engine
 
 import (
+	"context"
 	"log"
 	"runtime"
-	"sync"
 	"time"
 
+	"github.com/kakugri/redditClone/internal/idgen"
 	"github.com/kakugri/redditClone/internal/proto"
 
 	"github.com/asynkron/protoactor-go/actor"
+	"github.com/asynkron/protoactor-go/cluster"
+	"github.com/asynkron/protoactor-go/scheduler"
 	"github.com/shirou/gopsutil/v3/cpu"
 )
 
+// RedditEngine applies the commands of clients. It is an actor, and its state
+// is only touched while it handles a message, one at a time, so the handlers
+// take no locks; anything else reads the state by sending a query message.
 type RedditEngine struct {
-	users      map[string]*User
-	subreddits map[string]*Subreddit
-	posts      map[string]*Post
-	messages   map[string][]*DirectMessage
-	comments   map[string][]*Comment
-	metrics    *Metrics
-	mu         sync.RWMutex
+	store    Store
+	rankings map[string]*postRanking // subreddit ID -> ranked posts
+	automods map[string]*automod     // subreddit ID -> compiled AutoModerator rules
+	metrics  *Metrics
+	journal  *Journal         // nil unless the engine is durable
+	cmd      command          // the command being applied
+	cluster  *cluster.Cluster // set in the grains of an engine cluster, which own part of the records
+	recovery *recovery        // shared by the engine's incarnations
+	stopTick func()           // stops the metrics ticks
+	trace    context.Context  // of the message being handled, nil unless it is traced
 }
 
+// NewRedditEngine returns an engine that keeps its state in memory.
 func NewRedditEngine() *RedditEngine {
-	return &RedditEngine{
-		users:      make(map[string]*User),
-		subreddits: make(map[string]*Subreddit),
-		posts:      make(map[string]*Post),
-		messages:   make(map[string][]*DirectMessage),
-		comments:   make(map[string][]*Comment),
+	return newRedditEngine(NewMemoryStore())
+}
+
+// NewRedditEngineWithStore runs the engine on an existing store, rebuilding
+// the ranking indexes and metrics from its contents.
+func NewRedditEngineWithStore(store Store) (*RedditEngine, error) {
+	e := newRedditEngine(store)
+	if err := e.loadRankings(); err != nil {
+		return nil, err
+	}
+	totals, err := store.Totals()
+	if err != nil {
+		return nil, err
+	}
+	e.updateMetrics(func(m *Metrics) {
+		m.ActiveUsers = totals.Users
+		m.TotalPosts = totals.Posts
+		m.TotalComments = totals.Comments
+		m.TotalVotes = totals.Votes
+		m.TotalMessages = totals.Messages
+	})
+	return e, nil
+}
+
+func newRedditEngine(store Store) *RedditEngine {
+	e := &RedditEngine{
+		rankings: make(map[string]*postRanking),
+		automods: make(map[string]*automod),
 		metrics: &Metrics{
 			StartTime: time.Now(),
 		},
+		recovery: newRecovery(),
 	}
+	e.store = &tracedStore{Store: store, trace: &e.trace}
+	return e
+}
+
+func (e *RedditEngine) traceWith(ctx context.Context) {
+	e.trace = ctx
 }
 
 func (e *RedditEngine) Receive(context actor.Context) {
 	message := context.Message()
-	log.Printf("Engine received message of type: %T, content: %+v", message, message)
+	log.Printf("Engine received message of type: %T", message)
 
 	switch msg := message.(type) {
 	case *actor.Started:
 		log.Println("RedditEngine started and ready to receive messages.")
-		e.StartMetricsReporter(context)
-	case *proto.MetricsReportMsg:
-		// Capture memory usage
-		var m runtime.MemStats
-		runtime.ReadMemStats(&m)
-
-		// Capture CPU usage
-		cpuPercent, _ := cpu.Percent(0, false)
-		log.Printf("Metrics Report: TotalPosts=%d, ActiveUsers=%d, TotalVotes=%d, TotalComments=%d, TotalMessages=%d, Memory=%.2f MB, CPU=%.2f%%", e.metrics.TotalPosts, e.metrics.ActiveUsers,
-			e.metrics.TotalVotes, e.metrics.TotalComments, e.metrics.TotalMessages, float64(m.Alloc)/1024/1024,
-			cpuPercent[0])
+		e.stopTick = scheduleMetrics(context)
+	case *metricsTick:
+		logMetricsReport(e.recovery.report(e.metrics.report()))
+	case *actor.Restarting:
+		// The next incarnation schedules ticks of its own
+		e.stopTick()
+	case *actor.Stopping:
+		e.stopTick()
+		if e.journal != nil {
+			if err := e.takeSnapshot(); err != nil {
+				log.Printf("Failed to take snapshot: %v", err)
+			}
+		}
+	default:
+		e.cmd = command{at: time.Now()}
+		if !e.apply(context, message) {
+			log.Printf("Unhandled message type: %T", msg)
+		}
+	}
+}
+
+// apply routes a client message to its handler and reports whether it knows
+// the message type. Journal replay calls it with a nil context.
+func (e *RedditEngine) apply(context actor.Context, message interface{}) bool {
+	switch msg := message.(type) {
 	case *proto.RegisterUserMsg:
-		log.Printf("Received RegisterUserMsg: %+v", msg)
+		log.Printf("Received RegisterUserMsg: %q", msg.Username)
 		e.handleRegisterUser(context, msg)
 	case *proto.CreateSubredditMsg:
-		log.Printf("Received CreateSubredditMsg: %+v", msg)
+		log.Printf("Received CreateSubredditMsg: Name=%q, CreatorId=%s", msg.Name, msg.CreatorId)
 		e.handleCreateSubreddit(context, msg)
+	case *proto.JoinSubredditMsg:
+		log.Printf("Received JoinSubredditMsg: UserId=%s, SubredditId=%s", msg.UserId, msg.SubredditId)
+		e.handleJoinSubreddit(context, msg)
+	case *proto.LeaveSubredditMsg:
+		log.Printf("Received LeaveSubredditMsg: UserId=%s, SubredditId=%s", msg.UserId, msg.SubredditId)
+		e.handleLeaveSubreddit(context, msg)
+	case *proto.GetUserKarmaMsg:
+		e.handleGetUserKarma(context, msg)
+	case *proto.GetUserSubredditsMsg:
+		e.handleGetUserSubreddits(context, msg)
+	case *proto.GetFeedMsg:
+		e.handleGetFeed(context, msg)
+	case *proto.GetSubredditPostsMsg:
+		e.handleGetSubredditPosts(context, msg)
+	case *proto.GetPostCommentsMsg:
+		e.handleGetPostComments(context, msg)
+	case *proto.GetUserMsg:
+		e.handleGetUser(context, msg)
+	case *proto.GetSubredditMsg:
+		e.handleGetSubreddit(context, msg)
+	case *proto.GetPostMsg:
+		e.handleGetPost(context, msg)
+	case *proto.GetMessagesMsg:
+		e.handleGetMessages(context, msg)
+	case *proto.GetConversationsMsg:
+		e.handleGetConversations(context, msg)
+	case *proto.GetCredentialsMsg:
+		e.handleGetCredentials(context, msg)
 	case *proto.CreatePostMsg:
-		log.Printf("Received CreatePostMsg: %+v", msg)
+		log.Printf("Received CreatePostMsg: AuthorId=%s, SubredditId=%s", msg.AuthorId, msg.SubredditId)
 		e.handleCreatePost(context, msg)
 	case *proto.CreateCommentMsg:
-		log.Printf("Received CreateCommentMsg: %+v", msg)
+		log.Printf("Received CreateCommentMsg: AuthorId=%s, PostId=%s, ParentId=%s", msg.AuthorId, msg.PostId, msg.ParentId)
 		e.handleCreateComment(context, msg)
+	case *proto.EditPostMsg:
+		log.Printf("Received EditPostMsg: PostId=%s, UserId=%s", msg.PostId, msg.UserId)
+		e.handleEditPost(context, msg)
+	case *proto.EditCommentMsg:
+		log.Printf("Received EditCommentMsg: CommentId=%s, UserId=%s", msg.CommentId, msg.UserId)
+		e.handleEditComment(context, msg)
+	case *proto.DeletePostMsg:
+		log.Printf("Received DeletePostMsg: PostId=%s, UserId=%s", msg.PostId, msg.UserId)
+		e.handleDeletePost(context, msg)
+	case *proto.DeleteCommentMsg:
+		log.Printf("Received DeleteCommentMsg: CommentId=%s, UserId=%s", msg.CommentId, msg.UserId)
+		e.handleDeleteComment(context, msg)
+	case *proto.DeleteMessageMsg:
+		log.Printf("Received DeleteMessageMsg: MessageId=%s, UserId=%s", msg.MessageId, msg.UserId)
+		e.handleDeleteMessage(context, msg)
+	case *proto.MarkReadMsg:
+		log.Printf("Received MarkReadMsg: UserId=%s, MessageId=%s, OtherUserId=%s", msg.UserId, msg.MessageId, msg.OtherUserId)
+		e.handleMarkRead(context, msg)
 	case *proto.VoteMsg:
-		log.Printf("Received VoteMsg: %+v", msg)
+		log.Printf("Received VoteMsg: UserId=%s, TargetId=%s", msg.UserId, msg.TargetId)
 		e.handleVote(context, msg)
 	case *proto.DirectMessageMsg:
-		log.Printf("Received DirectMessageMsg: %+v", msg)
+		log.Printf("Received DirectMessageMsg: FromUserId=%s, ToUserId=%s, ReplyToId=%s", msg.FromUserId, msg.ToUserId, msg.ReplyToId)
 		e.handleDirectMessage(context, msg)
+	case *proto.BlockUserMsg:
+		log.Printf("Received BlockUserMsg: UserId=%s, BlockedUserId=%s", msg.UserId, msg.BlockedUserId)
+		e.handleBlockUser(context, msg)
+	case *proto.GetBlocksMsg:
+		e.handleGetBlocks(context, msg)
+	case *proto.SetMessageSettingsMsg:
+		log.Printf("Received SetMessageSettingsMsg: UserId=%s", msg.UserId)
+		e.handleSetMessageSettings(context, msg)
+	case *proto.GetMessageSettingsMsg:
+		e.handleGetMessageSettings(context, msg)
+	case *proto.AddModeratorMsg:
+		log.Printf("Received AddModeratorMsg: SubredditId=%s, UserId=%s, ModeratorId=%s", msg.SubredditId, msg.UserId, msg.ModeratorId)
+		e.handleAddModerator(context, msg)
+	case *proto.RemoveModeratorMsg:
+		log.Printf("Received RemoveModeratorMsg: SubredditId=%s, UserId=%s, ModeratorId=%s", msg.SubredditId, msg.UserId, msg.ModeratorId)
+		e.handleRemoveModerator(context, msg)
+	case *proto.RemovePostMsg:
+		log.Printf("Received RemovePostMsg: PostId=%s, UserId=%s", msg.PostId, msg.UserId)
+		e.handleRemovePost(context, msg)
+	case *proto.RemoveCommentMsg:
+		log.Printf("Received RemoveCommentMsg: CommentId=%s, UserId=%s", msg.CommentId, msg.UserId)
+		e.handleRemoveComment(context, msg)
+	case *proto.RestrictUserMsg:
+		log.Printf("Received RestrictUserMsg: SubredditId=%s, UserId=%s, TargetUserId=%s", msg.SubredditId, msg.UserId, msg.TargetUserId)
+		e.handleRestrictUser(context, msg)
+	case *proto.LiftRestrictionMsg:
+		log.Printf("Received LiftRestrictionMsg: SubredditId=%s, UserId=%s, TargetUserId=%s", msg.SubredditId, msg.UserId, msg.TargetUserId)
+		e.handleLiftRestriction(context, msg)
+	case *proto.GetModLogMsg:
+		e.handleGetModLog(context, msg)
+	case *proto.SetAutomodMsg:
+		log.Printf("Received SetAutomodMsg: SubredditId=%s, UserId=%s", msg.SubredditId, msg.UserId)
+		e.handleSetAutomod(context, msg)
+	case *proto.GetAutomodMsg:
+		e.handleGetAutomod(context, msg)
+	case *proto.SetRulesMsg:
+		log.Printf("Received SetRulesMsg: SubredditId=%s, UserId=%s", msg.SubredditId, msg.UserId)
+		e.handleSetRules(context, msg)
+	case *proto.ReportMsg:
+		log.Printf("Received ReportMsg: TargetId=%s, UserId=%s", msg.TargetId, msg.UserId)
+		e.handleReport(context, msg)
+	case *proto.GetModQueueMsg:
+		e.handleGetModQueue(context, msg)
+	case *proto.ResolveReportsMsg:
+		log.Printf("Received ResolveReportsMsg: TargetId=%s, UserId=%s", msg.TargetId, msg.UserId)
+		e.handleResolveReports(context, msg)
 	default:
-		log.Printf("Unhandled message type: %+v", msg)
+		return false
 	}
+	return true
 }
 
-func (e *RedditEngine) StartMetricsReporter(context actor.Context) {
-	go func() {
-		ticker := time.NewTicker(10 * time.Second)
-		defer ticker.Stop()
+// metricsInterval is how often engines report their metrics.
+const metricsInterval = 10 * time.Second
 
-		for range ticker.C {
-			e.ReportMetrics(context)
-		}
-	}()
+// metricsTick asks an engine actor to report its metrics.
+type metricsTick struct{}
+
+// scheduleMetrics sends the actor of context a metricsTick every
+// metricsInterval until the returned function is called, so the report is
+// made inside the actor like any other message.
+func scheduleMetrics(context actor.Context) scheduler.CancelFunc {
+	timer := scheduler.NewTimerScheduler(context.ActorSystem().Root)
+	return timer.SendRepeatedly(metricsInterval, metricsInterval, context.Self(), &metricsTick{})
 }
 
-func (e *RedditEngine) ReportMetrics(context actor.Context) {
-	e.mu.RLock()
-	defer e.mu.RUnlock()
-
-	metricsCopy := *e.metrics // Create a copy to avoid race conditions
-	context.Send(context.Self(), &proto.MetricsReportMsg{
-		TotalPosts:    metricsCopy.TotalPosts,
-		TotalComments: metricsCopy.TotalComments,
-		TotalVotes:    metricsCopy.TotalVotes,
-		ActiveUsers:   metricsCopy.ActiveUsers,
-		TotalMessages: metricsCopy.TotalMessages,
-	})
+func (m *Metrics) report() *proto.MetricsReportMsg {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return &proto.MetricsReportMsg{
+		TotalPosts:    m.TotalPosts,
+		TotalComments: m.TotalComments,
+		TotalVotes:    m.TotalVotes,
+		ActiveUsers:   m.ActiveUsers,
+		TotalMessages: m.TotalMessages,
+	}
+}
+
+// load replaces the counts of m with those of from.
+func (m *Metrics) load(from *Metrics) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.TotalPosts = from.TotalPosts
+	m.TotalComments = from.TotalComments
+	m.TotalVotes = from.TotalVotes
+	m.ActiveUsers = from.ActiveUsers
+	m.TotalMessages = from.TotalMessages
+}
+
+func logMetricsReport(report *proto.MetricsReportMsg) {
+	// Capture memory usage
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	// Capture CPU usage; cpu.Percent returns no values when it fails
+	var cpuPercent float64
+	if percents, err := cpu.Percent(0, false); err == nil && len(percents) > 0 {
+		cpuPercent = percents[0]
+	}
+	log.Printf("Metrics Report: TotalPosts=%d, ActiveUsers=%d, TotalVotes=%d, TotalComments=%d, TotalMessages=%d, Restarts=%d, QuarantinedMessages=%d, Memory=%.2f MB, CPU=%.2f%%", report.TotalPosts, report.ActiveUsers,
+		report.TotalVotes, report.TotalComments, report.TotalMessages, report.Restarts, report.QuarantinedMessages,
+		float64(m.Alloc)/1024/1024, cpuPercent)
 }
 
 func (e *RedditEngine) updateMetrics(metricFunc func(*Metrics)) {
@@ -109,209 +282,311 @@ func (e *RedditEngine) updateMetrics(metricFunc func(*Metrics)) {
 }
 
 func (e *RedditEngine) handleRegisterUser(context actor.Context, msg *proto.RegisterUserMsg) {
-	e.mu.Lock()
-	defer e.mu.Unlock()
-
+	err := validateRegisterUser(msg)
+	if err == nil && e.cluster == nil {
+		// In a cluster the directory checks the name when it picks the ID
+		err = nameFree(e.store, usernameKey(msg.Username), "username", "username", msg.Username)
+	}
+	if err := e.check(err); err != nil {
+		respond(context, &proto.RegisterUserResponse{Error: err})
+		return
+	}
 	user := &User{
-		ID:       generateID(),
-		Username: msg.Username,
-		JoinDate: time.Now(),
+		ID:             e.newID(idgen.KindUser),
+		Username:       msg.Username,
+		PasswordHash:   msg.PasswordHash,
+		SubredditKarma: make(map[string]*KarmaBreakdown),
+		JoinDate:       e.now(),
+		Subreddits:     make(map[string]bool),
+	}
+	e.store.PutUser(user)
+	if e.cluster == nil {
+		e.store.PutOwner(usernameKey(user.Username), user.ID)
 	}
-	e.users[user.ID] = user
 	e.updateMetrics(func(m *Metrics) {
 		m.ActiveUsers++
 	})
-	log.Printf("User registered: %+v", user)
+	if err := e.commit(msg); err != nil {
+		respond(context, &proto.RegisterUserResponse{Error: err})
+		return
+	}
+	log.Printf("User registered: %s %q", user.ID, user.Username)
+	respond(context, &proto.RegisterUserResponse{UserId: user.ID})
 }
 
 func (e *RedditEngine) handleCreatePost(context actor.Context, msg *proto.CreatePostMsg) {
-	e.mu.Lock()
-	defer e.mu.Unlock()
+	if err := e.check(validateCreatePost(msg)); err != nil {
+		respond(context, &proto.CreatePostResponse{Error: err})
+		return
+	}
+	subreddit, err := e.store.Subreddit(msg.SubredditId)
+	if err != nil {
+		log.Printf("Subreddit not found for CreatePostMsg: SubredditId=%s", msg.SubredditId)
+		respond(context, &proto.CreatePostResponse{
+			Error: storeError(err, "subreddit %q not found", msg.SubredditId),
+		})
+		return
+	}
+	if err := e.checkRestrictions(subreddit, msg.AuthorId, true); err != nil {
+		respond(context, &proto.CreatePostResponse{Error: err})
+		return
+	}
 
 	post := &Post{
-		ID:          generateID(),
+		ID:          e.newID(idgen.KindPost),
 		Title:       msg.Title,
 		Content:     msg.Content,
 		AuthorID:    msg.AuthorId,
 		SubredditID: msg.SubredditId,
-		CreatedAt:   time.Now(),
+		CreatedAt:   e.now(),
+	}
+	post.Scores = computeScores(0, 0, post.CreatedAt)
+	replies := e.runAutomod(subreddit, &reviewTarget{kind: "post", id: post.ID, subredditID: subreddit.ID, post: post}, automodTrigger{}, msg.Author)
+	e.store.PutPost(post)
+	e.storeAutomodReplies(replies)
+	e.ranking(subreddit.ID).add(post)
+	if err := e.commit(msg); err != nil {
+		respond(context, &proto.CreatePostResponse{Error: err})
+		return
 	}
-	e.posts[post.ID] = post
 	e.updateMetrics(func(m *Metrics) {
 		m.TotalPosts++
 	})
-	log.Printf("Post created: %+v", post)
+	e.automodRepliesCommitted(subreddit.ID, replies)
+	log.Printf("Post created: %s in %s", post.ID, post.SubredditID)
+	respond(context, &proto.CreatePostResponse{PostId: post.ID})
 }
 
 func (e *RedditEngine) handleCreateSubreddit(context actor.Context, msg *proto.CreateSubredditMsg) {
-	e.mu.Lock()
-	defer e.mu.Unlock()
-
+	err := validateCreateSubreddit(msg)
+	if err == nil && e.cluster == nil {
+		// In a cluster the directory checks the name when it picks the ID
+		err = nameFree(e.store, subredditNameKey(msg.Name), "name", "subreddit", msg.Name)
+	}
+	if err := e.check(err); err != nil {
+		respond(context, &proto.CreateSubredditResponse{Error: err})
+		return
+	}
 	subreddit := &Subreddit{
-		ID:          generateID(),
+		ID:          e.newID(idgen.KindSubreddit),
 		Name:        msg.Name,
 		Description: msg.Description,
-		Members:     make(map[string]*User),
-		CreatedAt:   time.Now(),
+		CreatorID:   msg.CreatorId,
+		CreatedAt:   e.now(),
 	}
-	e.subreddits[subreddit.ID] = subreddit
-	log.Printf("Subreddit created: %+v", subreddit)
-}
-
-func (e *RedditEngine) handleDirectMessage(context actor.Context, msg *proto.DirectMessageMsg) {
-	e.mu.Lock()
-	defer e.mu.Unlock()
-
-	dm := &DirectMessage{
-		ID:         generateID(),
-		FromUserID: msg.FromUserId,
-		ToUserID:   msg.ToUserId,
-		Content:    msg.Content,
-		CreatedAt:  time.Now(),
+	if msg.CreatorId != "" {
+		// The creator is the first, and most senior, moderator
+		subreddit.Moderators = []string{msg.CreatorId}
 	}
-	e.messages[msg.ToUserId] = append(e.messages[msg.ToUserId], dm)
-	e.updateMetrics(func(m *Metrics) {
-		m.TotalMessages++
-	})
-	log.Printf("Direct message sent: %+v", dm)
+	e.store.PutSubreddit(subreddit)
+	if e.cluster == nil {
+		// In a cluster the directory indexes the name when it picks the ID
+		e.store.PutOwner(subredditNameKey(subreddit.Name), subreddit.ID)
+	}
+	if err := e.commit(msg); err != nil {
+		respond(context, &proto.CreateSubredditResponse{Error: err})
+		return
+	}
+	log.Printf("Subreddit created: %s (%q)", subreddit.ID, subreddit.Name)
+	respond(context, &proto.CreateSubredditResponse{SubredditId: subreddit.ID})
 }
 
 func (e *RedditEngine) handleVote(context actor.Context, msg *proto.VoteMsg) {
-	e.mu.Lock()
-	defer e.mu.Unlock()
+	// In a cluster the voter's grain has checked the voter already
+	if e.cluster == nil {
+		if _, err := e.store.User(msg.UserId); err != nil {
+			respond(context, &proto.VoteResponse{
+				TargetId: msg.TargetId,
+				Error:    storeError(err, "user %q not found", msg.UserId),
+			})
+			return
+		}
+	}
+
+	value := voteValue(msg)
+	previous, err := e.store.Vote(msg.TargetId, msg.UserId)
+	if err != nil {
+		respond(context, &proto.VoteResponse{TargetId: msg.TargetId, Error: storeError(err, "")})
+		return
+	}
 
 	// Check if the vote target is a post
-	if post, exists := e.posts[msg.TargetId]; exists {
-		if msg.IsUpvote {
-			post.Upvotes++
-		} else {
-			post.Downvotes++
+	if post, err := e.store.Post(msg.TargetId); err == nil {
+		if err := e.checkVotable("post", post.ID, post.SubredditID, msg.UserId, post.Deleted, post.Removed); err != nil {
+			respond(context, &proto.VoteResponse{TargetId: post.ID, Error: err})
+			return
+		}
+		if value != previous {
+			e.rescorePost(post, func() {
+				applyVote(&post.Upvotes, &post.Downvotes, previous, value)
+			})
+			e.store.PutPost(post)
+			e.recordVote(msg.TargetId, msg.UserId, previous, value)
+			e.creditKarma(post.AuthorID, post.SubredditID, value-previous, 0)
+			if err := e.commit(msg); err != nil {
+				respond(context, &proto.VoteResponse{TargetId: post.ID, Error: err})
+				return
+			}
+			e.sendKarma(context)
 		}
 		log.Printf("Vote applied to post: PostID=%s, Upvotes=%d, Downvotes=%d, UserID=%s",
 			post.ID, post.Upvotes, post.Downvotes, msg.UserId)
-		e.updateMetrics(func(m *Metrics) {
-			m.TotalVotes++
+		respond(context, &proto.VoteResponse{
+			TargetId:  post.ID,
+			Upvotes:   int32(post.Upvotes),
+			Downvotes: int32(post.Downvotes),
+			Direction: voteDirection(value),
 		})
 		return
 	}
 
-	// Check if the vote target is a comment (very inefficient)
-	for _, comments := range e.comments {
-		for _, comment := range comments {
-			if comment.ID == msg.TargetId {
-				if msg.IsUpvote {
-					comment.Upvotes++
-				} else {
-					comment.Downvotes++
-				}
-				log.Printf("Vote applied to comment: CommentID=%s, Upvotes=%d, Downvotes=%d, UserID=%s",
-					comment.ID, comment.Upvotes, comment.Downvotes, msg.UserId)
-				e.updateMetrics(func(m *Metrics) {
-					m.TotalVotes++
-				})
+	// Check if the vote target is a comment
+	if comment, err := e.store.Comment(msg.TargetId); err == nil {
+		subredditID := ""
+		if post, err := e.store.Post(comment.PostID); err == nil {
+			subredditID = post.SubredditID
+		}
+		if err := e.checkVotable("comment", comment.ID, subredditID, msg.UserId, comment.Deleted, comment.Removed); err != nil {
+			respond(context, &proto.VoteResponse{TargetId: comment.ID, Error: err})
+			return
+		}
+		if value != previous {
+			rescoreComment(comment, func() {
+				applyVote(&comment.Upvotes, &comment.Downvotes, previous, value)
+			})
+			e.store.PutComment(comment)
+			e.recordVote(msg.TargetId, msg.UserId, previous, value)
+			if subredditID != "" {
+				e.creditKarma(comment.AuthorID, subredditID, 0, value-previous)
+			}
+			if err := e.commit(msg); err != nil {
+				respond(context, &proto.VoteResponse{TargetId: comment.ID, Error: err})
 				return
 			}
+			e.sendKarma(context)
 		}
+		log.Printf("Vote applied to comment: CommentID=%s, Upvotes=%d, Downvotes=%d, UserID=%s",
+			comment.ID, comment.Upvotes, comment.Downvotes, msg.UserId)
+		respond(context, &proto.VoteResponse{
+			TargetId:  comment.ID,
+			Upvotes:   int32(comment.Upvotes),
+			Downvotes: int32(comment.Downvotes),
+			Direction: voteDirection(value),
+		})
+		return
 	}
 
-	log.Printf("Target not found for VoteMsg: %+v", msg)
+	log.Printf("Target not found for VoteMsg: TargetId=%s", msg.TargetId)
+	respond(context, &proto.VoteResponse{
+		TargetId: msg.TargetId,
+		Error:    newError(ErrCodeNotFound, "vote target %q not found", msg.TargetId),
+	})
 }
 
-// func (e *RedditEngine) handleVote(context actor.Context, msg *proto.VoteMsg) {
-// 	e.mu.Lock()
-// 	defer e.mu.Unlock()
-
-// 	post, exists := e.posts[msg.TargetId]
-// 	if !exists {
-// 		log.Printf("Post not found for vote: %s", msg.TargetId)
-// 		return
-// 	}
-
-// 	if msg.IsUpvote {
-// 		post.Upvotes++
-// 	} else {
-// 		post.Downvotes++
-// 	}
-// 	e.updateMetrics(func(m *Metrics) {
-// 		m.TotalVotes++
-// 	})
-// 	log.Printf("Vote processed for post %s: Upvotes=%d, Downvotes=%d", msg.TargetId, post.Upvotes, post.Downvotes)
-// }
+// checkVotable allows votes on a post or comment of subredditID that is still
+// there, by users not banned from the subreddit.
+func (e *RedditEngine) checkVotable(kind, id, subredditID, userID string, deleted, removed bool) *proto.Error {
+	if deleted || removed {
+		return newError(ErrCodeInvalidArgument, "%s %q has been %s", kind, id, goneAs(deleted))
+	}
+	if subreddit, err := e.store.Subreddit(subredditID); err == nil {
+		return e.checkRestrictions(subreddit, userID, false)
+	}
+	return nil
+}
+
+// goneAs says how content that is no longer there went: deleted by its author
+// or removed by a moderator.
+func goneAs(deleted bool) string {
+	if deleted {
+		return "deleted"
+	}
+	return "removed"
+}
 
 func (e *RedditEngine) handleCreateComment(context actor.Context, msg *proto.CreateCommentMsg) {
-	e.mu.Lock()
-	defer e.mu.Unlock()
+	if err := e.check(validateComment(msg.Content, true)); err != nil {
+		respond(context, &proto.CreateCommentResponse{Error: err})
+		return
+	}
 
 	// Check if the post exists
-	post, postExists := e.posts[msg.PostId]
-	if !postExists {
-		log.Printf("Post not found for CreateCommentMsg: %+v", msg)
+	post, err := e.store.Post(msg.PostId)
+	if err != nil {
+		log.Printf("Post not found for CreateCommentMsg: PostId=%s", msg.PostId)
+		respond(context, &proto.CreateCommentResponse{
+			Error: storeError(err, "post %q not found", msg.PostId),
+		})
+		return
+	}
+	if post.Deleted || post.Removed {
+		respond(context, &proto.CreateCommentResponse{
+			Error: newError(ErrCodeInvalidArgument, "post %q has been %s", post.ID, goneAs(post.Deleted)),
+		})
+		return
+	}
+	subreddit, err := e.store.Subreddit(post.SubredditID)
+	if err != nil {
+		respond(context, &proto.CreateCommentResponse{
+			Error: storeError(err, "subreddit %q not found", post.SubredditID),
+		})
 		return
 	}
+	if err := e.checkRestrictions(subreddit, msg.AuthorId, true); err != nil {
+		respond(context, &proto.CreateCommentResponse{Error: err})
+		return
+	}
+	// Moderators can still comment on what is locked
+	moderator := subreddit.isModerator(msg.AuthorId)
+	if post.Locked && !moderator {
+		respond(context, &proto.CreateCommentResponse{
+			Error: newError(ErrCodePermissionDenied, "post %q is locked", post.ID),
+		})
+		return
+	}
+
+	// If the comment is a reply, validate the parent before storing anything
+	depth := 0
+	if msg.ParentId != "" {
+		parent, err := e.replyParent(msg.PostId, msg.ParentId)
+		if err == nil && parent.Locked && !moderator {
+			err = newError(ErrCodePermissionDenied, "comment %q is locked", parent.ID)
+		}
+		if err != nil {
+			log.Printf("Invalid parent for CreateCommentMsg: ParentId=%s", msg.ParentId)
+			respond(context, &proto.CreateCommentResponse{Error: err})
+			return
+		}
+		depth = parent.Depth + 1
+	}
 
 	// Create a new comment
 	comment := &Comment{
-		ID:        generateID(),
+		ID:        e.newID(idgen.KindComment),
 		Content:   msg.Content,
 		AuthorID:  msg.AuthorId,
 		PostID:    msg.PostId,
 		ParentID:  msg.ParentId,
-		Children:  []*Comment{},
-		CreatedAt: time.Now(),
-	}
-
-	// Add to the comments map
-	if _, exists := e.comments[comment.PostID]; !exists {
-		e.comments[comment.PostID] = []*Comment{}
-	}
-	e.comments[comment.PostID] = append(e.comments[comment.PostID], comment)
-
-	// If the comment has a parent, link it to the parent
-	if comment.ParentID != "" {
-		parentFound := false
-		// Get all the comments for the post
-		for _, c := range e.comments[comment.PostID] {
-			// Check if the parent comment exists
-			if c.ID == comment.ParentID {
-				c.Children = append(c.Children, comment)
-				parentFound = true
-				break
-			}
-		}
-		if !parentFound {
-			log.Printf("Parent comment not found for CommentID=%s, ParentID=%s", comment.ID, comment.ParentID)
-			return
-		}
-	} else {
-		// Root-level comment
-		post.Comments = append(post.Comments, comment)
+		Depth:     depth,
+		CreatedAt: e.now(),
 	}
+	comment.Scores = computeScores(0, 0, comment.CreatedAt)
+	replies := e.runAutomod(subreddit, &reviewTarget{kind: "comment", id: comment.ID, subredditID: subreddit.ID, post: post, comment: comment}, automodTrigger{}, msg.Author)
+
+	// The store indexes the comment under its post; replies are found by ParentID
+	e.store.PutComment(comment)
+	post.CommentCount++
+	e.store.PutPost(post)
+	e.storeAutomodReplies(replies)
 
-	log.Printf("Comment added: %+v", comment)
+	if err := e.commit(msg); err != nil {
+		respond(context, &proto.CreateCommentResponse{Error: err})
+		return
+	}
+	log.Printf("Comment added: %s on %s", comment.ID, comment.PostID)
 	e.updateMetrics(func(m *Metrics) {
 		m.TotalComments++
 	})
-}
-
-// func (e *RedditEngine) handleCreateComment(context actor.Context, msg *proto.CreateCommentMsg) {
-// 	e.mu.Lock()
-// 	defer e.mu.Unlock()
-
-// 	comment := &Comment{
-// 		ID:        generateID(),
-// 		Content:   msg.Content,
-// 		AuthorID:  msg.AuthorId,
-// 		PostID:    msg.PostId,
-// 		ParentID:  msg.ParentId,
-// 		CreatedAt: time.Now(),
-// 	}
-// 	e.comments[msg.PostId] = append(e.comments[msg.PostId], comment)
-// 	e.updateMetrics(func(m *Metrics) {
-// 		m.TotalComments++
-// 	})
-// 	log.Printf("Comment created: %+v", comment)
-// }
-
-func generateID() string {
-	return time.Now().Format("20060102150405.000")
+	e.automodRepliesCommitted(subreddit.ID, replies)
+	respond(context, &proto.CreateCommentResponse{CommentId: comment.ID})
 }
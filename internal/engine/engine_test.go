@@ -0,0 +1,221 @@
+// internal/engine/engine_test.go
+package engine
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/asynkron/protoactor-go/actor"
+	"github.com/asynkron/protoactor-go/cluster"
+	"github.com/asynkron/protoactor-go/cluster/clusterproviders/test"
+	"github.com/asynkron/protoactor-go/cluster/identitylookup/disthash"
+	"github.com/asynkron/protoactor-go/remote"
+	"github.com/kakugri/redditClone/internal/proto"
+)
+
+func TestMain(m *testing.M) {
+	// The engine logs every request; spawnEngine silences the actor systems
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func TestEngineLogsNoPrivateContent(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(io.Discard)
+
+	te := startEngine(t, NewRedditEngine())
+	resp := te.request(&proto.RegisterUserMsg{Username: "hashed", PasswordHash: "$2a$10$secret"}).(*proto.RegisterUserResponse)
+	if resp.Error != nil {
+		t.Fatal(resp.Error)
+	}
+	te.request(&proto.GetCredentialsMsg{Username: "hashed"})
+	other := te.registerUser("other")
+	subredditID := te.createSubreddit("logs", other)
+	postID := te.createPost(subredditID, other, "title")
+	te.request(&proto.DirectMessageMsg{FromUserId: resp.UserId, ToUserId: other, Content: "secret message"})
+	te.request(&proto.ReportMsg{TargetId: postID, UserId: resp.UserId, Reason: "secret reason"})
+	te.stop()
+	if strings.Contains(logged.String(), "secret") {
+		t.Errorf("the engine logged a password hash, message or report:\n%s", logged.String())
+	}
+}
+
+// testEngine runs an engine actor, or a cluster of grains, that tests send
+// requests to, the way the clients of cmd/engine do.
+type testEngine struct {
+	t       testing.TB
+	system  *actor.ActorSystem
+	pid     *actor.PID
+	cluster *cluster.Cluster // requests go to its grains if set, not to pid
+}
+
+// startEngine spawns e and stops it when the test ends.
+func startEngine(t testing.TB, e *RedditEngine) *testEngine {
+	return spawnEngine(t, actor.PropsFromProducer(func() actor.Actor { return e }))
+}
+
+// spawnEngine spawns an engine actor from props and stops it when the test
+// ends.
+func spawnEngine(t testing.TB, props *actor.Props) *testEngine {
+	system := actor.NewActorSystem(actor.WithLoggerFactory(func(system *actor.ActorSystem) *slog.Logger {
+		return slog.New(slog.NewTextHandler(io.Discard, nil))
+	}))
+	te := &testEngine{t: t, system: system, pid: system.Root.Spawn(props)}
+	t.Cleanup(te.stop)
+	return te
+}
+
+// startCluster starts a one-member engine cluster in memory and stops it when
+// the test ends.
+func startCluster(t testing.TB) *testEngine {
+	member, err := NewMember(NewMemoryStore(), 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	system := actor.NewActorSystem(actor.WithLoggerFactory(func(system *actor.ActorSystem) *slog.Logger {
+		return slog.New(slog.NewTextHandler(io.Discard, nil))
+	}))
+	config := cluster.Configure(ClusterName, test.NewTestProvider(test.NewInMemAgent()), disthash.New(),
+		remote.Configure("localhost", 0), cluster.WithKinds(member.Kinds()...))
+	c := cluster.New(system, config)
+	c.StartMember()
+	te := &testEngine{t: t, system: system, cluster: c}
+	t.Cleanup(te.stop)
+	return te
+}
+
+// stop stops the engine actor, which snapshots a durable engine. Stopping it
+// again does nothing.
+func (te *testEngine) stop() {
+	if te.cluster != nil {
+		// Shutting the cluster down shuts its actor system down
+		te.cluster.Shutdown(false)
+		te.cluster = nil
+		return
+	}
+	if te.pid == nil {
+		return
+	}
+	te.system.Root.StopFuture(te.pid).Wait()
+	te.system.Shutdown()
+	te.pid = nil
+}
+
+// request sends msg to the engine and returns its answer.
+func (te *testEngine) request(msg interface{}) interface{} {
+	te.t.Helper()
+	var result interface{}
+	var err error
+	if te.cluster != nil {
+		result, err = NewClient(te.cluster).Request(msg)
+	} else {
+		result, err = te.system.Root.RequestFuture(te.pid, msg, 5*time.Second).Result()
+	}
+	if err != nil {
+		te.t.Fatalf("%T: %v", msg, err)
+	}
+	return result
+}
+
+func (te *testEngine) registerUser(username string) string {
+	te.t.Helper()
+	resp := te.request(&proto.RegisterUserMsg{Username: username}).(*proto.RegisterUserResponse)
+	if resp.Error != nil {
+		te.t.Fatalf("register %q: %v", username, resp.Error)
+	}
+	return resp.UserId
+}
+
+func (te *testEngine) createSubreddit(name, creatorID string) string {
+	te.t.Helper()
+	resp := te.request(&proto.CreateSubredditMsg{Name: name, CreatorId: creatorID}).(*proto.CreateSubredditResponse)
+	if resp.Error != nil {
+		te.t.Fatalf("create subreddit %q: %v", name, resp.Error)
+	}
+	return resp.SubredditId
+}
+
+func (te *testEngine) createPost(subredditID, authorID, title string) string {
+	te.t.Helper()
+	resp := te.request(&proto.CreatePostMsg{
+		Title:       title,
+		Content:     "content of " + title,
+		AuthorId:    authorID,
+		SubredditId: subredditID,
+	}).(*proto.CreatePostResponse)
+	if resp.Error != nil {
+		te.t.Fatalf("create post %q: %v", title, resp.Error)
+	}
+	return resp.PostId
+}
+
+func (te *testEngine) createComment(postID, authorID, content string) string {
+	te.t.Helper()
+	resp := te.request(&proto.CreateCommentMsg{
+		Content:  content,
+		AuthorId: authorID,
+		PostId:   postID,
+	}).(*proto.CreateCommentResponse)
+	if resp.Error != nil {
+		te.t.Fatalf("create comment %q: %v", content, resp.Error)
+	}
+	return resp.CommentId
+}
+
+func (te *testEngine) post(postID string) *proto.PostInfo {
+	te.t.Helper()
+	resp := te.request(&proto.GetPostMsg{PostId: postID}).(*proto.GetPostResponse)
+	if resp.Error != nil {
+		te.t.Fatalf("get post %s: %v", postID, resp.Error)
+	}
+	return resp.Post
+}
+
+func (te *testEngine) karma(userID string) *proto.GetUserKarmaResponse {
+	te.t.Helper()
+	resp := te.request(&proto.GetUserKarmaMsg{UserId: userID}).(*proto.GetUserKarmaResponse)
+	if resp.Error != nil {
+		te.t.Fatalf("get karma of %s: %v", userID, resp.Error)
+	}
+	return resp
+}
+
+func (te *testEngine) modQueue(subredditID, moderatorID string) []*proto.QueueItemInfo {
+	te.t.Helper()
+	resp := te.request(&proto.GetModQueueMsg{SubredditId: subredditID, UserId: moderatorID}).(*proto.GetModQueueResponse)
+	if resp.Error != nil {
+		te.t.Fatalf("get the moderation queue of %s: %v", subredditID, resp.Error)
+	}
+	return resp.Items
+}
+
+// modLog returns the actions in the moderation log of the subreddit, newest
+// first, as action:target.
+func (te *testEngine) modLog(subredditID, moderatorID string) []string {
+	te.t.Helper()
+	resp := te.request(&proto.GetModLogMsg{SubredditId: subredditID, UserId: moderatorID}).(*proto.GetModLogResponse)
+	if resp.Error != nil {
+		te.t.Fatalf("get the moderation log of %s: %v", subredditID, resp.Error)
+	}
+	actions := make([]string, len(resp.Actions))
+	for i, action := range resp.Actions {
+		actions[i] = action.Action + ":" + action.TargetId
+	}
+	return actions
+}
+
+// postIDs returns the IDs of posts.
+func postIDs(posts []*proto.PostInfo) []string {
+	ids := make([]string, len(posts))
+	for i, post := range posts {
+		ids[i] = post.Id
+	}
+	return ids
+}
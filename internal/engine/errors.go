@@ -0,0 +1,36 @@
+// internal/engine/errors.go
+package engine
+
+import (
+	"fmt"
+
+	"github.com/asynkron/protoactor-go/actor"
+	"github.com/kakugri/redditClone/internal/proto"
+)
+
+// Error codes carried in proto.Error so callers can map them to their own
+// status codes without parsing the message text.
+const (
+	ErrCodeNotFound         = "not_found"
+	ErrCodeInvalidArgument  = "invalid_argument"
+	ErrCodePermissionDenied = "permission_denied"
+	ErrCodeAlreadyExists    = "already_exists"
+	ErrCodeInternal         = "internal"
+	ErrCodeUnavailable      = "unavailable" // the engine member that keeps the records is down
+)
+
+func newError(code, format string, args ...interface{}) *proto.Error {
+	return &proto.Error{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// respond replies to the sender of the current message. Fire-and-forget
+// senders (context.Send) have no sender, so there is nobody to answer.
+// Journal replay passes a nil context.
+func respond(context actor.Context, response interface{}) {
+	if context != nil && context.Sender() != nil {
+		context.Respond(response)
+	}
+}
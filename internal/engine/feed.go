@@ -0,0 +1,66 @@
+// internal/engine/feed.go
+package engine
+
+import (
+	"github.com/asynkron/protoactor-go/actor"
+	"github.com/kakugri/redditClone/internal/proto"
+)
+
+func (e *RedditEngine) handleGetFeed(context actor.Context, msg *proto.GetFeedMsg) {
+	user, err := e.store.User(msg.UserId)
+	if err != nil {
+		respond(context, &proto.GetFeedResponse{
+			Error: storeError(err, "user %q not found", msg.UserId),
+		})
+		return
+	}
+
+	query, queryErr := newListingQuery(msg.Sort, msg.TimeWindow, msg.Cursor, msg.Limit)
+	if queryErr != nil {
+		respond(context, &proto.GetFeedResponse{Error: queryErr})
+		return
+	}
+	query.hidden = user.Blocked
+
+	subredditIDs := make([]string, 0, len(user.Subreddits))
+	for subredditID := range user.Subreddits {
+		subredditIDs = append(subredditIDs, subredditID)
+	}
+
+	posts, next, err := e.listPosts(subredditIDs, query)
+	if err != nil {
+		respond(context, &proto.GetFeedResponse{Error: storeError(err, "post not found")})
+		return
+	}
+	respond(context, &proto.GetFeedResponse{
+		Posts:      postInfos(posts),
+		NextCursor: next,
+	})
+}
+
+func (e *RedditEngine) handleGetSubredditPosts(context actor.Context, msg *proto.GetSubredditPostsMsg) {
+	subreddit, err := e.store.Subreddit(msg.SubredditId)
+	if err != nil {
+		respond(context, &proto.GetSubredditPostsResponse{
+			Error: storeError(err, "subreddit %q not found", msg.SubredditId),
+		})
+		return
+	}
+
+	query, queryErr := newListingQuery(msg.Sort, msg.TimeWindow, msg.Cursor, msg.Limit)
+	if queryErr != nil {
+		respond(context, &proto.GetSubredditPostsResponse{Error: queryErr})
+		return
+	}
+	query.hidden = e.hiddenAuthors(msg.ViewerId, msg.HiddenAuthorIds)
+
+	posts, next, err := e.listPosts([]string{subreddit.ID}, query)
+	if err != nil {
+		respond(context, &proto.GetSubredditPostsResponse{Error: storeError(err, "post not found")})
+		return
+	}
+	respond(context, &proto.GetSubredditPostsResponse{
+		Posts:      postInfos(posts),
+		NextCursor: next,
+	})
+}
@@ -0,0 +1,622 @@
+// internal/engine/grains.go
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/asynkron/protoactor-go/actor"
+	"github.com/asynkron/protoactor-go/cluster"
+	"github.com/kakugri/redditClone/internal/idgen"
+	"github.com/kakugri/redditClone/internal/proto"
+	gproto "google.golang.org/protobuf/proto"
+)
+
+var errUnexpectedReply = errors.New("unexpected reply from a grain")
+
+// Grains never wait on a grain that could be waiting on them: user grains
+// wait on subreddit and directory grains, subreddit grains on directory
+// grains, and directory grains on none. User grains exchange direct messages
+// without waiting: the sender's grain goes on handling messages until the
+// recipient's answers.
+
+// subredditGrain owns one subreddit: its posts, their comments, the votes on
+// both and the post rankings.
+type subredditGrain struct {
+	*RedditEngine
+	member *Member
+	id     string
+}
+
+func (g *subredditGrain) Receive(context actor.Context) {
+	switch msg := context.Message().(type) {
+	case *cluster.ClusterInit:
+		g.id = msg.Identity.Identity
+		g.cluster = msg.Cluster
+		g.rankings[g.id] = g.member.ranking(g.id)
+	case *proto.CreateSubredditMsg:
+		// The directory picked the ID and activated the grain with it
+		g.cmd = command{at: time.Now(), id: g.id, assigned: true}
+		g.apply(context, msg)
+	case *proto.CreatePostMsg:
+		g.create(context, msg, idgen.KindPost)
+	case *proto.CreateCommentMsg:
+		g.create(context, msg, idgen.KindComment)
+	case *proto.MemberChangeMsg:
+		g.handleMemberChange(context, msg)
+	case *proto.ListingPageMsg:
+		g.handleListingPage(context, msg)
+	case *proto.SubredditInfoMsg:
+		g.handleSubredditInfo(context)
+	case *proto.GetSubredditMsg:
+		// Found by ID, or by name through the directory
+		g.describeSubreddit(context, g.id)
+	case *karmaRetry:
+		g.deliverKarma(context, msg.delta, msg.attempt)
+	case *actor.Restarting:
+		// The next incarnation picks up the ranking, which the crash may
+		// have left half updated
+		if err := g.member.reloadRanking(g.id); err != nil {
+			log.Printf("Failed to reload the ranking of %s: %v", g.id, err)
+		}
+	case *actor.Started, *actor.Stopping, *actor.Stopped:
+	default:
+		g.cmd = command{at: time.Now()}
+		if !g.apply(context, msg) {
+			log.Printf("Unhandled message type: %T", msg)
+		}
+	}
+}
+
+// create applies a command that creates a post or comment, once the
+// directory knows the new record belongs to this subreddit.
+func (g *subredditGrain) create(context actor.Context, msg gproto.Message, kind idgen.Kind) {
+	_, err := g.store.Subreddit(g.id)
+	if err != nil {
+		respond(context, errorResponse(msg, storeError(err, "subreddit %q not found", g.id)))
+		return
+	}
+	id, regErr := g.registerOwner(kind, g.id)
+	if regErr != nil {
+		respond(context, errorResponse(msg, regErr))
+		return
+	}
+	g.cmd = command{at: time.Now(), id: id, assigned: true}
+	g.apply(context, msg)
+}
+
+func (g *subredditGrain) handleMemberChange(context actor.Context, msg *proto.MemberChangeMsg) {
+	subreddit, err := g.store.Subreddit(g.id)
+	if err != nil {
+		respond(context, &proto.MemberChangeResponse{
+			Error: storeError(err, "subreddit %q not found", g.id),
+		})
+		return
+	}
+	if msg.Delta != 0 {
+		subreddit.MemberCount += int(msg.Delta)
+		g.store.PutSubreddit(subreddit)
+		if err := g.commit(msg); err != nil {
+			respond(context, &proto.MemberChangeResponse{Error: err})
+			return
+		}
+	}
+	log.Printf("Subreddit %s has %d members", g.id, subreddit.MemberCount)
+	respond(context, &proto.MemberChangeResponse{MemberCount: int32(subreddit.MemberCount)})
+}
+
+func (g *subredditGrain) handleListingPage(context actor.Context, msg *proto.ListingPageMsg) {
+	query, queryErr := newListingQuery(msg.Sort, msg.TimeWindow, msg.Cursor, msg.Limit)
+	if queryErr != nil {
+		respond(context, &proto.ListingPageResponse{Error: queryErr})
+		return
+	}
+	query.now = time.Unix(0, msg.Now)
+	query.hidden = userSet(msg.HiddenAuthorIds)
+
+	// A subreddit that does not exist has no ranking, so no posts
+	posts, next, err := g.listPosts([]string{g.id}, query)
+	if err != nil {
+		respond(context, &proto.ListingPageResponse{Error: storeError(err, "post not found")})
+		return
+	}
+	page := &proto.ListingPageResponse{
+		Posts: postInfos(posts),
+		Keys:  make([]*proto.RankKey, len(posts)),
+		More:  next != "",
+	}
+	for i, post := range posts {
+		key := postRankKey(post, query.sort, query.now)
+		page.Keys[i] = &proto.RankKey{Score: key.score, CreatedAt: key.createdAt, Id: key.id}
+	}
+	respond(context, page)
+}
+
+func (g *subredditGrain) handleSubredditInfo(context actor.Context) {
+	subreddit, err := g.store.Subreddit(g.id)
+	if err != nil {
+		respond(context, &proto.SubredditInfoResponse{
+			Error: storeError(err, "subreddit %q not found", g.id),
+		})
+		return
+	}
+	respond(context, &proto.SubredditInfoResponse{Subreddit: subredditInfo(subreddit)})
+}
+
+// userGrain owns one user: their account, karma, memberships and their copies
+// of the messages they sent and received.
+type userGrain struct {
+	*RedditEngine
+	id string
+}
+
+func (g *userGrain) Receive(context actor.Context) {
+	switch msg := context.Message().(type) {
+	case *cluster.ClusterInit:
+		g.id = msg.Identity.Identity
+		g.cluster = msg.Cluster
+	case *proto.RegisterUserMsg:
+		// The directory picked the ID and activated the grain with it
+		g.cmd = command{at: time.Now(), id: g.id, assigned: true}
+		g.apply(context, msg)
+	case *proto.DirectMessageMsg:
+		g.sendMessage(context, msg)
+	case *proto.DeliverMessageMsg:
+		g.receiveMessage(context, msg)
+	case *proto.VoteMsg:
+		g.forwardVote(context, msg)
+	case *proto.CreatePostMsg:
+		if user, ok := g.user(context, msg); ok {
+			msg.Author = userInfo(user)
+			g.forward(context, msg.SubredditId, SubredditKind)
+		}
+	case *proto.CreateCommentMsg:
+		if user, ok := g.user(context, msg); ok {
+			msg.Author = userInfo(user)
+			identity, kind := directoryKey(msg.PostId)
+			g.forward(context, identity, kind)
+		}
+	case *proto.KarmaDeltaMsg:
+		g.handleKarmaDelta(context, msg)
+	case *proto.JoinSubredditMsg:
+		g.handleMembership(context, msg, msg.SubredditId, true)
+	case *proto.LeaveSubredditMsg:
+		g.handleMembership(context, msg, msg.SubredditId, false)
+	case *proto.GetFeedMsg:
+		g.gatherFeed(context, msg)
+	// Listings the user views leave out the authors they blocked
+	case *proto.GetSubredditPostsMsg:
+		if user, ok := g.user(context, msg); ok {
+			msg.HiddenAuthorIds = blockedUserIDs(user)
+			g.forward(context, msg.SubredditId, SubredditKind)
+		}
+	case *proto.GetPostCommentsMsg:
+		if user, ok := g.user(context, msg); ok {
+			msg.HiddenAuthorIds = blockedUserIDs(user)
+			identity, kind := directoryKey(msg.PostId)
+			g.forward(context, identity, kind)
+		}
+	case *proto.GetUserSubredditsMsg:
+		g.gatherSubreddits(context)
+	case *proto.GetUserKarmaMsg:
+		g.gatherKarma(context)
+	case *proto.GetCredentialsMsg:
+		// The directory found the user by name
+		g.describeCredentials(context, g.id)
+	case *actor.Started, *actor.Stopping, *actor.Stopped, *actor.Restarting:
+	default:
+		g.cmd = command{at: time.Now()}
+		if !g.apply(context, msg) {
+			log.Printf("Unhandled message type: %T", msg)
+		}
+	}
+}
+
+// user returns the grain's user, answering msg with an error if it does not
+// exist.
+func (g *userGrain) user(context actor.Context, msg interface{}) (*User, bool) {
+	user, err := g.store.User(g.id)
+	if err != nil {
+		respond(context, errorResponse(msg, storeError(err, "user %q not found", g.id)))
+		return nil, false
+	}
+	return user, true
+}
+
+// sendMessage has the recipient's grain keep its copy of a message, then
+// keeps the sender's. The grain goes on handling other messages while it
+// waits, as the recipient may be sending one to the sender at the same time.
+func (g *userGrain) sendMessage(context actor.Context, msg *proto.DirectMessageMsg) {
+	g.cmd = command{at: time.Now()}
+	dm, sender, err := g.composeMessage(msg)
+	if err != nil {
+		respond(context, &proto.DirectMessageResponse{Error: err})
+		return
+	}
+	recipient := grainPID(g.cluster, dm.ToUserID, UserKind)
+	if recipient == nil {
+		respond(context, &proto.DirectMessageResponse{Error: newError(ErrCodeInternal, "user %q unreachable", dm.ToUserID)})
+		return
+	}
+
+	deliver := &proto.DeliverMessageMsg{Message: messageInfo(dm), SentAt: dm.CreatedAt.UnixNano(), Sender: sender}
+	future := tracedSender(g.cluster.ActorSystem, g.trace).RequestFuture(recipient, deliver, grainTimeout)
+	context.ReenterAfter(future, func(result interface{}, err error) {
+		delivered, ok := result.(*proto.DeliverMessageResponse)
+		if err == nil && !ok {
+			err = errUnexpectedReply
+		}
+		if err != nil {
+			log.Printf("Failed to deliver message %s to user %s: %v", dm.ID, dm.ToUserID, err)
+			respond(context, &proto.DirectMessageResponse{Error: newError(ErrCodeInternal, "failed to deliver the message")})
+			return
+		}
+		if delivered.Error != nil {
+			respond(context, &proto.DirectMessageResponse{Error: delivered.Error})
+			return
+		}
+
+		g.cmd = command{at: dm.CreatedAt, id: dm.ID, assigned: true}
+		g.store.PutMessage(sentCopy(dm))
+		if err := g.commit(msg); err != nil {
+			respond(context, &proto.DirectMessageResponse{Error: err})
+			return
+		}
+		log.Printf("Direct message sent: MessageID=%s, From=%s, To=%s", dm.ID, dm.FromUserID, dm.ToUserID)
+		respond(context, &proto.DirectMessageResponse{MessageId: dm.ID})
+	})
+}
+
+// receiveMessage keeps the recipient's copy of a message that the sender's
+// grain composed.
+func (g *userGrain) receiveMessage(context actor.Context, msg *proto.DeliverMessageMsg) {
+	dm := directMessage(msg.Message, time.Unix(0, msg.SentAt))
+	g.cmd = command{at: dm.CreatedAt, id: dm.ID, assigned: true}
+	if err := g.acceptMessage(dm, msg.Sender); err != nil {
+		respond(context, &proto.DeliverMessageResponse{Error: err})
+		return
+	}
+	g.deliverMessage(dm)
+	if err := g.commit(msg); err != nil {
+		respond(context, &proto.DeliverMessageResponse{Error: err})
+		return
+	}
+	respond(context, &proto.DeliverMessageResponse{})
+}
+
+// forwardVote checks the voter, then has the directory pass the vote on to
+// the subreddit of the post or comment, which answers it.
+func (g *userGrain) forwardVote(context actor.Context, msg *proto.VoteMsg) {
+	if _, ok := g.user(context, msg); !ok {
+		return
+	}
+	directory := grainPID(g.cluster, directoryIdentity(msg.TargetId), DirectoryKind)
+	if directory == nil {
+		respond(context, errorResponse(msg, newError(ErrCodeInternal, "directory unreachable")))
+		return
+	}
+	context.Forward(directory)
+}
+
+// forward passes the message being handled on to a grain, which answers it.
+// A new post or comment carries what AutoModerator needs to know of its
+// author, and a listing the users its viewer blocked, so the subreddit's grain
+// does not have to ask this one.
+func (g *userGrain) forward(context actor.Context, identity, kind string) {
+	msg := context.Message()
+	if identity == "" {
+		respond(context, errorResponse(msg, missingID(kind)))
+		return
+	}
+	pid := grainPID(g.cluster, identity, kind)
+	if pid == nil {
+		respond(context, errorResponse(msg, newError(ErrCodeInternal, "%s %q unreachable", kind, identity)))
+		return
+	}
+	context.Forward(pid)
+}
+
+// handleKarmaDelta credits the user with the karma a vote earned them, unless
+// the subreddit's grain sent the same change before and it was credited then.
+func (g *userGrain) handleKarmaDelta(context actor.Context, msg *proto.KarmaDeltaMsg) {
+	user, ok := g.user(context, msg)
+	if !ok {
+		return
+	}
+	if !slices.Contains(user.KarmaCredited, msg.Id) {
+		addKarma(user, msg.SubredditId, int(msg.PostDelta), int(msg.CommentDelta))
+		user.KarmaCredited = append(user.KarmaCredited, msg.Id)
+		if excess := len(user.KarmaCredited) - maxKarmaCredited; excess > 0 {
+			user.KarmaCredited = user.KarmaCredited[excess:]
+		}
+		g.store.PutUser(user)
+		if err := g.commit(msg); err != nil {
+			respond(context, &proto.KarmaDeltaResponse{Error: err})
+			return
+		}
+	}
+	respond(context, &proto.KarmaDeltaResponse{})
+}
+
+// handleMembership has the subreddit's grain count a join or leave, then
+// records it on the user.
+func (g *userGrain) handleMembership(context actor.Context, msg gproto.Message, subredditID string, join bool) {
+	user, ok := g.user(context, msg)
+	if !ok {
+		return
+	}
+
+	// Joining twice or leaving twice is a no-op so clients can safely retry
+	delta := 0
+	switch {
+	case join && !user.Subreddits[subredditID]:
+		delta = 1
+	case !join && user.Subreddits[subredditID]:
+		delta = -1
+	}
+
+	result, err := g.request(subredditID, SubredditKind, &proto.MemberChangeMsg{Delta: int32(delta)})
+	change, ok := result.(*proto.MemberChangeResponse)
+	if err == nil && !ok {
+		err = errUnexpectedReply
+	}
+	if err != nil {
+		log.Printf("Failed to update the members of subreddit %s: %v", subredditID, err)
+		respond(context, membershipResponse(msg, subredditID, 0, newError(ErrCodeInternal, "failed to update the subreddit")))
+		return
+	}
+	if change.Error != nil {
+		respond(context, membershipResponse(msg, subredditID, 0, change.Error))
+		return
+	}
+
+	if delta != 0 {
+		if join {
+			user.Subreddits[subredditID] = true
+		} else {
+			delete(user.Subreddits, subredditID)
+		}
+		g.store.PutUser(user)
+		if err := g.commit(msg); err != nil {
+			respond(context, membershipResponse(msg, subredditID, 0, err))
+			return
+		}
+	}
+	respond(context, membershipResponse(msg, subredditID, int(change.MemberCount), nil))
+}
+
+// feedEntry is a post of a feed with its rank key.
+type feedEntry struct {
+	post *proto.PostInfo
+	key  rankKey
+}
+
+// gatherFeed asks the grain of every joined subreddit for a page and merges
+// the pages. Each page is as long as the whole feed page, so the merged page
+// holds the best ranked posts across all of them.
+func (g *userGrain) gatherFeed(context actor.Context, msg *proto.GetFeedMsg) {
+	user, ok := g.user(context, msg)
+	if !ok {
+		return
+	}
+
+	query, queryErr := newListingQuery(msg.Sort, msg.TimeWindow, msg.Cursor, msg.Limit)
+	if queryErr != nil {
+		respond(context, &proto.GetFeedResponse{Error: queryErr})
+		return
+	}
+
+	request := &proto.ListingPageMsg{
+		Sort:            msg.Sort,
+		TimeWindow:      msg.TimeWindow,
+		Cursor:          msg.Cursor,
+		Limit:           int32(query.limit),
+		Now:             query.now.UnixNano(),
+		HiddenAuthorIds: blockedUserIDs(user),
+	}
+	var entries []feedEntry
+	more := false
+	err := g.gather(subredditIDs(user), request, func(result interface{}) error {
+		page, ok := result.(*proto.ListingPageResponse)
+		if !ok {
+			return errUnexpectedReply
+		}
+		if page.Error != nil {
+			return errors.New(page.Error.Message)
+		}
+		for i, post := range page.Posts {
+			key := page.Keys[i]
+			entries = append(entries, feedEntry{
+				post: post,
+				key:  rankKey{score: key.Score, createdAt: key.CreatedAt, id: key.Id},
+			})
+		}
+		more = more || page.More
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to gather feed of user %s: %v", g.id, err)
+		respond(context, &proto.GetFeedResponse{
+			Error: newError(ErrCodeInternal, "failed to load the feed"),
+		})
+		return
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return compareRankKeys(entries[i].key, entries[j].key) < 0
+	})
+	if len(entries) > query.limit {
+		entries = entries[:query.limit]
+		more = true
+	}
+	posts := make([]*proto.PostInfo, len(entries))
+	for i, entry := range entries {
+		posts[i] = entry.post
+	}
+	next := ""
+	if more && len(entries) > 0 {
+		next = encodeListingCursor(query.sort, query.window, entries[len(entries)-1].key)
+	}
+	respond(context, &proto.GetFeedResponse{Posts: posts, NextCursor: next})
+}
+
+func (g *userGrain) gatherSubreddits(context actor.Context) {
+	user, ok := g.user(context, context.Message())
+	if !ok {
+		return
+	}
+	infos, err := g.subredditInfos(subredditIDs(user))
+	if err != nil {
+		log.Printf("Failed to gather subreddits of user %s: %v", g.id, err)
+		respond(context, &proto.GetUserSubredditsResponse{
+			Error: newError(ErrCodeInternal, "failed to load the subreddits"),
+		})
+		return
+	}
+
+	subreddits := make([]*proto.SubredditInfo, 0, len(infos))
+	for _, info := range infos {
+		subreddits = append(subreddits, info)
+	}
+	sort.Slice(subreddits, func(i, j int) bool {
+		return subreddits[i].Name < subreddits[j].Name
+	})
+	respond(context, &proto.GetUserSubredditsResponse{Subreddits: subreddits})
+}
+
+// gatherKarma answers a karma request with the names of the subreddits the
+// karma was earned in, which their grains know.
+func (g *userGrain) gatherKarma(context actor.Context) {
+	user, ok := g.user(context, context.Message())
+	if !ok {
+		return
+	}
+	ids := make([]string, 0, len(user.SubredditKarma))
+	for subredditID := range user.SubredditKarma {
+		ids = append(ids, subredditID)
+	}
+
+	infos, err := g.subredditInfos(ids)
+	if err != nil {
+		log.Printf("Failed to gather karma of user %s: %v", g.id, err)
+		respond(context, &proto.GetUserKarmaResponse{
+			UserId: g.id,
+			Error:  newError(ErrCodeInternal, "failed to load the karma"),
+		})
+		return
+	}
+
+	respond(context, karmaResponse(user, func(subredditID string) string {
+		if info := infos[subredditID]; info != nil {
+			return info.Name
+		}
+		return ""
+	}))
+}
+
+// subredditInfos asks the grains of the given subreddits to describe them.
+// Subreddits that no longer exist are left out.
+func (g *userGrain) subredditInfos(ids []string) (map[string]*proto.SubredditInfo, error) {
+	infos := make(map[string]*proto.SubredditInfo, len(ids))
+	err := g.gather(ids, &proto.SubredditInfoMsg{}, func(result interface{}) error {
+		resp, ok := result.(*proto.SubredditInfoResponse)
+		switch {
+		case !ok:
+			return errUnexpectedReply
+		case resp.Error != nil && resp.Error.Code != ErrCodeNotFound:
+			return errors.New(resp.Error.Message)
+		case resp.Subreddit != nil:
+			infos[resp.Subreddit.Id] = resp.Subreddit
+		}
+		return nil
+	})
+	return infos, err
+}
+
+// gather sends request to the grains of the given subreddits at once, then
+// passes their answers to handle. The requests are sent directly rather than
+// with cluster.RequestFuture, whose call options are shared by every caller
+// and so cannot carry the trace.
+func (g *userGrain) gather(subredditIDs []string, request interface{}, handle func(result interface{}) error) error {
+	sender := tracedSender(g.cluster.ActorSystem, g.trace)
+	futures := make([]*actor.Future, 0, len(subredditIDs))
+	for _, subredditID := range subredditIDs {
+		pid := grainPID(g.cluster, subredditID, SubredditKind)
+		if pid == nil {
+			return fmt.Errorf("subreddit %q unreachable", subredditID)
+		}
+		futures = append(futures, sender.RequestFuture(pid, request, grainTimeout))
+	}
+	for _, future := range futures {
+		result, err := future.Result()
+		if err != nil {
+			return err
+		}
+		if err := handle(result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// subredditIDs lists the subreddits a user has joined.
+func subredditIDs(user *User) []string {
+	ids := make([]string, 0, len(user.Subreddits))
+	for subredditID := range user.Subreddits {
+		ids = append(ids, subredditID)
+	}
+	return ids
+}
+
+// registerOwner mints the ID of a post or comment a grain is about
+// to create and records in the directory that ownerID owns it, so requests
+// about the record can be routed as soon as its creator learns the ID. If
+// the command is then rejected, the entry routes to a grain that answers
+// that the record does not exist.
+func (e *RedditEngine) registerOwner(kind idgen.Kind, ownerID string) (string, *proto.Error) {
+	id := idgen.New(kind)
+	if err := e.registerID(id, ownerID); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// registerID records in the directory that ownerID owns the record with id.
+func (e *RedditEngine) registerID(id, ownerID string) *proto.Error {
+	result, err := e.request(directoryIdentity(id), DirectoryKind, &proto.RegisterOwnerMsg{Id: id, OwnerId: ownerID})
+	resp, ok := result.(*proto.RegisterOwnerResponse)
+	if err == nil && !ok {
+		err = errUnexpectedReply
+	}
+	if err != nil {
+		log.Printf("Failed to register %s in the directory: %v", id, err)
+		return newError(ErrCodeInternal, "failed to register the record")
+	}
+	return resp.Error
+}
+
+// request sends msg to a grain and waits for the answer, carrying on the
+// trace of the message being handled.
+func (e *RedditEngine) request(identity, kind string, msg interface{}) (interface{}, error) {
+	return e.cluster.Request(identity, kind, msg, cluster.WithContext(tracedSender(e.cluster.ActorSystem, e.trace)))
+}
+
+// membershipResponse answers a JoinSubredditMsg or LeaveSubredditMsg.
+func membershipResponse(msg interface{}, subredditID string, memberCount int, err *proto.Error) interface{} {
+	if _, ok := msg.(*proto.LeaveSubredditMsg); ok {
+		return &proto.LeaveSubredditResponse{
+			SubredditId: subredditID,
+			MemberCount: int32(memberCount),
+			Error:       err,
+		}
+	}
+	return &proto.JoinSubredditResponse{
+		SubredditId: subredditID,
+		MemberCount: int32(memberCount),
+		Error:       err,
+	}
+}
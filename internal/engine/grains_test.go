@@ -0,0 +1,147 @@
+// internal/engine/grains_test.go
+package engine
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/kakugri/redditClone/internal/proto"
+)
+
+// engineModes starts the engine as a single actor, and as a cluster whose
+// user grains gather from the subreddit grains.
+var engineModes = []struct {
+	name  string
+	start func(testing.TB) *testEngine
+}{
+	{"single", func(t testing.TB) *testEngine { return startEngine(t, NewRedditEngine()) }},
+	{"cluster", startCluster},
+}
+
+func TestMembershipChangesOnce(t *testing.T) {
+	for _, mode := range engineModes {
+		te := mode.start(t)
+		creator, user, stranger := te.registerUser("creator"), te.registerUser("user"), te.registerUser("stranger")
+		subredditID := te.createSubreddit("members", creator)
+		members := func() int32 {
+			resp := te.request(&proto.GetSubredditMsg{SubredditId: subredditID}).(*proto.GetSubredditResponse)
+			if resp.Error != nil {
+				t.Fatalf("%s: %v", mode.name, resp.Error)
+			}
+			return resp.Subreddit.MemberCount
+		}
+		initial := members()
+
+		steps := []struct {
+			name string
+			msg  interface{}
+			want int32
+		}{
+			{"join", &proto.JoinSubredditMsg{UserId: user, SubredditId: subredditID}, initial + 1},
+			{"join again", &proto.JoinSubredditMsg{UserId: user, SubredditId: subredditID}, initial + 1},
+			{"leave without being a member", &proto.LeaveSubredditMsg{UserId: stranger, SubredditId: subredditID}, initial + 1},
+			{"leave", &proto.LeaveSubredditMsg{UserId: user, SubredditId: subredditID}, initial},
+			{"leave again", &proto.LeaveSubredditMsg{UserId: user, SubredditId: subredditID}, initial},
+		}
+		for _, step := range steps {
+			var count int32
+			switch resp := te.request(step.msg).(type) {
+			case *proto.JoinSubredditResponse:
+				if resp.Error != nil {
+					t.Fatalf("%s: %s: %v", mode.name, step.name, resp.Error)
+				}
+				count = resp.MemberCount
+			case *proto.LeaveSubredditResponse:
+				if resp.Error != nil {
+					t.Fatalf("%s: %s: %v", mode.name, step.name, resp.Error)
+				}
+				count = resp.MemberCount
+			}
+			if count != step.want {
+				t.Errorf("%s: %s answered %d members, want %d", mode.name, step.name, count, step.want)
+			}
+			if got := members(); got != step.want {
+				t.Errorf("%s: %s left %d members, want %d", mode.name, step.name, got, step.want)
+			}
+		}
+	}
+}
+
+// pageFeed pages through the feed of userID, limit posts at a time.
+func (te *testEngine) pageFeed(userID, sort string, limit int32) []string {
+	te.t.Helper()
+	var ids []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 20 {
+			te.t.Fatalf("feed sorted by %q: more than 20 pages", sort)
+		}
+		resp := te.request(&proto.GetFeedMsg{UserId: userID, Sort: sort, Cursor: cursor, Limit: limit}).(*proto.GetFeedResponse)
+		if resp.Error != nil {
+			te.t.Fatalf("feed sorted by %q: %v", sort, resp.Error)
+		}
+		ids = append(ids, postIDs(resp.Posts)...)
+		if resp.NextCursor == "" {
+			return ids
+		}
+		cursor = resp.NextCursor
+	}
+}
+
+func TestFeedMergesSubreddits(t *testing.T) {
+	for _, mode := range engineModes {
+		te := mode.start(t)
+		creator, reader := te.registerUser("creator"), te.registerUser("reader")
+		var subreddits []string
+		for i := 0; i < 3; i++ {
+			subredditID := te.createSubreddit(fmt.Sprintf("feed%d", i), creator)
+			te.request(&proto.JoinSubredditMsg{UserId: reader, SubredditId: subredditID})
+			subreddits = append(subreddits, subredditID)
+		}
+		unjoined := te.createSubreddit("unjoined", creator)
+		te.createPost(unjoined, creator, "not in the feed")
+
+		// The posts take turns between the subreddits, and each gets as
+		// many upvotes as its score below
+		scores := []int{2, 5, 0, 4, 1, 3, 6}
+		var voters []string
+		for i := 0; i < slices.Max(scores); i++ {
+			voters = append(voters, te.registerUser(fmt.Sprintf("voter%d", i)))
+		}
+		var created []string
+		for i, score := range scores {
+			postID := te.createPost(subreddits[i%len(subreddits)], creator, fmt.Sprintf("post %d", i))
+			for _, voter := range voters[:score] {
+				resp := te.request(&proto.VoteMsg{UserId: voter, TargetId: postID, Direction: proto.VoteDirection_VOTE_UP}).(*proto.VoteResponse)
+				if resp.Error != nil {
+					t.Fatalf("%s: vote: %v", mode.name, resp.Error)
+				}
+			}
+			created = append(created, postID)
+		}
+
+		newest := slices.Clone(created)
+		slices.Reverse(newest)
+		top := []string{created[6], created[1], created[3], created[5], created[0], created[4], created[2]}
+		for _, tt := range []struct {
+			sort string
+			want []string
+		}{
+			{"new", newest},
+			{"top", top},
+			{"hot", nil},
+		} {
+			whole := tt.want
+			if whole == nil {
+				whole = te.pageFeed(reader, tt.sort, 25)
+			}
+			if got := te.pageFeed(reader, tt.sort, 25); !slices.Equal(got, whole) {
+				t.Errorf("%s: feed sorted by %q %v, want %v", mode.name, tt.sort, got, whole)
+			}
+			if got := te.pageFeed(reader, tt.sort, 2); !slices.Equal(got, whole) {
+				t.Errorf("%s: feed sorted by %q paged through %v, want %v", mode.name, tt.sort, got, whole)
+			}
+		}
+	}
+}
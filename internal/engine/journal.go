@@ -0,0 +1,259 @@
+// internal/engine/journal.go
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/kakugri/redditClone/internal/idgen"
+	"github.com/kakugri/redditClone/internal/proto"
+	"github.com/kakugri/redditClone/internal/wal"
+	gproto "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+const defaultSnapshotEvery = 10000
+
+// Journal makes an engine on the memory store durable. Every accepted command
+// is appended to a write-ahead log before its response is sent, and the whole
+// store is snapshotted every snapshotEvery commands so recovery only has to
+// replay the tail of the log.
+type Journal struct {
+	log           *wal.Log
+	store         *memoryStore
+	snapshotEvery int
+	sinceSnapshot int
+}
+
+// OpenJournal opens the write-ahead log in dir. snapshotEvery <= 0 uses the
+// default interval.
+func OpenJournal(dir string, opts wal.Options, snapshotEvery int) (*Journal, error) {
+	l, err := wal.Open(dir, opts)
+	if err != nil {
+		return nil, err
+	}
+	if snapshotEvery <= 0 {
+		snapshotEvery = defaultSnapshotEvery
+	}
+	return &Journal{log: l, snapshotEvery: snapshotEvery}, nil
+}
+
+func (j *Journal) Close() error {
+	return j.log.Close()
+}
+
+// command is what a handler assigns while applying one message: the time it
+// happened and the IDs it minted. Replay feeds the journaled values back in,
+// and the grains of an engine cluster assign IDs picked before the command
+// reached them.
+type command struct {
+	at        time.Time
+	id        string
+	assigned  bool     // id was fixed before the command reached its handler
+	extraIDs  []string // minted by extraID, in order
+	extraUsed int      // how many of the journaled extraIDs replay handed out
+	replay    bool
+	karma     []*proto.KarmaDeltaMsg // for authors on other grains, sent once the command is committed
+}
+
+// now is the time the current command was applied at.
+func (e *RedditEngine) now() time.Time {
+	return e.cmd.at
+}
+
+// newID mints the ID of whatever the current command creates.
+func (e *RedditEngine) newID(kind idgen.Kind) string {
+	if !e.cmd.assigned {
+		e.cmd.id = idgen.New(kind)
+	}
+	return e.cmd.id
+}
+
+// extraID mints the ID of a record the current command creates besides the
+// one newID names, such as an entry in a moderation log.
+func (e *RedditEngine) extraID(kind idgen.Kind) string {
+	if e.cmd.replay && e.cmd.extraUsed < len(e.cmd.extraIDs) {
+		e.cmd.extraUsed++
+		return e.cmd.extraIDs[e.cmd.extraUsed-1]
+	}
+	id := idgen.New(kind)
+	e.cmd.extraIDs = append(e.cmd.extraIDs, id)
+	return id
+}
+
+// commit makes the changes msg's handler has applied durable, so a client
+// never sees a success that would not survive a restart. A durable engine
+// appends msg to the journal, synced as the fsync policy asks, before it
+// commits the store: the store never holds a change the journal could lose.
+// Replayed commands are already in the journal.
+func (e *RedditEngine) commit(msg gproto.Message) *proto.Error {
+	if e.journal != nil && !e.cmd.replay {
+		if err := e.journalCommand(msg); err != nil {
+			log.Printf("Failed to journal %T: %v", msg, err)
+			// The memory store has the change already, and only a restart
+			// reloading the state from the journal takes it back out
+			panic(&journalFailure{err: err})
+		}
+	}
+	if err := e.store.Commit(); err != nil {
+		log.Printf("Failed to store %T: %v", msg, err)
+		return newError(ErrCodeInternal, "failed to persist the change")
+	}
+	if e.journal == nil || e.cmd.replay {
+		return nil
+	}
+
+	e.journal.sinceSnapshot++
+	if e.journal.sinceSnapshot >= e.journal.snapshotEvery {
+		if err := e.takeSnapshot(); err != nil {
+			log.Printf("Failed to take snapshot: %v", err)
+		}
+	}
+	return nil
+}
+
+// journalCommand appends msg to the journal with the time and IDs its handler
+// assigned.
+func (e *RedditEngine) journalCommand(msg gproto.Message) error {
+	payload, err := gproto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	payload, err = gproto.Marshal(&proto.JournalEntry{
+		CommandType: string(msg.ProtoReflect().Descriptor().FullName()),
+		Command:     payload,
+		AppliedAt:   e.cmd.at.UnixNano(),
+		AssignedId:  e.cmd.id,
+		ExtraIds:    e.cmd.extraIDs,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = e.journal.log.Append(payload)
+	return err
+}
+
+// journalFailure is what a durable engine panics with when it cannot journal
+// a command it has applied. The supervisor restarts the engine, which loads
+// the state without the command from the snapshot and the log.
+type journalFailure struct {
+	err error
+}
+
+func (f *journalFailure) Error() string {
+	return fmt.Sprintf("failed to journal the command: %v", f.err)
+}
+
+// takeSnapshot writes the current state to disk and compacts the log entries
+// it makes redundant.
+func (e *RedditEngine) takeSnapshot() error {
+	if err := e.journal.log.Rotate(); err != nil {
+		return err
+	}
+	seq := e.journal.log.LastSequence()
+	data, err := e.journal.store.encodeSnapshot()
+	if err != nil {
+		return err
+	}
+	if err := e.journal.log.WriteSnapshot(seq, data); err != nil {
+		return err
+	}
+	e.journal.sinceSnapshot = 0
+	log.Printf("Snapshot written at journal sequence %d (%d bytes)", seq, len(data))
+	return e.journal.log.Compact(seq)
+}
+
+// NewDurableEngine rebuilds an engine from the latest snapshot in the journal
+// and the log entries written after it. The engine journals every command it
+// accepts from then on.
+func NewDurableEngine(journal *Journal) (*RedditEngine, error) {
+	store := newMemoryStore()
+	from := uint64(1)
+	seq, data, err := journal.log.LatestSnapshot()
+	switch {
+	case err == nil:
+		if err := store.restoreSnapshot(data); err != nil {
+			return nil, fmt.Errorf("restore snapshot %d: %w", seq, err)
+		}
+		from = seq + 1
+	case !errors.Is(err, wal.ErrNoSnapshot):
+		return nil, err
+	}
+
+	e, err := NewRedditEngineWithStore(store)
+	if err != nil {
+		return nil, err
+	}
+
+	replayed := 0
+	err = journal.log.Replay(from, func(seq uint64, payload []byte) error {
+		err := e.replay(payload)
+		var crash *replayPanic
+		switch {
+		case errors.As(err, &crash):
+			// It would crash every restart; skip it rather than never recover
+			log.Printf("Skipped journal entry %d: %v", seq, err)
+		case err != nil:
+			return fmt.Errorf("replay journal entry %d: %w", seq, err)
+		}
+		replayed++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	e.cmd = command{}
+	log.Printf("Engine recovered: snapshot=%d, replayed=%d, users=%d, posts=%d, comments=%d",
+		seq, replayed, len(store.users), len(store.posts), len(store.comments))
+
+	journal.store = store
+	e.journal = journal
+	e.journal.sinceSnapshot = replayed
+	return e, nil
+}
+
+// replayPanic is the error of a journaled command that panicked on replay.
+type replayPanic struct {
+	command string
+	reason  interface{}
+}
+
+func (p *replayPanic) Error() string {
+	return fmt.Sprintf("%s panicked: %v", p.command, p.reason)
+}
+
+// replay applies one journaled command as if it had just arrived.
+func (e *RedditEngine) replay(payload []byte) (err error) {
+	var entry proto.JournalEntry
+	if err := gproto.Unmarshal(payload, &entry); err != nil {
+		return err
+	}
+	msgType, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(entry.CommandType))
+	if err != nil {
+		return err
+	}
+	msg := msgType.New().Interface()
+	if err := gproto.Unmarshal(entry.Command, msg); err != nil {
+		return err
+	}
+
+	e.cmd = command{
+		at:       time.Unix(0, entry.AppliedAt),
+		id:       entry.AssignedId,
+		assigned: true,
+		extraIDs: entry.ExtraIds,
+		replay:   true,
+	}
+	defer func() {
+		if reason := recover(); reason != nil {
+			err = &replayPanic{command: entry.CommandType, reason: reason}
+		}
+	}()
+	if !e.apply(nil, msg) {
+		return fmt.Errorf("unknown command %s", entry.CommandType)
+	}
+	return nil
+}
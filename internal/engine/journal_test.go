@@ -0,0 +1,181 @@
+// internal/engine/journal_test.go
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kakugri/redditClone/internal/proto"
+	"github.com/kakugri/redditClone/internal/wal"
+)
+
+func openJournal(t *testing.T, dir string, snapshotEvery int) *Journal {
+	t.Helper()
+	journal, err := OpenJournal(dir, wal.DefaultOptions(), snapshotEvery)
+	if err != nil {
+		t.Fatalf("OpenJournal: %v", err)
+	}
+	t.Cleanup(func() { journal.Close() })
+	return journal
+}
+
+// recoverEngine rebuilds an engine from the journal in dir, as a restart
+// after a crash would, and reports how many commands it replayed.
+func recoverEngine(t *testing.T, dir string) (*testEngine, int) {
+	t.Helper()
+	e, err := NewDurableEngine(openJournal(t, dir, 0))
+	if err != nil {
+		t.Fatalf("NewDurableEngine: %v", err)
+	}
+	return startEngine(t, e), e.journal.sinceSnapshot
+}
+
+// journaledState is what the commands of fillJournal leave behind.
+type journaledState struct {
+	author, voter, postID, commentID string
+}
+
+// fillJournal runs seven commands on a durable engine.
+func fillJournal(te *testEngine) journaledState {
+	var s journaledState
+	s.author = te.registerUser("author")
+	s.voter = te.registerUser("voter")
+	subredditID := te.createSubreddit("journal", s.author)
+	s.postID = te.createPost(subredditID, s.author, "title")
+	s.commentID = te.createComment(s.postID, s.voter, "comment")
+	te.request(&proto.VoteMsg{UserId: s.voter, TargetId: s.postID, Direction: proto.VoteDirection_VOTE_UP})
+	te.request(&proto.EditPostMsg{PostId: s.postID, UserId: s.author, Content: "edited"})
+	return s
+}
+
+// checkRecovered checks that te holds the state fillJournal left, under the
+// same IDs.
+func checkRecovered(t *testing.T, te *testEngine, s journaledState) {
+	t.Helper()
+	post := te.post(s.postID)
+	if post.AuthorId != s.author || post.Upvotes != 1 || post.Content != "edited" || post.CommentCount != 1 {
+		t.Errorf("recovered post %+v, want the author's edited post with one upvote and one comment", post)
+	}
+	if karma := te.karma(s.author); karma.PostKarma != 1 {
+		t.Errorf("recovered author has %d post karma, want 1", karma.PostKarma)
+	}
+	comments := te.request(&proto.GetPostCommentsMsg{PostId: s.postID}).(*proto.GetPostCommentsResponse)
+	if len(comments.Comments) != 1 || comments.Comments[0].Id != s.commentID {
+		t.Errorf("recovered comments %+v, want %s", comments.Comments, s.commentID)
+	}
+}
+
+// journalFiles lists the segments and snapshots in dir.
+func journalFiles(t *testing.T, dir string) (segments, snapshots []string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		switch name := entry.Name(); {
+		case strings.HasPrefix(name, "wal-"):
+			segments = append(segments, name)
+		case strings.HasPrefix(name, "snapshot-"):
+			snapshots = append(snapshots, name)
+		}
+	}
+	return segments, snapshots
+}
+
+func TestJournalReplay(t *testing.T) {
+	dir := t.TempDir()
+	te := spawnEngine(t, EngineProps(nil, openJournal(t, dir, 0), nil))
+	state := fillJournal(te)
+
+	// The first engine is still running, so no snapshot was taken
+	recovered, replayed := recoverEngine(t, dir)
+	if replayed != 7 {
+		t.Errorf("replayed %d commands, want 7", replayed)
+	}
+	checkRecovered(t, recovered, state)
+}
+
+func TestJournalSnapshotsAndCompacts(t *testing.T) {
+	dir := t.TempDir()
+	te := spawnEngine(t, EngineProps(nil, openJournal(t, dir, 3), nil))
+	state := fillJournal(te)
+
+	// Snapshots after the 3rd and 6th command; the 6th covers all but the 7th
+	segments, snapshots := journalFiles(t, dir)
+	if len(snapshots) != 1 || !strings.HasSuffix(snapshots[0], "00000000000000000006.snap") {
+		t.Errorf("snapshots %v, want only the one after the 6th command", snapshots)
+	}
+	if len(segments) != 1 || !strings.HasSuffix(segments[0], "00000000000000000007.log") {
+		t.Errorf("segments %v, want only the one starting at the 7th command", segments)
+	}
+
+	recovered, replayed := recoverEngine(t, dir)
+	if replayed != 1 {
+		t.Errorf("replayed %d commands after the snapshot, want 1", replayed)
+	}
+	checkRecovered(t, recovered, state)
+}
+
+func TestJournalRecoversFromTornLastRecord(t *testing.T) {
+	dir := t.TempDir()
+	te := spawnEngine(t, EngineProps(nil, openJournal(t, dir, 0), nil))
+	state := fillJournal(te)
+	te.stop()
+
+	// Stopping took a snapshot; the commands after it die with a torn record
+	te = spawnEngine(t, EngineProps(nil, openJournal(t, dir, 0), nil))
+	late := te.registerUser("late")
+	segments, _ := journalFiles(t, dir)
+	last := filepath.Join(dir, segments[len(segments)-1])
+	file, err := os.OpenFile(last, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte{0, 0, 1, 0, 'p', 'a', 'r', 't'})
+	file.Close()
+
+	recovered, replayed := recoverEngine(t, dir)
+	if replayed != 1 {
+		t.Errorf("replayed %d commands, want 1", replayed)
+	}
+	checkRecovered(t, recovered, state)
+	user := recovered.request(&proto.GetUserMsg{UserId: late}).(*proto.GetUserResponse)
+	if user.Error != nil || user.User.Username != "late" {
+		t.Errorf("user registered before the torn record: got %+v, %v", user.User, user.Error)
+	}
+
+	// Records appended after the recovery are not lost behind the torn one
+	next := recovered.registerUser("next")
+	again, replayed := recoverEngine(t, dir)
+	if replayed != 2 {
+		t.Errorf("replayed %d commands, want 2", replayed)
+	}
+	if resp := again.request(&proto.GetUserMsg{UserId: next}).(*proto.GetUserResponse); resp.Error != nil {
+		t.Errorf("user registered after the recovery: %v", resp.Error)
+	}
+}
+
+func TestJournalFailureDropsTheCommand(t *testing.T) {
+	journal := openJournal(t, t.TempDir(), 0)
+	te := spawnEngine(t, EngineProps(nil, journal, nil))
+	author := te.registerUser("author")
+
+	// Appends fail from now on
+	journal.log.Close()
+	resp := te.request(&proto.CreateSubredditMsg{Name: "lost", CreatorId: author}).(*proto.CreateSubredditResponse)
+	if resp.Error == nil || resp.Error.Code != ErrCodeInternal {
+		t.Fatalf("create subreddit with a broken journal: got %v, want %s", resp.Error, ErrCodeInternal)
+	}
+
+	// The engine restarted and reloaded what the journal holds
+	lookup := te.request(&proto.GetSubredditMsg{Name: "lost"}).(*proto.GetSubredditResponse)
+	if lookup.Error == nil || lookup.Error.Code != ErrCodeNotFound {
+		t.Errorf("subreddit that was never journaled: got %+v, %v, want %s", lookup.Subreddit, lookup.Error, ErrCodeNotFound)
+	}
+	if user := te.request(&proto.GetUserMsg{UserId: author}).(*proto.GetUserResponse); user.Error != nil {
+		t.Errorf("journaled user after the restart: %v", user.Error)
+	}
+}
@@ -0,0 +1,170 @@
+// internal/engine/karma.go
+package engine
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/asynkron/protoactor-go/actor"
+	"github.com/asynkron/protoactor-go/scheduler"
+	"github.com/kakugri/redditClone/internal/idgen"
+	"github.com/kakugri/redditClone/internal/proto"
+)
+
+const (
+	// A karma change for an author on another grain is sent up to
+	// karmaAttempts times, waiting karmaRetryDelay before the first resend
+	// and twice as long before each one after.
+	karmaAttempts   = 5
+	karmaRetryDelay = 200 * time.Millisecond
+
+	// maxKarmaCredited bounds how many karma change IDs a user keeps to
+	// recognise one sent again. Resends come within seconds of the first.
+	maxKarmaCredited = 256
+)
+
+// addKarma credits the author of a voted post or comment with the change in
+// its net score. A vote switched from down to up moves karma by two. The
+// caller puts the author.
+func addKarma(author *User, subredditID string, postDelta, commentDelta int) {
+	breakdown := author.SubredditKarma[subredditID]
+	if breakdown == nil {
+		breakdown = &KarmaBreakdown{}
+		author.SubredditKarma[subredditID] = breakdown
+	}
+	breakdown.PostKarma += postDelta
+	breakdown.CommentKarma += commentDelta
+	author.PostKarma += postDelta
+	author.CommentKarma += commentDelta
+	author.Karma = author.PostKarma + author.CommentKarma
+}
+
+// creditKarma is addKarma for the vote handler. A subreddit grain does not
+// own the author, so it keeps the change for sendKarma to send to the
+// author's grain once the vote is committed. AutoModerator is not a user and
+// earns no karma.
+func (e *RedditEngine) creditKarma(authorID, subredditID string, postDelta, commentDelta int) {
+	if authorID == automoderatorID {
+		return
+	}
+	if e.cluster != nil {
+		e.cmd.karma = append(e.cmd.karma, &proto.KarmaDeltaMsg{
+			Id:           idgen.New(idgen.KindKarma),
+			AuthorId:     authorID,
+			SubredditId:  subredditID,
+			PostDelta:    int32(postDelta),
+			CommentDelta: int32(commentDelta),
+		})
+		return
+	}
+	author, err := e.store.User(authorID)
+	if err != nil {
+		return
+	}
+	addKarma(author, subredditID, postDelta, commentDelta)
+	e.store.PutUser(author)
+}
+
+// karmaRetry has a grain send a karma change again.
+type karmaRetry struct {
+	delta   *proto.KarmaDeltaMsg
+	attempt int
+}
+
+// sendKarma sends the karma changes of the committed command to the grains of
+// their authors.
+func (e *RedditEngine) sendKarma(context actor.Context) {
+	for _, delta := range e.cmd.karma {
+		e.deliverKarma(context, delta, 1)
+	}
+}
+
+// deliverKarma sends a karma change to the author's grain, and sends it again
+// after a while if the grain did not answer that it was credited. The grain
+// goes on handling messages meanwhile. A change still pending when the
+// sending grain stops is lost.
+func (e *RedditEngine) deliverKarma(context actor.Context, delta *proto.KarmaDeltaMsg, attempt int) {
+	retry := func(err error) {
+		if attempt >= karmaAttempts {
+			log.Printf("Gave up crediting karma %s to user %s: %v", delta.Id, delta.AuthorId, err)
+			return
+		}
+		log.Printf("Failed to credit karma %s to user %s, retrying: %v", delta.Id, delta.AuthorId, err)
+		// The grain may have moved or restarted since its PID was cached
+		e.cluster.PidCache.Remove(delta.AuthorId, UserKind)
+		timer := scheduler.NewTimerScheduler(context.ActorSystem().Root)
+		timer.SendOnce(karmaRetryDelay<<(attempt-1), context.Self(), &karmaRetry{delta: delta, attempt: attempt + 1})
+	}
+
+	author := grainPID(e.cluster, delta.AuthorId, UserKind)
+	if author == nil {
+		retry(errors.New("grain unreachable"))
+		return
+	}
+	future := tracedSender(e.cluster.ActorSystem, e.trace).RequestFuture(author, delta, grainTimeout)
+	context.ReenterAfter(future, func(result interface{}, err error) {
+		credited, ok := result.(*proto.KarmaDeltaResponse)
+		if err == nil && !ok {
+			err = errUnexpectedReply
+		}
+		if err == nil && credited.Error != nil {
+			if credited.Error.Code == ErrCodeNotFound {
+				// Nobody to credit
+				return
+			}
+			err = errors.New(credited.Error.Message)
+		}
+		if err != nil {
+			retry(err)
+		}
+	})
+}
+
+func (e *RedditEngine) handleGetUserKarma(context actor.Context, msg *proto.GetUserKarmaMsg) {
+	user, err := e.store.User(msg.UserId)
+	if err != nil {
+		respond(context, &proto.GetUserKarmaResponse{
+			UserId: msg.UserId,
+			Error:  storeError(err, "user %q not found", msg.UserId),
+		})
+		return
+	}
+	respond(context, karmaResponse(user, func(subredditID string) string {
+		if subreddit, err := e.store.Subreddit(subredditID); err == nil {
+			return subreddit.Name
+		}
+		return ""
+	}))
+}
+
+// karmaResponse describes a user's karma, naming subreddits with name.
+func karmaResponse(user *User, name func(subredditID string) string) *proto.GetUserKarmaResponse {
+	subreddits := make([]*proto.SubredditKarma, 0, len(user.SubredditKarma))
+	for subredditID, breakdown := range user.SubredditKarma {
+		subreddits = append(subreddits, &proto.SubredditKarma{
+			SubredditId:   subredditID,
+			SubredditName: name(subredditID),
+			PostKarma:     int32(breakdown.PostKarma),
+			CommentKarma:  int32(breakdown.CommentKarma),
+		})
+	}
+	// Highest total first
+	sort.Slice(subreddits, func(i, j int) bool {
+		a := subreddits[i].PostKarma + subreddits[i].CommentKarma
+		b := subreddits[j].PostKarma + subreddits[j].CommentKarma
+		if a != b {
+			return a > b
+		}
+		return subreddits[i].SubredditId < subreddits[j].SubredditId
+	})
+
+	return &proto.GetUserKarmaResponse{
+		UserId:       user.ID,
+		Karma:        int32(user.Karma),
+		PostKarma:    int32(user.PostKarma),
+		CommentKarma: int32(user.CommentKarma),
+		Subreddits:   subreddits,
+	}
+}
@@ -0,0 +1,282 @@
+// internal/engine/listing.go
+package engine
+
+import (
+	"container/heap"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emirpasic/gods/trees/redblacktree"
+	"github.com/kakugri/redditClone/internal/proto"
+)
+
+const (
+	defaultListingLimit = 25
+	maxListingLimit     = 100
+)
+
+// listingQuery describes one page of a post listing.
+type listingQuery struct {
+	sort   SortOrder
+	window TimeWindow
+	after  *rankKey // rank key of the last post of the previous page
+	limit  int
+	now    time.Time
+	hidden map[string]bool // authors whose posts are left out
+}
+
+// newListingQuery validates the paging and sorting parameters of a listing
+// request. The cursor must come from a listing with the same sort and window.
+func newListingQuery(sortParam, windowParam, cursor string, limit int32) (listingQuery, *proto.Error) {
+	order, err := parsePostSort(sortParam)
+	if err != nil {
+		return listingQuery{}, newError(ErrCodeInvalidArgument, "%v", err)
+	}
+	window, err := parseTimeWindow(windowParam)
+	if err != nil {
+		return listingQuery{}, newError(ErrCodeInvalidArgument, "%v", err)
+	}
+	if order != SortTop && order != SortControversial {
+		window = WindowAll
+	}
+
+	query := listingQuery{sort: order, window: window, limit: int(limit), now: time.Now()}
+	if query.limit <= 0 {
+		query.limit = defaultListingLimit
+	} else if query.limit > maxListingLimit {
+		query.limit = maxListingLimit
+	}
+	if cursor != "" {
+		key, err := decodeListingCursor(cursor, order, window)
+		if err != nil {
+			return listingQuery{}, newError(ErrCodeInvalidArgument, "%v", err)
+		}
+		query.after = &key
+	}
+	return query, nil
+}
+
+// A listing cursor records the sort, the window and the rank key of the last
+// post of a page. The next page starts right after that key, so posts created
+// in the meantime never shift the pages a client is walking through.
+func encodeListingCursor(order SortOrder, window TimeWindow, key rankKey) string {
+	raw := strings.Join([]string{
+		string(order),
+		string(window),
+		strconv.FormatUint(math.Float64bits(key.score), 16),
+		strconv.FormatInt(key.createdAt, 10),
+		key.id,
+	}, "|")
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeListingCursor(cursor string, order SortOrder, window TimeWindow) (rankKey, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return rankKey{}, fmt.Errorf("malformed cursor")
+	}
+	parts := strings.SplitN(string(raw), "|", 5)
+	if len(parts) != 5 {
+		return rankKey{}, fmt.Errorf("malformed cursor")
+	}
+	if SortOrder(parts[0]) != order || TimeWindow(parts[1]) != window {
+		return rankKey{}, fmt.Errorf("cursor belongs to a %s/%s listing", parts[0], parts[1])
+	}
+	bits, err := strconv.ParseUint(parts[2], 16, 64)
+	if err != nil {
+		return rankKey{}, fmt.Errorf("malformed cursor")
+	}
+	createdAt, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil {
+		return rankKey{}, fmt.Errorf("malformed cursor")
+	}
+	return rankKey{score: math.Float64frombits(bits), createdAt: createdAt, id: parts[4]}, nil
+}
+
+// postSource yields the rank keys of one subreddit's posts in rank order.
+type postSource interface {
+	current() rankKey
+	// next advances the source and reports whether a post is left.
+	next() bool
+}
+
+// treeSource walks one of a subreddit's incrementally maintained rankings.
+type treeSource struct {
+	it redblacktree.Iterator
+}
+
+func newTreeSource(tree *redblacktree.Tree, after *rankKey) (postSource, bool) {
+	if after == nil {
+		s := &treeSource{it: tree.Iterator()}
+		return s, s.it.Next()
+	}
+	// Ceiling reports found for any ceiling, not only the cursor's own key
+	node, _ := tree.Ceiling(*after)
+	if node == nil {
+		return nil, false
+	}
+	it := tree.IteratorAt(node)
+	if compareRankKeys(node.Key, *after) == 0 && !it.Next() {
+		return nil, false
+	}
+	return &treeSource{it: it}, true
+}
+
+func (s *treeSource) current() rankKey {
+	return s.it.Key().(rankKey)
+}
+
+func (s *treeSource) next() bool {
+	return s.it.Next()
+}
+
+// newestSource walks a subreddit's posts from newest to oldest. Posts are
+// indexed in creation order, so no tree is needed.
+type newestSource struct {
+	keys  []rankKey
+	index int
+}
+
+func newNewestSource(keys []rankKey, after *rankKey) (postSource, bool) {
+	index := len(keys) - 1
+	if after != nil {
+		// Keys are oldest first, so the ones ranked after the cursor come first
+		index = sort.Search(len(keys), func(i int) bool {
+			return compareRankKeys(keys[i], *after) <= 0
+		}) - 1
+	}
+	return &newestSource{keys: keys, index: index}, index >= 0
+}
+
+func (s *newestSource) current() rankKey {
+	return s.keys[s.index]
+}
+
+func (s *newestSource) next() bool {
+	s.index--
+	return s.index >= 0
+}
+
+// recentSource ranks the posts created since a cutoff at query time. It backs
+// windowed listings and rising, whose scores depend on the current time.
+type recentSource struct {
+	keys  []rankKey
+	index int
+}
+
+func (e *RedditEngine) newRecentSource(newest []rankKey, query listingQuery, cutoff time.Time) (postSource, bool, error) {
+	start := sort.Search(len(newest), func(i int) bool {
+		return newest[i].createdAt >= cutoff.UnixNano()
+	})
+	recent := newest[start:]
+	s := &recentSource{keys: make([]rankKey, 0, len(recent))}
+	for _, key := range recent {
+		post, err := e.store.Post(key.id)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		s.keys = append(s.keys, postRankKey(post, query.sort, query.now))
+	}
+	sort.Slice(s.keys, func(i, j int) bool {
+		return compareRankKeys(s.keys[i], s.keys[j]) < 0
+	})
+	if query.after != nil {
+		s.index = sort.Search(len(s.keys), func(i int) bool {
+			return compareRankKeys(s.keys[i], *query.after) > 0
+		})
+	}
+	return s, s.index < len(s.keys), nil
+}
+
+func (s *recentSource) current() rankKey {
+	return s.keys[s.index]
+}
+
+func (s *recentSource) next() bool {
+	s.index++
+	return s.index < len(s.keys)
+}
+
+func (e *RedditEngine) postSource(subredditID string, query listingQuery) (postSource, bool, error) {
+	ranking := e.rankings[subredditID]
+	if ranking == nil {
+		return nil, false, nil
+	}
+	switch {
+	case query.sort == SortNew:
+		source, ok := newNewestSource(ranking.newest, query.after)
+		return source, ok, nil
+	case query.sort == SortRising:
+		return e.newRecentSource(ranking.newest, query, query.now.Add(-risingWindow))
+	case query.window != WindowAll:
+		return e.newRecentSource(ranking.newest, query, query.now.Add(-query.window.duration()))
+	}
+	source, ok := newTreeSource(ranking.trees[query.sort], query.after)
+	return source, ok, nil
+}
+
+// sourceHeap merges several sources, best ranked current post first.
+type sourceHeap []postSource
+
+func (h sourceHeap) Len() int { return len(h) }
+func (h sourceHeap) Less(i, j int) bool {
+	return compareRankKeys(h[i].current(), h[j].current()) < 0
+}
+func (h sourceHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *sourceHeap) Push(x interface{}) { *h = append(*h, x.(postSource)) }
+func (h *sourceHeap) Pop() interface{} {
+	old := *h
+	source := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return source
+}
+
+// listPosts returns one page of the posts of the given subreddits, merged and
+// ranked by the query's sort order, and the cursor for the following page if
+// there is one.
+func (e *RedditEngine) listPosts(subredditIDs []string, query listingQuery) ([]*Post, string, error) {
+	sources := make(sourceHeap, 0, len(subredditIDs))
+	for _, subredditID := range subredditIDs {
+		source, ok, err := e.postSource(subredditID, query)
+		if err != nil {
+			return nil, "", err
+		}
+		if ok {
+			sources = append(sources, source)
+		}
+	}
+	heap.Init(&sources)
+
+	page := make([]*Post, 0, query.limit)
+	var last rankKey
+	for len(page) < query.limit && sources.Len() > 0 {
+		key := sources[0].current()
+		post, err := e.store.Post(key.id)
+		switch {
+		case err == nil && !post.Deleted && !post.Removed && !query.hidden[post.AuthorID]:
+			page = append(page, post)
+		case err != nil && !errors.Is(err, ErrNotFound):
+			return nil, "", err
+		}
+		last = key
+		if sources[0].next() {
+			heap.Fix(&sources, 0)
+		} else {
+			heap.Pop(&sources)
+		}
+	}
+
+	if sources.Len() == 0 {
+		return page, "", nil
+	}
+	return page, encodeListingCursor(query.sort, query.window, last), nil
+}
@@ -0,0 +1,142 @@
+// internal/engine/listing_test.go
+package engine
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testSubredditID = "t5_test"
+
+// newListingEngine returns an engine whose test subreddit has 20 posts, ten
+// minutes apart, with small vote counts so most sorts have ties.
+func newListingEngine(now time.Time) *RedditEngine {
+	e := NewRedditEngine()
+	// Rankings expect posts oldest first
+	for i := 19; i >= 0; i-- {
+		post := &Post{
+			ID:          fmt.Sprintf("t3_%02d", i),
+			SubredditID: testSubredditID,
+			Upvotes:     i % 4,
+			Downvotes:   i % 3,
+			CreatedAt:   now.Add(-time.Duration(i) * 10 * time.Minute),
+		}
+		post.Scores = computeScores(post.Upvotes, post.Downvotes, post.CreatedAt)
+		e.store.PutPost(post)
+		e.ranking(testSubredditID).add(post)
+	}
+	return e
+}
+
+// listPage returns the IDs of one page of the test subreddit and the cursor of
+// the next.
+func listPage(t *testing.T, e *RedditEngine, now time.Time, sort SortOrder, window TimeWindow, cursor string, limit int32) ([]string, string) {
+	t.Helper()
+	query, queryErr := newListingQuery(string(sort), string(window), cursor, limit)
+	if queryErr != nil {
+		t.Fatalf("newListingQuery: %v", queryErr)
+	}
+	query.now = now
+	posts, next, err := e.listPosts([]string{testSubredditID}, query)
+	if err != nil {
+		t.Fatalf("listPosts: %v", err)
+	}
+	ids := make([]string, len(posts))
+	for i, post := range posts {
+		ids[i] = post.ID
+	}
+	return ids, next
+}
+
+func TestListingCursorRoundTrip(t *testing.T) {
+	now := time.Now()
+	e := newListingEngine(now)
+
+	tests := []struct {
+		sort   SortOrder
+		window TimeWindow
+	}{
+		{SortHot, ""},
+		{SortNew, ""},
+		{SortTop, ""},
+		{SortTop, WindowDay},
+		{SortRising, ""},
+		{SortControversial, ""},
+		{SortControversial, WindowWeek},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/%s", tt.sort, tt.window), func(t *testing.T) {
+			all, next := listPage(t, e, now, tt.sort, tt.window, "", maxListingLimit)
+			if len(all) != 20 || next != "" {
+				t.Fatalf("one page has %d posts and cursor %q, want 20 posts and no cursor", len(all), next)
+			}
+
+			// Walking pages of 3 must visit every post once, in the same order
+			var paged []string
+			cursor := ""
+			for pages := 0; ; pages++ {
+				if pages > len(all) {
+					t.Fatalf("paging did not end after %d pages", pages)
+				}
+				ids, next := listPage(t, e, now, tt.sort, tt.window, cursor, 3)
+				paged = append(paged, ids...)
+				if next == "" {
+					break
+				}
+				cursor = next
+			}
+			if !reflect.DeepEqual(paged, all) {
+				t.Errorf("paged listing\n%v\nwant\n%v", paged, all)
+			}
+		})
+	}
+}
+
+func TestListingCursorKeepsItsSort(t *testing.T) {
+	now := time.Now()
+	e := newListingEngine(now)
+
+	_, cursor := listPage(t, e, now, SortTop, "", "", 3)
+	if cursor == "" {
+		t.Fatal("first page has no cursor")
+	}
+	if _, err := newListingQuery(string(SortHot), "", cursor, 3); err == nil || err.Code != ErrCodeInvalidArgument {
+		t.Errorf("cursor of a top listing used for hot: got %v, want %s", err, ErrCodeInvalidArgument)
+	}
+	if _, err := newListingQuery(string(SortTop), string(WindowDay), cursor, 3); err == nil {
+		t.Error("cursor of an all-time top listing accepted for a daily one")
+	}
+	if _, err := newListingQuery(string(SortTop), "", "not a cursor", 3); err == nil {
+		t.Error("malformed cursor accepted")
+	}
+}
+
+// A vote between pages moves the post a cursor points at. The next page must
+// still start right after the cursor's key rather than skip a post.
+func TestListingCursorAfterItsPostMoved(t *testing.T) {
+	for _, sort := range []SortOrder{SortHot, SortTop} {
+		t.Run(string(sort), func(t *testing.T) {
+			now := time.Now()
+			e := newListingEngine(now)
+			all, _ := listPage(t, e, now, sort, "", "", maxListingLimit)
+			first, cursor := listPage(t, e, now, sort, "", "", 3)
+
+			post, err := e.store.Post(first[len(first)-1])
+			if err != nil {
+				t.Fatal(err)
+			}
+			ranking := e.ranking(testSubredditID)
+			ranking.remove(post)
+			post.Upvotes += 10
+			post.Scores = computeScores(post.Upvotes, post.Downvotes, post.CreatedAt)
+			ranking.insert(post)
+
+			second, _ := listPage(t, e, now, sort, "", cursor, 3)
+			if !reflect.DeepEqual(second, all[3:6]) {
+				t.Errorf("second page %v, want %v", second, all[3:6])
+			}
+		})
+	}
+}
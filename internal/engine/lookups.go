@@ -0,0 +1,81 @@
+// internal/engine/lookups.go
+package engine
+
+import (
+	"github.com/asynkron/protoactor-go/actor"
+	"github.com/kakugri/redditClone/internal/proto"
+)
+
+// subredditNameKey is the key under which the owner index maps a subreddit's
+// name to its ID, so subreddits can be looked up by name.
+func subredditNameKey(name string) string {
+	return "r/" + foldName(name)
+}
+
+// usernameKey is the key under which the owner index maps a username to the
+// user's ID, so users can log in by name.
+func usernameKey(username string) string {
+	return "u/" + foldName(username)
+}
+
+func (e *RedditEngine) handleGetUser(context actor.Context, msg *proto.GetUserMsg) {
+	user, err := e.store.User(msg.UserId)
+	if err != nil {
+		respond(context, &proto.GetUserResponse{Error: storeError(err, "user %q not found", msg.UserId)})
+		return
+	}
+	respond(context, &proto.GetUserResponse{User: userInfo(user)})
+}
+
+func (e *RedditEngine) handleGetSubreddit(context actor.Context, msg *proto.GetSubredditMsg) {
+	id := msg.SubredditId
+	if id == "" {
+		key := subredditNameKey(msg.Name)
+		owner, err := e.store.Owner(key)
+		if err != nil {
+			respond(context, &proto.GetSubredditResponse{Error: storeError(err, "subreddit %q not found", key)})
+			return
+		}
+		id = owner
+	}
+	e.describeSubreddit(context, id)
+}
+
+// describeSubreddit answers a GetSubredditMsg about the subreddit id.
+func (e *RedditEngine) describeSubreddit(context actor.Context, id string) {
+	subreddit, err := e.store.Subreddit(id)
+	if err != nil {
+		respond(context, &proto.GetSubredditResponse{Error: storeError(err, "subreddit %q not found", id)})
+		return
+	}
+	respond(context, &proto.GetSubredditResponse{Subreddit: subredditInfo(subreddit)})
+}
+
+func (e *RedditEngine) handleGetPost(context actor.Context, msg *proto.GetPostMsg) {
+	post, err := e.store.Post(msg.PostId)
+	if err != nil {
+		respond(context, &proto.GetPostResponse{Error: storeError(err, "post %q not found", msg.PostId)})
+		return
+	}
+	respond(context, &proto.GetPostResponse{Post: postInfo(post)})
+}
+
+func (e *RedditEngine) handleGetCredentials(context actor.Context, msg *proto.GetCredentialsMsg) {
+	key := usernameKey(msg.Username)
+	id, err := e.store.Owner(key)
+	if err != nil {
+		respond(context, &proto.GetCredentialsResponse{Error: storeError(err, "user %q not found", key)})
+		return
+	}
+	e.describeCredentials(context, id)
+}
+
+// describeCredentials answers a GetCredentialsMsg about the user id.
+func (e *RedditEngine) describeCredentials(context actor.Context, id string) {
+	user, err := e.store.User(id)
+	if err != nil {
+		respond(context, &proto.GetCredentialsResponse{Error: storeError(err, "user %q not found", id)})
+		return
+	}
+	respond(context, &proto.GetCredentialsResponse{UserId: user.ID, PasswordHash: user.PasswordHash})
+}
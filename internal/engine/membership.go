@@ -0,0 +1,100 @@
+// internal/engine/membership.go
+package engine
+
+import (
+	"errors"
+	"log"
+	"sort"
+
+	"github.com/asynkron/protoactor-go/actor"
+	"github.com/kakugri/redditClone/internal/proto"
+)
+
+func (e *RedditEngine) handleJoinSubreddit(context actor.Context, msg *proto.JoinSubredditMsg) {
+	user, subreddit, err := e.lookupMembership(msg.UserId, msg.SubredditId)
+	if err != nil {
+		respond(context, &proto.JoinSubredditResponse{SubredditId: msg.SubredditId, Error: err})
+		return
+	}
+
+	// Joining twice is a no-op so clients can safely retry
+	if !user.Subreddits[subreddit.ID] {
+		user.Subreddits[subreddit.ID] = true
+		subreddit.MemberCount++
+		e.store.PutUser(user)
+		e.store.PutSubreddit(subreddit)
+	}
+	if err := e.commit(msg); err != nil {
+		respond(context, &proto.JoinSubredditResponse{SubredditId: subreddit.ID, Error: err})
+		return
+	}
+	log.Printf("User %s joined subreddit %s (%d members)", user.ID, subreddit.ID, subreddit.MemberCount)
+	respond(context, &proto.JoinSubredditResponse{
+		SubredditId: subreddit.ID,
+		MemberCount: int32(subreddit.MemberCount),
+	})
+}
+
+func (e *RedditEngine) handleLeaveSubreddit(context actor.Context, msg *proto.LeaveSubredditMsg) {
+	user, subreddit, err := e.lookupMembership(msg.UserId, msg.SubredditId)
+	if err != nil {
+		respond(context, &proto.LeaveSubredditResponse{SubredditId: msg.SubredditId, Error: err})
+		return
+	}
+
+	if user.Subreddits[subreddit.ID] {
+		delete(user.Subreddits, subreddit.ID)
+		subreddit.MemberCount--
+		e.store.PutUser(user)
+		e.store.PutSubreddit(subreddit)
+	}
+	if err := e.commit(msg); err != nil {
+		respond(context, &proto.LeaveSubredditResponse{SubredditId: subreddit.ID, Error: err})
+		return
+	}
+	log.Printf("User %s left subreddit %s (%d members)", user.ID, subreddit.ID, subreddit.MemberCount)
+	respond(context, &proto.LeaveSubredditResponse{
+		SubredditId: subreddit.ID,
+		MemberCount: int32(subreddit.MemberCount),
+	})
+}
+
+func (e *RedditEngine) handleGetUserSubreddits(context actor.Context, msg *proto.GetUserSubredditsMsg) {
+	user, err := e.store.User(msg.UserId)
+	if err != nil {
+		respond(context, &proto.GetUserSubredditsResponse{
+			Error: storeError(err, "user %q not found", msg.UserId),
+		})
+		return
+	}
+
+	subreddits := make([]*proto.SubredditInfo, 0, len(user.Subreddits))
+	for id := range user.Subreddits {
+		subreddit, err := e.store.Subreddit(id)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			respond(context, &proto.GetUserSubredditsResponse{Error: storeError(err, "")})
+			return
+		}
+		subreddits = append(subreddits, subredditInfo(subreddit))
+	}
+	sort.Slice(subreddits, func(i, j int) bool {
+		return subreddits[i].Name < subreddits[j].Name
+	})
+	respond(context, &proto.GetUserSubredditsResponse{Subreddits: subreddits})
+}
+
+// lookupMembership resolves both sides of a join/leave request.
+func (e *RedditEngine) lookupMembership(userID, subredditID string) (*User, *Subreddit, *proto.Error) {
+	user, err := e.store.User(userID)
+	if err != nil {
+		return nil, nil, storeError(err, "user %q not found", userID)
+	}
+	subreddit, err := e.store.Subreddit(subredditID)
+	if err != nil {
+		return nil, nil, storeError(err, "subreddit %q not found", subredditID)
+	}
+	return user, subreddit, nil
+}
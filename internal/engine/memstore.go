@@ -0,0 +1,262 @@
+// internal/engine/memstore.go
+package engine
+
+import "sync"
+
+// memoryStore keeps every record in maps. It hands out its own pointers, so a
+// Put of a record read from it only has to index new records.
+//
+// The maps are guarded by a lock so the grains of an engine cluster member
+// can share one store. The records themselves are not: each is only changed
+// by the grain that owns it.
+type memoryStore struct {
+	mu sync.RWMutex
+
+	users      map[string]*User
+	subreddits map[string]*Subreddit
+	posts      map[string]*Post
+	comments   map[string]*Comment
+	messages   map[string]*DirectMessage // owner ID/message ID -> the owner's copy
+	votes      map[string]map[string]int // target ID -> user ID -> +1 or -1
+	owners     map[string]string         // record ID -> owning grain
+
+	postComments map[string][]*Comment       // post ID -> comments, oldest first
+	mailboxes    map[string][]*DirectMessage // user ID -> their copies, oldest first
+	modLogs      map[string][]*ModAction     // subreddit ID -> actions, oldest first
+	modQueues    map[string]map[string]bool  // subreddit ID -> queued post and comment IDs
+}
+
+// NewMemoryStore returns an empty store that lives only as long as the process.
+func NewMemoryStore() Store {
+	return newMemoryStore()
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		users:        make(map[string]*User),
+		subreddits:   make(map[string]*Subreddit),
+		posts:        make(map[string]*Post),
+		comments:     make(map[string]*Comment),
+		messages:     make(map[string]*DirectMessage),
+		votes:        make(map[string]map[string]int),
+		owners:       make(map[string]string),
+		postComments: make(map[string][]*Comment),
+		mailboxes:    make(map[string][]*DirectMessage),
+		modLogs:      make(map[string][]*ModAction),
+		modQueues:    make(map[string]map[string]bool),
+	}
+}
+
+func (s *memoryStore) User(id string) (*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if user, exists := s.users[id]; exists {
+		return user, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (s *memoryStore) Subreddit(id string) (*Subreddit, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if subreddit, exists := s.subreddits[id]; exists {
+		return subreddit, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (s *memoryStore) Post(id string) (*Post, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if post, exists := s.posts[id]; exists {
+		return post, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (s *memoryStore) Comment(id string) (*Comment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if comment, exists := s.comments[id]; exists {
+		return comment, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (s *memoryStore) Message(userID, id string) (*DirectMessage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if dm, exists := s.messages[string(indexKey(userID, id))]; exists {
+		return dm, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (s *memoryStore) Vote(targetID, userID string) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.votes[targetID][userID], nil
+}
+
+// PostComments returns a copy of the index, which later Puts append to.
+func (s *memoryStore) PostComments(postID string) ([]*Comment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]*Comment(nil), s.postComments[postID]...), nil
+}
+
+func (s *memoryStore) Mailbox(userID string) ([]*DirectMessage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]*DirectMessage(nil), s.mailboxes[userID]...), nil
+}
+
+func (s *memoryStore) ModLog(subredditID string) ([]*ModAction, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]*ModAction(nil), s.modLogs[subredditID]...), nil
+}
+
+func (s *memoryStore) ModQueue(subredditID string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ids := make([]string, 0, len(s.modQueues[subredditID]))
+	for id := range s.modQueues[subredditID] {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// ForEachPost calls fn on a list of the posts taken up front, so fn may use
+// the store.
+func (s *memoryStore) ForEachPost(fn func(*Post) error) error {
+	s.mu.RLock()
+	posts := make([]*Post, 0, len(s.posts))
+	for _, post := range s.posts {
+		posts = append(posts, post)
+	}
+	s.mu.RUnlock()
+
+	for _, post := range posts {
+		if err := fn(post); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) Totals() (Totals, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	totals := Totals{
+		Users:    int64(len(s.users)),
+		Posts:    int64(len(s.posts)),
+		Comments: int64(len(s.comments)),
+	}
+	for _, ledger := range s.votes {
+		totals.Votes += int64(len(ledger))
+	}
+	for _, dm := range s.messages {
+		if dm.received() {
+			totals.Messages++
+		}
+	}
+	return totals, nil
+}
+
+func (s *memoryStore) Owner(id string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if ownerID, exists := s.owners[id]; exists {
+		return ownerID, nil
+	}
+	return "", ErrNotFound
+}
+
+func (s *memoryStore) PutUser(user *User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[user.ID] = user
+}
+
+func (s *memoryStore) PutSubreddit(subreddit *Subreddit) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subreddits[subreddit.ID] = subreddit
+}
+
+func (s *memoryStore) PutPost(post *Post) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.posts[post.ID] = post
+}
+
+func (s *memoryStore) PutComment(comment *Comment) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.comments[comment.ID]; !exists {
+		s.postComments[comment.PostID] = append(s.postComments[comment.PostID], comment)
+	}
+	s.comments[comment.ID] = comment
+}
+
+func (s *memoryStore) PutMessage(dm *DirectMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := string(indexKey(dm.OwnerID, dm.ID))
+	if _, exists := s.messages[key]; !exists {
+		s.mailboxes[dm.OwnerID] = append(s.mailboxes[dm.OwnerID], dm)
+	}
+	s.messages[key] = dm
+}
+
+// PutModAction appends to the log: actions are never changed.
+func (s *memoryStore) PutModAction(action *ModAction) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.modLogs[action.SubredditID] = append(s.modLogs[action.SubredditID], action)
+}
+
+func (s *memoryStore) PutQueued(subredditID, targetID string, queued bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !queued {
+		delete(s.modQueues[subredditID], targetID)
+		return
+	}
+	if s.modQueues[subredditID] == nil {
+		s.modQueues[subredditID] = make(map[string]bool)
+	}
+	s.modQueues[subredditID][targetID] = true
+}
+
+func (s *memoryStore) PutVote(targetID, userID string, value int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if value == 0 {
+		delete(s.votes[targetID], userID)
+		if len(s.votes[targetID]) == 0 {
+			delete(s.votes, targetID)
+		}
+		return
+	}
+	if s.votes[targetID] == nil {
+		s.votes[targetID] = make(map[string]int)
+	}
+	s.votes[targetID][userID] = value
+}
+
+func (s *memoryStore) PutOwner(id, ownerID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.owners[id] = ownerID
+}
+
+// Commit has nothing to do: every Put is applied immediately.
+func (s *memoryStore) Commit() error {
+	return nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
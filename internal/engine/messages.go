@@ -0,0 +1,347 @@
+// internal/engine/messages.go
+package engine
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"github.com/asynkron/protoactor-go/actor"
+	"github.com/kakugri/redditClone/internal/idgen"
+	"github.com/kakugri/redditClone/internal/proto"
+)
+
+const (
+	defaultMessagesLimit = 25
+	maxMessagesLimit     = 100
+)
+
+// Folders of a message listing
+const (
+	folderInbox = "inbox" // received
+	folderSent  = "sent"
+	folderAll   = "all"
+)
+
+// composeMessage checks a message on behalf of its sender and returns the
+// recipient's copy, and the sender for the recipient's checks. A reply goes
+// to the other participant of the message it answers and continues its
+// thread.
+func (e *RedditEngine) composeMessage(msg *proto.DirectMessageMsg) (*DirectMessage, *proto.UserInfo, *proto.Error) {
+	if err := e.check(validateDirectMessage(msg)); err != nil {
+		return nil, nil, err
+	}
+	sender, err := e.store.User(msg.FromUserId)
+	if err != nil {
+		return nil, nil, storeError(err, "user %q not found", msg.FromUserId)
+	}
+
+	toUserID, threadID := msg.ToUserId, ""
+	if msg.ReplyToId != "" {
+		parent, err := e.store.Message(msg.FromUserId, msg.ReplyToId)
+		if err != nil || parent.Deleted {
+			return nil, nil, storeError(ErrNotFound, "message %q not found", msg.ReplyToId)
+		}
+		if toUserID == "" {
+			toUserID = parent.otherParty()
+		}
+		if toUserID != parent.otherParty() {
+			return nil, nil, newError(ErrCodeInvalidArgument, "message %q is not from your conversation with user %q", parent.ID, toUserID)
+		}
+		threadID = parent.ThreadID
+	}
+	if toUserID == msg.FromUserId {
+		return nil, nil, newError(ErrCodeInvalidArgument, "cannot send a message to yourself")
+	}
+	if sender.Blocked[toUserID] {
+		return nil, nil, newError(ErrCodeInvalidArgument, "you blocked user %q; unblock them to send them messages", toUserID)
+	}
+
+	dm := &DirectMessage{
+		ID:         e.newID(idgen.KindMessage),
+		OwnerID:    toUserID,
+		FromUserID: msg.FromUserId,
+		ToUserID:   toUserID,
+		Content:    msg.Content,
+		ReplyToID:  msg.ReplyToId,
+		ThreadID:   threadID,
+		CreatedAt:  e.now(),
+	}
+	if dm.ThreadID == "" {
+		dm.ThreadID = dm.ID
+	}
+	return dm, userInfo(sender), nil
+}
+
+// acceptMessage checks that the recipient of dm takes messages from sender:
+// that they did not block the sender, and that the sender meets their
+// message settings.
+func (e *RedditEngine) acceptMessage(dm *DirectMessage, sender *proto.UserInfo) *proto.Error {
+	recipient, err := e.store.User(dm.ToUserID)
+	if err != nil {
+		return storeError(err, "user %q not found", dm.ToUserID)
+	}
+	if recipient.Blocked[dm.FromUserID] {
+		return newError(ErrCodePermissionDenied, "user %q does not accept messages from you", recipient.ID)
+	}
+	if !recipient.Messages.accepts(sender, e.now()) {
+		return newError(ErrCodePermissionDenied, "user %q only accepts messages from %s", recipient.ID, recipient.Messages)
+	}
+	return nil
+}
+
+// deliverMessage stores the recipient's copy of dm.
+func (e *RedditEngine) deliverMessage(dm *DirectMessage) {
+	e.store.PutMessage(dm)
+	e.updateMetrics(func(m *Metrics) {
+		m.TotalMessages++
+	})
+}
+
+// sentCopy returns the sender's copy of dm, which is never unread.
+func sentCopy(dm *DirectMessage) *DirectMessage {
+	sent := *dm
+	sent.OwnerID = dm.FromUserID
+	sent.Read = true
+	return &sent
+}
+
+// directMessage rebuilds the recipient's copy of a message its sender's grain
+// composed.
+func directMessage(info *proto.MessageInfo, sentAt time.Time) *DirectMessage {
+	return &DirectMessage{
+		ID:         info.Id,
+		OwnerID:    info.ToUserId,
+		FromUserID: info.FromUserId,
+		ToUserID:   info.ToUserId,
+		Content:    info.Content,
+		ReplyToID:  info.ReplyToId,
+		ThreadID:   info.ThreadId,
+		CreatedAt:  sentAt,
+	}
+}
+
+// handleDirectMessage sends a message within a single engine, which keeps
+// both copies. In a cluster the grains of the two participants each keep
+// their own.
+func (e *RedditEngine) handleDirectMessage(context actor.Context, msg *proto.DirectMessageMsg) {
+	dm, sender, err := e.composeMessage(msg)
+	if err == nil {
+		err = e.acceptMessage(dm, sender)
+	}
+	if err != nil {
+		respond(context, &proto.DirectMessageResponse{Error: err})
+		return
+	}
+
+	e.deliverMessage(dm)
+	e.store.PutMessage(sentCopy(dm))
+	if err := e.commit(msg); err != nil {
+		respond(context, &proto.DirectMessageResponse{Error: err})
+		return
+	}
+	log.Printf("Direct message sent: MessageID=%s, From=%s, To=%s", dm.ID, dm.FromUserID, dm.ToUserID)
+	respond(context, &proto.DirectMessageResponse{MessageId: dm.ID})
+}
+
+// mailbox returns the copies of messages a user has not deleted, newest
+// first, and how many of them are unread.
+func (e *RedditEngine) mailbox(userID string) ([]*DirectMessage, int, *proto.Error) {
+	if _, err := e.store.User(userID); err != nil {
+		return nil, 0, storeError(err, "user %q not found", userID)
+	}
+	copies, err := e.store.Mailbox(userID)
+	if err != nil {
+		return nil, 0, storeError(err, "")
+	}
+
+	messages := make([]*DirectMessage, 0, len(copies))
+	unread := 0
+	for _, dm := range copies {
+		if dm.Deleted {
+			continue
+		}
+		messages = append(messages, dm)
+		if !dm.Read {
+			unread++
+		}
+	}
+	// IDs sort by creation time
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].ID > messages[j].ID
+	})
+	return messages, unread, nil
+}
+
+// inFolder reports whether the owner's copy dm is listed in folder.
+func inFolder(dm *DirectMessage, folder string) bool {
+	switch folder {
+	case folderSent:
+		return !dm.received()
+	case folderAll:
+		return true
+	}
+	return dm.received()
+}
+
+// messagesLimit bounds the page size a client asked for.
+func messagesLimit(limit int32) int {
+	if limit <= 0 {
+		return defaultMessagesLimit
+	}
+	return min(int(limit), maxMessagesLimit)
+}
+
+func (e *RedditEngine) handleGetMessages(context actor.Context, msg *proto.GetMessagesMsg) {
+	if err := validateGetMessages(msg); err != nil {
+		respond(context, &proto.GetMessagesResponse{Error: err})
+		return
+	}
+	messages, unread, err := e.mailbox(msg.UserId)
+	if err != nil {
+		respond(context, &proto.GetMessagesResponse{Error: err})
+		return
+	}
+
+	limit := messagesLimit(msg.Limit)
+	page := make([]*proto.MessageInfo, 0, min(limit, len(messages)))
+	next := ""
+	for _, dm := range messages {
+		if msg.Cursor != "" && dm.ID >= msg.Cursor {
+			continue
+		}
+		if !inFolder(dm, msg.Folder) || msg.OtherUserId != "" && dm.otherParty() != msg.OtherUserId || msg.UnreadOnly && dm.Read {
+			continue
+		}
+		if len(page) == limit {
+			next = page[len(page)-1].Id
+			break
+		}
+		page = append(page, messageInfo(dm))
+	}
+	respond(context, &proto.GetMessagesResponse{Messages: page, NextCursor: next, UnreadCount: int32(unread)})
+}
+
+func (e *RedditEngine) handleGetConversations(context actor.Context, msg *proto.GetConversationsMsg) {
+	if err := validateGetConversations(msg); err != nil {
+		respond(context, &proto.GetConversationsResponse{Error: err})
+		return
+	}
+	messages, unread, err := e.mailbox(msg.UserId)
+	if err != nil {
+		respond(context, &proto.GetConversationsResponse{Error: err})
+		return
+	}
+
+	// Newest first, so each conversation is met first at its last message
+	byUser := make(map[string]*proto.ConversationInfo)
+	var conversations []*proto.ConversationInfo
+	for _, dm := range messages {
+		conversation := byUser[dm.otherParty()]
+		if conversation == nil {
+			conversation = &proto.ConversationInfo{OtherUserId: dm.otherParty(), LastMessage: messageInfo(dm)}
+			byUser[dm.otherParty()] = conversation
+			conversations = append(conversations, conversation)
+		}
+		conversation.MessageCount++
+		if !dm.Read {
+			conversation.UnreadCount++
+		}
+	}
+
+	limit := messagesLimit(msg.Limit)
+	page := make([]*proto.ConversationInfo, 0, min(limit, len(conversations)))
+	next := ""
+	for _, conversation := range conversations {
+		if msg.Cursor != "" && conversation.LastMessage.Id >= msg.Cursor {
+			continue
+		}
+		if len(page) == limit {
+			next = page[len(page)-1].LastMessage.Id
+			break
+		}
+		page = append(page, conversation)
+	}
+	respond(context, &proto.GetConversationsResponse{Conversations: page, NextCursor: next, UnreadCount: int32(unread)})
+}
+
+func (e *RedditEngine) handleMarkRead(context actor.Context, msg *proto.MarkReadMsg) {
+	messages, unread, err := e.mailbox(msg.UserId)
+	if err == nil {
+		err = e.check(validateMarkRead(msg))
+	}
+	if err != nil {
+		respond(context, &proto.MarkReadResponse{Error: err})
+		return
+	}
+
+	var marked []*DirectMessage
+	if msg.MessageId != "" {
+		dm, storeErr := e.store.Message(msg.UserId, msg.MessageId)
+		if storeErr != nil || dm.Deleted {
+			respond(context, &proto.MarkReadResponse{Error: storeError(ErrNotFound, "message %q not found", msg.MessageId)})
+			return
+		}
+		if !dm.received() {
+			respond(context, &proto.MarkReadResponse{
+				Error: newError(ErrCodeInvalidArgument, "message %q was sent by user %q, not received", dm.ID, msg.UserId),
+			})
+			return
+		}
+		marked = append(marked, dm)
+	} else {
+		for _, dm := range messages {
+			if dm.received() && dm.FromUserID == msg.OtherUserId {
+				marked = append(marked, dm)
+			}
+		}
+	}
+
+	updated := 0
+	for _, dm := range marked {
+		if dm.Read == msg.Read {
+			continue
+		}
+		dm.Read = msg.Read
+		e.store.PutMessage(dm)
+		updated++
+		if msg.Read {
+			unread--
+		} else {
+			unread++
+		}
+	}
+	if updated > 0 {
+		if err := e.commit(msg); err != nil {
+			respond(context, &proto.MarkReadResponse{Error: err})
+			return
+		}
+	}
+	respond(context, &proto.MarkReadResponse{Updated: int32(updated), UnreadCount: int32(unread)})
+}
+
+// handleDeleteMessage deletes the user's copy of a message. Deleting it again
+// is a no-op so clients can safely retry.
+func (e *RedditEngine) handleDeleteMessage(context actor.Context, msg *proto.DeleteMessageMsg) {
+	dm, err := e.store.Message(msg.UserId, msg.MessageId)
+	if err != nil {
+		respond(context, &proto.DeleteMessageResponse{
+			MessageId: msg.MessageId,
+			Error:     storeError(err, "message %q not found", msg.MessageId),
+		})
+		return
+	}
+
+	if !dm.Deleted {
+		dm.Deleted = true
+		dm.Content = ""
+		dm.Read = true
+		e.store.PutMessage(dm)
+		if err := e.commit(msg); err != nil {
+			respond(context, &proto.DeleteMessageResponse{MessageId: dm.ID, Error: err})
+			return
+		}
+		log.Printf("Direct message deleted: MessageID=%s, By=%s", dm.ID, msg.UserId)
+	}
+	respond(context, &proto.DeleteMessageResponse{MessageId: dm.ID})
+}
@@ -0,0 +1,196 @@
+// internal/engine/messages_test.go
+package engine
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/kakugri/redditClone/internal/proto"
+)
+
+func (te *testEngine) sendMessage(msg *proto.DirectMessageMsg) string {
+	te.t.Helper()
+	resp := te.request(msg).(*proto.DirectMessageResponse)
+	if resp.Error != nil {
+		te.t.Fatalf("message from %s to %s: %v", msg.FromUserId, msg.ToUserId, resp.Error)
+	}
+	return resp.MessageId
+}
+
+func (te *testEngine) messages(msg *proto.GetMessagesMsg) *proto.GetMessagesResponse {
+	te.t.Helper()
+	resp := te.request(msg).(*proto.GetMessagesResponse)
+	if resp.Error != nil {
+		te.t.Fatalf("messages of %s: %v", msg.UserId, resp.Error)
+	}
+	return resp
+}
+
+func (te *testEngine) markRead(msg *proto.MarkReadMsg) *proto.MarkReadResponse {
+	te.t.Helper()
+	resp := te.request(msg).(*proto.MarkReadResponse)
+	if resp.Error != nil {
+		te.t.Fatalf("mark messages of %s: %v", msg.UserId, resp.Error)
+	}
+	return resp
+}
+
+// messageIDs returns the IDs of messages.
+func messageIDs(messages []*proto.MessageInfo) []string {
+	ids := make([]string, len(messages))
+	for i, message := range messages {
+		ids[i] = message.Id
+	}
+	return ids
+}
+
+func TestReplyStaysInItsConversation(t *testing.T) {
+	te := startEngine(t, NewRedditEngine())
+	alice, bob, carol := te.registerUser("alice"), te.registerUser("bob"), te.registerUser("carol")
+	first := te.sendMessage(&proto.DirectMessageMsg{FromUserId: alice, ToUserId: bob, Content: "hi"})
+	other := te.sendMessage(&proto.DirectMessageMsg{FromUserId: carol, ToUserId: bob, Content: "hello"})
+
+	// A reply goes to the other participant and continues the thread
+	reply := te.sendMessage(&proto.DirectMessageMsg{FromUserId: bob, ReplyToId: first, Content: "hi back"})
+	inbox := te.messages(&proto.GetMessagesMsg{UserId: alice})
+	if len(inbox.Messages) != 1 || inbox.Messages[0].Id != reply || inbox.Messages[0].ThreadId != first || inbox.Messages[0].ReplyToId != first {
+		t.Errorf("alice's inbox %v, want the reply in the thread of %s", inbox.Messages, first)
+	}
+
+	tests := []struct {
+		name string
+		msg  *proto.DirectMessageMsg
+		code string
+	}{
+		{"reply to someone outside the conversation", &proto.DirectMessageMsg{FromUserId: bob, ToUserId: carol, ReplyToId: first, Content: "x"}, ErrCodeInvalidArgument},
+		{"reply to a message of another conversation", &proto.DirectMessageMsg{FromUserId: alice, ReplyToId: other, Content: "x"}, ErrCodeNotFound},
+		{"reply to a missing message", &proto.DirectMessageMsg{FromUserId: alice, ReplyToId: "t4_missing", Content: "x"}, ErrCodeNotFound},
+		{"message to oneself", &proto.DirectMessageMsg{FromUserId: alice, ToUserId: alice, Content: "x"}, ErrCodeInvalidArgument},
+	}
+	for _, tt := range tests {
+		resp := te.request(tt.msg).(*proto.DirectMessageResponse)
+		if resp.Error == nil || resp.Error.Code != tt.code {
+			t.Errorf("%s: got %v, want %s", tt.name, resp.Error, tt.code)
+		}
+	}
+}
+
+func TestMessagePaging(t *testing.T) {
+	te := startEngine(t, NewRedditEngine())
+	bob := te.registerUser("bob")
+	var sent []string
+	for i := 0; i < 5; i++ {
+		sender := te.registerUser(fmt.Sprintf("sender%d", i))
+		sent = append(sent, te.sendMessage(&proto.DirectMessageMsg{FromUserId: sender, ToUserId: bob, Content: "hi"}))
+	}
+	slices.Reverse(sent) // newest first
+
+	var listed []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages == 3 {
+			t.Fatalf("more than 3 pages of 2 for 5 messages")
+		}
+		resp := te.messages(&proto.GetMessagesMsg{UserId: bob, Cursor: cursor, Limit: 2})
+		listed = append(listed, messageIDs(resp.Messages)...)
+		if resp.NextCursor == "" {
+			break
+		}
+		cursor = resp.NextCursor
+	}
+	if !slices.Equal(listed, sent) {
+		t.Errorf("paged through %v, want %v", listed, sent)
+	}
+
+	// Each sender is a conversation of its own
+	var conversations []string
+	cursor = ""
+	for {
+		resp := te.request(&proto.GetConversationsMsg{UserId: bob, Cursor: cursor, Limit: 2}).(*proto.GetConversationsResponse)
+		if resp.Error != nil {
+			t.Fatal(resp.Error)
+		}
+		for _, conversation := range resp.Conversations {
+			conversations = append(conversations, conversation.LastMessage.Id)
+		}
+		if resp.NextCursor == "" {
+			break
+		}
+		cursor = resp.NextCursor
+	}
+	if !slices.Equal(conversations, sent) {
+		t.Errorf("paged through conversations ending in %v, want %v", conversations, sent)
+	}
+}
+
+func TestMarkRead(t *testing.T) {
+	te := startEngine(t, NewRedditEngine())
+	alice, bob, carol := te.registerUser("alice"), te.registerUser("bob"), te.registerUser("carol")
+	var fromAlice []string
+	for i := 0; i < 3; i++ {
+		fromAlice = append(fromAlice, te.sendMessage(&proto.DirectMessageMsg{FromUserId: alice, ToUserId: bob, Content: "hi"}))
+	}
+	te.sendMessage(&proto.DirectMessageMsg{FromUserId: carol, ToUserId: bob, Content: "hello"})
+	sent := te.sendMessage(&proto.DirectMessageMsg{FromUserId: bob, ToUserId: carol, Content: "hello back"})
+
+	if resp := te.messages(&proto.GetMessagesMsg{UserId: bob}); resp.UnreadCount != 4 {
+		t.Errorf("%d unread before marking, want 4", resp.UnreadCount)
+	}
+	steps := []struct {
+		name            string
+		msg             *proto.MarkReadMsg
+		updated, unread int32
+	}{
+		{"one message read", &proto.MarkReadMsg{UserId: bob, MessageId: fromAlice[0], Read: true}, 1, 3},
+		{"the same message read again", &proto.MarkReadMsg{UserId: bob, MessageId: fromAlice[0], Read: true}, 0, 3},
+		{"a conversation read", &proto.MarkReadMsg{UserId: bob, OtherUserId: alice, Read: true}, 2, 1},
+		{"one message unread", &proto.MarkReadMsg{UserId: bob, MessageId: fromAlice[1], Read: false}, 1, 2},
+	}
+	for _, step := range steps {
+		resp := te.markRead(step.msg)
+		if resp.Updated != step.updated || resp.UnreadCount != step.unread {
+			t.Errorf("%s: updated %d with %d unread, want %d with %d", step.name, resp.Updated, resp.UnreadCount, step.updated, step.unread)
+		}
+		if listed := te.messages(&proto.GetMessagesMsg{UserId: bob}); listed.UnreadCount != step.unread {
+			t.Errorf("%s: listing counts %d unread, want %d", step.name, listed.UnreadCount, step.unread)
+		}
+	}
+	unread := te.messages(&proto.GetMessagesMsg{UserId: bob, OtherUserId: alice, UnreadOnly: true})
+	if !slices.Equal(messageIDs(unread.Messages), []string{fromAlice[1]}) {
+		t.Errorf("unread messages from alice %v, want %s", messageIDs(unread.Messages), fromAlice[1])
+	}
+
+	resp := te.request(&proto.MarkReadMsg{UserId: bob, MessageId: sent, Read: true}).(*proto.MarkReadResponse)
+	if resp.Error == nil || resp.Error.Code != ErrCodeInvalidArgument {
+		t.Errorf("marking a sent message: got %v, want %s", resp.Error, ErrCodeInvalidArgument)
+	}
+}
+
+func TestDeleteMessageKeepsTheOtherCopy(t *testing.T) {
+	te := startEngine(t, NewRedditEngine())
+	alice, bob := te.registerUser("alice"), te.registerUser("bob")
+	id := te.sendMessage(&proto.DirectMessageMsg{FromUserId: alice, ToUserId: bob, Content: "hi"})
+
+	for i := 0; i < 2; i++ {
+		// Deleting again is a no-op
+		resp := te.request(&proto.DeleteMessageMsg{MessageId: id, UserId: alice}).(*proto.DeleteMessageResponse)
+		if resp.Error != nil {
+			t.Fatalf("delete %d: %v", i+1, resp.Error)
+		}
+	}
+	if sent := te.messages(&proto.GetMessagesMsg{UserId: alice, Folder: folderAll}); len(sent.Messages) != 0 {
+		t.Errorf("sender's messages after deleting: %v, want none", sent.Messages)
+	}
+	inbox := te.messages(&proto.GetMessagesMsg{UserId: bob})
+	if len(inbox.Messages) != 1 || inbox.Messages[0].Id != id || inbox.Messages[0].Content != "hi" {
+		t.Errorf("recipient's inbox %v, want the message as sent", inbox.Messages)
+	}
+
+	// The sender's deleted copy can no longer be replied to; the recipient's can
+	resp := te.request(&proto.DirectMessageMsg{FromUserId: alice, ReplyToId: id, Content: "x"}).(*proto.DirectMessageResponse)
+	if resp.Error == nil || resp.Error.Code != ErrCodeNotFound {
+		t.Errorf("reply to a deleted copy: got %v, want %s", resp.Error, ErrCodeNotFound)
+	}
+	te.sendMessage(&proto.DirectMessageMsg{FromUserId: bob, ReplyToId: id, Content: "hi back"})
+}
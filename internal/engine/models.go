@@ -7,55 +7,151 @@ import (
 )
 
 type User struct {
-	ID       string
-	Username string
-	Karma    int
-	JoinDate time.Time
+	ID             string
+	Username       string
+	PasswordHash   string // bcrypt; empty if the user cannot log in
+	Karma          int    // PostKarma + CommentKarma
+	PostKarma      int
+	CommentKarma   int
+	SubredditKarma map[string]*KarmaBreakdown // subreddit ID -> karma earned there
+	JoinDate       time.Time
+	Subreddits     map[string]bool // IDs of the subreddits the user has joined
+	Blocked        map[string]bool // IDs of the users this user blocked
+	Messages       MessageSettings
+	KarmaCredited  []string // IDs of the latest karma changes sent by other grains, so one sent again counts once
+}
+
+// MessageSettings limits who can send a user direct messages. With neither
+// minimum set anyone can; otherwise senders must meet at least one.
+type MessageSettings struct {
+	MinAccountAgeDays int
+	MinKarma          int
+}
+
+type KarmaBreakdown struct {
+	PostKarma    int
+	CommentKarma int
 }
 
 type Subreddit struct {
 	ID          string
 	Name        string
 	Description string
-	Members     map[string]*User
-	Posts       []*Post
+	MemberCount int // members are tracked in User.Subreddits
 	CreatedAt   time.Time
+	CreatorID   string
+	Moderators  []string               // user IDs, most senior first; the creator is the first
+	Bans        map[string]Restriction // user ID -> ban from posting, commenting and voting
+	Mutes       map[string]Restriction // user ID -> mute from posting and commenting
+	Rules       []string               // cited by reports
+	Automod     string                 // AutoModerator's YAML configuration
 }
 
-type Post struct {
+// Restriction is a ban or mute a moderator put on a user.
+type Restriction struct {
+	ModeratorID string
+	Reason      string
+	Until       time.Time // zero if permanent
+}
+
+// ModAction is an entry in a subreddit's moderation log.
+type ModAction struct {
 	ID          string
-	Title       string
-	Content     string
-	AuthorID    string
 	SubredditID string
-	Upvotes     int
-	Downvotes   int
-	Comments    []*Comment
+	ModeratorID string
+	Action      string // one of the modAction constants
+	TargetID    string // the user, post or comment acted on
+	Reason      string
+	Until       time.Time // end of a ban or mute; zero if permanent or not one
 	CreatedAt   time.Time
 }
 
+type Post struct {
+	ID           string
+	Title        string
+	Content      string
+	AuthorID     string
+	SubredditID  string
+	Upvotes      int
+	Downvotes    int
+	Scores       Scores
+	CommentCount int // comments at every depth
+	CreatedAt    time.Time
+	EditedAt     time.Time  // zero if never edited
+	History      []Revision // previous versions, oldest first
+	Deleted      bool
+	Removed      bool // by a moderator
+	Review       Review
+	Flair        string
+	Locked       bool // no new comments
+}
+
 type Comment struct {
 	ID        string
 	Content   string
 	AuthorID  string
 	PostID    string
 	ParentID  string
-	Children  []*Comment
+	Depth     int // 0 for top-level comments
 	Upvotes   int
 	Downvotes int
+	Scores    Scores
 	CreatedAt time.Time
+	EditedAt  time.Time  // zero if never edited
+	History   []Revision // previous versions, oldest first
+	Deleted   bool
+	Removed   bool // by a moderator
+	Review    Review
+	Locked    bool // no new replies
 }
 
+// Review is what keeps a post or comment in its subreddit's moderation queue:
+// the reports against it, or the filter that held it back, until a moderator
+// resolves them.
+type Review struct {
+	Reports       map[string]string // reporter ID -> reason
+	FilterReason  string            // set while it is removed by a filter
+	QueuedAt      time.Time         // when it was last reported or filtered
+	IgnoreReports bool              // a moderator chose to ignore its reports
+}
+
+// Revision is a previous version of an edited post or comment
+type Revision struct {
+	Title      string // empty for comments
+	Content    string
+	ReplacedAt time.Time
+}
+
+// DirectMessage is one participant's copy of a message. The sender and the
+// recipient each keep their own, which they read and delete on their own.
 type DirectMessage struct {
 	ID         string
+	OwnerID    string // the participant whose copy this is
 	FromUserID string
 	ToUserID   string
 	Content    string
+	ReplyToID  string // the message this answers, if any
+	ThreadID   string // the first message of the reply chain
 	CreatedAt  time.Time
+	Read       bool
+	Deleted    bool // by its owner
+}
+
+// otherParty returns the participant who is not the owner of the copy.
+func (dm *DirectMessage) otherParty() string {
+	if dm.OwnerID == dm.FromUserID {
+		return dm.ToUserID
+	}
+	return dm.FromUserID
+}
+
+// received reports whether the copy is the recipient's.
+func (dm *DirectMessage) received() bool {
+	return dm.OwnerID == dm.ToUserID
 }
 
 type Metrics struct {
-	mu            sync.Mutex
+	mu            sync.Mutex // the grains of a cluster member share its metrics
 	TotalPosts    int64
 	TotalComments int64
 	TotalVotes    int64
@@ -0,0 +1,367 @@
+// internal/engine/moderation.go
+package engine
+
+import (
+	"log"
+	"time"
+
+	"github.com/asynkron/protoactor-go/actor"
+	"github.com/kakugri/redditClone/internal/idgen"
+	"github.com/kakugri/redditClone/internal/proto"
+)
+
+// The actions a subreddit's moderation log records.
+const (
+	modActionAddModerator    = "add_moderator"
+	modActionRemoveModerator = "remove_moderator"
+	modActionRemovePost      = "remove_post"
+	modActionRemoveComment   = "remove_comment"
+	modActionBan             = "ban_user"
+	modActionUnban           = "unban_user"
+	modActionMute            = "mute_user"
+	modActionUnmute          = "unmute_user"
+	modActionEditRules       = "edit_rules"
+	modActionApprovePost     = "approve_post"
+	modActionApproveComment  = "approve_comment"
+	modActionIgnoreReports   = "ignore_reports"
+	modActionEditAutomod     = "edit_automod"
+	modActionFilterPost      = "filter_post"
+	modActionFilterComment   = "filter_comment"
+	modActionSetFlair        = "set_flair"
+	modActionLockPost        = "lock_post"
+	modActionUnlockPost      = "unlock_post"
+	modActionLockComment     = "lock_comment"
+	modActionUnlockComment   = "unlock_comment"
+)
+
+const (
+	defaultModLogLimit = 100
+	maxModLogLimit     = 500
+)
+
+// moderatorRank returns the seniority of a moderator of the subreddit, 0 for
+// the most senior, or -1 if userID is not one.
+func (s *Subreddit) moderatorRank(userID string) int {
+	for i, id := range s.Moderators {
+		if id == userID {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *Subreddit) isModerator(userID string) bool {
+	return userID != "" && s.moderatorRank(userID) >= 0
+}
+
+// restrictions returns the subreddit's bans or mutes, creating the map if it
+// is nil and create is set.
+func (s *Subreddit) restrictions(kind proto.Restriction, create bool) map[string]Restriction {
+	restrictions := &s.Bans
+	if kind == proto.Restriction_RESTRICTION_MUTE {
+		restrictions = &s.Mutes
+	}
+	if *restrictions == nil && create {
+		*restrictions = make(map[string]Restriction)
+	}
+	return *restrictions
+}
+
+func (r Restriction) activeAt(t time.Time) bool {
+	return r.Until.IsZero() || t.Before(r.Until)
+}
+
+// describe says how long the restriction lasts, for error messages.
+func (r Restriction) describe() string {
+	if r.Until.IsZero() {
+		return "permanently"
+	}
+	return "until " + r.Until.UTC().Format(time.RFC3339)
+}
+
+// checkRestrictions returns an error if userID is banned from subreddit at the
+// time of the current command, or muted there and muted users may not do what
+// they are trying to. Moderators are never restricted.
+func (e *RedditEngine) checkRestrictions(subreddit *Subreddit, userID string, mutedToo bool) *proto.Error {
+	if subreddit.isModerator(userID) {
+		return nil
+	}
+	now := e.now()
+	if ban, banned := subreddit.Bans[userID]; banned && ban.activeAt(now) {
+		return newError(ErrCodePermissionDenied, "you are banned from r/%s %s", subreddit.Name, ban.describe())
+	}
+	if mute, muted := subreddit.Mutes[userID]; mutedToo && muted && mute.activeAt(now) {
+		return newError(ErrCodePermissionDenied, "you are muted in r/%s %s", subreddit.Name, mute.describe())
+	}
+	return nil
+}
+
+// moderatedSubreddit returns the subreddit id if userID moderates it.
+func (e *RedditEngine) moderatedSubreddit(id, userID string) (*Subreddit, *proto.Error) {
+	subreddit, err := e.store.Subreddit(id)
+	if err != nil {
+		return nil, storeError(err, "subreddit %q not found", id)
+	}
+	if !subreddit.isModerator(userID) {
+		return nil, newError(ErrCodePermissionDenied, "only moderators of r/%s can do this", subreddit.Name)
+	}
+	return subreddit, nil
+}
+
+// logModAction adds the current command to the moderation log of subredditID.
+func (e *RedditEngine) logModAction(subredditID, moderatorID, action, targetID, reason string, until time.Time) {
+	e.store.PutModAction(&ModAction{
+		ID:          e.extraID(idgen.KindModAction),
+		SubredditID: subredditID,
+		ModeratorID: moderatorID,
+		Action:      action,
+		TargetID:    targetID,
+		Reason:      reason,
+		Until:       until,
+		CreatedAt:   e.now(),
+	})
+}
+
+func (e *RedditEngine) handleAddModerator(context actor.Context, msg *proto.AddModeratorMsg) {
+	subreddit, err := e.moderatedSubreddit(msg.SubredditId, msg.UserId)
+	if err == nil {
+		err = e.check(validateModerator(msg.ModeratorId))
+	}
+	if err == nil && subreddit.isModerator(msg.ModeratorId) {
+		err = newError(ErrCodeAlreadyExists, "%s is already a moderator of r/%s", msg.ModeratorId, subreddit.Name)
+	}
+	if err != nil {
+		respond(context, &proto.ModeratorsResponse{SubredditId: msg.SubredditId, Error: err})
+		return
+	}
+
+	subreddit.Moderators = append(subreddit.Moderators, msg.ModeratorId)
+	e.store.PutSubreddit(subreddit)
+	e.logModAction(subreddit.ID, msg.UserId, modActionAddModerator, msg.ModeratorId, "", time.Time{})
+	if err := e.commit(msg); err != nil {
+		respond(context, &proto.ModeratorsResponse{SubredditId: subreddit.ID, Error: err})
+		return
+	}
+	log.Printf("Moderator added: SubredditID=%s, ModeratorID=%s, By=%s", subreddit.ID, msg.ModeratorId, msg.UserId)
+	respond(context, &proto.ModeratorsResponse{SubredditId: subreddit.ID, ModeratorIds: subreddit.Moderators})
+}
+
+func (e *RedditEngine) handleRemoveModerator(context actor.Context, msg *proto.RemoveModeratorMsg) {
+	subreddit, err := e.moderatedSubreddit(msg.SubredditId, msg.UserId)
+	if err != nil {
+		respond(context, &proto.ModeratorsResponse{SubredditId: msg.SubredditId, Error: err})
+		return
+	}
+	// Moderators answer to those who were there before them
+	rank := subreddit.moderatorRank(msg.ModeratorId)
+	if rank < 0 {
+		err = newError(ErrCodeNotFound, "%s is not a moderator of r/%s", msg.ModeratorId, subreddit.Name)
+	} else if msg.ModeratorId != msg.UserId && rank < subreddit.moderatorRank(msg.UserId) {
+		err = newError(ErrCodePermissionDenied, "%s became a moderator of r/%s before you", msg.ModeratorId, subreddit.Name)
+	}
+	if err != nil {
+		respond(context, &proto.ModeratorsResponse{SubredditId: subreddit.ID, Error: err})
+		return
+	}
+
+	subreddit.Moderators = append(subreddit.Moderators[:rank:rank], subreddit.Moderators[rank+1:]...)
+	e.store.PutSubreddit(subreddit)
+	e.logModAction(subreddit.ID, msg.UserId, modActionRemoveModerator, msg.ModeratorId, "", time.Time{})
+	if err := e.commit(msg); err != nil {
+		respond(context, &proto.ModeratorsResponse{SubredditId: subreddit.ID, Error: err})
+		return
+	}
+	log.Printf("Moderator removed: SubredditID=%s, ModeratorID=%s, By=%s", subreddit.ID, msg.ModeratorId, msg.UserId)
+	respond(context, &proto.ModeratorsResponse{SubredditId: subreddit.ID, ModeratorIds: subreddit.Moderators})
+}
+
+func (e *RedditEngine) handleRemovePost(context actor.Context, msg *proto.RemovePostMsg) {
+	post, err := e.store.Post(msg.PostId)
+	if err != nil {
+		respond(context, &proto.RemovePostResponse{
+			PostId: msg.PostId,
+			Error:  storeError(err, "post %q not found", msg.PostId),
+		})
+		return
+	}
+	_, modErr := e.moderatedSubreddit(post.SubredditID, msg.UserId)
+	if modErr == nil {
+		modErr = e.check(validateReason(msg.Reason))
+	}
+	if modErr == nil {
+		modErr = checkRemovable("post", post.ID, post.Deleted, post.Removed)
+	}
+	if modErr != nil {
+		respond(context, &proto.RemovePostResponse{PostId: post.ID, Error: modErr})
+		return
+	}
+
+	// The post keeps its content as a record of what was removed; listings
+	// skip it and views show it as removed
+	post.Removed = true
+	e.dequeue(post.SubredditID, post.ID, &post.Review)
+	e.store.PutPost(post)
+	e.logModAction(post.SubredditID, msg.UserId, modActionRemovePost, post.ID, msg.Reason, time.Time{})
+	if err := e.commit(msg); err != nil {
+		respond(context, &proto.RemovePostResponse{PostId: post.ID, Error: err})
+		return
+	}
+	log.Printf("Post removed: PostID=%s, By=%s", post.ID, msg.UserId)
+	respond(context, &proto.RemovePostResponse{PostId: post.ID})
+}
+
+func (e *RedditEngine) handleRemoveComment(context actor.Context, msg *proto.RemoveCommentMsg) {
+	comment, err := e.store.Comment(msg.CommentId)
+	if err != nil {
+		respond(context, &proto.RemoveCommentResponse{
+			CommentId: msg.CommentId,
+			Error:     storeError(err, "comment %q not found", msg.CommentId),
+		})
+		return
+	}
+	post, err := e.store.Post(comment.PostID)
+	if err != nil {
+		respond(context, &proto.RemoveCommentResponse{
+			CommentId: comment.ID,
+			Error:     storeError(err, "post %q not found", comment.PostID),
+		})
+		return
+	}
+	_, modErr := e.moderatedSubreddit(post.SubredditID, msg.UserId)
+	if modErr == nil {
+		modErr = e.check(validateReason(msg.Reason))
+	}
+	if modErr == nil {
+		modErr = checkRemovable("comment", comment.ID, comment.Deleted, comment.Removed)
+	}
+	if modErr != nil {
+		respond(context, &proto.RemoveCommentResponse{CommentId: comment.ID, Error: modErr})
+		return
+	}
+
+	// Like a deleted comment, it stays in the tree so its replies keep their
+	// parent
+	comment.Removed = true
+	e.dequeue(post.SubredditID, comment.ID, &comment.Review)
+	e.store.PutComment(comment)
+	e.logModAction(post.SubredditID, msg.UserId, modActionRemoveComment, comment.ID, msg.Reason, time.Time{})
+	if err := e.commit(msg); err != nil {
+		respond(context, &proto.RemoveCommentResponse{CommentId: comment.ID, Error: err})
+		return
+	}
+	log.Printf("Comment removed: CommentID=%s, By=%s", comment.ID, msg.UserId)
+	respond(context, &proto.RemoveCommentResponse{CommentId: comment.ID})
+}
+
+// checkRemovable allows the removal of content its author has not deleted and
+// no moderator has removed yet.
+func checkRemovable(kind, id string, deleted, removed bool) *proto.Error {
+	if deleted {
+		return newError(ErrCodeInvalidArgument, "%s %q has been deleted", kind, id)
+	}
+	if removed {
+		return newError(ErrCodeInvalidArgument, "%s %q has already been removed", kind, id)
+	}
+	return nil
+}
+
+func (e *RedditEngine) handleRestrictUser(context actor.Context, msg *proto.RestrictUserMsg) {
+	subreddit, err := e.moderatedSubreddit(msg.SubredditId, msg.UserId)
+	if err == nil {
+		err = e.check(validateRestrictUser(msg))
+	}
+	if err == nil && subreddit.isModerator(msg.TargetUserId) {
+		err = newError(ErrCodeInvalidArgument, "%s is a moderator of r/%s", msg.TargetUserId, subreddit.Name)
+	}
+	if err != nil {
+		respond(context, &proto.RestrictUserResponse{
+			SubredditId:  msg.SubredditId,
+			TargetUserId: msg.TargetUserId,
+			Error:        err,
+		})
+		return
+	}
+
+	restriction := Restriction{ModeratorID: msg.UserId, Reason: msg.Reason}
+	if msg.DurationSeconds > 0 {
+		restriction.Until = e.now().Add(time.Duration(msg.DurationSeconds) * time.Second)
+	}
+	subreddit.restrictions(msg.Restriction, true)[msg.TargetUserId] = restriction
+	e.store.PutSubreddit(subreddit)
+	action := modActionBan
+	if msg.Restriction == proto.Restriction_RESTRICTION_MUTE {
+		action = modActionMute
+	}
+	e.logModAction(subreddit.ID, msg.UserId, action, msg.TargetUserId, msg.Reason, restriction.Until)
+	if err := e.commit(msg); err != nil {
+		respond(context, &proto.RestrictUserResponse{SubredditId: subreddit.ID, TargetUserId: msg.TargetUserId, Error: err})
+		return
+	}
+	log.Printf("User restricted: SubredditID=%s, UserID=%s, Action=%s, By=%s", subreddit.ID, msg.TargetUserId, action, msg.UserId)
+	respond(context, &proto.RestrictUserResponse{
+		SubredditId:  subreddit.ID,
+		TargetUserId: msg.TargetUserId,
+		ExpiresAt:    unixOrZero(restriction.Until),
+	})
+}
+
+func (e *RedditEngine) handleLiftRestriction(context actor.Context, msg *proto.LiftRestrictionMsg) {
+	subreddit, err := e.moderatedSubreddit(msg.SubredditId, msg.UserId)
+	if err == nil {
+		err = e.check(validateRestriction(msg.Restriction))
+	}
+	action, state := modActionUnban, "banned from"
+	if msg.Restriction == proto.Restriction_RESTRICTION_MUTE {
+		action, state = modActionUnmute, "muted in"
+	}
+	if err == nil {
+		restriction, exists := subreddit.restrictions(msg.Restriction, false)[msg.TargetUserId]
+		if !exists || !restriction.activeAt(e.now()) {
+			err = newError(ErrCodeNotFound, "%s is not %s r/%s", msg.TargetUserId, state, subreddit.Name)
+		}
+	}
+	if err != nil {
+		respond(context, &proto.LiftRestrictionResponse{
+			SubredditId:  msg.SubredditId,
+			TargetUserId: msg.TargetUserId,
+			Error:        err,
+		})
+		return
+	}
+
+	delete(subreddit.restrictions(msg.Restriction, false), msg.TargetUserId)
+	e.store.PutSubreddit(subreddit)
+	e.logModAction(subreddit.ID, msg.UserId, action, msg.TargetUserId, "", time.Time{})
+	if err := e.commit(msg); err != nil {
+		respond(context, &proto.LiftRestrictionResponse{SubredditId: subreddit.ID, TargetUserId: msg.TargetUserId, Error: err})
+		return
+	}
+	log.Printf("User restriction lifted: SubredditID=%s, UserID=%s, Action=%s, By=%s", subreddit.ID, msg.TargetUserId, action, msg.UserId)
+	respond(context, &proto.LiftRestrictionResponse{SubredditId: subreddit.ID, TargetUserId: msg.TargetUserId})
+}
+
+func (e *RedditEngine) handleGetModLog(context actor.Context, msg *proto.GetModLogMsg) {
+	subreddit, modErr := e.moderatedSubreddit(msg.SubredditId, msg.UserId)
+	if modErr != nil {
+		respond(context, &proto.GetModLogResponse{Error: modErr})
+		return
+	}
+	actions, err := e.store.ModLog(subreddit.ID)
+	if err != nil {
+		respond(context, &proto.GetModLogResponse{Error: storeError(err, "")})
+		return
+	}
+
+	limit := int(msg.Limit)
+	if limit <= 0 {
+		limit = defaultModLogLimit
+	}
+	if limit > maxModLogLimit {
+		limit = maxModLogLimit
+	}
+	infos := make([]*proto.ModActionInfo, 0, min(limit, len(actions)))
+	for i := len(actions) - 1; i >= 0 && len(infos) < limit; i-- {
+		infos = append(infos, modActionInfo(actions[i]))
+	}
+	respond(context, &proto.GetModLogResponse{Actions: infos})
+}
@@ -0,0 +1,167 @@
+// internal/engine/privacy.go
+package engine
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/asynkron/protoactor-go/actor"
+	"github.com/kakugri/redditClone/internal/proto"
+)
+
+// blockedUserIDs lists the users a user blocked, in ID order.
+func blockedUserIDs(user *User) []string {
+	ids := make([]string, 0, len(user.Blocked))
+	for id := range user.Blocked {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// hiddenAuthors returns the authors whose posts and comments viewerID does
+// not see, having blocked them. In an engine cluster the viewer belongs to
+// another grain, which sent them along as hiddenIDs.
+func (e *RedditEngine) hiddenAuthors(viewerID string, hiddenIDs []string) map[string]bool {
+	if viewerID == "" {
+		return nil
+	}
+	if e.cluster == nil {
+		viewer, err := e.store.User(viewerID)
+		if err != nil {
+			return nil
+		}
+		return viewer.Blocked
+	}
+	return userSet(hiddenIDs)
+}
+
+func userSet(ids []string) map[string]bool {
+	set := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set
+}
+
+// accepts reports whether the settings let sender send a message at now. A
+// sender meets a minimum by reaching it: karma of exactly MinKarma will do.
+func (s MessageSettings) accepts(sender *proto.UserInfo, now time.Time) bool {
+	if s.MinAccountAgeDays == 0 && s.MinKarma == 0 {
+		return true
+	}
+	if sender == nil {
+		return false
+	}
+	age := now.Sub(time.Unix(sender.JoinedAt, 0))
+	if s.MinAccountAgeDays > 0 && age >= time.Duration(s.MinAccountAgeDays)*24*time.Hour {
+		return true
+	}
+	return s.MinKarma > 0 && int(sender.Karma) >= s.MinKarma
+}
+
+// String describes the senders the settings accept.
+func (s MessageSettings) String() string {
+	var conditions []string
+	switch {
+	case s.MinAccountAgeDays == 1:
+		conditions = append(conditions, "accounts at least a day old")
+	case s.MinAccountAgeDays > 1:
+		conditions = append(conditions, fmt.Sprintf("accounts at least %d days old", s.MinAccountAgeDays))
+	}
+	if s.MinKarma > 0 {
+		conditions = append(conditions, fmt.Sprintf("users with at least %d karma", s.MinKarma))
+	}
+	if len(conditions) == 0 {
+		return "anyone"
+	}
+	return strings.Join(conditions, " or ")
+}
+
+func messageSettingsInfo(s MessageSettings) *proto.MessageSettings {
+	return &proto.MessageSettings{
+		MinAccountAgeDays: int32(s.MinAccountAgeDays),
+		MinKarma:          int32(s.MinKarma),
+	}
+}
+
+func (e *RedditEngine) handleBlockUser(context actor.Context, msg *proto.BlockUserMsg) {
+	user, err := e.store.User(msg.UserId)
+	if err != nil {
+		respond(context, &proto.BlocksResponse{Error: storeError(err, "user %q not found", msg.UserId)})
+		return
+	}
+	blocked := user.Blocked[msg.BlockedUserId]
+	blockErr := validateBlockUser(msg)
+	if blockErr == nil && msg.Block && !blocked && len(user.Blocked) >= maxBlockedUsers {
+		blockErr = newError(ErrCodeInvalidArgument, "you can block at most %d users", maxBlockedUsers)
+	}
+	if err := e.check(blockErr); err != nil {
+		respond(context, &proto.BlocksResponse{Error: err})
+		return
+	}
+
+	// Blocking twice or unblocking twice is a no-op so clients can safely retry
+	if msg.Block != blocked {
+		if msg.Block {
+			if user.Blocked == nil {
+				user.Blocked = make(map[string]bool)
+			}
+			user.Blocked[msg.BlockedUserId] = true
+		} else {
+			delete(user.Blocked, msg.BlockedUserId)
+		}
+		e.store.PutUser(user)
+		if err := e.commit(msg); err != nil {
+			respond(context, &proto.BlocksResponse{Error: err})
+			return
+		}
+		log.Printf("User block changed: UserID=%s, BlockedUserID=%s, Blocked=%t", user.ID, msg.BlockedUserId, msg.Block)
+	}
+	respond(context, &proto.BlocksResponse{BlockedUserIds: blockedUserIDs(user)})
+}
+
+func (e *RedditEngine) handleGetBlocks(context actor.Context, msg *proto.GetBlocksMsg) {
+	user, err := e.store.User(msg.UserId)
+	if err != nil {
+		respond(context, &proto.BlocksResponse{Error: storeError(err, "user %q not found", msg.UserId)})
+		return
+	}
+	respond(context, &proto.BlocksResponse{BlockedUserIds: blockedUserIDs(user)})
+}
+
+func (e *RedditEngine) handleSetMessageSettings(context actor.Context, msg *proto.SetMessageSettingsMsg) {
+	user, err := e.store.User(msg.UserId)
+	if err != nil {
+		respond(context, &proto.MessageSettingsResponse{Error: storeError(err, "user %q not found", msg.UserId)})
+		return
+	}
+	if err := e.check(validateMessageSettings(msg.Settings)); err != nil {
+		respond(context, &proto.MessageSettingsResponse{Error: err})
+		return
+	}
+
+	user.Messages = MessageSettings{
+		MinAccountAgeDays: int(msg.Settings.GetMinAccountAgeDays()),
+		MinKarma:          int(msg.Settings.GetMinKarma()),
+	}
+	e.store.PutUser(user)
+	if err := e.commit(msg); err != nil {
+		respond(context, &proto.MessageSettingsResponse{Error: err})
+		return
+	}
+	log.Printf("Message settings set: UserID=%s, Accepting=%s", user.ID, user.Messages)
+	respond(context, &proto.MessageSettingsResponse{Settings: messageSettingsInfo(user.Messages)})
+}
+
+func (e *RedditEngine) handleGetMessageSettings(context actor.Context, msg *proto.GetMessageSettingsMsg) {
+	user, err := e.store.User(msg.UserId)
+	if err != nil {
+		respond(context, &proto.MessageSettingsResponse{Error: storeError(err, "user %q not found", msg.UserId)})
+		return
+	}
+	respond(context, &proto.MessageSettingsResponse{Settings: messageSettingsInfo(user.Messages)})
+}
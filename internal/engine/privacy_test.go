@@ -0,0 +1,127 @@
+// internal/engine/privacy_test.go
+package engine
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/kakugri/redditClone/internal/proto"
+)
+
+func (te *testEngine) block(userID, blockedID string, block bool) {
+	te.t.Helper()
+	resp := te.request(&proto.BlockUserMsg{UserId: userID, BlockedUserId: blockedID, Block: block}).(*proto.BlocksResponse)
+	if resp.Error != nil {
+		te.t.Fatalf("block %s for %s: %v", blockedID, userID, resp.Error)
+	}
+}
+
+func TestBlockStopsMessagesBothWays(t *testing.T) {
+	te := startEngine(t, NewRedditEngine())
+	alice, bob := te.registerUser("alice"), te.registerUser("bob")
+	te.block(alice, bob, true)
+
+	toBlocker := te.request(&proto.DirectMessageMsg{FromUserId: bob, ToUserId: alice, Content: "hi"}).(*proto.DirectMessageResponse)
+	if toBlocker.Error == nil || toBlocker.Error.Code != ErrCodePermissionDenied {
+		t.Errorf("message to the blocker: got %v, want %s", toBlocker.Error, ErrCodePermissionDenied)
+	}
+	toBlocked := te.request(&proto.DirectMessageMsg{FromUserId: alice, ToUserId: bob, Content: "hi"}).(*proto.DirectMessageResponse)
+	if toBlocked.Error == nil || toBlocked.Error.Code != ErrCodeInvalidArgument {
+		t.Errorf("message to the blocked user: got %v, want %s", toBlocked.Error, ErrCodeInvalidArgument)
+	}
+
+	te.block(alice, bob, false)
+	te.sendMessage(&proto.DirectMessageMsg{FromUserId: bob, ToUserId: alice, Content: "hi"})
+	te.sendMessage(&proto.DirectMessageMsg{FromUserId: alice, ToUserId: bob, Content: "hi"})
+}
+
+func TestBlockHidesAuthors(t *testing.T) {
+	te := startEngine(t, NewRedditEngine())
+	viewer, blocked, other := te.registerUser("viewer"), te.registerUser("blocked"), te.registerUser("other")
+	subredditID := te.createSubreddit("blocks", other)
+	te.request(&proto.JoinSubredditMsg{UserId: viewer, SubredditId: subredditID})
+	te.createPost(subredditID, blocked, "blocked post")
+	otherPost := te.createPost(subredditID, other, "other post")
+	lone := te.createComment(otherPost, blocked, "lone comment")
+	answered := te.createComment(otherPost, blocked, "answered comment")
+	reply := te.createComment(otherPost, other, "reply")
+	te.request(&proto.CreateCommentMsg{PostId: otherPost, ParentId: answered, AuthorId: other, Content: "reply"})
+	te.block(viewer, blocked, true)
+
+	feed := te.request(&proto.GetFeedMsg{UserId: viewer}).(*proto.GetFeedResponse)
+	if ids := postIDs(feed.Posts); !slices.Equal(ids, []string{otherPost}) {
+		t.Errorf("feed of the blocker %v, want only %s", ids, otherPost)
+	}
+	listing := te.request(&proto.GetSubredditPostsMsg{SubredditId: subredditID, ViewerId: viewer}).(*proto.GetSubredditPostsResponse)
+	if ids := postIDs(listing.Posts); !slices.Equal(ids, []string{otherPost}) {
+		t.Errorf("listing for the blocker %v, want only %s", ids, otherPost)
+	}
+	if listing := te.request(&proto.GetSubredditPostsMsg{SubredditId: subredditID}).(*proto.GetSubredditPostsResponse); len(listing.Posts) != 2 {
+		t.Errorf("anonymous listing has %d posts, want both", len(listing.Posts))
+	}
+
+	// A blocked comment that others answered stays, as a placeholder
+	tree := te.request(&proto.GetPostCommentsMsg{PostId: otherPost, ViewerId: viewer}).(*proto.GetPostCommentsResponse)
+	comments := make(map[string]*proto.CommentInfo)
+	for _, comment := range tree.Comments {
+		comments[comment.Id] = comment
+	}
+	if comments[lone] != nil {
+		t.Errorf("blocked comment without replies is in the blocker's tree")
+	}
+	if c := comments[answered]; c == nil || c.Content != blockedPlaceholder || c.AuthorId != blockedPlaceholder || len(c.Replies) != 1 {
+		t.Errorf("blocked comment with a reply: got %+v, want a placeholder with the reply", c)
+	}
+	if comments[reply] == nil {
+		t.Errorf("comment of another user is missing from the blocker's tree")
+	}
+}
+
+func TestMessageSettingsAccepts(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	day := 24 * time.Hour
+	sender := func(age time.Duration, karma int32) *proto.UserInfo {
+		return &proto.UserInfo{JoinedAt: now.Add(-age).Unix(), Karma: karma}
+	}
+	tests := []struct {
+		name     string
+		settings MessageSettings
+		sender   *proto.UserInfo
+		accepted bool
+	}{
+		{"anyone", MessageSettings{}, sender(0, 0), true},
+		{"old enough", MessageSettings{MinAccountAgeDays: 7}, sender(8*day, 0), true},
+		{"exactly old enough", MessageSettings{MinAccountAgeDays: 7}, sender(7*day, 0), true},
+		{"too new", MessageSettings{MinAccountAgeDays: 7}, sender(7*day-time.Second, 0), false},
+		{"enough karma", MessageSettings{MinKarma: 10}, sender(0, 11), true},
+		{"exactly enough karma", MessageSettings{MinKarma: 10}, sender(0, 10), true},
+		{"too little karma", MessageSettings{MinKarma: 10}, sender(0, 9), false},
+		{"old enough, too little karma", MessageSettings{MinAccountAgeDays: 7, MinKarma: 10}, sender(30*day, 0), true},
+		{"too new, enough karma", MessageSettings{MinAccountAgeDays: 7, MinKarma: 10}, sender(0, 50), true},
+		{"too new and too little karma", MessageSettings{MinAccountAgeDays: 7, MinKarma: 10}, sender(day, 9), false},
+		{"unknown sender", MessageSettings{MinKarma: 10}, nil, false},
+	}
+	for _, tt := range tests {
+		if got := tt.settings.accepts(tt.sender, now); got != tt.accepted {
+			t.Errorf("%s: accepted %v, want %v", tt.name, got, tt.accepted)
+		}
+	}
+}
+
+func TestMessageSettingsStopMessages(t *testing.T) {
+	e := NewRedditEngine()
+	te := startEngine(t, e)
+	picky, newbie, veteran := te.registerUser("picky"), te.registerUser("newbie"), te.registerUser("veteran")
+	setAuthor(t, e, veteran, 0, 10)
+	resp := te.request(&proto.SetMessageSettingsMsg{UserId: picky, Settings: &proto.MessageSettings{MinAccountAgeDays: 7, MinKarma: 10}}).(*proto.MessageSettingsResponse)
+	if resp.Error != nil {
+		t.Fatal(resp.Error)
+	}
+
+	refused := te.request(&proto.DirectMessageMsg{FromUserId: newbie, ToUserId: picky, Content: "hi"}).(*proto.DirectMessageResponse)
+	if refused.Error == nil || refused.Error.Code != ErrCodePermissionDenied {
+		t.Errorf("message from a new account without karma: got %v, want %s", refused.Error, ErrCodePermissionDenied)
+	}
+	te.sendMessage(&proto.DirectMessageMsg{FromUserId: veteran, ToUserId: picky, Content: "hi"})
+}
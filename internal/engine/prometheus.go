@@ -0,0 +1,144 @@
+// internal/engine/prometheus.go
+package engine
+
+import (
+	"sync"
+	"time"
+
+	"github.com/asynkron/protoactor-go/actor"
+	"github.com/prometheus/client_golang/prometheus"
+	gproto "google.golang.org/protobuf/proto"
+)
+
+// instruments export the metrics of an engine, or of a cluster member, to
+// Prometheus: the record counts and crash counts it keeps anyway, plus how
+// long its actors take to handle each type of message, how many messages wait
+// in their mailboxes and how many messages the actor system could not
+// deliver.
+type instruments struct {
+	handling    *prometheus.HistogramVec // by actor kind and message type
+	mailbox     *prometheus.GaugeVec     // by actor kind
+	deadLetters prometheus.Counter
+
+	watchOnce sync.Once
+}
+
+// newInstruments registers the instruments, and collectors that read metrics
+// and r when scraped, with reg. With a nil reg nothing is exported.
+func newInstruments(reg prometheus.Registerer, metrics *Metrics, r *recovery) *instruments {
+	in := &instruments{
+		handling: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "reddit",
+			Subsystem: "engine",
+			Name:      "message_duration_seconds",
+			Help:      "Time taken by engine actors to handle a message, by actor kind and message type.",
+			Buckets:   prometheus.ExponentialBuckets(0.00001, 4, 10),
+		}, []string{"actor", "type"}),
+		mailbox: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "reddit",
+			Subsystem: "engine",
+			Name:      "mailbox_messages",
+			Help:      "Messages waiting in, or being handled from, the mailboxes of engine actors, by actor kind.",
+		}, []string{"actor"}),
+		deadLetters: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "reddit",
+			Subsystem: "engine",
+			Name:      "dead_letters_total",
+			Help:      "Messages sent to actors that no longer exist.",
+		}),
+	}
+	if reg == nil {
+		return in
+	}
+
+	counter := func(name, help string, value func() float64) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: "reddit", Subsystem: "engine", Name: name, Help: help,
+		}, value)
+	}
+	gauge := func(name, help string, value func() float64) prometheus.Collector {
+		return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: "reddit", Subsystem: "engine", Name: name, Help: help,
+		}, value)
+	}
+	reg.MustRegister(in.handling, in.mailbox, in.deadLetters,
+		counter("users_total", "Registered users.",
+			metrics.read(func(m *Metrics) int64 { return m.ActiveUsers })),
+		counter("posts_total", "Posts created, deleted ones included.",
+			metrics.read(func(m *Metrics) int64 { return m.TotalPosts })),
+		counter("comments_total", "Comments created, deleted ones included.",
+			metrics.read(func(m *Metrics) int64 { return m.TotalComments })),
+		counter("messages_total", "Direct messages sent, deleted ones included.",
+			metrics.read(func(m *Metrics) int64 { return m.TotalMessages })),
+		// Votes are withdrawn, so their number goes down as well as up
+		gauge("votes", "Votes currently cast on posts and comments.",
+			metrics.read(func(m *Metrics) int64 { return m.TotalVotes })),
+		counter("actor_restarts_total", "Engine actors restarted after a crash.",
+			func() float64 { return float64(r.restarts.Load()) }),
+		gauge("quarantined_messages", "Messages refused because they crashed an engine actor.",
+			func() float64 { return float64(r.quarantinedCount()) }),
+	)
+	return in
+}
+
+// read returns a function that reads one of the metrics.
+func (m *Metrics) read(field func(*Metrics) int64) func() float64 {
+	return func() float64 {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return float64(field(m))
+	}
+}
+
+// props returns the options that instrument the actors of one kind.
+func (in *instruments) props(kind string) []actor.PropsOption {
+	return []actor.PropsOption{
+		actor.WithReceiverMiddleware(in.receive(kind)),
+		actor.WithMailbox(actor.Unbounded(mailboxLength{in.mailbox.WithLabelValues(kind)})),
+	}
+}
+
+// receive times the handling of the protobuf messages an actor receives.
+func (in *instruments) receive(kind string) actor.ReceiverMiddleware {
+	return func(next actor.ReceiverFunc) actor.ReceiverFunc {
+		return func(c actor.ReceiverContext, envelope *actor.MessageEnvelope) {
+			msg, ok := envelope.Message.(gproto.Message)
+			if !ok {
+				if _, started := envelope.Message.(*actor.Started); started {
+					in.watchDeadLetters(c.ActorSystem())
+				}
+				next(c, envelope)
+				return
+			}
+
+			start := time.Now()
+			defer func() {
+				msgType := string(msg.ProtoReflect().Descriptor().Name())
+				in.handling.WithLabelValues(kind, msgType).Observe(time.Since(start).Seconds())
+			}()
+			next(c, envelope)
+		}
+	}
+}
+
+// watchDeadLetters counts the dead letters of system from now on.
+func (in *instruments) watchDeadLetters(system *actor.ActorSystem) {
+	in.watchOnce.Do(func() {
+		system.EventStream.Subscribe(func(event interface{}) {
+			if _, ok := event.(*actor.DeadLetterEvent); ok {
+				in.deadLetters.Inc()
+			}
+		})
+	})
+}
+
+// mailboxLength counts the messages in the mailboxes it is installed in. A
+// message is counted until the actor has handled it.
+type mailboxLength struct {
+	gauge prometheus.Gauge
+}
+
+func (l mailboxLength) MailboxStarted()                     {}
+func (l mailboxLength) MessagePosted(message interface{})   { l.gauge.Inc() }
+func (l mailboxLength) MessageReceived(message interface{}) { l.gauge.Dec() }
+func (l mailboxLength) MailboxEmpty()                       {}
@@ -0,0 +1,294 @@
+// internal/engine/ranking.go
+package engine
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/emirpasic/gods/trees/redblacktree"
+)
+
+// SortOrder selects how posts or comments are ranked in a listing.
+type SortOrder string
+
+const (
+	SortHot           SortOrder = "hot"
+	SortNew           SortOrder = "new"
+	SortTop           SortOrder = "top"
+	SortRising        SortOrder = "rising"
+	SortControversial SortOrder = "controversial"
+	SortBest          SortOrder = "best"
+)
+
+// TimeWindow limits a top listing to recently created content.
+type TimeWindow string
+
+const (
+	WindowHour TimeWindow = "hour"
+	WindowDay  TimeWindow = "day"
+	WindowWeek TimeWindow = "week"
+	WindowAll  TimeWindow = "all"
+)
+
+// risingWindow is how far back the rising listing looks for new posts.
+const risingWindow = 24 * time.Hour
+
+// hotEpoch is the reference point of the hot score. Only score differences
+// matter, so any fixed instant works.
+var hotEpoch = time.Date(2005, time.December, 8, 7, 46, 43, 0, time.UTC)
+
+func parsePostSort(sort string) (SortOrder, error) {
+	switch SortOrder(sort) {
+	case "":
+		return SortHot, nil
+	case SortHot, SortNew, SortTop, SortRising, SortControversial:
+		return SortOrder(sort), nil
+	}
+	return "", fmt.Errorf("unsupported post sort %q", sort)
+}
+
+func parseCommentSort(sort string) (SortOrder, error) {
+	switch SortOrder(sort) {
+	case "":
+		return SortBest, nil
+	case SortBest, SortTop, SortNew, SortControversial:
+		return SortOrder(sort), nil
+	}
+	return "", fmt.Errorf("unsupported comment sort %q", sort)
+}
+
+func parseTimeWindow(window string) (TimeWindow, error) {
+	switch TimeWindow(window) {
+	case "":
+		return WindowAll, nil
+	case WindowHour, WindowDay, WindowWeek, WindowAll:
+		return TimeWindow(window), nil
+	}
+	return "", fmt.Errorf("unsupported time window %q", window)
+}
+
+func (w TimeWindow) duration() time.Duration {
+	switch w {
+	case WindowHour:
+		return time.Hour
+	case WindowDay:
+		return 24 * time.Hour
+	case WindowWeek:
+		return 7 * 24 * time.Hour
+	}
+	return 0
+}
+
+// Scores caches the vote-derived ranking scores of a post or comment. They are
+// refreshed whenever a vote lands so listings never have to recompute them.
+type Scores struct {
+	Hot         float64
+	Controversy float64
+	Best        float64
+}
+
+func computeScores(upvotes, downvotes int, createdAt time.Time) Scores {
+	return Scores{
+		Hot:         hotScore(upvotes, downvotes, createdAt),
+		Controversy: controversyScore(upvotes, downvotes),
+		Best:        wilsonScore(upvotes, downvotes),
+	}
+}
+
+// hotScore combines the order of magnitude of the net score with the age of
+// the content, so that newer content needs fewer votes to rank as high.
+// Every 12.5 hours of age is worth a tenfold increase in net votes.
+func hotScore(upvotes, downvotes int, createdAt time.Time) float64 {
+	net := upvotes - downvotes
+	order := math.Log10(math.Max(math.Abs(float64(net)), 1))
+	sign := 0.0
+	if net > 0 {
+		sign = 1
+	} else if net < 0 {
+		sign = -1
+	}
+	seconds := createdAt.Sub(hotEpoch).Seconds()
+	return sign*order + seconds/45000
+}
+
+// controversyScore favours content with many votes split evenly between up
+// and down.
+func controversyScore(upvotes, downvotes int) float64 {
+	if upvotes <= 0 || downvotes <= 0 {
+		return 0
+	}
+	magnitude := float64(upvotes + downvotes)
+	balance := float64(downvotes) / float64(upvotes)
+	if upvotes < downvotes {
+		balance = float64(upvotes) / float64(downvotes)
+	}
+	return math.Pow(magnitude, balance)
+}
+
+// wilsonScore is the lower bound of the Wilson score confidence interval for
+// the fraction of upvotes, at 80% confidence.
+func wilsonScore(upvotes, downvotes int) float64 {
+	n := float64(upvotes + downvotes)
+	if n == 0 {
+		return 0
+	}
+	const z = 1.281551565545
+	p := float64(upvotes) / n
+	left := p + z*z/(2*n)
+	right := z * math.Sqrt(p*(1-p)/n+z*z/(4*n*n))
+	return (left - right) / (1 + z*z/n)
+}
+
+// risingScore is the net vote rate per hour of age. It depends on the current
+// time, so it is computed at query time over the small set of recent posts.
+func risingScore(upvotes, downvotes int, createdAt, now time.Time) float64 {
+	hours := math.Max(now.Sub(createdAt).Hours(), 1.0/60)
+	return float64(upvotes-downvotes) / hours
+}
+
+// rankKey orders entries in a listing: higher score first, then newer, with
+// the ID as a final tie breaker so every entry has a unique position.
+type rankKey struct {
+	score     float64
+	createdAt int64 // UnixNano
+	id        string
+}
+
+// compareRankKeys is a gods comparator that sorts the best ranked entry first.
+func compareRankKeys(a, b interface{}) int {
+	x, y := a.(rankKey), b.(rankKey)
+	switch {
+	case x.score > y.score:
+		return -1
+	case x.score < y.score:
+		return 1
+	case x.createdAt > y.createdAt:
+		return -1
+	case x.createdAt < y.createdAt:
+		return 1
+	case x.id > y.id:
+		return -1
+	case x.id < y.id:
+		return 1
+	}
+	return 0
+}
+
+func postRankKey(post *Post, sort SortOrder, now time.Time) rankKey {
+	key := rankKey{createdAt: post.CreatedAt.UnixNano(), id: post.ID}
+	switch sort {
+	case SortHot:
+		key.score = post.Scores.Hot
+	case SortTop:
+		key.score = float64(post.Upvotes - post.Downvotes)
+	case SortControversial:
+		key.score = post.Scores.Controversy
+	case SortBest:
+		key.score = post.Scores.Best
+	case SortRising:
+		key.score = risingScore(post.Upvotes, post.Downvotes, post.CreatedAt, now)
+	}
+	return key
+}
+
+func commentRankKey(comment *Comment, sort SortOrder) rankKey {
+	key := rankKey{createdAt: comment.CreatedAt.UnixNano(), id: comment.ID}
+	switch sort {
+	case SortTop:
+		key.score = float64(comment.Upvotes - comment.Downvotes)
+	case SortControversial:
+		key.score = comment.Scores.Controversy
+	case SortBest:
+		key.score = comment.Scores.Best
+	}
+	return key
+}
+
+// postRanking keeps a subreddit's posts ordered by each time-independent
+// score, so a listing only walks the entries it returns.
+type postRanking struct {
+	trees  map[SortOrder]*redblacktree.Tree
+	newest []rankKey // new keys of every post, oldest first
+}
+
+// indexedSorts are the orders maintained incrementally. New listings use the
+// creation-ordered Subreddit.Posts, while windowed top and rising listings
+// only consider recent posts and rank them per request.
+var indexedSorts = []SortOrder{SortHot, SortTop, SortControversial}
+
+func newPostRanking() *postRanking {
+	r := &postRanking{trees: make(map[SortOrder]*redblacktree.Tree, len(indexedSorts))}
+	for _, sort := range indexedSorts {
+		r.trees[sort] = redblacktree.NewWith(compareRankKeys)
+	}
+	return r
+}
+
+// add indexes a new post. Posts are added in creation order.
+func (r *postRanking) add(post *Post) {
+	r.insert(post)
+	r.newest = append(r.newest, postRankKey(post, SortNew, time.Time{}))
+}
+
+func (r *postRanking) insert(post *Post) {
+	for sort, tree := range r.trees {
+		tree.Put(postRankKey(post, sort, time.Time{}), nil)
+	}
+}
+
+func (r *postRanking) remove(post *Post) {
+	for sort, tree := range r.trees {
+		tree.Remove(postRankKey(post, sort, time.Time{}))
+	}
+}
+
+// sortNewest puts the new keys of posts added out of creation order, as the
+// store returns them, back in order.
+func (r *postRanking) sortNewest() {
+	keys := r.newest
+	sort.Slice(keys, func(i, j int) bool {
+		return compareRankKeys(keys[i], keys[j]) > 0
+	})
+}
+
+// ranking returns a subreddit's rankings, creating them for its first post.
+func (e *RedditEngine) ranking(subredditID string) *postRanking {
+	ranking := e.rankings[subredditID]
+	if ranking == nil {
+		ranking = newPostRanking()
+		e.rankings[subredditID] = ranking
+	}
+	return ranking
+}
+
+// loadRankings rebuilds every subreddit's rankings from the posts in the store.
+func (e *RedditEngine) loadRankings() error {
+	err := e.store.ForEachPost(func(post *Post) error {
+		e.ranking(post.SubredditID).add(post)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, ranking := range e.rankings {
+		ranking.sortNewest()
+	}
+	return nil
+}
+
+// rescorePost applies a vote change to a post and moves it to its new
+// position in the subreddit's rankings.
+func (e *RedditEngine) rescorePost(post *Post, apply func()) {
+	ranking := e.ranking(post.SubredditID)
+	ranking.remove(post)
+	apply()
+	post.Scores = computeScores(post.Upvotes, post.Downvotes, post.CreatedAt)
+	ranking.insert(post)
+}
+
+func rescoreComment(comment *Comment, apply func()) {
+	apply()
+	comment.Scores = computeScores(comment.Upvotes, comment.Downvotes, comment.CreatedAt)
+}
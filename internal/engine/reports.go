@@ -0,0 +1,301 @@
+// internal/engine/reports.go
+package engine
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"github.com/asynkron/protoactor-go/actor"
+	"github.com/kakugri/redditClone/internal/idgen"
+	"github.com/kakugri/redditClone/internal/proto"
+)
+
+const (
+	defaultModQueueLimit = 100
+	maxModQueueLimit     = 500
+)
+
+// queued reports whether the post or comment waits in the moderation queue.
+func (r *Review) queued() bool {
+	return len(r.Reports) > 0 || r.FilterReason != ""
+}
+
+// reviewTarget is a post or comment as moderation sees it.
+type reviewTarget struct {
+	kind        string // "post" or "comment"
+	id          string
+	subredditID string
+	post        *Post    // the target, or the post of the comment
+	comment     *Comment // nil if the target is the post
+}
+
+// reviewTarget looks up the post or comment with id, and the subreddit it is in.
+func (e *RedditEngine) reviewTarget(id string) (*reviewTarget, *proto.Error) {
+	switch idgen.KindOf(id) {
+	case idgen.KindPost:
+		post, err := e.store.Post(id)
+		if err != nil {
+			return nil, storeError(err, "post %q not found", id)
+		}
+		return &reviewTarget{kind: "post", id: id, subredditID: post.SubredditID, post: post}, nil
+	case idgen.KindComment:
+		comment, err := e.store.Comment(id)
+		if err != nil {
+			return nil, storeError(err, "comment %q not found", id)
+		}
+		post, err := e.store.Post(comment.PostID)
+		if err != nil {
+			return nil, storeError(err, "post %q not found", comment.PostID)
+		}
+		return &reviewTarget{kind: "comment", id: id, subredditID: post.SubredditID, post: post, comment: comment}, nil
+	}
+	return nil, newError(ErrCodeNotFound, "post or comment %q not found", id)
+}
+
+func (t *reviewTarget) review() *Review {
+	if t.comment != nil {
+		return &t.comment.Review
+	}
+	return &t.post.Review
+}
+
+func (t *reviewTarget) gone() (deleted, removed bool) {
+	if t.comment != nil {
+		return t.comment.Deleted, t.comment.Removed
+	}
+	return t.post.Deleted, t.post.Removed
+}
+
+func (t *reviewTarget) setRemoved(removed bool) {
+	if t.comment != nil {
+		t.comment.Removed = removed
+	} else {
+		t.post.Removed = removed
+	}
+}
+
+func (t *reviewTarget) put(store Store) {
+	if t.comment != nil {
+		store.PutComment(t.comment)
+	} else {
+		store.PutPost(t.post)
+	}
+}
+
+// queueItemInfo describes the target as an entry of the moderation queue.
+// Moderators see the content of a removed post or comment, to judge it.
+func (t *reviewTarget) queueItemInfo() *proto.QueueItemInfo {
+	review := t.review()
+	info := &proto.QueueItemInfo{
+		ReportCount:  int32(len(review.Reports)),
+		Reasons:      reportReasons(review.Reports),
+		FilterReason: review.FilterReason,
+		QueuedAt:     review.QueuedAt.Unix(),
+	}
+	if t.comment != nil {
+		info.Comment = commentInfo(t.comment)
+		info.Comment.Content = t.comment.Content
+	} else {
+		info.Post = postInfo(t.post)
+		info.Post.Content = t.post.Content
+	}
+	return info
+}
+
+// reportReasons counts the reports that give each reason, most given first.
+func reportReasons(reports map[string]string) []*proto.ReportReason {
+	counts := make(map[string]int32)
+	for _, reason := range reports {
+		counts[reason]++
+	}
+	reasons := make([]*proto.ReportReason, 0, len(counts))
+	for reason, count := range counts {
+		reasons = append(reasons, &proto.ReportReason{Reason: reason, Count: count})
+	}
+	sort.Slice(reasons, func(i, j int) bool {
+		if reasons[i].Count != reasons[j].Count {
+			return reasons[i].Count > reasons[j].Count
+		}
+		return reasons[i].Reason < reasons[j].Reason
+	})
+	return reasons
+}
+
+// dequeue takes a post or comment of subredditID out of the moderation queue,
+// dropping its reports. Whether reports are ignored stays as it was.
+func (e *RedditEngine) dequeue(subredditID, id string, review *Review) {
+	if !review.queued() {
+		return
+	}
+	*review = Review{IgnoreReports: review.IgnoreReports}
+	e.store.PutQueued(subredditID, id, false)
+}
+
+func (e *RedditEngine) handleSetRules(context actor.Context, msg *proto.SetRulesMsg) {
+	subreddit, err := e.moderatedSubreddit(msg.SubredditId, msg.UserId)
+	if err == nil {
+		err = e.check(validateSetRules(msg.Rules))
+	}
+	if err != nil {
+		respond(context, &proto.SetRulesResponse{SubredditId: msg.SubredditId, Error: err})
+		return
+	}
+
+	subreddit.Rules = append([]string(nil), msg.Rules...)
+	e.store.PutSubreddit(subreddit)
+	e.logModAction(subreddit.ID, msg.UserId, modActionEditRules, subreddit.ID, "", time.Time{})
+	if err := e.commit(msg); err != nil {
+		respond(context, &proto.SetRulesResponse{SubredditId: subreddit.ID, Error: err})
+		return
+	}
+	log.Printf("Subreddit rules set: SubredditID=%s, Rules=%d, By=%s", subreddit.ID, len(subreddit.Rules), msg.UserId)
+	respond(context, &proto.SetRulesResponse{SubredditId: subreddit.ID, Rules: subreddit.Rules})
+}
+
+func (e *RedditEngine) handleReport(context actor.Context, msg *proto.ReportMsg) {
+	target, err := e.reviewTarget(msg.TargetId)
+	if err != nil {
+		respond(context, &proto.ReportResponse{TargetId: msg.TargetId, Error: err})
+		return
+	}
+	subreddit, storeErr := e.store.Subreddit(target.subredditID)
+	if storeErr != nil {
+		respond(context, &proto.ReportResponse{
+			TargetId: target.id,
+			Error:    storeError(storeErr, "subreddit %q not found", target.subredditID),
+		})
+		return
+	}
+	if deleted, removed := target.gone(); deleted || removed {
+		err = newError(ErrCodeInvalidArgument, "%s %q has been %s", target.kind, target.id, goneAs(deleted))
+	} else {
+		err = e.check(validateReport(msg, subreddit.Rules))
+	}
+	if err != nil {
+		respond(context, &proto.ReportResponse{TargetId: target.id, Error: err})
+		return
+	}
+
+	// Reports of a target whose reports are ignored are taken but dropped
+	review := target.review()
+	if review.IgnoreReports {
+		respond(context, &proto.ReportResponse{TargetId: target.id})
+		return
+	}
+	reason := msg.Reason
+	if msg.Rule > 0 && int(msg.Rule) <= len(subreddit.Rules) {
+		reason = subreddit.Rules[msg.Rule-1]
+	}
+	if review.Reports == nil {
+		review.Reports = make(map[string]string)
+	}
+	trigger := automodTrigger{reported: true, before: len(review.Reports)}
+	review.Reports[msg.UserId] = reason
+	trigger.after = len(review.Reports)
+	review.QueuedAt = e.now()
+	e.store.PutQueued(target.subredditID, target.id, true)
+	replies := e.runAutomod(subreddit, target, trigger, nil)
+	target.put(e.store)
+	if len(replies) > 0 && target.comment != nil {
+		// The replies are counted on the post
+		e.store.PutPost(target.post)
+	}
+	e.storeAutomodReplies(replies)
+	if err := e.commit(msg); err != nil {
+		respond(context, &proto.ReportResponse{TargetId: target.id, Error: err})
+		return
+	}
+	e.automodRepliesCommitted(subreddit.ID, replies)
+	log.Printf("Reported: TargetID=%s, Reports=%d", target.id, len(review.Reports))
+	respond(context, &proto.ReportResponse{TargetId: target.id})
+}
+
+func (e *RedditEngine) handleGetModQueue(context actor.Context, msg *proto.GetModQueueMsg) {
+	subreddit, modErr := e.moderatedSubreddit(msg.SubredditId, msg.UserId)
+	if modErr != nil {
+		respond(context, &proto.GetModQueueResponse{Error: modErr})
+		return
+	}
+	ids, err := e.store.ModQueue(subreddit.ID)
+	if err != nil {
+		respond(context, &proto.GetModQueueResponse{Error: storeError(err, "")})
+		return
+	}
+
+	targets := make([]*reviewTarget, 0, len(ids))
+	for _, id := range ids {
+		target, err := e.reviewTarget(id)
+		if err != nil {
+			respond(context, &proto.GetModQueueResponse{Error: err})
+			return
+		}
+		targets = append(targets, target)
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		a, b := targets[i].review().QueuedAt, targets[j].review().QueuedAt
+		if !a.Equal(b) {
+			return a.After(b)
+		}
+		return targets[i].id > targets[j].id
+	})
+
+	limit := int(msg.Limit)
+	if limit <= 0 {
+		limit = defaultModQueueLimit
+	}
+	if limit > maxModQueueLimit {
+		limit = maxModQueueLimit
+	}
+	items := make([]*proto.QueueItemInfo, 0, min(limit, len(targets)))
+	for _, target := range targets[:min(limit, len(targets))] {
+		items = append(items, target.queueItemInfo())
+	}
+	respond(context, &proto.GetModQueueResponse{Items: items})
+}
+
+func (e *RedditEngine) handleResolveReports(context actor.Context, msg *proto.ResolveReportsMsg) {
+	target, err := e.reviewTarget(msg.TargetId)
+	if err == nil {
+		_, err = e.moderatedSubreddit(target.subredditID, msg.UserId)
+	}
+	if err == nil {
+		err = e.check(validateResolveReports(msg))
+	}
+	if err == nil && !target.review().queued() {
+		err = newError(ErrCodeNotFound, "%s %q is not in the moderation queue", target.kind, target.id)
+	}
+	if err != nil {
+		respond(context, &proto.ResolveReportsResponse{TargetId: msg.TargetId, Error: err})
+		return
+	}
+
+	action, reason := "", ""
+	switch msg.Resolution {
+	case proto.QueueResolution_QUEUE_RESOLUTION_APPROVE:
+		// Only filtered content is removed while it waits in the queue
+		target.setRemoved(false)
+		action = modActionApprovePost
+		if target.comment != nil {
+			action = modActionApproveComment
+		}
+	case proto.QueueResolution_QUEUE_RESOLUTION_REMOVE:
+		target.setRemoved(true)
+		action, reason = modActionRemovePost, msg.Reason
+		if target.comment != nil {
+			action = modActionRemoveComment
+		}
+	default:
+		target.review().IgnoreReports = true
+		action = modActionIgnoreReports
+	}
+	e.dequeue(target.subredditID, target.id, target.review())
+	target.put(e.store)
+	e.logModAction(target.subredditID, msg.UserId, action, target.id, reason, time.Time{})
+	if err := e.commit(msg); err != nil {
+		respond(context, &proto.ResolveReportsResponse{TargetId: target.id, Error: err})
+		return
+	}
+	log.Printf("Reports resolved: TargetID=%s, Action=%s, By=%s", target.id, action, msg.UserId)
+	respond(context, &proto.ResolveReportsResponse{TargetId: target.id})
+}
@@ -0,0 +1,195 @@
+// internal/engine/reports_test.go
+package engine
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/kakugri/redditClone/internal/proto"
+)
+
+func (te *testEngine) report(targetID, userID string, rule int32, reason string) *proto.Error {
+	te.t.Helper()
+	return te.request(&proto.ReportMsg{TargetId: targetID, UserId: userID, Rule: rule, Reason: reason}).(*proto.ReportResponse).Error
+}
+
+func (te *testEngine) resolve(targetID, moderatorID string, resolution proto.QueueResolution, reason string) *proto.Error {
+	te.t.Helper()
+	return te.request(&proto.ResolveReportsMsg{TargetId: targetID, UserId: moderatorID, Resolution: resolution, Reason: reason}).(*proto.ResolveReportsResponse).Error
+}
+
+func TestReportsAreCountedOncePerUser(t *testing.T) {
+	te := startEngine(t, NewRedditEngine())
+	mod := te.registerUser("mod")
+	author := te.registerUser("author")
+	alice, bob, carol := te.registerUser("alice"), te.registerUser("bob"), te.registerUser("carol")
+	subredditID := te.createSubreddit("reports", mod)
+	te.request(&proto.SetRulesMsg{SubredditId: subredditID, UserId: mod, Rules: []string{"No spam", "Be civil"}})
+	postID := te.createPost(subredditID, author, "title")
+
+	// A later report by the same user replaces their first
+	for _, r := range []struct {
+		user   string
+		rule   int32
+		reason string
+	}{
+		{alice, 0, "off topic"},
+		{alice, 1, ""},
+		{bob, 1, ""},
+		{carol, 0, "off topic"},
+	} {
+		if err := te.report(postID, r.user, r.rule, r.reason); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	queue := te.modQueue(subredditID, mod)
+	if len(queue) != 1 || queue[0].ReportCount != 3 {
+		t.Fatalf("queue %v, want the post with 3 reports", queue)
+	}
+	reasons := queue[0].Reasons
+	if len(reasons) != 2 || reasons[0].Reason != "No spam" || reasons[0].Count != 2 || reasons[1].Reason != "off topic" || reasons[1].Count != 1 {
+		t.Errorf("reasons %v, want the cited rule twice, then off topic once", reasons)
+	}
+}
+
+func TestReportValidation(t *testing.T) {
+	te := startEngine(t, NewRedditEngine())
+	mod := te.registerUser("mod")
+	author := te.registerUser("author")
+	reporter := te.registerUser("reporter")
+	ruleless := te.createSubreddit("ruleless", mod)
+	ruled := te.createSubreddit("ruled", mod)
+	te.request(&proto.SetRulesMsg{SubredditId: ruled, UserId: mod, Rules: []string{"No spam"}})
+	ruledPost := te.createPost(ruled, author, "ruled")
+	rulelessPost := te.createPost(ruleless, author, "ruleless")
+	deleted := te.createPost(ruled, author, "deleted")
+	te.request(&proto.DeletePostMsg{PostId: deleted, UserId: author})
+
+	tests := []struct {
+		name, target string
+		rule         int32
+		reason       string
+		code, field  string
+	}{
+		{"rule in a subreddit without rules", rulelessPost, 1, "", ErrCodeInvalidArgument, "rule"},
+		{"no reason in a subreddit without rules", rulelessPost, 0, "", ErrCodeInvalidArgument, "reason"},
+		{"rule and reason", ruledPost, 1, "spam", ErrCodeInvalidArgument, "reason"},
+		{"rule out of range", ruledPost, 2, "", ErrCodeInvalidArgument, "rule"},
+		{"negative rule", ruledPost, -1, "", ErrCodeInvalidArgument, "rule"},
+		{"deleted post", deleted, 1, "", ErrCodeInvalidArgument, ""},
+		{"missing post", "t3_missing", 1, "", ErrCodeNotFound, ""},
+	}
+	for _, tt := range tests {
+		err := te.report(tt.target, reporter, tt.rule, tt.reason)
+		if err == nil || err.Code != tt.code {
+			t.Errorf("%s: got %v, want %s", tt.name, err, tt.code)
+			continue
+		}
+		if tt.field != "" && (len(err.Fields) != 1 || err.Fields[0].Field != tt.field) {
+			t.Errorf("%s: got violations %v, want one of %s", tt.name, err.Fields, tt.field)
+		}
+	}
+
+	if err := te.report(rulelessPost, reporter, 0, "spam"); err != nil {
+		t.Errorf("reason in a subreddit without rules: got %v, want no error", err)
+	}
+	if err := te.report(ruledPost, reporter, 1, ""); err != nil {
+		t.Errorf("rule of the subreddit: got %v, want no error", err)
+	}
+}
+
+func TestModQueue(t *testing.T) {
+	te := startEngine(t, NewRedditEngine())
+	mod := te.registerUser("mod")
+	author := te.registerUser("author")
+	reporter := te.registerUser("reporter")
+	subredditID := te.createSubreddit("queue", mod)
+	te.setAutomod(subredditID, mod, "body_regex: buy now\naction: filter")
+
+	reported := te.createPost(subredditID, author, "reported")
+	te.report(reported, reporter, 0, "spam")
+	filtered := te.createComment(reported, author, "buy now")
+	clean := te.createComment(reported, author, "nice post")
+
+	queue := te.modQueue(subredditID, mod)
+	if len(queue) != 2 || queue[0].Comment == nil || queue[0].Comment.Id != filtered || queue[1].Post == nil || queue[1].Post.Id != reported {
+		t.Fatalf("queue %v, want the filtered comment, then the reported post", queue)
+	}
+	// Moderators see what was filtered, to judge it
+	if queue[0].Comment.Content != "buy now" || !queue[0].Comment.Removed {
+		t.Errorf("filtered comment in the queue: got %+v", queue[0].Comment)
+	}
+	if slices.ContainsFunc(queue, func(item *proto.QueueItemInfo) bool { return item.Comment != nil && item.Comment.Id == clean }) {
+		t.Errorf("comment that was neither filtered nor reported is in the queue")
+	}
+
+	resp := te.request(&proto.GetModQueueMsg{SubredditId: subredditID, UserId: reporter}).(*proto.GetModQueueResponse)
+	if resp.Error == nil || resp.Error.Code != ErrCodePermissionDenied {
+		t.Errorf("queue for a user who is not a moderator: got %v, want %s", resp.Error, ErrCodePermissionDenied)
+	}
+}
+
+func TestResolveReports(t *testing.T) {
+	te := startEngine(t, NewRedditEngine())
+	mod := te.registerUser("mod")
+	author := te.registerUser("author")
+	reporter := te.registerUser("reporter")
+	subredditID := te.createSubreddit("resolve", mod)
+	te.setAutomod(subredditID, mod, "body_regex: buy now\naction: filter")
+
+	approved := te.createPost(subredditID, author, "approved")
+	removed := te.createPost(subredditID, author, "removed")
+	ignored := te.createPost(subredditID, author, "ignored")
+	filtered := te.createComment(approved, author, "buy now")
+	for _, postID := range []string{removed, ignored} {
+		if err := te.report(postID, reporter, 0, "spam"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		target     string
+		resolution proto.QueueResolution
+		logged     string
+	}{
+		{filtered, proto.QueueResolution_QUEUE_RESOLUTION_APPROVE, "approve_comment"},
+		{removed, proto.QueueResolution_QUEUE_RESOLUTION_REMOVE, "remove_post"},
+		{ignored, proto.QueueResolution_QUEUE_RESOLUTION_IGNORE, "ignore_reports"},
+	}
+	for _, tt := range tests {
+		if err := te.resolve(tt.target, mod, tt.resolution, "resolved"); err != nil {
+			t.Fatalf("%s: %v", tt.resolution, err)
+		}
+		if slices.ContainsFunc(te.modQueue(subredditID, mod), func(item *proto.QueueItemInfo) bool {
+			return item.Post != nil && item.Post.Id == tt.target || item.Comment != nil && item.Comment.Id == tt.target
+		}) {
+			t.Errorf("%s: %s is still in the queue", tt.resolution, tt.target)
+		}
+		if log := te.modLog(subredditID, mod); log[0] != tt.logged+":"+tt.target {
+			t.Errorf("%s: latest moderator action %s, want %s:%s", tt.resolution, log[0], tt.logged, tt.target)
+		}
+	}
+
+	tree := te.request(&proto.GetPostCommentsMsg{PostId: approved}).(*proto.GetPostCommentsResponse)
+	if len(tree.Comments) != 1 || tree.Comments[0].Removed || tree.Comments[0].Content != "buy now" {
+		t.Errorf("approved comment: got %v, want it restored", tree.Comments)
+	}
+	if post := te.post(removed); !post.Removed {
+		t.Errorf("post removed from the queue is not removed")
+	}
+
+	// Reports of a post whose reports are ignored are taken but dropped
+	if err := te.report(ignored, author, 0, "spam again"); err != nil {
+		t.Errorf("report of an ignored post: got %v, want no error", err)
+	}
+	if queue := te.modQueue(subredditID, mod); len(queue) != 0 {
+		t.Errorf("queue after every item was resolved: got %v, want it empty", queue)
+	}
+	if err := te.resolve(ignored, mod, proto.QueueResolution_QUEUE_RESOLUTION_APPROVE, ""); err == nil || err.Code != ErrCodeNotFound {
+		t.Errorf("resolving what is not queued: got %v, want %s", err, ErrCodeNotFound)
+	}
+	if err := te.resolve(approved, reporter, proto.QueueResolution_QUEUE_RESOLUTION_REMOVE, ""); err == nil || err.Code != ErrCodePermissionDenied {
+		t.Errorf("resolving as a user who is not a moderator: got %v, want %s", err, ErrCodePermissionDenied)
+	}
+}
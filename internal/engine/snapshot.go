@@ -0,0 +1,121 @@
+// internal/engine/snapshot.go
+package engine
+
+import (
+	"bytes"
+	"encoding/gob"
+	"sort"
+)
+
+// memorySnapshot is the content of a memory store in a form gob can encode.
+// The per-post and per-user indexes are rebuilt on restore.
+type memorySnapshot struct {
+	Users      map[string]*User
+	Subreddits map[string]*Subreddit
+	Posts      map[string]*Post
+	Comments   map[string]*Comment
+	Messages   map[string]*DirectMessage
+	Votes      map[string]map[string]int
+	Owners     map[string]string
+	ModLogs    map[string][]*ModAction
+	ModQueues  map[string]map[string]bool
+}
+
+func (s *memoryStore) encodeSnapshot() ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	snap := memorySnapshot{
+		Users:      s.users,
+		Subreddits: s.subreddits,
+		Posts:      s.posts,
+		Comments:   s.comments,
+		Messages:   s.messages,
+		Votes:      s.votes,
+		Owners:     s.owners,
+		ModLogs:    s.modLogs,
+		ModQueues:  s.modQueues,
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&snap); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// restoreSnapshot loads a snapshot into an empty memory store.
+func (s *memoryStore) restoreSnapshot(data []byte) error {
+	var snap memorySnapshot
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&snap); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	for _, user := range snap.Users {
+		// gob leaves empty maps nil
+		if user.SubredditKarma == nil {
+			user.SubredditKarma = make(map[string]*KarmaBreakdown)
+		}
+		if user.Subreddits == nil {
+			user.Subreddits = make(map[string]bool)
+		}
+		s.users[user.ID] = user
+	}
+	for _, subreddit := range snap.Subreddits {
+		s.subreddits[subreddit.ID] = subreddit
+	}
+	for _, post := range snap.Posts {
+		s.posts[post.ID] = post
+	}
+	for targetID, ledger := range snap.Votes {
+		s.votes[targetID] = ledger
+	}
+	for id, ownerID := range snap.Owners {
+		s.owners[id] = ownerID
+	}
+	for subredditID, actions := range snap.ModLogs {
+		s.modLogs[subredditID] = actions
+	}
+	for subredditID, queue := range snap.ModQueues {
+		s.modQueues[subredditID] = queue
+	}
+	s.mu.Unlock()
+
+	// The indexes list records oldest first, so add them in that order
+	comments := make([]*Comment, 0, len(snap.Comments))
+	for _, comment := range snap.Comments {
+		comments = append(comments, comment)
+	}
+	sort.Slice(comments, func(i, j int) bool {
+		return createdBefore(comments[i].CreatedAt.UnixNano(), comments[i].ID, comments[j].CreatedAt.UnixNano(), comments[j].ID)
+	})
+	for _, comment := range comments {
+		s.PutComment(comment)
+	}
+
+	messages := make([]*DirectMessage, 0, len(snap.Messages))
+	for _, dm := range snap.Messages {
+		if dm.OwnerID == "" {
+			// Snapshots from before each participant had a copy only hold
+			// the recipient's
+			dm.OwnerID = dm.ToUserID
+			dm.ThreadID = dm.ID
+		}
+		messages = append(messages, dm)
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		return createdBefore(messages[i].CreatedAt.UnixNano(), messages[i].ID, messages[j].CreatedAt.UnixNano(), messages[j].ID)
+	})
+	for _, dm := range messages {
+		s.PutMessage(dm)
+	}
+	return nil
+}
+
+// createdBefore orders records by creation time, then ID.
+func createdBefore(aCreated int64, aID string, bCreated int64, bID string) bool {
+	if aCreated != bCreated {
+		return aCreated < bCreated
+	}
+	return aID < bID
+}
@@ -0,0 +1,93 @@
+// internal/engine/store.go
+package engine
+
+import (
+	"errors"
+	"log"
+
+	"github.com/kakugri/redditClone/internal/proto"
+)
+
+// ErrNotFound is returned by Store lookups for records that do not exist.
+var ErrNotFound = errors.New("record not found")
+
+// Store holds the engine's records, so the same engine logic can run against
+// memory, disk or a test fake.
+//
+// Records returned by a Store may be shared with it, so callers only change
+// them in order to Put them back. Puts are staged until Commit, which applies
+// all the changes of one command together and returns the first error any of
+// them hit.
+type Store interface {
+	User(id string) (*User, error)
+	Subreddit(id string) (*Subreddit, error)
+	Post(id string) (*Post, error)
+	Comment(id string) (*Comment, error)
+	// Message returns userID's copy of a direct message.
+	Message(userID, id string) (*DirectMessage, error)
+	// Vote returns a user's vote on a post or comment: +1, -1, or 0 for none.
+	Vote(targetID, userID string) (int, error)
+
+	// PostComments returns every comment on a post at any depth, oldest first.
+	PostComments(postID string) ([]*Comment, error)
+	// Mailbox returns a user's copies of the messages they sent and
+	// received, oldest first.
+	Mailbox(userID string) ([]*DirectMessage, error)
+	// ModLog returns the moderation actions taken in a subreddit, oldest first.
+	ModLog(subredditID string) ([]*ModAction, error)
+	// ModQueue returns the IDs of the posts and comments in a subreddit's
+	// moderation queue, in no particular order.
+	ModQueue(subredditID string) ([]string, error)
+	// ForEachPost calls fn for every post, in no particular order.
+	ForEachPost(fn func(*Post) error) error
+	Totals() (Totals, error)
+	// Owner returns the grain a clustered engine routes requests about a
+	// post or comment to: its subreddit.
+	Owner(id string) (string, error)
+
+	PutUser(user *User)
+	PutSubreddit(subreddit *Subreddit)
+	PutPost(post *Post)
+	PutComment(comment *Comment)
+	// PutMessage stores a copy of a message in its owner's mailbox.
+	PutMessage(dm *DirectMessage)
+	PutModAction(action *ModAction)
+	// PutQueued adds a post or comment to its subreddit's moderation queue,
+	// or takes it out.
+	PutQueued(subredditID, targetID string, queued bool)
+	// PutVote records a user's vote on a target; 0 removes it.
+	PutVote(targetID, userID string, value int)
+	PutOwner(id, ownerID string)
+
+	Commit() error
+	Close() error
+}
+
+// forkStore returns the store a grain of an engine cluster should use. Stores
+// that stage Puts until Commit give each actor its own staging area; the
+// others are shared as they are.
+func forkStore(store Store) Store {
+	if forker, ok := store.(interface{ fork() Store }); ok {
+		return forker.fork()
+	}
+	return store
+}
+
+// Totals are the record counts the engine's metrics start from.
+type Totals struct {
+	Users    int64
+	Posts    int64
+	Comments int64
+	Votes    int64
+	Messages int64 // counted once, by the recipient's copies
+}
+
+// storeError converts a failed lookup into a response error. A missing record
+// is reported as such; anything else is a storage failure.
+func storeError(err error, format string, args ...interface{}) *proto.Error {
+	if errors.Is(err, ErrNotFound) {
+		return newError(ErrCodeNotFound, format, args...)
+	}
+	log.Printf("Store error: %v", err)
+	return newError(ErrCodeInternal, "storage failure")
+}
@@ -0,0 +1,177 @@
+// internal/engine/store_test.go
+package engine
+
+import (
+	"errors"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kakugri/redditClone/internal/idgen"
+	"github.com/kakugri/redditClone/internal/proto"
+)
+
+var errFakeCommit = errors.New("fake commit failure")
+
+// fakeStore is a memory store that counts its commits and fails them on
+// demand.
+type fakeStore struct {
+	Store
+	commits    atomic.Int64
+	failCommit atomic.Bool
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{Store: NewMemoryStore()}
+}
+
+func (s *fakeStore) Commit() error {
+	s.commits.Add(1)
+	if s.failCommit.Load() {
+		return errFakeCommit
+	}
+	return s.Store.Commit()
+}
+
+func TestEngineCommitsEveryAcceptedCommand(t *testing.T) {
+	store := newFakeStore()
+	e, err := NewRedditEngineWithStore(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	te := startEngine(t, e)
+	author := te.registerUser("author")
+	subredditID := te.createSubreddit("fakes", author)
+	postID := te.createPost(subredditID, author, "title")
+	if got := store.commits.Load(); got != 3 {
+		t.Errorf("%d commits after three commands, want 3", got)
+	}
+
+	// Rejected commands and queries change nothing
+	te.request(&proto.RegisterUserMsg{Username: "x"})
+	te.request(&proto.CreatePostMsg{SubredditId: "t5_missing", AuthorId: author, Title: "title"})
+	te.post(postID)
+	if got := store.commits.Load(); got != 3 {
+		t.Errorf("%d commits after rejected commands and a query, want 3", got)
+	}
+
+	store.failCommit.Store(true)
+	resp := te.request(&proto.CreatePostMsg{SubredditId: subredditID, AuthorId: author, Title: "lost"}).(*proto.CreatePostResponse)
+	if resp.Error == nil || resp.Error.Code != ErrCodeInternal {
+		t.Errorf("create post with a failing store: got %v, want %s", resp.Error, ErrCodeInternal)
+	}
+}
+
+func TestEngineLoadsExistingStore(t *testing.T) {
+	store := newFakeStore()
+	e, err := NewRedditEngineWithStore(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	te := startEngine(t, e)
+	author := te.registerUser("author")
+	subredditID := te.createSubreddit("fakes", author)
+	postID := te.createPost(subredditID, author, "title")
+	te.stop()
+
+	// A new engine on the same store ranks the posts it finds there
+	e, err = NewRedditEngineWithStore(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	te = startEngine(t, e)
+	listing := te.request(&proto.GetSubredditPostsMsg{SubredditId: subredditID}).(*proto.GetSubredditPostsResponse)
+	if listing.Error != nil || len(listing.Posts) != 1 || listing.Posts[0].Id != postID {
+		t.Errorf("listing on a reloaded store: got %+v, %v, want %s", listing.Posts, listing.Error, postID)
+	}
+	if e.metrics.ActiveUsers != 1 || e.metrics.TotalPosts != 1 {
+		t.Errorf("reloaded metrics count %d users and %d posts, want 1 and 1", e.metrics.ActiveUsers, e.metrics.TotalPosts)
+	}
+}
+
+// TestStores checks that every Store implementation keeps the contract the
+// engine relies on.
+func TestStores(t *testing.T) {
+	stores := map[string]func(t *testing.T) Store{
+		"memory": func(t *testing.T) Store { return NewMemoryStore() },
+		"bolt": func(t *testing.T) Store {
+			store, err := OpenBoltStore(filepath.Join(t.TempDir(), "engine.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return store
+		},
+	}
+	for name, open := range stores {
+		t.Run(name, func(t *testing.T) {
+			store := open(t)
+			defer store.Close()
+			testStore(t, store)
+		})
+	}
+}
+
+func testStore(t *testing.T, store Store) {
+	if _, err := store.User("t2_missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing user: got %v, want ErrNotFound", err)
+	}
+	if _, err := store.Owner("t3_missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing owner: got %v, want ErrNotFound", err)
+	}
+
+	now := time.Now().Truncate(time.Second)
+	user := &User{ID: idgen.New(idgen.KindUser), Username: "user", JoinDate: now,
+		SubredditKarma: map[string]*KarmaBreakdown{}, Subreddits: map[string]bool{}}
+	post := &Post{ID: idgen.New(idgen.KindPost), Title: "title", AuthorID: user.ID, SubredditID: "t5_sub", CreatedAt: now}
+	first := &Comment{ID: idgen.New(idgen.KindComment), Content: "first", PostID: post.ID, CreatedAt: now}
+	second := &Comment{ID: idgen.New(idgen.KindComment), Content: "second", PostID: post.ID, CreatedAt: now}
+	store.PutUser(user)
+	store.PutPost(post)
+	store.PutComment(first)
+	store.PutComment(second)
+	store.PutVote(post.ID, user.ID, -1)
+	store.PutOwner(post.ID, post.SubredditID)
+	if err := store.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	if got, err := store.User(user.ID); err != nil || got.Username != "user" || !got.JoinDate.Equal(now) {
+		t.Errorf("User got %+v, %v", got, err)
+	}
+	if got, err := store.Post(post.ID); err != nil || got.Title != "title" {
+		t.Errorf("Post got %+v, %v", got, err)
+	}
+	comments, err := store.PostComments(post.ID)
+	if err != nil || len(comments) != 2 || comments[0].ID != first.ID || comments[1].ID != second.ID {
+		t.Errorf("PostComments got %v, %v, want the two comments oldest first", comments, err)
+	}
+	if vote, err := store.Vote(post.ID, user.ID); err != nil || vote != -1 {
+		t.Errorf("Vote got %d, %v, want -1", vote, err)
+	}
+	if owner, err := store.Owner(post.ID); err != nil || owner != post.SubredditID {
+		t.Errorf("Owner got %q, %v, want %q", owner, err, post.SubredditID)
+	}
+	totals, err := store.Totals()
+	if err != nil || totals.Users != 1 || totals.Posts != 1 || totals.Comments != 2 || totals.Votes != 1 {
+		t.Errorf("Totals got %+v, %v, want 1 user, 1 post, 2 comments and 1 vote", totals, err)
+	}
+
+	// Changing a comment again does not index it twice, and a zero vote is
+	// no vote
+	first.Content = "edited"
+	store.PutComment(first)
+	store.PutVote(post.ID, user.ID, 0)
+	if err := store.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if comments, _ := store.PostComments(post.ID); len(comments) != 2 || comments[0].Content != "edited" {
+		t.Errorf("PostComments after an edit got %v, want the edited comment first of two", comments)
+	}
+	if vote, err := store.Vote(post.ID, user.ID); err != nil || vote != 0 {
+		t.Errorf("cleared Vote got %d, %v, want 0", vote, err)
+	}
+	if totals, _ := store.Totals(); totals.Votes != 0 {
+		t.Errorf("Totals count %d votes after clearing the only one", totals.Votes)
+	}
+}
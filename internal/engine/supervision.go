@@ -0,0 +1,230 @@
+// internal/engine/supervision.go
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/asynkron/protoactor-go/actor"
+	"github.com/kakugri/redditClone/internal/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	gproto "google.golang.org/protobuf/proto"
+)
+
+// A panic in a handler fails the actor running it. Its supervisor restarts
+// it, and the new incarnation loads its state again: from the latest snapshot
+// and the log if the engine has a journal, or else from the store, dropping
+// whatever the failed command had staged. The memory store applies Puts as
+// they happen, so without a journal a failed command's changes may survive
+// the restart.
+//
+// The message that caused the panic is quarantined: its sender is told the
+// request failed, and the same message is refused for quarantineTTL instead
+// of crashing the actor again. A command the journal failed to write is not
+// to blame, so it is not quarantined.
+
+const (
+	// An engine actor that fails more than maxRestarts times within
+	// restartWindow is stopped rather than restarted again.
+	maxRestarts   = 10
+	restartWindow = time.Minute
+
+	// A message stays in quarantine for quarantineTTL, so one that crashed
+	// an actor because of a bug fixed since, or of state that changed, is
+	// accepted again. At most maxQuarantined messages are kept; when more
+	// crash actors, the oldest are released first.
+	quarantineTTL  = time.Hour
+	maxQuarantined = 1024
+)
+
+// engineSupervisor restarts a failed engine actor, unless it keeps failing.
+func engineSupervisor() actor.SupervisorStrategy {
+	return actor.NewOneForOneStrategy(maxRestarts, restartWindow, func(reason interface{}) actor.Directive {
+		log.Printf("Engine actor failed: %v", reason)
+		return actor.RestartDirective
+	})
+}
+
+// recovery counts the restarts of the actors of one engine, or one cluster
+// member, and keeps the messages that crashed them. It outlives the actors'
+// incarnations.
+type recovery struct {
+	restarts atomic.Int64
+
+	mu          sync.RWMutex
+	quarantined map[string]*quarantineEntry // message fingerprint -> its entry
+	order       []*quarantineEntry          // oldest first
+	count       atomic.Int64                // len(quarantined), read without the lock
+}
+
+// quarantineEntry is a message in quarantine.
+type quarantineEntry struct {
+	fingerprint string
+	reason      string
+	at          time.Time
+}
+
+func newRecovery() *recovery {
+	return &recovery{quarantined: make(map[string]*quarantineEntry)}
+}
+
+// receive is the receiver middleware of supervised actors. It refuses
+// quarantined messages and quarantines the ones a handler panics on.
+func (r *recovery) receive(next actor.ReceiverFunc) actor.ReceiverFunc {
+	return func(c actor.ReceiverContext, envelope *actor.MessageEnvelope) {
+		if _, ok := envelope.Message.(*actor.Restarting); ok {
+			log.Printf("Restarting %s after a crash", c.Self().Id)
+			r.restarts.Add(1)
+		}
+		msg, ok := envelope.Message.(gproto.Message)
+		if !ok {
+			next(c, envelope)
+			return
+		}
+		if reason := r.quarantinedFor(msg); reason != "" {
+			log.Printf("Refused quarantined %T: %s", msg, reason)
+			reply(c, envelope, newError(ErrCodeInternal, "request refused: it crashed the engine before"))
+			return
+		}
+
+		defer func() {
+			switch reason := recover().(type) {
+			case nil:
+			case *journalFailure:
+				reply(c, envelope, newError(ErrCodeInternal, "failed to persist the change"))
+				panic(reason)
+			default:
+				log.Printf("Panic while handling %T: %v\n%s", msg, reason, debug.Stack())
+				r.quarantine(msg, reason)
+				reply(c, envelope, newError(ErrCodeInternal, "the engine failed to process the request"))
+				// Let the supervisor restart the actor
+				panic(reason)
+			}
+		}()
+		next(c, envelope)
+	}
+}
+
+// reply answers the sender of a message the actor's handlers did not.
+func reply(c actor.ReceiverContext, envelope *actor.MessageEnvelope, err *proto.Error) {
+	if envelope.Sender == nil {
+		return
+	}
+	if response := errorResponse(envelope.Message, err); response != nil {
+		c.ActorSystem().Root.Send(envelope.Sender, response)
+	}
+}
+
+// quarantinedFor returns why msg is quarantined, or "" if it is not.
+func (r *recovery) quarantinedFor(msg gproto.Message) string {
+	if r.count.Load() == 0 {
+		return ""
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	entry := r.quarantined[fingerprint(msg)]
+	if entry == nil || time.Since(entry.at) >= quarantineTTL {
+		return ""
+	}
+	return entry.reason
+}
+
+func (r *recovery) quarantine(msg gproto.Message, reason interface{}) {
+	entry := &quarantineEntry{fingerprint: fingerprint(msg), reason: fmt.Sprintf("%v", reason), at: time.Now()}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expireLocked(entry.at)
+	if r.quarantined[entry.fingerprint] != nil {
+		// Another actor crashed on the same message at the same time
+		return
+	}
+	if len(r.order) >= maxQuarantined {
+		log.Printf("Quarantine full, releasing the oldest message")
+		r.releaseLocked()
+	}
+	r.quarantined[entry.fingerprint] = entry
+	r.order = append(r.order, entry)
+	r.count.Store(int64(len(r.order)))
+	log.Printf("Quarantined %T", msg)
+}
+
+// expire releases the messages whose quarantine has run out.
+func (r *recovery) expire() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expireLocked(time.Now())
+}
+
+func (r *recovery) expireLocked(now time.Time) {
+	for len(r.order) > 0 && now.Sub(r.order[0].at) >= quarantineTTL {
+		r.releaseLocked()
+	}
+	r.count.Store(int64(len(r.order)))
+}
+
+// releaseLocked takes the oldest message out of quarantine.
+func (r *recovery) releaseLocked() {
+	delete(r.quarantined, r.order[0].fingerprint)
+	r.order[0] = nil
+	r.order = r.order[1:]
+}
+
+// quarantinedCount returns how many messages are in quarantine.
+func (r *recovery) quarantinedCount() int64 {
+	r.expire()
+	return r.count.Load()
+}
+
+// report adds the restart and quarantine counts to a metrics report.
+func (r *recovery) report(report *proto.MetricsReportMsg) *proto.MetricsReportMsg {
+	report.Restarts = r.restarts.Load()
+	report.QuarantinedMessages = r.quarantinedCount()
+	return report
+}
+
+// fingerprint identifies a message by its type and content.
+func fingerprint(msg gproto.Message) string {
+	data, err := gproto.MarshalOptions{Deterministic: true}.Marshal(msg)
+	if err != nil {
+		return fmt.Sprintf("%T:%v", msg, err)
+	}
+	sum := sha256.Sum256(data)
+	return string(msg.ProtoReflect().Descriptor().FullName()) + ":" + hex.EncodeToString(sum[:])
+}
+
+// EngineProps returns the props of a supervised single engine actor. It runs
+// on journal's memory store if journal is not nil, and on store otherwise.
+// Every incarnation of the actor loads the state anew, so one restarted after
+// a crash carries on from what was committed. The engine's metrics are
+// exported to Prometheus through reg, unless reg is nil.
+func EngineProps(store Store, journal *Journal, reg prometheus.Registerer) *actor.Props {
+	r := newRecovery()
+	metrics := &Metrics{StartTime: time.Now()}
+	in := newInstruments(reg, metrics, r)
+	opts := append(in.props("engine"), actor.WithReceiverMiddleware(traceReceive("engine")),
+		actor.WithGuardian(engineSupervisor()), actor.WithReceiverMiddleware(r.receive))
+	return actor.PropsFromProducer(func() actor.Actor {
+		var e *RedditEngine
+		var err error
+		if journal != nil {
+			e, err = NewDurableEngine(journal)
+		} else {
+			// A staging area of its own drops what the last incarnation staged
+			e, err = NewRedditEngineWithStore(forkStore(store))
+		}
+		if err != nil {
+			log.Fatalf("Failed to load engine state: %v", err)
+		}
+		// The metrics outlive the incarnation, counting what it loaded
+		metrics.load(e.metrics)
+		e.metrics = metrics
+		e.recovery = r
+		return e
+	}, opts...)
+}
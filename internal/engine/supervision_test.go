@@ -0,0 +1,52 @@
+// internal/engine/supervision_test.go
+package engine
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/kakugri/redditClone/internal/proto"
+)
+
+func TestQuarantineExpires(t *testing.T) {
+	r := newRecovery()
+	msg := &proto.GetPostMsg{PostId: "t3_crash"}
+	r.quarantine(msg, "boom")
+	if reason := r.quarantinedFor(msg); reason != "boom" {
+		t.Fatalf("quarantined for %q, want boom", reason)
+	}
+
+	r.order[0].at = time.Now().Add(-quarantineTTL)
+	if reason := r.quarantinedFor(msg); reason != "" {
+		t.Errorf("expired quarantine still refuses the message: %q", reason)
+	}
+	if count := r.quarantinedCount(); count != 0 {
+		t.Errorf("%d messages in quarantine after the only one expired", count)
+	}
+
+	// Crashing an actor again puts it back
+	r.quarantine(msg, "boom again")
+	if reason := r.quarantinedFor(msg); reason != "boom again" {
+		t.Errorf("quarantined again for %q, want boom again", reason)
+	}
+}
+
+func TestQuarantineReleasesOldestWhenFull(t *testing.T) {
+	r := newRecovery()
+	message := func(i int) *proto.GetPostMsg {
+		return &proto.GetPostMsg{PostId: fmt.Sprintf("t3_%d", i)}
+	}
+	for i := 0; i <= maxQuarantined; i++ {
+		r.quarantine(message(i), "boom")
+	}
+	if count := r.quarantinedCount(); count != maxQuarantined {
+		t.Errorf("%d messages in quarantine, want %d", count, maxQuarantined)
+	}
+	if r.quarantinedFor(message(0)) != "" {
+		t.Error("oldest message still quarantined")
+	}
+	if r.quarantinedFor(message(1)) == "" || r.quarantinedFor(message(maxQuarantined)) == "" {
+		t.Error("newer messages released")
+	}
+}
@@ -0,0 +1,126 @@
+// internal/engine/throughput_test.go
+package engine
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/asynkron/protoactor-go/actor"
+	"github.com/kakugri/redditClone/internal/proto"
+)
+
+// lockedEngine handles messages the way the engine did before its state was
+// actor-owned: every handler held the engine's mutex, and a reporter goroutine
+// of its own took the same mutex every tick to copy the metrics.
+type lockedEngine struct {
+	mu sync.RWMutex
+	*RedditEngine
+	tick time.Duration
+	done chan struct{}
+}
+
+func (l *lockedEngine) Receive(context actor.Context) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	switch context.Message().(type) {
+	case *actor.Started:
+		l.done = make(chan struct{})
+		go l.report()
+	case *actor.Stopping:
+		close(l.done)
+	}
+	l.RedditEngine.Receive(context)
+}
+
+// report copies the metrics every tick, as StartMetricsReporter did.
+func (l *lockedEngine) report() {
+	ticker := time.NewTicker(l.tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+			l.mu.RLock()
+			l.metrics.mu.Lock()
+			posts, comments, votes := l.metrics.TotalPosts, l.metrics.TotalComments, l.metrics.TotalVotes
+			l.metrics.mu.Unlock()
+			l.mu.RUnlock()
+			_, _, _ = posts, comments, votes
+		}
+	}
+}
+
+// throughputWorkload is what the clients of the benchmarks act on.
+type throughputWorkload struct {
+	users, subreddits, posts []string
+}
+
+func newThroughputWorkload(te *testEngine) *throughputWorkload {
+	w := &throughputWorkload{}
+	for i := 0; i < 100; i++ {
+		w.users = append(w.users, te.registerUser(fmt.Sprintf("user%d", i)))
+	}
+	for i := 0; i < 10; i++ {
+		w.subreddits = append(w.subreddits, te.createSubreddit(fmt.Sprintf("sub%d", i), w.users[i]))
+	}
+	for i := 0; i < 200; i++ {
+		w.posts = append(w.posts, te.createPost(w.subreddits[i%len(w.subreddits)], w.users[i%len(w.users)], fmt.Sprintf("post %d", i)))
+	}
+	return w
+}
+
+// request picks a request the way clients mostly read and sometimes write.
+func (w *throughputWorkload) request(r *rand.Rand) interface{} {
+	user := w.users[r.Intn(len(w.users))]
+	post := w.posts[r.Intn(len(w.posts))]
+	switch n := r.Intn(10); {
+	case n < 4:
+		return &proto.GetPostMsg{PostId: post}
+	case n < 6:
+		return &proto.GetSubredditPostsMsg{SubredditId: w.subreddits[r.Intn(len(w.subreddits))], Limit: 25}
+	case n < 9:
+		return &proto.VoteMsg{UserId: user, TargetId: post, Direction: proto.VoteDirection(1 + r.Intn(3))}
+	default:
+		return &proto.CreateCommentMsg{AuthorId: user, PostId: post, Content: "benchmark comment"}
+	}
+}
+
+// benchmarkEngine has 64 concurrent clients per CPU send requests to the engine
+// actor spawned from producer.
+func benchmarkEngine(b *testing.B, producer func(*RedditEngine) actor.Actor) {
+	e := NewRedditEngine()
+	te := spawnEngine(b, actor.PropsFromProducer(func() actor.Actor { return producer(e) }))
+	w := newThroughputWorkload(te)
+
+	var seed atomic.Int64
+	b.SetParallelism(64)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewSource(seed.Add(1)))
+		for pb.Next() {
+			if _, err := te.system.Root.RequestFuture(te.pid, w.request(r), 5*time.Second).Result(); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+// BenchmarkEngineThroughput compares the engine actor that owns its state with
+// the same engine taking a lock in every handler, against a reporter taking it
+// every millisecond. The engine reported every 10 seconds; reporting that
+// rarely never contends with a benchmark. On one CPU the two are equally fast
+// within noise.
+func BenchmarkEngineThroughput(b *testing.B) {
+	b.Run("actor-owned", func(b *testing.B) {
+		benchmarkEngine(b, func(e *RedditEngine) actor.Actor { return e })
+	})
+	b.Run("locked", func(b *testing.B) {
+		benchmarkEngine(b, func(e *RedditEngine) actor.Actor { return &lockedEngine{RedditEngine: e, tick: time.Millisecond} })
+	})
+}
@@ -0,0 +1,220 @@
+// internal/engine/tracing.go
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/asynkron/protoactor-go/actor"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	gproto "google.golang.org/protobuf/proto"
+)
+
+// A traced request carries its trace context in the headers of the message
+// envelope, which remote delivers along with the message. Each actor it
+// reaches handles it in a span of its own, and passes the trace on to the
+// store calls and the grain requests it makes meanwhile. Messages that carry
+// no trace are handled as they always were, without spans.
+
+var tracer = otel.Tracer("github.com/kakugri/redditClone/internal/engine")
+
+// traced is implemented by actors that trace what they do while handling a
+// traced message.
+type traced interface {
+	// traceWith sets the context of the message being handled; nil once
+	// it is handled.
+	traceWith(ctx context.Context)
+}
+
+// traceReceive continues the trace of each traced protobuf message an actor
+// of the given kind receives, in a span that lasts while the actor handles
+// it.
+func traceReceive(kind string) actor.ReceiverMiddleware {
+	return func(next actor.ReceiverFunc) actor.ReceiverFunc {
+		return func(c actor.ReceiverContext, envelope *actor.MessageEnvelope) {
+			// Kept apart so untraced messages do not pay for the larger
+			// stack frame of tracing
+			if envelope.Header.Length() == 0 {
+				next(c, envelope)
+				return
+			}
+			receiveTraced(kind, next, c, envelope)
+		}
+	}
+}
+
+func receiveTraced(kind string, next actor.ReceiverFunc, c actor.ReceiverContext, envelope *actor.MessageEnvelope) {
+	msg := envelope.Message
+	carrier := propagation.MapCarrier(envelope.Header)
+	parent := otel.GetTextMapPropagator().Extract(context.Background(), carrier)
+	if _, ok := msg.(gproto.Message); !ok || !trace.SpanContextFromContext(parent).IsValid() {
+		next(c, envelope)
+		return
+	}
+
+	ctx, span := tracer.Start(parent, kind+" "+messageName(msg),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("actor.id", c.Self().Id)))
+	defer span.End()
+	// A message the actor forwards carries the trace on from here
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	if a, ok := c.Actor().(traced); ok {
+		a.traceWith(ctx)
+		defer a.traceWith(nil)
+	}
+	next(c, envelope)
+}
+
+// messageName names the type of a message in span names.
+func messageName(msg interface{}) string {
+	if m, ok := msg.(gproto.Message); ok {
+		return string(m.ProtoReflect().Descriptor().Name())
+	}
+	return fmt.Sprintf("%T", msg)
+}
+
+// tracedSender returns a sender context whose messages carry the trace of
+// ctx, or system's root context if ctx is nil or not traced.
+func tracedSender(system *actor.ActorSystem, ctx context.Context) actor.SenderContext {
+	if ctx == nil || !trace.SpanContextFromContext(ctx).IsValid() {
+		return system.Root
+	}
+	return system.Root.Copy().WithSenderMiddleware(func(next actor.SenderFunc) actor.SenderFunc {
+		return func(c actor.SenderContext, target *actor.PID, envelope *actor.MessageEnvelope) {
+			carrier := propagation.MapCarrier{}
+			otel.GetTextMapPropagator().Inject(ctx, carrier)
+			for key, value := range carrier {
+				envelope.SetHeader(key, value)
+			}
+			next(c, target, envelope)
+		}
+	})
+}
+
+// tracedStore records the store calls of a traced message as spans. Puts
+// only stage changes, so they are left out; Commit is where they are
+// applied.
+type tracedStore struct {
+	Store
+	trace *context.Context // of the message its actor is handling
+}
+
+// start begins the span of a store call, or returns nil if the message being
+// handled is not traced.
+func (s *tracedStore) start(name string) trace.Span {
+	ctx := *s.trace
+	if ctx == nil {
+		return nil
+	}
+	_, span := tracer.Start(ctx, "store."+name, trace.WithSpanKind(trace.SpanKindInternal))
+	return span
+}
+
+// endStoreSpan ends the span of a store call that returned err. Missing
+// records are answers rather than failures.
+func endStoreSpan(span trace.Span, err error) {
+	if span == nil {
+		return
+	}
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (s *tracedStore) User(id string) (*User, error) {
+	span := s.start("User")
+	user, err := s.Store.User(id)
+	endStoreSpan(span, err)
+	return user, err
+}
+
+func (s *tracedStore) Subreddit(id string) (*Subreddit, error) {
+	span := s.start("Subreddit")
+	subreddit, err := s.Store.Subreddit(id)
+	endStoreSpan(span, err)
+	return subreddit, err
+}
+
+func (s *tracedStore) Post(id string) (*Post, error) {
+	span := s.start("Post")
+	post, err := s.Store.Post(id)
+	endStoreSpan(span, err)
+	return post, err
+}
+
+func (s *tracedStore) Comment(id string) (*Comment, error) {
+	span := s.start("Comment")
+	comment, err := s.Store.Comment(id)
+	endStoreSpan(span, err)
+	return comment, err
+}
+
+func (s *tracedStore) Message(userID, id string) (*DirectMessage, error) {
+	span := s.start("Message")
+	dm, err := s.Store.Message(userID, id)
+	endStoreSpan(span, err)
+	return dm, err
+}
+
+func (s *tracedStore) Vote(targetID, userID string) (int, error) {
+	span := s.start("Vote")
+	value, err := s.Store.Vote(targetID, userID)
+	endStoreSpan(span, err)
+	return value, err
+}
+
+func (s *tracedStore) PostComments(postID string) ([]*Comment, error) {
+	span := s.start("PostComments")
+	comments, err := s.Store.PostComments(postID)
+	endStoreSpan(span, err)
+	return comments, err
+}
+
+func (s *tracedStore) Mailbox(userID string) ([]*DirectMessage, error) {
+	span := s.start("Mailbox")
+	messages, err := s.Store.Mailbox(userID)
+	endStoreSpan(span, err)
+	return messages, err
+}
+
+func (s *tracedStore) ModLog(subredditID string) ([]*ModAction, error) {
+	span := s.start("ModLog")
+	actions, err := s.Store.ModLog(subredditID)
+	endStoreSpan(span, err)
+	return actions, err
+}
+
+func (s *tracedStore) ModQueue(subredditID string) ([]string, error) {
+	span := s.start("ModQueue")
+	ids, err := s.Store.ModQueue(subredditID)
+	endStoreSpan(span, err)
+	return ids, err
+}
+
+func (s *tracedStore) ForEachPost(fn func(*Post) error) error {
+	span := s.start("ForEachPost")
+	err := s.Store.ForEachPost(fn)
+	endStoreSpan(span, err)
+	return err
+}
+
+func (s *tracedStore) Owner(id string) (string, error) {
+	span := s.start("Owner")
+	ownerID, err := s.Store.Owner(id)
+	endStoreSpan(span, err)
+	return ownerID, err
+}
+
+func (s *tracedStore) Commit() error {
+	span := s.start("Commit")
+	err := s.Store.Commit()
+	endStoreSpan(span, err)
+	return err
+}
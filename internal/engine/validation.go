@@ -0,0 +1,324 @@
+// internal/engine/validation.go
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/kakugri/redditClone/internal/idgen"
+	"github.com/kakugri/redditClone/internal/proto"
+)
+
+// Limits on what users write, in characters.
+const (
+	minNameLength          = 3
+	maxUsernameLength      = 20
+	maxSubredditNameLength = 21
+	maxDescriptionLength   = 500
+	maxTitleLength         = 300
+	maxPostLength          = 40000
+	maxCommentLength       = 10000
+	maxMessageLength       = 10000
+	maxReasonLength        = 300
+	maxRuleLength          = 100
+)
+
+// maxRules is the most rules a subreddit may have.
+const maxRules = 15
+
+// MaxRestrictionDays is the longest duration a ban or mute may be given.
+const MaxRestrictionDays = 999
+
+// maxRestrictionSeconds is MaxRestrictionDays in the seconds RestrictUserMsg
+// gives durations in.
+const maxRestrictionSeconds = MaxRestrictionDays * 24 * 60 * 60
+
+const (
+	// maxBlockedUsers is the most users one user may block.
+	maxBlockedUsers = 1000
+	// maxMinAccountAgeDays is the oldest account age message settings may
+	// ask senders for.
+	maxMinAccountAgeDays = 3650
+)
+
+// Names nobody may register, compared regardless of case: they belong to the
+// site, or would be mistaken for it.
+var (
+	reservedUsernames = map[string]bool{
+		"admin": true, "administrator": true, "automoderator": true, "deleted": true,
+		"moderator": true, "reddit": true, "root": true, "system": true,
+	}
+	reservedSubredditNames = map[string]bool{
+		"all": true, "api": true, "friends": true, "mod": true,
+		"popular": true, "random": true, "users": true,
+	}
+)
+
+// foldName returns the form of a username or subreddit name that is unique:
+// names that differ only in case are the same name.
+func foldName(name string) string {
+	return strings.ToLower(name)
+}
+
+// violations collects what is wrong with the fields of one request.
+type violations []*proto.FieldViolation
+
+func (v *violations) add(field, format string, args ...interface{}) {
+	*v = append(*v, &proto.FieldViolation{Field: field, Description: fmt.Sprintf(format, args...)})
+}
+
+// err returns the error that rejects the request, or nil if nothing is wrong.
+func (v violations) err() *proto.Error {
+	if len(v) == 0 {
+		return nil
+	}
+	descriptions := make([]string, len(v))
+	for i, violation := range v {
+		descriptions[i] = violation.Field + ": " + violation.Description
+	}
+	err := newError(ErrCodeInvalidArgument, "invalid request: %s", strings.Join(descriptions, "; "))
+	err.Fields = v
+	return err
+}
+
+// name checks a username or subreddit name: its length, that it only has
+// ASCII letters, digits and the punctuation in extra, described by charset,
+// and that it is not reserved.
+func (v *violations) name(field, name string, maxLength int, extra, charset string, reserved map[string]bool) {
+	if len(name) < minNameLength || len(name) > maxLength {
+		v.add(field, "must be %d to %d characters long", minNameLength, maxLength)
+		return
+	}
+	for _, r := range name {
+		if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || strings.ContainsRune(extra, r)) {
+			v.add(field, "may only contain %s", charset)
+			return
+		}
+	}
+	if reserved[foldName(name)] {
+		v.add(field, "%q is reserved", name)
+	}
+}
+
+// text checks the length of text. If required, it must not be blank.
+func (v *violations) text(field, text string, required bool, maxLength int) {
+	if required && strings.TrimSpace(text) == "" {
+		v.add(field, "is required")
+		return
+	}
+	if utf8.RuneCountInString(text) > maxLength {
+		v.add(field, "must be at most %d characters long", maxLength)
+	}
+}
+
+func validateRegisterUser(msg *proto.RegisterUserMsg) *proto.Error {
+	var v violations
+	v.name("username", msg.Username, maxUsernameLength, "_-", "letters, digits, '_' and '-'", reservedUsernames)
+	return v.err()
+}
+
+func validateCreateSubreddit(msg *proto.CreateSubredditMsg) *proto.Error {
+	var v violations
+	v.name("name", msg.Name, maxSubredditNameLength, "_", "letters, digits and '_'", reservedSubredditNames)
+	v.text("description", msg.Description, false, maxDescriptionLength)
+	return v.err()
+}
+
+func validateCreatePost(msg *proto.CreatePostMsg) *proto.Error {
+	var v violations
+	v.text("title", msg.Title, true, maxTitleLength)
+	v.text("content", msg.Content, false, maxPostLength)
+	return v.err()
+}
+
+// validateEditPost only checks what the edit changes: an empty title or
+// content is left as it was, but not both.
+func validateEditPost(msg *proto.EditPostMsg) *proto.Error {
+	var v violations
+	if msg.Title == "" && msg.Content == "" {
+		v.add("title", "or content is required")
+	}
+	v.text("title", msg.Title, false, maxTitleLength)
+	v.text("content", msg.Content, false, maxPostLength)
+	return v.err()
+}
+
+func validateComment(content string, required bool) *proto.Error {
+	var v violations
+	v.text("content", content, required, maxCommentLength)
+	return v.err()
+}
+
+// validateDirectMessage checks a message, which goes to to_user_id unless it
+// is a reply.
+func validateDirectMessage(msg *proto.DirectMessageMsg) *proto.Error {
+	var v violations
+	if msg.ToUserId == "" && msg.ReplyToId == "" {
+		v.add("to_user_id", "is required")
+	}
+	v.text("content", msg.Content, true, maxMessageLength)
+	return v.err()
+}
+
+// validateGetMessages checks the folder and page of a message listing.
+func validateGetMessages(msg *proto.GetMessagesMsg) *proto.Error {
+	var v violations
+	switch msg.Folder {
+	case "", folderInbox, folderSent, folderAll:
+	default:
+		v.add("folder", `must be "inbox", "sent" or "all"`)
+	}
+	v.messageCursor(msg.Cursor)
+	return v.err()
+}
+
+func validateGetConversations(msg *proto.GetConversationsMsg) *proto.Error {
+	var v violations
+	v.messageCursor(msg.Cursor)
+	return v.err()
+}
+
+// messageCursor checks the cursor of a page of messages or conversations:
+// the ID of the last message of the previous page.
+func (v *violations) messageCursor(cursor string) {
+	if cursor != "" && idgen.KindOf(cursor) != idgen.KindMessage {
+		v.add("cursor", "is not a message ID")
+	}
+}
+
+func validateMarkRead(msg *proto.MarkReadMsg) *proto.Error {
+	var v violations
+	if (msg.MessageId == "") == (msg.OtherUserId == "") {
+		v.add("message_id", "or other_user_id is required, but not both")
+	}
+	return v.err()
+}
+
+func validateBlockUser(msg *proto.BlockUserMsg) *proto.Error {
+	var v violations
+	switch msg.BlockedUserId {
+	case "":
+		v.add("blocked_user_id", "is required")
+	case msg.UserId:
+		v.add("blocked_user_id", "cannot be yourself")
+	}
+	return v.err()
+}
+
+func validateMessageSettings(settings *proto.MessageSettings) *proto.Error {
+	var v violations
+	if days := settings.GetMinAccountAgeDays(); days < 0 || days > maxMinAccountAgeDays {
+		v.add("min_account_age_days", "must be 0 to %d", maxMinAccountAgeDays)
+	}
+	if settings.GetMinKarma() < 0 {
+		v.add("min_karma", "must not be negative")
+	}
+	return v.err()
+}
+
+func validateModerator(moderatorID string) *proto.Error {
+	var v violations
+	if moderatorID == "" {
+		v.add("moderator_id", "is required")
+	}
+	return v.err()
+}
+
+// validateReason checks the reason a moderator gives for an action.
+func validateReason(reason string) *proto.Error {
+	var v violations
+	v.text("reason", reason, false, maxReasonLength)
+	return v.err()
+}
+
+func validateRestriction(restriction proto.Restriction) *proto.Error {
+	var v violations
+	v.restriction(restriction)
+	return v.err()
+}
+
+func validateRestrictUser(msg *proto.RestrictUserMsg) *proto.Error {
+	var v violations
+	if msg.TargetUserId == "" {
+		v.add("target_user_id", "is required")
+	}
+	v.restriction(msg.Restriction)
+	if msg.DurationSeconds < 0 || msg.DurationSeconds > maxRestrictionSeconds {
+		v.add("duration_seconds", "must be 0 to %d", maxRestrictionSeconds)
+	}
+	v.text("reason", msg.Reason, false, maxReasonLength)
+	return v.err()
+}
+
+func validateSetRules(rules []string) *proto.Error {
+	var v violations
+	if len(rules) > maxRules {
+		v.add("rules", "must be at most %d", maxRules)
+	}
+	for i, rule := range rules {
+		v.text(fmt.Sprintf("rules[%d]", i), rule, true, maxRuleLength)
+	}
+	return v.err()
+}
+
+// validateReport checks that a report cites one of rules or gives a reason,
+// but not both.
+func validateReport(msg *proto.ReportMsg, rules []string) *proto.Error {
+	var v violations
+	switch {
+	case msg.Rule != 0 && msg.Reason != "":
+		v.add("reason", "must be empty when a rule is cited")
+	case msg.Rule != 0 && len(rules) == 0:
+		v.add("rule", "the subreddit has no rules; give a reason")
+	case msg.Rule < 0 || int(msg.Rule) > len(rules):
+		v.add("rule", "must be 1 to %d", len(rules))
+	case msg.Rule == 0:
+		v.text("reason", msg.Reason, true, maxReasonLength)
+	}
+	return v.err()
+}
+
+func validateResolveReports(msg *proto.ResolveReportsMsg) *proto.Error {
+	var v violations
+	switch msg.Resolution {
+	case proto.QueueResolution_QUEUE_RESOLUTION_APPROVE, proto.QueueResolution_QUEUE_RESOLUTION_REMOVE,
+		proto.QueueResolution_QUEUE_RESOLUTION_IGNORE:
+	default:
+		v.add("resolution", "must be approve, remove or ignore")
+	}
+	v.text("reason", msg.Reason, false, maxReasonLength)
+	return v.err()
+}
+
+func (v *violations) restriction(restriction proto.Restriction) {
+	if restriction != proto.Restriction_RESTRICTION_BAN && restriction != proto.Restriction_RESTRICTION_MUTE {
+		v.add("restriction", "must be a ban or a mute")
+	}
+}
+
+// nameFree returns an error unless the owner index has no entry under key,
+// the index key of the username or subreddit name in field.
+func nameFree(store Store, key, field, what, name string) *proto.Error {
+	_, err := store.Owner(key)
+	if err == nil {
+		taken := newError(ErrCodeAlreadyExists, "%s %q is taken", what, name)
+		taken.Fields = []*proto.FieldViolation{{Field: field, Description: "is taken"}}
+		return taken
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return storeError(err, "")
+	}
+	return nil
+}
+
+// check returns err unless the current command is replayed from the journal:
+// the journal holds commands accepted under the rules of their time, which
+// replay must not judge again.
+func (e *RedditEngine) check(err *proto.Error) *proto.Error {
+	if e.cmd.replay {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,105 @@
+// internal/engine/validation_test.go
+package engine
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kakugri/redditClone/internal/proto"
+)
+
+func TestValidateNames(t *testing.T) {
+	tests := []struct {
+		name, username, subreddit string
+		wantUser, wantSubreddit   bool // whether the name is accepted
+	}{
+		{"shortest", "abc", "abc", true, true},
+		{"too short", "ab", "ab", false, false},
+		{"longest username", strings.Repeat("a", maxUsernameLength), strings.Repeat("a", maxUsernameLength), true, true},
+		{"longest subreddit name", strings.Repeat("a", maxSubredditNameLength), strings.Repeat("a", maxSubredditNameLength), false, true},
+		{"too long", strings.Repeat("a", maxSubredditNameLength+1), strings.Repeat("a", maxSubredditNameLength+1), false, false},
+		{"digits and underscore", "Go_123", "Go_123", true, true},
+		{"hyphen", "go-pher", "go-pher", true, false},
+		{"space", "go pher", "go pher", false, false},
+		{"not ASCII", "gophér", "gophér", false, false},
+		{"reserved", "admin", "all", false, false},
+		{"reserved in another case", "Root", "Popular", false, false},
+		{"reserved for the other kind", "popular", "root", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateRegisterUser(&proto.RegisterUserMsg{Username: tt.username})
+			checkViolation(t, "username "+tt.username, err, "username", tt.wantUser)
+			err = validateCreateSubreddit(&proto.CreateSubredditMsg{Name: tt.subreddit})
+			checkViolation(t, "subreddit name "+tt.subreddit, err, "name", tt.wantSubreddit)
+		})
+	}
+}
+
+func TestValidateTextLengths(t *testing.T) {
+	long := strings.Repeat("é", maxTitleLength)
+	if err := validateCreatePost(&proto.CreatePostMsg{Title: long}); err != nil {
+		t.Errorf("title of %d characters: got %v, want no error", maxTitleLength, err)
+	}
+	err := validateCreatePost(&proto.CreatePostMsg{Title: long + "é"})
+	checkViolation(t, "longer title", err, "title", false)
+	err = validateCreatePost(&proto.CreatePostMsg{Title: "  "})
+	checkViolation(t, "blank title", err, "title", false)
+	err = validateComment(strings.Repeat("a", maxCommentLength+1), true)
+	checkViolation(t, "long comment", err, "content", false)
+}
+
+func TestValidateRestrictionDuration(t *testing.T) {
+	tests := []struct {
+		name     string
+		seconds  int64
+		accepted bool
+	}{
+		{"until lifted", 0, true},
+		{"longest", maxRestrictionSeconds, true},
+		{"negative", -1, false},
+		{"longer", maxRestrictionSeconds + 1, false},
+		// Too long for a time.Duration
+		{"overflowing", 106752 * 24 * 60 * 60, false},
+	}
+	for _, tt := range tests {
+		msg := &proto.RestrictUserMsg{TargetUserId: "t2_target", Restriction: proto.Restriction_RESTRICTION_BAN, DurationSeconds: tt.seconds}
+		checkViolation(t, tt.name, validateRestrictUser(msg), "duration_seconds", tt.accepted)
+	}
+}
+
+// checkViolation checks that err accepts what it checked, or rejects it for
+// field.
+func checkViolation(t *testing.T, what string, err *proto.Error, field string, accepted bool) {
+	t.Helper()
+	if accepted {
+		if err != nil {
+			t.Errorf("%s: got %v, want no error", what, err)
+		}
+		return
+	}
+	if err == nil || err.Code != ErrCodeInvalidArgument || len(err.Fields) != 1 || err.Fields[0].Field != field {
+		t.Errorf("%s: got %v, want %s for %s", what, err, ErrCodeInvalidArgument, field)
+	}
+}
+
+func TestNamesAreUniqueRegardlessOfCase(t *testing.T) {
+	te := startEngine(t, NewRedditEngine())
+	author := te.registerUser("Gopher")
+	te.createSubreddit("Golang", author)
+
+	user := te.request(&proto.RegisterUserMsg{Username: "gOPHER"}).(*proto.RegisterUserResponse)
+	if user.Error == nil || user.Error.Code != ErrCodeAlreadyExists {
+		t.Errorf("username taken in another case: got %v, want %s", user.Error, ErrCodeAlreadyExists)
+	}
+	subreddit := te.request(&proto.CreateSubredditMsg{Name: "golang", CreatorId: author}).(*proto.CreateSubredditResponse)
+	if subreddit.Error == nil || subreddit.Error.Code != ErrCodeAlreadyExists {
+		t.Errorf("subreddit name taken in another case: got %v, want %s", subreddit.Error, ErrCodeAlreadyExists)
+	}
+
+	// Lookups find the name in any case, as it was registered
+	lookup := te.request(&proto.GetSubredditMsg{Name: "GOLANG"}).(*proto.GetSubredditResponse)
+	if lookup.Error != nil || lookup.Subreddit.Name != "Golang" {
+		t.Errorf("subreddit lookup in another case: got %+v, %v, want Golang", lookup.Subreddit, lookup.Error)
+	}
+}
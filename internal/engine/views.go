@@ -0,0 +1,136 @@
+// internal/engine/views.go
+package engine
+
+import (
+	"time"
+
+	"github.com/kakugri/redditClone/internal/proto"
+)
+
+// Conversions from engine models to the proto messages returned to clients.
+
+func userInfo(user *User) *proto.UserInfo {
+	return &proto.UserInfo{
+		Id:             user.ID,
+		Username:       user.Username,
+		Karma:          int32(user.Karma),
+		PostKarma:      int32(user.PostKarma),
+		CommentKarma:   int32(user.CommentKarma),
+		JoinedAt:       user.JoinDate.Unix(),
+		SubredditCount: int32(len(user.Subreddits)),
+	}
+}
+
+func subredditInfo(subreddit *Subreddit) *proto.SubredditInfo {
+	return &proto.SubredditInfo{
+		Id:           subreddit.ID,
+		Name:         subreddit.Name,
+		Description:  subreddit.Description,
+		MemberCount:  int32(subreddit.MemberCount),
+		CreatedAt:    subreddit.CreatedAt.Unix(),
+		ModeratorIds: subreddit.Moderators,
+		Rules:        subreddit.Rules,
+	}
+}
+
+// deletedPlaceholder replaces the content and author of deleted items.
+const deletedPlaceholder = "[deleted]"
+
+// removedPlaceholder replaces the content of items a moderator removed.
+const removedPlaceholder = "[removed]"
+
+// blockedPlaceholder replaces the content and author of comments by users the
+// viewer blocked, where the replies to them are still shown.
+const blockedPlaceholder = "[blocked]"
+
+func postInfo(post *Post) *proto.PostInfo {
+	info := &proto.PostInfo{
+		Id:           post.ID,
+		Title:        post.Title,
+		Content:      post.Content,
+		AuthorId:     post.AuthorID,
+		SubredditId:  post.SubredditID,
+		Upvotes:      int32(post.Upvotes),
+		Downvotes:    int32(post.Downvotes),
+		CommentCount: int32(post.CommentCount),
+		CreatedAt:    post.CreatedAt.Unix(),
+		EditedAt:     unixOrZero(post.EditedAt),
+		Deleted:      post.Deleted,
+		Removed:      post.Removed,
+		Flair:        post.Flair,
+		Locked:       post.Locked,
+	}
+	if post.Deleted {
+		info.Content = deletedPlaceholder
+		info.AuthorId = deletedPlaceholder
+	} else if post.Removed {
+		info.Content = removedPlaceholder
+	}
+	return info
+}
+
+func postInfos(posts []*Post) []*proto.PostInfo {
+	infos := make([]*proto.PostInfo, 0, len(posts))
+	for _, post := range posts {
+		infos = append(infos, postInfo(post))
+	}
+	return infos
+}
+
+// commentInfo converts a single comment; replies are filled in by the caller.
+func commentInfo(comment *Comment) *proto.CommentInfo {
+	info := &proto.CommentInfo{
+		Id:        comment.ID,
+		Content:   comment.Content,
+		AuthorId:  comment.AuthorID,
+		PostId:    comment.PostID,
+		ParentId:  comment.ParentID,
+		Upvotes:   int32(comment.Upvotes),
+		Downvotes: int32(comment.Downvotes),
+		CreatedAt: comment.CreatedAt.Unix(),
+		Depth:     int32(comment.Depth),
+		EditedAt:  unixOrZero(comment.EditedAt),
+		Deleted:   comment.Deleted,
+		Removed:   comment.Removed,
+		Locked:    comment.Locked,
+	}
+	if comment.Deleted {
+		info.Content = deletedPlaceholder
+		info.AuthorId = deletedPlaceholder
+	} else if comment.Removed {
+		info.Content = removedPlaceholder
+	}
+	return info
+}
+
+func messageInfo(dm *DirectMessage) *proto.MessageInfo {
+	return &proto.MessageInfo{
+		Id:         dm.ID,
+		FromUserId: dm.FromUserID,
+		ToUserId:   dm.ToUserID,
+		Content:    dm.Content,
+		CreatedAt:  dm.CreatedAt.Unix(),
+		ReplyToId:  dm.ReplyToID,
+		ThreadId:   dm.ThreadID,
+		Read:       dm.Read,
+	}
+}
+
+func modActionInfo(action *ModAction) *proto.ModActionInfo {
+	return &proto.ModActionInfo{
+		Id:          action.ID,
+		ModeratorId: action.ModeratorID,
+		Action:      action.Action,
+		TargetId:    action.TargetID,
+		Reason:      action.Reason,
+		CreatedAt:   action.CreatedAt.Unix(),
+		ExpiresAt:   unixOrZero(action.Until),
+	}
+}
+
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
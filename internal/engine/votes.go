@@ -0,0 +1,64 @@
+// internal/engine/votes.go
+package engine
+
+import "github.com/kakugri/redditClone/internal/proto"
+
+// voteValue maps a VoteMsg to the ledger value: +1 up, -1 down, 0 no vote.
+func voteValue(msg *proto.VoteMsg) int {
+	switch msg.Direction {
+	case proto.VoteDirection_VOTE_UP:
+		return 1
+	case proto.VoteDirection_VOTE_DOWN:
+		return -1
+	case proto.VoteDirection_VOTE_CLEAR:
+		return 0
+	}
+	if msg.IsUpvote {
+		return 1
+	}
+	return -1
+}
+
+func voteDirection(value int) proto.VoteDirection {
+	switch value {
+	case 1:
+		return proto.VoteDirection_VOTE_UP
+	case -1:
+		return proto.VoteDirection_VOTE_DOWN
+	}
+	return proto.VoteDirection_VOTE_CLEAR
+}
+
+// applyVote moves a target's aggregate counters from a user's previous vote to
+// their new one, so the counters always equal the sums over the ledger.
+func applyVote(upvotes, downvotes *int, previous, value int) {
+	switch previous {
+	case 1:
+		*upvotes--
+	case -1:
+		*downvotes--
+	}
+	switch value {
+	case 1:
+		*upvotes++
+	case -1:
+		*downvotes++
+	}
+}
+
+// recordVote stores a user's vote on a target in the ledger and keeps the
+// vote count metric equal to the number of votes in it.
+func (e *RedditEngine) recordVote(targetID, userID string, previous, value int) {
+	e.store.PutVote(targetID, userID, value)
+
+	switch {
+	case previous == 0 && value != 0:
+		e.updateMetrics(func(m *Metrics) {
+			m.TotalVotes++
+		})
+	case previous != 0 && value == 0:
+		e.updateMetrics(func(m *Metrics) {
+			m.TotalVotes--
+		})
+	}
+}
@@ -0,0 +1,135 @@
+// internal/engine/votes_test.go
+package engine
+
+import (
+	"testing"
+
+	"github.com/asynkron/protoactor-go/actor"
+	"github.com/kakugri/redditClone/internal/proto"
+)
+
+func TestApplyVote(t *testing.T) {
+	tests := []struct {
+		name            string
+		previous, value int
+		up, down        int // counters after the vote, starting from 5 up and 5 down
+	}{
+		{"new upvote", 0, 1, 6, 5},
+		{"new downvote", 0, -1, 5, 6},
+		{"repeated upvote", 1, 1, 5, 5},
+		{"flip up to down", 1, -1, 4, 6},
+		{"flip down to up", -1, 1, 6, 4},
+		{"undo upvote", 1, 0, 4, 5},
+		{"undo downvote", -1, 0, 5, 4},
+		{"undo nothing", 0, 0, 5, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			up, down := 5, 5
+			applyVote(&up, &down, tt.previous, tt.value)
+			if up != tt.up || down != tt.down {
+				t.Errorf("got %d up %d down, want %d up %d down", up, down, tt.up, tt.down)
+			}
+		})
+	}
+}
+
+func TestVoteLedger(t *testing.T) {
+	e := NewRedditEngine()
+	te := startEngine(t, e)
+	author := te.registerUser("author")
+	voter := te.registerUser("voter")
+	subredditID := te.createSubreddit("votes", author)
+	postID := te.createPost(subredditID, author, "a post")
+
+	steps := []struct {
+		name      string
+		msg       *proto.VoteMsg
+		up, down  int32
+		direction proto.VoteDirection
+		karma     int32 // the author's post karma afterwards
+	}{
+		{"upvote", &proto.VoteMsg{Direction: proto.VoteDirection_VOTE_UP}, 1, 0, proto.VoteDirection_VOTE_UP, 1},
+		{"upvote again", &proto.VoteMsg{Direction: proto.VoteDirection_VOTE_UP}, 1, 0, proto.VoteDirection_VOTE_UP, 1},
+		{"flip to down", &proto.VoteMsg{Direction: proto.VoteDirection_VOTE_DOWN}, 0, 1, proto.VoteDirection_VOTE_DOWN, -1},
+		{"legacy downvote again", &proto.VoteMsg{IsUpvote: false}, 0, 1, proto.VoteDirection_VOTE_DOWN, -1},
+		{"legacy flip to up", &proto.VoteMsg{IsUpvote: true}, 1, 0, proto.VoteDirection_VOTE_UP, 1},
+		{"clear", &proto.VoteMsg{Direction: proto.VoteDirection_VOTE_CLEAR}, 0, 0, proto.VoteDirection_VOTE_CLEAR, 0},
+		{"clear again", &proto.VoteMsg{Direction: proto.VoteDirection_VOTE_CLEAR}, 0, 0, proto.VoteDirection_VOTE_CLEAR, 0},
+	}
+	for _, step := range steps {
+		step.msg.UserId = voter
+		step.msg.TargetId = postID
+		resp := te.request(step.msg).(*proto.VoteResponse)
+		if resp.Error != nil {
+			t.Fatalf("%s: %v", step.name, resp.Error)
+		}
+		if resp.Upvotes != step.up || resp.Downvotes != step.down || resp.Direction != step.direction {
+			t.Errorf("%s: got %d up %d down %v, want %d up %d down %v", step.name,
+				resp.Upvotes, resp.Downvotes, resp.Direction, step.up, step.down, step.direction)
+		}
+		if post := te.post(postID); post.Upvotes != step.up || post.Downvotes != step.down {
+			t.Errorf("%s: post has %d up %d down, want %d up %d down", step.name,
+				post.Upvotes, post.Downvotes, step.up, step.down)
+		}
+		if karma := te.karma(author).PostKarma; karma != step.karma {
+			t.Errorf("%s: author has %d post karma, want %d", step.name, karma, step.karma)
+		}
+	}
+
+	// The ledger holds no entry for a cleared vote
+	if value, err := e.store.Vote(postID, voter); err != nil || value != 0 {
+		t.Errorf("ledger has %d, %v for a cleared vote, want 0", value, err)
+	}
+}
+
+func TestVoteLedgerCountsEachVoterOnce(t *testing.T) {
+	te := startEngine(t, NewRedditEngine())
+	author := te.registerUser("author")
+	subredditID := te.createSubreddit("votes", author)
+	postID := te.createPost(subredditID, author, "a post")
+	commentID := te.createComment(postID, author, "a comment")
+
+	voters := []string{te.registerUser("first"), te.registerUser("second"), te.registerUser("third")}
+	for _, voter := range voters {
+		for i := 0; i < 3; i++ {
+			te.request(&proto.VoteMsg{UserId: voter, TargetId: commentID, Direction: proto.VoteDirection_VOTE_UP})
+		}
+	}
+	resp := te.request(&proto.VoteMsg{UserId: voters[0], TargetId: commentID, Direction: proto.VoteDirection_VOTE_DOWN}).(*proto.VoteResponse)
+	if resp.Upvotes != 2 || resp.Downvotes != 1 {
+		t.Errorf("comment has %d up %d down, want 2 up 1 down", resp.Upvotes, resp.Downvotes)
+	}
+	if karma := te.karma(author).CommentKarma; karma != 1 {
+		t.Errorf("author has %d comment karma, want 1", karma)
+	}
+
+	missing := te.request(&proto.VoteMsg{UserId: voters[0], TargetId: "t3_missing", Direction: proto.VoteDirection_VOTE_UP}).(*proto.VoteResponse)
+	if missing.Error == nil || missing.Error.Code != ErrCodeNotFound {
+		t.Errorf("vote on a missing target: got %v, want %s", missing.Error, ErrCodeNotFound)
+	}
+}
+
+func TestKarmaDeltaIsCreditedOnce(t *testing.T) {
+	store := NewMemoryStore()
+	store.PutUser(&User{ID: "t2_author", Username: "author", SubredditKarma: map[string]*KarmaBreakdown{}})
+	te := spawnEngine(t, actor.PropsFromProducer(func() actor.Actor {
+		return &userGrain{RedditEngine: newRedditEngine(store), id: "t2_author"}
+	}))
+
+	// A subreddit grain that got no answer sends the same change again
+	deltas := []*proto.KarmaDeltaMsg{
+		{Id: "kd_1", AuthorId: "t2_author", SubredditId: "t5_sub", PostDelta: 1},
+		{Id: "kd_1", AuthorId: "t2_author", SubredditId: "t5_sub", PostDelta: 1},
+		{Id: "kd_2", AuthorId: "t2_author", SubredditId: "t5_sub", CommentDelta: -1},
+	}
+	for _, delta := range deltas {
+		if resp := te.request(delta).(*proto.KarmaDeltaResponse); resp.Error != nil {
+			t.Fatalf("credit %s: %v", delta.Id, resp.Error)
+		}
+	}
+	user, _ := store.User("t2_author")
+	if user.PostKarma != 1 || user.CommentKarma != -1 || user.SubredditKarma["t5_sub"].PostKarma != 1 {
+		t.Errorf("author has %d post and %d comment karma, want 1 and -1", user.PostKarma, user.CommentKarma)
+	}
+}
@@ -0,0 +1,152 @@
+// internal/idgen/idgen.go
+
+// Package idgen generates collision-free, time-sortable IDs shared by the
+// engine and the simulator.
+//
+// IDs are Snowflake-style 63-bit integers: milliseconds since a custom epoch,
+// the ID of the node that generated them, and a per-millisecond sequence.
+// They are rendered as Reddit-like fullnames, a type prefix followed by the
+// zero-padded base36 value, e.g. "t3_0a1b2c3d4e5f6", so that comparing two
+// IDs of the same kind as strings orders them by creation time.
+package idgen
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Kind is the type prefix of a fullname.
+type Kind string
+
+const (
+	KindComment   Kind = "t1"
+	KindUser      Kind = "t2"
+	KindPost      Kind = "t3"
+	KindMessage   Kind = "t4"
+	KindSubreddit Kind = "t5"
+	KindModAction Kind = "ma" // an entry in a subreddit's moderation log
+	KindKarma     Kind = "kd" // a karma change one engine grain sends another
+)
+
+const (
+	nodeBits     = 10
+	sequenceBits = 12
+
+	// MaxNode is the highest node ID; every engine process needs its own.
+	MaxNode     = 1<<nodeBits - 1
+	maxSequence = 1<<sequenceBits - 1
+
+	// ClientNode is kept for clients of the engine that generate IDs of
+	// their own, like the simulator; engines must not use it.
+	ClientNode = MaxNode
+
+	// Width of the base36 part; 36^13 exceeds the largest 63-bit value
+	width = 13
+)
+
+// epoch is the zero point of the timestamp bits (2024-01-01 UTC).
+var epoch = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+
+// Generator hands out unique IDs for one node. It is safe for concurrent use.
+type Generator struct {
+	mu       sync.Mutex
+	node     int64
+	last     int64 // milliseconds since epoch of the last ID
+	sequence int64
+}
+
+// NewGenerator creates a generator for the given node ID.
+func NewGenerator(node int) (*Generator, error) {
+	if node < 0 || node > MaxNode {
+		return nil, fmt.Errorf("node ID %d out of range [0, %d]", node, MaxNode)
+	}
+	return &Generator{node: int64(node)}, nil
+}
+
+// Next returns the next raw ID. IDs from one generator are strictly
+// increasing, even if the wall clock steps backwards.
+func (g *Generator) Next() int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now().UnixMilli() - epoch
+	if now > g.last {
+		g.last = now
+		g.sequence = 0
+	} else {
+		// Same millisecond, or the clock went backwards: keep counting from
+		// the last timestamp and borrow the next millisecond when the
+		// sequence runs out.
+		g.sequence++
+		if g.sequence > maxSequence {
+			g.last++
+			g.sequence = 0
+		}
+	}
+	return g.last<<(nodeBits+sequenceBits) | g.node<<sequenceBits | g.sequence
+}
+
+// New returns the fullname of a new ID of the given kind.
+func (g *Generator) New(kind Kind) string {
+	return Format(kind, g.Next())
+}
+
+// Format renders a raw ID as a fullname of the given kind.
+func Format(kind Kind, id int64) string {
+	digits := strconv.FormatInt(id, 36)
+	return string(kind) + "_" + strings.Repeat("0", width-len(digits)) + digits
+}
+
+// Parse splits a fullname into its kind and raw ID.
+func Parse(fullname string) (Kind, int64, error) {
+	kind, digits, found := strings.Cut(fullname, "_")
+	if !found || len(digits) != width {
+		return "", 0, fmt.Errorf("malformed ID %q", fullname)
+	}
+	id, err := strconv.ParseInt(digits, 36, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("malformed ID %q", fullname)
+	}
+	return Kind(kind), id, nil
+}
+
+// KindOf returns the kind of a fullname, or "" if it is malformed.
+func KindOf(fullname string) Kind {
+	kind, _, err := Parse(fullname)
+	if err != nil {
+		return ""
+	}
+	return kind
+}
+
+// Time returns when a raw ID was generated.
+func Time(id int64) time.Time {
+	return time.UnixMilli(id>>(nodeBits+sequenceBits) + epoch)
+}
+
+var defaultGenerator atomic.Pointer[Generator]
+
+func init() {
+	g, _ := NewGenerator(0)
+	defaultGenerator.Store(g)
+}
+
+// SetNode replaces the process-wide generator with one for the given node.
+// Call it at startup, before any IDs are generated.
+func SetNode(node int) error {
+	g, err := NewGenerator(node)
+	if err != nil {
+		return err
+	}
+	defaultGenerator.Store(g)
+	return nil
+}
+
+// New returns a new fullname of the given kind from the process-wide generator.
+func New(kind Kind) string {
+	return defaultGenerator.Load().New(kind)
+}
@@ -0,0 +1,108 @@
+// internal/idgen/idgen_test.go
+package idgen
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestNewIsUniqueUnderConcurrency(t *testing.T) {
+	g, err := NewGenerator(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const goroutines, each = 16, 2000
+	ids := make([][]string, goroutines)
+	var wg sync.WaitGroup
+	for i := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < each; j++ {
+				ids[i] = append(ids[i], g.New(KindPost))
+			}
+		}()
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool, goroutines*each)
+	for _, batch := range ids {
+		for _, id := range batch {
+			if seen[id] {
+				t.Fatalf("%s generated twice", id)
+			}
+			seen[id] = true
+		}
+	}
+}
+
+func TestNewIsOrdered(t *testing.T) {
+	g, err := NewGenerator(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// More than one millisecond's sequence, so it borrows the next one
+	last := g.New(KindComment)
+	for i := 0; i < 3*(maxSequence+1); i++ {
+		id := g.New(KindComment)
+		if id <= last {
+			t.Fatalf("%s generated after %s", id, last)
+		}
+		last = id
+	}
+}
+
+func TestNodesDoNotCollide(t *testing.T) {
+	engine, err := NewGenerator(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewGenerator(ClientNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[int64]bool)
+	for i := 0; i < 10000; i++ {
+		for _, id := range []int64{engine.Next(), client.Next()} {
+			if seen[id] {
+				t.Fatalf("%d generated by both nodes", id)
+			}
+			seen[id] = true
+		}
+	}
+}
+
+func TestNewGeneratorRejectsNodes(t *testing.T) {
+	for _, node := range []int{-1, MaxNode + 1} {
+		if _, err := NewGenerator(node); err == nil {
+			t.Errorf("node %d: got no error", node)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	g, err := NewGenerator(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, kind := range []Kind{KindComment, KindUser, KindPost, KindMessage, KindSubreddit, KindModAction, KindKarma} {
+		raw := g.Next()
+		fullname := Format(kind, raw)
+		gotKind, gotRaw, err := Parse(fullname)
+		if err != nil || gotKind != kind || gotRaw != raw {
+			t.Errorf("Parse(%q) = %s, %d, %v, want %s, %d", fullname, gotKind, gotRaw, err, kind, raw)
+		}
+		if got := KindOf(fullname); got != kind {
+			t.Errorf("KindOf(%q) = %q, want %q", fullname, got, kind)
+		}
+	}
+
+	for _, fullname := range []string{"", "t3", "t3_", "t3_abc", "t3_0a1b2c3d4e5f", "t3_0a1b2c3d4e5f6g", "t3_0a1b2c3d4e5f!", "t30a1b2c3d4e5f6"} {
+		if _, _, err := Parse(fullname); err == nil {
+			t.Errorf("Parse(%q): got no error", fullname)
+		}
+		if got := KindOf(fullname); got != "" {
+			t.Errorf("KindOf(%q) = %q, want none", fullname, got)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+// internal/simulator/bench.go
+package simulator
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kakugri/redditClone/internal/engine"
+	"github.com/kakugri/redditClone/internal/proto"
+)
+
+// BenchmarkConfig describes a throughput benchmark run.
+type BenchmarkConfig struct {
+	Users      int // users registered before the run
+	Subreddits int // subreddits the workload is spread over
+	Clients    int // concurrent clients, each waiting for an answer before its next request
+	Duration   time.Duration
+}
+
+// BenchmarkResult is what one benchmark run measured.
+type BenchmarkResult struct {
+	Requests int64 // requests answered, including errors
+	Failures int64 // requests answered with an error or not at all
+	Elapsed  time.Duration
+}
+
+// Throughput is the number of answered requests per second.
+func (r BenchmarkResult) Throughput() float64 {
+	return float64(r.Requests) / r.Elapsed.Seconds()
+}
+
+// postsPerSubreddit is how many posts each subreddit starts with, so votes
+// and comments have something to target from the first request.
+const postsPerSubreddit = 10
+
+// benchTarget is the seeded state the clients pick their requests from.
+type benchTarget struct {
+	users      []string
+	subreddits []string
+	posts      map[string][]string // subreddit ID -> post IDs
+}
+
+// RunBenchmark seeds the engine cluster behind client with users, subreddits
+// and posts, then has cfg.Clients clients send it a mix of votes, comments,
+// posts and listings for cfg.Duration.
+func RunBenchmark(client *engine.Client, cfg BenchmarkConfig) (BenchmarkResult, error) {
+	target, err := seedBenchmark(client, cfg)
+	if err != nil {
+		return BenchmarkResult{}, err
+	}
+
+	var requests, failures int64
+	deadline := time.Now().Add(cfg.Duration)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.Clients; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(seed))
+			for time.Now().Before(deadline) {
+				if _, err := request(client, target.randomRequest(rng)); err != nil {
+					atomic.AddInt64(&failures, 1)
+				}
+				atomic.AddInt64(&requests, 1)
+			}
+		}(int64(i))
+	}
+	wg.Wait()
+
+	return BenchmarkResult{
+		Requests: requests,
+		Failures: failures,
+		Elapsed:  time.Since(start),
+	}, nil
+}
+
+func seedBenchmark(client *engine.Client, cfg BenchmarkConfig) (*benchTarget, error) {
+	target := &benchTarget{posts: make(map[string][]string)}
+	for i := 0; i < cfg.Users; i++ {
+		resp, err := request(client, &proto.RegisterUserMsg{Username: fmt.Sprintf("bench-user-%d", i)})
+		if err != nil {
+			return nil, fmt.Errorf("register user: %w", err)
+		}
+		target.users = append(target.users, resp.(*proto.RegisterUserResponse).UserId)
+	}
+	for i := 0; i < cfg.Subreddits; i++ {
+		resp, err := request(client, &proto.CreateSubredditMsg{
+			Name:        fmt.Sprintf("bench_%d", i),
+			Description: "Benchmark Subreddit",
+			CreatorId:   target.users[i%len(target.users)],
+		})
+		if err != nil {
+			return nil, fmt.Errorf("create subreddit: %w", err)
+		}
+		subredditID := resp.(*proto.CreateSubredditResponse).SubredditId
+		target.subreddits = append(target.subreddits, subredditID)
+
+		for j := 0; j < postsPerSubreddit; j++ {
+			resp, err := request(client, &proto.CreatePostMsg{
+				Title:       "Benchmark Post",
+				Content:     "Benchmark Content",
+				AuthorId:    target.users[(i+j)%len(target.users)],
+				SubredditId: subredditID,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("create post: %w", err)
+			}
+			target.posts[subredditID] = append(target.posts[subredditID], resp.(*proto.CreatePostResponse).PostId)
+		}
+	}
+	return target, nil
+}
+
+// randomRequest picks the next request of a client: mostly votes and
+// listings, like real traffic, with some comments and posts.
+func (t *benchTarget) randomRequest(rng *rand.Rand) interface{} {
+	userID := t.users[rng.Intn(len(t.users))]
+	subredditID := t.subreddits[rng.Intn(len(t.subreddits))]
+	posts := t.posts[subredditID]
+	postID := posts[rng.Intn(len(posts))]
+
+	switch n := rng.Intn(10); {
+	case n < 4:
+		direction := proto.VoteDirection_VOTE_UP
+		if rng.Intn(3) == 0 {
+			direction = proto.VoteDirection_VOTE_DOWN
+		}
+		return &proto.VoteMsg{UserId: userID, TargetId: postID, Direction: direction}
+	case n < 6:
+		return &proto.CreateCommentMsg{PostId: postID, Content: "Benchmark Comment", AuthorId: userID}
+	case n < 7:
+		return &proto.CreatePostMsg{
+			Title:       "Benchmark Post",
+			Content:     "Benchmark Content",
+			AuthorId:    userID,
+			SubredditId: subredditID,
+		}
+	case n < 9:
+		return &proto.GetSubredditPostsMsg{SubredditId: subredditID, Sort: "hot"}
+	}
+	return &proto.GetUserKarmaMsg{UserId: userID}
+}
+
+// request sends msg to the engine and waits for the answer. An answer that
+// carries an error is returned along with it.
+func request(client *engine.Client, msg interface{}) (interface{}, error) {
+	result, err := client.Request(msg)
+	if err != nil {
+		return nil, err
+	}
+	if resp, ok := result.(interface{ GetError() *proto.Error }); ok && resp.GetError() != nil {
+		return result, fmt.Errorf("%s: %s", resp.GetError().GetCode(), resp.GetError().GetMessage())
+	}
+	return result, nil
+}
@@ -2,20 +2,27 @@
 package simulator
 
 import (
+	"fmt"
 	"log"
 	"math/rand"
+	"sync"
 	"time"
 
 	"github.com/asynkron/protoactor-go/actor"
 	"github.com/kakugri/redditClone/internal/engine"
+	"github.com/kakugri/redditClone/internal/idgen"
 	"github.com/kakugri/redditClone/internal/proto"
 )
 
 type Simulator struct {
-	enginePID *actor.PID
-	users     []*SimulatedUser
-	numUsers  int
-	metrics   *engine.Metrics
+	system   *actor.ActorSystem
+	client   *engine.Client
+	users    []*SimulatedUser
+	numUsers int
+	metrics  *engine.Metrics
+
+	mu           sync.Mutex
+	subredditIDs []string // IDs of subreddits created by simulated users
 }
 
 type SimulatedUser struct {
@@ -25,10 +32,10 @@ type SimulatedUser struct {
 }
 
 type NewUserActor struct {
-	enginePID     *actor.PID
+	client        *engine.Client
 	postFrequency time.Duration
 	userID        string
-	subredditID   string
+	subredditName string
 	simulator     *Simulator
 }
 
@@ -39,10 +46,13 @@ func (u *NewUserActor) Receive(context actor.Context) {
 	}
 }
 
-func NewSimulator(enginePID *actor.PID, numUsers int) *Simulator {
+// NewSimulator creates a simulator whose user actors live in system and reach
+// the engine cluster through client.
+func NewSimulator(system *actor.ActorSystem, client *engine.Client, numUsers int) *Simulator {
 	return &Simulator{
-		enginePID: enginePID,
-		numUsers:  numUsers,
+		system:   system,
+		client:   client,
+		numUsers: numUsers,
 		metrics: &engine.Metrics{
 			StartTime: time.Now(),
 		},
@@ -52,19 +62,19 @@ func NewSimulator(enginePID *actor.PID, numUsers int) *Simulator {
 func (s *Simulator) Start() {
 	// Create user actors
 	for i := 0; i < s.numUsers; i++ {
-		userID := generateID()
-		subredditID := "subreddit-1" // Simulate a fixed subreddit for now
+		userID := idgen.New(idgen.KindUser)
+		subredditName := fmt.Sprintf("sim_%d", i) // The subreddit the user may create
 		props := actor.PropsFromProducer(func() actor.Actor {
 			return &NewUserActor{
-				enginePID:     s.enginePID,
+				client:        s.client,
 				postFrequency: time.Duration(rand.Intn(10)+1) * time.Second,
 				userID:        userID,
-				subredditID:   subredditID,
+				subredditName: subredditName,
 				simulator:     s,
 			}
 		})
 
-		pid := actor.NewActorSystem().Root.Spawn(props)
+		pid := s.system.Root.Spawn(props)
 		s.users = append(s.users, &SimulatedUser{
 			userID:    userID,
 			connected: true,
@@ -82,35 +92,58 @@ func (u *NewUserActor) simulateActivity(context actor.Context) {
 		}
 
 		// Simulate various actions
-		switch rand.Intn(5) {
+		switch rand.Intn(6) {
 		case 0:
-			// Simulate creating a post
+			// Simulate creating a post in one of the subreddits created so far
+			subredditID, ok := u.simulator.randomSubreddit()
+			if !ok {
+				break
+			}
 			msg := &proto.CreatePostMsg{
 				Title:       "Simulated Post Title",
 				Content:     "Simulated Post Content",
 				AuthorId:    u.userID,
-				SubredditId: u.subredditID,
+				SubredditId: subredditID,
 			}
 			u.simulator.metrics.TotalPosts++
-			context.Send(u.enginePID, msg)
-			log.Printf("User %s sent a post to subreddit %s", u.userID, u.subredditID)
+			u.client.Send(msg)
+			log.Printf("User %s sent a post to subreddit %s", u.userID, subredditID)
 		case 1:
 			// Simulate registering a user
 			msg := &proto.RegisterUserMsg{
 				Username: u.userID,
 			}
 			u.simulator.metrics.ActiveUsers++
-			context.Send(u.enginePID, msg)
+			result, err := u.client.Request(msg)
+			if err != nil {
+				log.Printf("User %s registration failed: %v", u.userID, err)
+				break
+			}
+			// Use the engine-assigned ID from now on
+			if resp, ok := result.(*proto.RegisterUserResponse); ok && resp.Error == nil {
+				u.userID = resp.UserId
+			}
 			log.Printf("User %s registered", u.userID)
 		case 2:
 			// Simulate creating a subreddit
 			msg := &proto.CreateSubredditMsg{
-				Name:        u.subredditID,
+				Name:        u.subredditName,
 				Description: "Simulated Subreddit",
 				CreatorId:   u.userID,
 			}
-			context.Send(u.enginePID, msg)
-			log.Printf("User %s created subreddit %s", u.userID, u.subredditID)
+			result, err := u.client.Request(msg)
+			if err != nil {
+				log.Printf("User %s failed to create subreddit %s: %v", u.userID, u.subredditName, err)
+				break
+			}
+			resp, ok := result.(*proto.CreateSubredditResponse)
+			if !ok || resp.Error != nil {
+				// Once the user has created it, the name is taken
+				log.Printf("User %s could not create subreddit %s: %v", u.userID, u.subredditName, resp.GetError())
+				break
+			}
+			u.simulator.addSubreddit(resp.SubredditId)
+			log.Printf("User %s created subreddit %s", u.userID, u.subredditName)
 		case 3:
 			// Simulate creating a post
 			msg := &proto.CreateCommentMsg{
@@ -120,7 +153,7 @@ func (u *NewUserActor) simulateActivity(context actor.Context) {
 				ParentId: "Simulated ParentId",
 			}
 			u.simulator.metrics.TotalComments++
-			context.Send(u.enginePID, msg)
+			u.client.Send(msg)
 			log.Printf("User %s created a comment under %s", u.userID, msg.ParentId)
 		case 4:
 			// Simulate creating a post
@@ -130,27 +163,41 @@ func (u *NewUserActor) simulateActivity(context actor.Context) {
 				FromUserId: "Simulated Recipient ID",
 			}
 			u.simulator.metrics.TotalMessages++
-			context.Send(u.enginePID, msg)
+			u.client.Send(msg)
 			log.Printf("User %s sent a message to %s", msg.ToUserId, msg.FromUserId)
 		case 5:
-			// Simulate creating a post
-			// msg := &proto.JoinSubredditMsg{
-			// 	UserId:      "Simulated Recipient ID",
-			// 	SubredditId: "Simulated Subreddit ID",
-			// }
-			// u.simulator.metrics.TotalComments++
-			// context.Send(u.enginePID, msg)
-			// log.Printf("User %s joined subreddit %s", msg.UserId, msg.SubredditId)
+			// Simulate joining one of the subreddits created so far
+			subredditID, ok := u.simulator.randomSubreddit()
+			if !ok {
+				break
+			}
+			msg := &proto.JoinSubredditMsg{
+				UserId:      u.userID,
+				SubredditId: subredditID,
+			}
+			u.client.Send(msg)
+			log.Printf("User %s joined subreddit %s", msg.UserId, msg.SubredditId)
 		}
 
 		time.Sleep(u.postFrequency)
 	}
 }
 
-func (s *Simulator) GetMetrics() *engine.Metrics {
-	return s.metrics
+func (s *Simulator) addSubreddit(subredditID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subredditIDs = append(s.subredditIDs, subredditID)
+}
+
+func (s *Simulator) randomSubreddit() (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.subredditIDs) == 0 {
+		return "", false
+	}
+	return s.subredditIDs[rand.Intn(len(s.subredditIDs))], true
 }
 
-func generateID() string {
-	return time.Now().Format("20060102150405.000")
+func (s *Simulator) GetMetrics() *engine.Metrics {
+	return s.metrics
 }
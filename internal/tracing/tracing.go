@@ -0,0 +1,67 @@
+// internal/tracing/tracing.go
+
+// Package tracing sets up OpenTelemetry tracing for the API and the engine.
+//
+// A request is traced from the HTTP handler that receives it, through the
+// messages the engine's actors exchange about it, to the store calls made
+// while handling it. The trace context travels in the W3C traceparent header,
+// in HTTP headers and in the headers of actor message envelopes alike.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+)
+
+// Setup installs the process-wide tracer provider of service, which exports
+// spans to output: "stdout", or the path of a file spans are appended to as
+// JSON, one per line. An empty output leaves tracing off. The returned
+// function flushes the spans not yet exported and must be called before the
+// process exits.
+func Setup(service, output string) (shutdown func(context.Context) error, err error) {
+	if output == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	var w io.Writer = os.Stdout
+	opts := []stdouttrace.Option{stdouttrace.WithPrettyPrint()}
+	var file *os.File
+	if output != "stdout" {
+		file, err = os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("open trace file: %w", err)
+		}
+		w = file
+		opts = nil
+	}
+	exporter, err := stdouttrace.New(append(opts, stdouttrace.WithWriter(w))...)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(service))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if file != nil {
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		return err
+	}, nil
+}
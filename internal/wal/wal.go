@@ -0,0 +1,508 @@
+// internal/wal/wal.go
+
+// Package wal implements an append-only, segmented write-ahead log with
+// snapshots on the local filesystem.
+//
+// Records are numbered from 1 and stored in segment files named after the
+// sequence number of their first record. Each record is framed as
+//
+//	[4 byte length][4 byte CRC32 of payload][payload]
+//
+// so a record torn by a crash is detected on open and truncated away.
+// Snapshots are written atomically next to the segments; once a snapshot
+// covers a segment entirely, Compact deletes it.
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SyncPolicy controls when appended records are fsynced to disk.
+type SyncPolicy string
+
+const (
+	// SyncAlways fsyncs after every append: no acknowledged record is lost.
+	SyncAlways SyncPolicy = "always"
+	// SyncInterval fsyncs in the background every Options.SyncInterval.
+	SyncInterval SyncPolicy = "interval"
+	// SyncNever leaves flushing to the operating system.
+	SyncNever SyncPolicy = "never"
+)
+
+// ParseSyncPolicy validates a policy name from configuration.
+func ParseSyncPolicy(name string) (SyncPolicy, error) {
+	switch SyncPolicy(name) {
+	case SyncAlways, SyncInterval, SyncNever:
+		return SyncPolicy(name), nil
+	}
+	return "", fmt.Errorf("unknown fsync policy %q", name)
+}
+
+type Options struct {
+	Sync         SyncPolicy
+	SyncInterval time.Duration // used by SyncInterval
+	SegmentSize  int64         // a new segment is started once this many bytes are written
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Sync:         SyncAlways,
+		SyncInterval: time.Second,
+		SegmentSize:  64 << 20,
+	}
+}
+
+const (
+	headerSize     = 8
+	maxRecordSize  = 64 << 20
+	segmentPrefix  = "wal-"
+	segmentSuffix  = ".log"
+	snapshotPrefix = "snapshot-"
+	snapshotSuffix = ".snap"
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Log is a write-ahead log stored in one directory. It is safe for
+// concurrent use.
+type Log struct {
+	dir  string
+	opts Options
+
+	mu       sync.Mutex
+	segments []uint64 // first sequence number of every segment, ascending
+	file     *os.File // the last segment, open for appending
+	size     int64    // bytes in the last segment
+	next     uint64   // sequence number of the next record
+	dirty    bool     // written since the last fsync
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Open opens the log in dir, creating it if needed, and truncates a torn
+// record left at the end by a crash.
+func Open(dir string, opts Options) (*Log, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = DefaultOptions().SegmentSize
+	}
+
+	l := &Log{dir: dir, opts: opts, next: 1}
+	segments, err := l.listFiles(segmentPrefix, segmentSuffix)
+	if err != nil {
+		return nil, err
+	}
+	l.segments = segments
+
+	if len(l.segments) == 0 {
+		// Start after the latest snapshot, in case every segment was compacted
+		if seq, _, err := l.LatestSnapshot(); err == nil {
+			l.next = seq + 1
+		}
+		if err := l.openSegment(l.next); err != nil {
+			return nil, err
+		}
+	} else if err := l.recoverTail(); err != nil {
+		return nil, err
+	}
+
+	if opts.Sync == SyncInterval {
+		l.stop = make(chan struct{})
+		l.done = make(chan struct{})
+		go l.syncLoop()
+	}
+	return l, nil
+}
+
+// recoverTail scans the last segment to find the next sequence number and
+// cuts off an incomplete or corrupt final record.
+func (l *Log) recoverTail() error {
+	start := l.segments[len(l.segments)-1]
+	path := l.segmentPath(start)
+	file, err := os.OpenFile(path, os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+
+	count, valid, err := scanSegment(file, nil)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if valid < info.Size() {
+		log.Printf("WAL: truncating torn record in %s at offset %d", path, valid)
+		if err := file.Truncate(valid); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if _, err := file.Seek(valid, io.SeekStart); err != nil {
+		file.Close()
+		return err
+	}
+
+	l.file = file
+	l.size = valid
+	l.next = start + count
+	return nil
+}
+
+// Append writes a record and returns its sequence number. With SyncAlways the
+// record is on disk when Append returns. A failed Append leaves no record
+// behind.
+func (l *Log) Append(payload []byte) (uint64, error) {
+	if len(payload) > maxRecordSize {
+		return 0, fmt.Errorf("record of %d bytes exceeds the %d byte limit", len(payload), maxRecordSize)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.size >= l.opts.SegmentSize {
+		if err := l.rotateLocked(); err != nil {
+			return 0, err
+		}
+	}
+
+	record := make([]byte, headerSize+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.Checksum(payload, crcTable))
+	copy(record[headerSize:], payload)
+	if _, err := l.file.Write(record); err != nil {
+		return 0, l.truncateLocked(err)
+	}
+	l.dirty = true
+	if l.opts.Sync == SyncAlways {
+		if err := l.syncLocked(); err != nil {
+			return 0, l.truncateLocked(err)
+		}
+	}
+	l.size += int64(len(record))
+
+	seq := l.next
+	l.next++
+	return seq, nil
+}
+
+// truncateLocked cuts what a failed Append wrote off the segment, so the next
+// record does not follow a torn one, and returns the error of the Append.
+func (l *Log) truncateLocked(err error) error {
+	if truncErr := l.file.Truncate(l.size); truncErr != nil {
+		log.Printf("WAL: failed to truncate a failed append: %v", truncErr)
+	}
+	return err
+}
+
+// LastSequence returns the sequence number of the last appended record, or 0.
+func (l *Log) LastSequence() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.next - 1
+}
+
+// Replay calls fn for every record with a sequence number of at least from,
+// in order.
+func (l *Log) Replay(from uint64, fn func(seq uint64, payload []byte) error) error {
+	l.mu.Lock()
+	segments := append([]uint64(nil), l.segments...)
+	l.mu.Unlock()
+
+	for i, start := range segments {
+		// Skip segments that end before from
+		if i+1 < len(segments) && segments[i+1] <= from {
+			continue
+		}
+		file, err := os.Open(l.segmentPath(start))
+		if err != nil {
+			return err
+		}
+		seq := start
+		_, _, err = scanSegment(file, func(payload []byte) error {
+			defer func() { seq++ }()
+			if seq < from {
+				return nil
+			}
+			return fn(seq, payload)
+		})
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sync flushes appended records to disk.
+func (l *Log) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.syncLocked()
+}
+
+func (l *Log) syncLocked() error {
+	if !l.dirty {
+		return nil
+	}
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+	l.dirty = false
+	return nil
+}
+
+func (l *Log) syncLoop() {
+	defer close(l.done)
+	ticker := time.NewTicker(l.opts.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := l.Sync(); err != nil {
+				log.Printf("WAL: background fsync failed: %v", err)
+			}
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+// Rotate closes the current segment and starts a new one at the next
+// sequence number.
+func (l *Log) Rotate() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rotateLocked()
+}
+
+func (l *Log) rotateLocked() error {
+	if l.size == 0 {
+		return nil
+	}
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	return l.openSegment(l.next)
+}
+
+func (l *Log) openSegment(start uint64) error {
+	file, err := os.OpenFile(l.segmentPath(start), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := syncDir(l.dir); err != nil {
+		file.Close()
+		return err
+	}
+	l.file = file
+	l.size = 0
+	l.dirty = false
+	if n := len(l.segments); n == 0 || l.segments[n-1] != start {
+		l.segments = append(l.segments, start)
+	}
+	return nil
+}
+
+// WriteSnapshot atomically stores a snapshot of the state after record seq.
+func (l *Log) WriteSnapshot(seq uint64, data []byte) error {
+	final := filepath.Join(l.dir, fmt.Sprintf("%s%020d%s", snapshotPrefix, seq, snapshotSuffix))
+	tmp := final + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	header := make([]byte, headerSize)
+	binary.BigEndian.PutUint32(header[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(header[4:8], crc32.Checksum(data, crcTable))
+	if _, err := file.Write(append(header, data...)); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, final); err != nil {
+		return err
+	}
+	return syncDir(l.dir)
+}
+
+// ErrNoSnapshot is returned by LatestSnapshot when none has been written.
+var ErrNoSnapshot = errors.New("wal: no snapshot")
+
+// LatestSnapshot returns the newest valid snapshot and the sequence number of
+// the last record it covers.
+func (l *Log) LatestSnapshot() (uint64, []byte, error) {
+	snapshots, err := l.listFiles(snapshotPrefix, snapshotSuffix)
+	if err != nil {
+		return 0, nil, err
+	}
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		seq := snapshots[i]
+		path := filepath.Join(l.dir, fmt.Sprintf("%s%020d%s", snapshotPrefix, seq, snapshotSuffix))
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return 0, nil, err
+		}
+		if len(raw) < headerSize {
+			log.Printf("WAL: ignoring truncated snapshot %s", path)
+			continue
+		}
+		data := raw[headerSize:]
+		if int(binary.BigEndian.Uint32(raw[0:4])) != len(data) ||
+			binary.BigEndian.Uint32(raw[4:8]) != crc32.Checksum(data, crcTable) {
+			log.Printf("WAL: ignoring corrupt snapshot %s", path)
+			continue
+		}
+		return seq, data, nil
+	}
+	return 0, nil, ErrNoSnapshot
+}
+
+// Compact deletes the segments and older snapshots made redundant by the
+// snapshot covering every record up to seq.
+func (l *Log) Compact(seq uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// A segment can go once the next one starts at or before seq+1, which
+	// means all of its records are in the snapshot. The last segment is
+	// never deleted because it is open for appending.
+	keep := 0
+	for keep+1 < len(l.segments) && l.segments[keep+1] <= seq+1 {
+		if err := os.Remove(l.segmentPath(l.segments[keep])); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		keep++
+	}
+	l.segments = l.segments[keep:]
+
+	snapshots, err := l.listFiles(snapshotPrefix, snapshotSuffix)
+	if err != nil {
+		return err
+	}
+	for _, old := range snapshots {
+		if old < seq {
+			path := filepath.Join(l.dir, fmt.Sprintf("%s%020d%s", snapshotPrefix, old, snapshotSuffix))
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return syncDir(l.dir)
+}
+
+// Close stops background syncing and flushes the log to disk.
+func (l *Log) Close() error {
+	if l.stop != nil {
+		close(l.stop)
+		<-l.done
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.file.Sync(); err != nil {
+		l.file.Close()
+		return err
+	}
+	return l.file.Close()
+}
+
+func (l *Log) segmentPath(start uint64) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%s%020d%s", segmentPrefix, start, segmentSuffix))
+}
+
+// listFiles returns the sequence numbers embedded in the names of the files
+// with the given prefix and suffix, ascending.
+func (l *Log) listFiles(prefix, suffix string) ([]uint64, error) {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return nil, err
+	}
+	var seqs []uint64
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, prefix), suffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	return seqs, nil
+}
+
+// scanSegment reads records from the start of file, calling fn for each
+// valid one. It returns the number of valid records and the offset just past
+// the last of them; anything after that is a torn or corrupt tail.
+func scanSegment(file *os.File, fn func(payload []byte) error) (uint64, int64, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 0, 0, err
+	}
+	var count uint64
+	var offset int64
+	header := make([]byte, headerSize)
+	for {
+		if _, err := io.ReadFull(file, header); err != nil {
+			return count, offset, nil
+		}
+		length := binary.BigEndian.Uint32(header[0:4])
+		if length > maxRecordSize {
+			return count, offset, nil
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(file, payload); err != nil {
+			return count, offset, nil
+		}
+		if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[4:8]) {
+			return count, offset, nil
+		}
+		if fn != nil {
+			if err := fn(payload); err != nil {
+				return count, offset, err
+			}
+		}
+		count++
+		offset += int64(headerSize) + int64(length)
+	}
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
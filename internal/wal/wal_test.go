@@ -0,0 +1,194 @@
+// internal/wal/wal_test.go
+package wal
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func openLog(t *testing.T, dir string, opts Options) *Log {
+	t.Helper()
+	l, err := Open(dir, opts)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func appendRecords(t *testing.T, l *Log, payloads ...string) {
+	t.Helper()
+	for _, payload := range payloads {
+		if _, err := l.Append([]byte(payload)); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+}
+
+// replayAll returns the records from sequence number from on, as seq:payload.
+func replayAll(t *testing.T, l *Log, from uint64) []string {
+	t.Helper()
+	var records []string
+	err := l.Replay(from, func(seq uint64, payload []byte) error {
+		records = append(records, fmt.Sprintf("%d:%s", seq, payload))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	return records
+}
+
+func TestReplayAfterReopen(t *testing.T) {
+	dir := t.TempDir()
+	l := openLog(t, dir, DefaultOptions())
+	appendRecords(t, l, "a", "b", "c")
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	l = openLog(t, dir, DefaultOptions())
+	if got, want := replayAll(t, l, 1), []string{"1:a", "2:b", "3:c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed %v, want %v", got, want)
+	}
+	if got, want := replayAll(t, l, 3), []string{"3:c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed from 3 %v, want %v", got, want)
+	}
+	if seq, err := l.Append([]byte("d")); err != nil || seq != 4 {
+		t.Errorf("Append after reopen got %d, %v, want 4", seq, err)
+	}
+}
+
+func TestTornLastRecordIsTruncated(t *testing.T) {
+	dir := t.TempDir()
+	l := openLog(t, dir, DefaultOptions())
+	appendRecords(t, l, "a", "b")
+	l.Close()
+
+	// A crash in the middle of a write leaves a header and part of a payload
+	segment := l.segmentPath(1)
+	before, err := os.Stat(segment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.OpenFile(segment, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte{0, 0, 0, 100, 1, 2, 3, 4, 'p', 'a', 'r', 't'})
+	file.Close()
+
+	l = openLog(t, dir, DefaultOptions())
+	if after, _ := os.Stat(segment); after.Size() != before.Size() {
+		t.Errorf("segment is %d bytes after recovery, want %d", after.Size(), before.Size())
+	}
+	appendRecords(t, l, "c")
+	if got, want := replayAll(t, l, 1), []string{"1:a", "2:b", "3:c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed %v, want %v", got, want)
+	}
+}
+
+func TestCorruptLastRecordIsTruncated(t *testing.T) {
+	dir := t.TempDir()
+	l := openLog(t, dir, DefaultOptions())
+	appendRecords(t, l, "a", "b")
+	l.Close()
+
+	// Flip the last byte of the payload of the last record
+	segment := l.segmentPath(1)
+	data, err := os.ReadFile(segment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err := os.WriteFile(segment, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	l = openLog(t, dir, DefaultOptions())
+	if got, want := replayAll(t, l, 1), []string{"1:a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed %v, want %v", got, want)
+	}
+	if seq, err := l.Append([]byte("c")); err != nil || seq != 2 {
+		t.Errorf("Append after recovery got %d, %v, want 2", seq, err)
+	}
+}
+
+func TestSnapshotAndCompact(t *testing.T) {
+	dir := t.TempDir()
+	// Every record starts a segment of its own
+	opts := DefaultOptions()
+	opts.SegmentSize = 1
+	l := openLog(t, dir, opts)
+	appendRecords(t, l, "a", "b", "c")
+	if err := l.WriteSnapshot(1, []byte("state 1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.WriteSnapshot(3, []byte("state 3")); err != nil {
+		t.Fatal(err)
+	}
+	appendRecords(t, l, "d", "e")
+	if err := l.Compact(3); err != nil {
+		t.Fatal(err)
+	}
+
+	seq, data, err := l.LatestSnapshot()
+	if err != nil || seq != 3 || string(data) != "state 3" {
+		t.Errorf("LatestSnapshot got %d %q %v, want 3 \"state 3\"", seq, data, err)
+	}
+	if segments, _ := l.listFiles(segmentPrefix, segmentSuffix); !reflect.DeepEqual(segments, []uint64{4, 5}) {
+		t.Errorf("segments after compaction start at %v, want [4 5]", segments)
+	}
+	if snapshots, _ := l.listFiles(snapshotPrefix, snapshotSuffix); !reflect.DeepEqual(snapshots, []uint64{3}) {
+		t.Errorf("snapshots after compaction %v, want [3]", snapshots)
+	}
+	if got, want := replayAll(t, l, seq+1), []string{"4:d", "5:e"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed %v, want %v", got, want)
+	}
+}
+
+func TestReopenAfterEverySegmentWasCompacted(t *testing.T) {
+	dir := t.TempDir()
+	l := openLog(t, dir, DefaultOptions())
+	appendRecords(t, l, "a", "b")
+	if err := l.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.WriteSnapshot(2, []byte("state 2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Compact(2); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	// Without the empty segment Rotate opened, numbering carries on after
+	// the snapshot
+	os.Remove(l.segmentPath(3))
+	l = openLog(t, dir, DefaultOptions())
+	if seq, err := l.Append([]byte("c")); err != nil || seq != 3 {
+		t.Errorf("Append got %d, %v, want 3", seq, err)
+	}
+}
+
+func TestCorruptSnapshotFallsBackToOlder(t *testing.T) {
+	dir := t.TempDir()
+	l := openLog(t, dir, DefaultOptions())
+	if err := l.WriteSnapshot(1, []byte("state 1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.WriteSnapshot(2, []byte("state 2")); err != nil {
+		t.Fatal(err)
+	}
+	path := fmt.Sprintf("%s/%s%020d%s", dir, snapshotPrefix, 2, snapshotSuffix)
+	if err := os.WriteFile(path, []byte("torn"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	seq, data, err := l.LatestSnapshot()
+	if err != nil || seq != 1 || string(data) != "state 1" {
+		t.Errorf("LatestSnapshot got %d %q %v, want 1 \"state 1\"", seq, data, err)
+	}
+}